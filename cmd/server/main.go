@@ -4,10 +4,21 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/hra42/7x42/internal/ai"
+	"github.com/hra42/7x42/internal/auth"
+	"github.com/hra42/7x42/internal/cluster"
+	"github.com/hra42/7x42/internal/credits"
 	"github.com/hra42/7x42/internal/database"
+	"github.com/hra42/7x42/internal/events"
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/providerkeys"
+	"github.com/hra42/7x42/internal/ratelimit"
+	"github.com/hra42/7x42/internal/secrets"
 	"github.com/hra42/7x42/internal/server"
 )
 
@@ -41,12 +52,56 @@ func main() {
 	}
 	log.Println("Database migrations completed")
 
+	// Credits are charged by the AI service and managed through the server's API
+	creditService := credits.NewService(db, credits.Config{
+		RequireCredits: getEnvBool("CREDITS_REQUIRED", false),
+	})
+
+	// Users' own provider keys are encrypted with the master keys in SECRETS_MASTER_KEYS,
+	// "id:base64key" pairs with the current key first. To rotate, put a new key first and
+	// restart; keys are re-encrypted at startup, after which the old key can be dropped.
+	keyring, err := secrets.ParseKeyring(getEnv("SECRETS_MASTER_KEYS", ""))
+	if err != nil {
+		log.Fatal("Invalid SECRETS_MASTER_KEYS:", err)
+	}
+	providerKeys := providerkeys.NewService(db, keyring)
+
+	// Changes to chats, messages and workspaces are published here and delivered to
+	// WebSocket clients subscribed to their topics
+	eventBus := events.NewBus()
+
+	// Instances sharing the database reach each other's WebSocket clients through Postgres
+	// LISTEN/NOTIFY when CLUSTER_ENABLED is set. NODE_ID names this instance in the logs;
+	// a random ID is used if unset.
+	clustered := getEnvBool("CLUSTER_ENABLED", false)
+	var clusterBus *cluster.Bus
+	if clustered {
+		clusterBus, err = cluster.New(cluster.Config{
+			DB:      db,
+			DSN:     dbConfig.DSN(),
+			NodeID:  getEnv("NODE_ID", ""),
+			Channel: getEnv("CLUSTER_CHANNEL", cluster.DefaultChannel),
+		})
+		if err != nil {
+			log.Fatal("Failed to initialize cluster bus:", err)
+		}
+	}
+
 	// Initialize AI service directly with configuration
 	log.Println("Initializing AI service...")
 	aiConfig := ai.Config{
 		OpenRouterKey: getEnv("OPENROUTER_API_KEY", ""),
 		Model:         getEnv("OPENROUTER_MODEL", "google/gemini-2.0-flash-001"),
 		DB:            db,
+		JobTimeout:    getEnvDuration("GENERATION_JOB_TIMEOUT", 0),
+
+		FallbackModels:      getEnvList("OPENROUTER_FALLBACK_MODELS"),
+		StreamFlushInterval: getEnvDuration("STREAM_FLUSH_INTERVAL", 0),
+		StreamFlushBytes:    getEnvInt("STREAM_FLUSH_BYTES", 0),
+		Credits:             creditService,
+		ProviderKeys:        providerKeys,
+		Events:              eventBus,
+		Clustered:           clustered,
 	}
 
 	aiService, err := ai.NewServiceWithConfig(aiConfig)
@@ -59,6 +114,35 @@ func main() {
 	app := server.New(&server.Config{
 		DB:        db,
 		AIService: aiService,
+		Auth: auth.Config{
+			SessionTTL:        getEnvDuration("SESSION_TTL", auth.DefaultSessionTTL),
+			AllowRegistration: getEnvBool("AUTH_ALLOW_REGISTRATION", true),
+			SecureCookies:     getEnvBool("COOKIE_SECURE", false),
+			TicketSecret:      getEnv("WS_TICKET_SECRET", ""),
+			DisablePasswords:  !getEnvBool("AUTH_PASSWORD_LOGIN", true),
+			OIDC: &auth.OIDCConfig{
+				IssuerURL:      getEnv("OIDC_ISSUER_URL", ""),
+				ClientID:       getEnv("OIDC_CLIENT_ID", ""),
+				ClientSecret:   getEnv("OIDC_CLIENT_SECRET", ""),
+				RedirectURL:    getEnv("OIDC_REDIRECT_URL", "http://localhost:8080/auth/oidc/callback"),
+				Scopes:         getEnvList("OIDC_SCOPES"),
+				GroupsClaim:    getEnv("OIDC_GROUPS_CLAIM", "groups"),
+				AdminGroups:    getEnvList("OIDC_ADMIN_GROUPS"),
+				MemberGroups:   getEnvList("OIDC_MEMBER_GROUPS"),
+				ReadOnlyGroups: getEnvList("OIDC_READONLY_GROUPS"),
+			},
+		},
+		RateLimit:    rateLimitConfig(),
+		Credits:      creditService,
+		ProviderKeys: providerKeys,
+		Events:       eventBus,
+		Cluster:      clusterBus,
+		// Behind a load balancer, TRUSTED_PROXIES lists its addresses or CIDR ranges so client
+		// addresses are taken from PROXY_HEADER for rate limits, sessions and the audit log.
+		// The first address in X-Forwarded-For, the default, is used; a header the proxy
+		// overwrites, such as X-Real-IP, cannot be spoofed by clients.
+		TrustedProxies: getEnvList("TRUSTED_PROXIES"),
+		ProxyHeader:    getEnv("PROXY_HEADER", ""),
 	})
 	log.Println("Server initialized")
 
@@ -86,6 +170,45 @@ func main() {
 	}
 }
 
+// rateLimitConfig builds the rate limits from the defaults and environment overrides.
+// RATE_LIMIT_ENABLED=false turns limiting off; RATE_LIMIT_IP and RATE_LIMIT_<ROLE>_<CLASS>,
+// e.g. RATE_LIMIT_MEMBER_GENERATIONS=30/10, take "perMinute/burst" or "off".
+func rateLimitConfig() ratelimit.Config {
+	if !getEnvBool("RATE_LIMIT_ENABLED", true) {
+		return ratelimit.Config{}
+	}
+
+	config := ratelimit.DefaultConfig()
+	if limit, ok := getEnvLimit("RATE_LIMIT_IP"); ok {
+		config.IP = limit
+	}
+	for _, role := range models.UserRoles {
+		for _, class := range ratelimit.Classes {
+			key := "RATE_LIMIT_" + strings.ToUpper(strings.ReplaceAll(role, "-", "_")) + "_" + strings.ToUpper(class)
+			if limit, ok := getEnvLimit(key); ok {
+				if config.Roles[role] == nil {
+					config.Roles[role] = make(map[string]ratelimit.Limit)
+				}
+				config.Roles[role][class] = limit
+			}
+		}
+	}
+	return config
+}
+
+// getEnvLimit retrieves a rate limit environment variable; invalid values are fatal
+func getEnvLimit(key string) (ratelimit.Limit, bool) {
+	value, ok := os.LookupEnv(key)
+	if !ok {
+		return ratelimit.Limit{}, false
+	}
+	limit, err := ratelimit.ParseLimit(value)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", key, err)
+	}
+	return limit, true
+}
+
 // getEnv retrieves environment variables with fallback values
 func getEnv(key, fallback string) string {
 	if value, ok := os.LookupEnv(key); ok {
@@ -93,3 +216,46 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// getEnvDuration retrieves a duration environment variable such as "50ms" with a fallback value
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	if value, ok := os.LookupEnv(key); ok {
+		if duration, err := time.ParseDuration(value); err == nil {
+			return duration
+		}
+		log.Printf("Ignoring invalid duration for %s: %q", key, value)
+	}
+	return fallback
+}
+
+// getEnvInt retrieves an integer environment variable with a fallback value
+func getEnvInt(key string, fallback int) int {
+	if value, ok := os.LookupEnv(key); ok {
+		if n, err := strconv.Atoi(value); err == nil {
+			return n
+		}
+		log.Printf("Ignoring invalid integer for %s: %q", key, value)
+	}
+	return fallback
+}
+
+// getEnvBool retrieves a boolean environment variable with a fallback value
+func getEnvBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
+
+// getEnvList retrieves a comma-separated environment variable as a list
+func getEnvList(key string) []string {
+	var result []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
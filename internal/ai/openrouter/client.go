@@ -7,7 +7,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/gofiber/websocket/v2"
 	"github.com/hra42/7x42/internal/repository"
 	"gorm.io/gorm"
 )
@@ -61,30 +60,58 @@ func (c *Client) SetMessageRepository(repo *repository.MessageRepository) {
 
 // GenerateResponse sends a prompt to OpenRouter and returns the response
 func (c *Client) GenerateResponse(ctx context.Context, prompt string, chatHistory []ChatMessage) (string, error) {
+	completion, err := c.GenerateCompletion(ctx, "", prompt, chatHistory)
+	if err != nil {
+		return "", err
+	}
+	return completion.Content, nil
+}
+
+// GenerateCompletion sends a prompt to the given model and returns the full completion.
+// An empty model falls back to the configured default.
+func (c *Client) GenerateCompletion(ctx context.Context, model string, prompt string, chatHistory []ChatMessage) (*Completion, error) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
 	if !c.isReady {
-		return "", errors.New("client not initialized")
+		return nil, errors.New("client not initialized")
+	}
+
+	if model == "" {
+		model = c.config.Model
 	}
 
 	messages := c.prepareMessages(prompt, chatHistory)
 
 	requestBody := map[string]interface{}{
-		"model":       c.config.Model,
 		"messages":    messages,
 		"temperature": c.config.Temperature,
 		"max_tokens":  c.config.MaxTokens,
+		"usage": map[string]interface{}{
+			"include": true,
+		},
 	}
+	c.applyModels(requestBody, model)
 
-	var response string
+	var completion *Completion
 	var err error
 
 	// Implement retry logic
+	startTime := time.Now()
 	for i := 0; i < c.config.MaxRetries; i++ {
-		response, err = c.makeAPIRequest(ctx, requestBody)
+		completion, err = c.makeAPIRequest(ctx, requestBody)
 		if err == nil {
-			return response, nil
+			completion.Latency = time.Since(startTime)
+			completion.TimeToFirstToken = completion.Latency
+			completion.Retries = i
+			if completion.Model == "" {
+				completion.Model = model
+			}
+			completion.FallbackHops = c.fallbackHops(model, completion.Model)
+			return completion, nil
+		}
+		if IsKeyError(err) {
+			break
 		}
 
 		if i < c.config.MaxRetries-1 {
@@ -92,51 +119,29 @@ func (c *Client) GenerateResponse(ctx context.Context, prompt string, chatHistor
 		}
 	}
 
-	return "", err
+	return nil, err
 }
 
-// StreamResponse streams the AI response through a WebSocket connection
-func (c *Client) StreamResponse(ctx context.Context, wsConn *websocket.Conn, prompt string, chatHistory []ChatMessage) error {
+// StreamCompletion streams a completion from the given model, passing each content delta to onDelta.
+// An empty model falls back to the configured default.
+func (c *Client) StreamCompletion(ctx context.Context, model string, prompt string, chatHistory []ChatMessage, onDelta StreamHandler) (*Completion, error) {
 	c.mu.RLock()
 	if !c.isReady {
 		c.mu.RUnlock()
-		return errors.New("client not initialized")
+		return nil, errors.New("client not initialized")
 	}
 	c.mu.RUnlock()
 
-	// Send typing indicator
-	if err := wsConn.WriteJSON(map[string]interface{}{
-		"type": "typing",
-	}); err != nil {
-		return err
+	if model == "" {
+		model = c.config.Model
 	}
 
 	messages := c.prepareMessages(prompt, chatHistory)
 
-	// Extract chat ID if present in the prompt
-	chatID := c.extractChatID(prompt)
-
-	// Stream the response
-	startTime := time.Now()
-	fullContent, err := c.streamAPIResponse(ctx, wsConn, messages)
-	if err != nil {
-		return err
-	}
-
-	// Calculate processing metrics
-	processingTime := int(time.Since(startTime).Milliseconds())
-
-	// Save the message if we have a chat repository and valid chat ID
-	if c.chatRepo != nil && chatID > 0 {
-		c.saveMessage(ctx, chatID, fullContent, processingTime)
-	}
+	return c.streamAPIResponse(ctx, model, messages, onDelta)
+}
 
-	// Send completion notification
-	return wsConn.WriteJSON(map[string]interface{}{
-		"type": "chat_message",
-		"metadata": map[string]interface{}{
-			"complete":       true,
-			"processingTime": processingTime,
-		},
-	})
+// DefaultModel returns the model used when a request does not name one
+func (c *Client) DefaultModel() string {
+	return c.config.Model
 }
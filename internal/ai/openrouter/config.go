@@ -14,6 +14,8 @@ type Config struct {
 	MaxRetries  int
 	RetryDelay  time.Duration
 	BaseURL     string
+	// FallbackModels are tried in order by OpenRouter when the requested model is unavailable
+	FallbackModels []string
 }
 
 // Validate checks if the configuration is valid
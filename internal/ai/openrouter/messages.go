@@ -1,14 +1,6 @@
 package openrouter
 
-import (
-	"context"
-	"log"
-	"regexp"
-	"strconv"
-	"time"
-
-	"github.com/hra42/7x42/internal/models"
-)
+import "strings"
 
 // prepareMessages formats the messages for the API request
 func (c *Client) prepareMessages(prompt string, chatHistory []ChatMessage) []ChatMessage {
@@ -18,36 +10,43 @@ func (c *Client) prepareMessages(prompt string, chatHistory []ChatMessage) []Cha
 		}
 	}
 
+	// Copy the history so concurrent requests sharing it never alias
+	messages := make([]ChatMessage, 0, len(chatHistory)+1)
+	messages = append(messages, chatHistory...)
+
 	// Append the new prompt to existing chat history
-	return append(chatHistory, ChatMessage{Role: "user", Content: prompt})
+	return append(messages, ChatMessage{Role: "user", Content: prompt})
 }
 
-// extractChatID extracts the chat ID from the prompt if available
-func (c *Client) extractChatID(prompt string) uint64 {
-	chatIDMatches := regexp.MustCompile(`chat:(\d+)`).FindStringSubmatch(prompt)
-	if len(chatIDMatches) > 1 {
-		if id, err := strconv.ParseUint(chatIDMatches[1], 10, 64); err == nil {
-			return id
+// modelCandidates returns the requested model followed by the configured fallbacks
+func (c *Client) modelCandidates(model string) []string {
+	candidates := []string{model}
+	for _, fallback := range c.config.FallbackModels {
+		if fallback != model {
+			candidates = append(candidates, fallback)
 		}
 	}
-	return 0
+	return candidates
 }
 
-func (c *Client) saveMessage(ctx context.Context, chatID uint64, content string, processingTime int) {
-	message := &models.Message{
-		ChatID:    chatID,
-		Content:   content,
-		Role:      "assistant",
-		Timestamp: time.Now(),
-		Metadata: models.MessageMetadata{
-			Model:       c.config.Model,
-			TokenCount:  len(content) / 4,
-			ProcessTime: processingTime,
-		},
+// applyModels sets the model, and the fallback list if any, on a request body
+func (c *Client) applyModels(requestBody map[string]interface{}, model string) {
+	requestBody["model"] = model
+	if candidates := c.modelCandidates(model); len(candidates) > 1 {
+		requestBody["models"] = candidates
 	}
+}
 
-	// Use messageRepo instead of chatRepo
-	if err := c.messageRepo.CreateMessage(ctx, message); err != nil {
-		log.Printf("Failed to save AI response: %v", err)
+// fallbackHops returns how far down the fallback list the answering model was.
+// Responses may name a dated variant of the requested model, so prefixes match.
+func (c *Client) fallbackHops(requested, answered string) int {
+	if answered == "" {
+		return 0
 	}
+	for i, candidate := range c.modelCandidates(requested) {
+		if strings.HasPrefix(answered, candidate) {
+			return i
+		}
+	}
+	return 0
 }
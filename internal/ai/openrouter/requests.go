@@ -4,53 +4,117 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 )
 
+// Errors the API reports about the key a request was made with
+var (
+	ErrUnauthorized    = errors.New("API key was rejected")
+	ErrPaymentRequired = errors.New("API key has insufficient credits")
+)
+
+// APIError is a non-200 response from the API
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+// Error implements the error interface
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API returned non-200 status: %d - %s", e.StatusCode, e.Body)
+}
+
+// Is matches ErrUnauthorized and ErrPaymentRequired by status code
+func (e *APIError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+	case ErrPaymentRequired:
+		return e.StatusCode == http.StatusPaymentRequired
+	}
+	return false
+}
+
+// IsKeyError returns true if the request failed because of the API key, so retrying cannot help
+func IsKeyError(err error) bool {
+	return errors.Is(err, ErrUnauthorized) || errors.Is(err, ErrPaymentRequired)
+}
+
+// apiKeyContextKey is the context key of a per-request API key
+type apiKeyContextKey struct{}
+
+// WithAPIKey returns a context whose requests use apiKey instead of the configured key
+func WithAPIKey(ctx context.Context, apiKey string) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey{}, apiKey)
+}
+
+// apiKey returns the key a request should use: the one in its context, or the configured key
+func (c *Client) apiKey(ctx context.Context) string {
+	if key, ok := ctx.Value(apiKeyContextKey{}).(string); ok && key != "" {
+		return key
+	}
+	return c.config.APIKey
+}
+
+// newAPIError reads a non-200 response into an APIError
+func newAPIError(resp *http.Response) error {
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	return &APIError{StatusCode: resp.StatusCode, Body: string(bodyBytes)}
+}
+
 // setRequestHeaders sets common headers for API requests
 func (c *Client) setRequestHeaders(req *http.Request) {
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.config.APIKey))
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.apiKey(req.Context())))
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("X-Title", "7x42 Chat")
 	req.Header.Set("HTTP-Referer", "https://7x42.net")
 }
 
 // makeAPIRequest sends a request to the OpenRouter API and returns the response
-func (c *Client) makeAPIRequest(ctx context.Context, requestBody map[string]interface{}) (string, error) {
+func (c *Client) makeAPIRequest(ctx context.Context, requestBody map[string]interface{}) (*Completion, error) {
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request body: %w", err)
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, "POST", c.config.BaseURL+"/chat/completions", bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	c.setRequestHeaders(req)
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("request failed: %w", err)
+		return nil, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API returned non-200 status: %d - %s", resp.StatusCode, string(bodyBytes))
+		return nil, newAPIError(resp)
 	}
 
 	var result completionResponse
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return "", fmt.Errorf("failed to decode response: %w", err)
+		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
 	if len(result.Choices) == 0 {
-		return "", fmt.Errorf("no response choices returned from the API")
+		return nil, fmt.Errorf("no response choices returned from the API")
+	}
+
+	completion := &Completion{
+		Content:  result.Choices[0].Message.Content,
+		Model:    result.Model,
+		Provider: result.Provider,
+	}
+	if result.Usage != nil {
+		completion.Usage = *result.Usage
 	}
 
-	return result.Choices[0].Message.Content, nil
+	return completion, nil
 }
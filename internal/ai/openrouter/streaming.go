@@ -8,52 +8,64 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
-
-	"github.com/gofiber/websocket/v2"
 )
 
-// streamAPIResponse handles streaming the API response through WebSockets
-func (c *Client) streamAPIResponse(ctx context.Context, wsConn *websocket.Conn, messages []ChatMessage) (string, error) {
+// streamAPIResponse streams a completion for the given model, passing each content delta to onDelta
+func (c *Client) streamAPIResponse(ctx context.Context, model string, messages []ChatMessage, onDelta StreamHandler) (*Completion, error) {
 	requestBody := map[string]interface{}{
-		"model":       c.config.Model,
 		"messages":    messages,
 		"temperature": c.config.Temperature,
 		"max_tokens":  c.config.MaxTokens,
 		"stream":      true,
+		"usage": map[string]interface{}{
+			"include": true,
+		},
 	}
 
+	c.applyModels(requestBody, model)
+
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal request body: %w", err)
+		return nil, fmt.Errorf("failed to marshal request body: %w", err)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, "POST", c.config.BaseURL+"/chat/completions", bytes.NewBuffer(jsonData))
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	c.setRequestHeaders(req)
 	req.Header.Set("Accept", "text/event-stream")
 
+	startTime := time.Now()
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("request failed: %w", err)
+		return nil, fmt.Errorf("request failed: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("API returned non-200 status: %d - %s", resp.StatusCode, string(bodyBytes))
+		return nil, newAPIError(resp)
 	}
 
-	return c.processStreamResponse(resp.Body, wsConn)
+	completion, err := c.processStreamResponse(resp.Body, startTime, onDelta)
+	if completion != nil {
+		completion.Latency = time.Since(startTime)
+		if completion.Model == "" {
+			completion.Model = model
+		}
+		completion.FallbackHops = c.fallbackHops(model, completion.Model)
+	}
+	return completion, err
 }
 
 // processStreamResponse handles the streaming response data
-func (c *Client) processStreamResponse(responseBody io.ReadCloser, wsConn *websocket.Conn) (string, error) {
+func (c *Client) processStreamResponse(responseBody io.ReadCloser, startTime time.Time, onDelta StreamHandler) (*Completion, error) {
 	reader := bufio.NewReaderSize(responseBody, 32*1024)
-	var fullContent string
+	completion := &Completion{}
+	var fullContent strings.Builder
 
 	for {
 		line, err := reader.ReadBytes('\n')
@@ -61,7 +73,8 @@ func (c *Client) processStreamResponse(responseBody io.ReadCloser, wsConn *webso
 			if err == io.EOF {
 				break
 			}
-			return fullContent, fmt.Errorf("error reading stream: %w", err)
+			completion.Content = fullContent.String()
+			return completion, fmt.Errorf("error reading stream: %w", err)
 		}
 
 		line = bytes.TrimSpace(line)
@@ -69,8 +82,6 @@ func (c *Client) processStreamResponse(responseBody io.ReadCloser, wsConn *webso
 			continue
 		}
 
-		buffer += string(line)
-
 		// Skip non-data lines
 		if !bytes.HasPrefix(line, []byte("data: ")) {
 			continue
@@ -89,24 +100,33 @@ func (c *Client) processStreamResponse(responseBody io.ReadCloser, wsConn *webso
 			continue
 		}
 
+		if streamResponse.Model != "" {
+			completion.Model = streamResponse.Model
+		}
+		if streamResponse.Provider != "" {
+			completion.Provider = streamResponse.Provider
+		}
+
+		// The final chunk carries the usage accounting
+		if streamResponse.Usage != nil {
+			completion.Usage = *streamResponse.Usage
+		}
+
 		// Process content if available
 		if len(streamResponse.Choices) > 0 && streamResponse.Choices[0].Delta.Content != "" {
 			content := streamResponse.Choices[0].Delta.Content
-			fullContent += content
-
-			// Send the content chunk via WebSocket
-			if err := wsConn.WriteJSON(map[string]interface{}{
-				"type": "chat_message",
-				"content": map[string]interface{}{
-					"content":   content,
-					"role":      "assistant",
-					"timestamp": time.Now(),
-				},
-			}); err != nil {
-				return fullContent, fmt.Errorf("failed to send message chunk: %w", err)
+			if fullContent.Len() == 0 {
+				completion.TimeToFirstToken = time.Since(startTime)
+			}
+			fullContent.WriteString(content)
+
+			if err := onDelta(content); err != nil {
+				completion.Content = fullContent.String()
+				return completion, fmt.Errorf("failed to send message chunk: %w", err)
 			}
 		}
 	}
 
-	return fullContent, nil
+	completion.Content = fullContent.String()
+	return completion, nil
 }
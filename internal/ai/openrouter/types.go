@@ -1,25 +1,60 @@
 package openrouter
 
+import "time"
+
 // ChatMessage represents a message in the conversation
 type ChatMessage struct {
 	Role    string `json:"role"`
 	Content string `json:"content"`
 }
 
+// Usage holds the token accounting reported by OpenRouter for a completion
+type Usage struct {
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// Completion is the result of a finished completion request
+type Completion struct {
+	Content string
+	Model   string
+	Usage   Usage
+	Latency time.Duration
+	// TimeToFirstToken is the delay until the first content arrived
+	TimeToFirstToken time.Duration
+	// Provider is the upstream provider OpenRouter routed the request to
+	Provider string
+	// Retries is the number of failed attempts before the request succeeded
+	Retries int
+	// FallbackHops is the position of the answering model in the fallback list, 0 for the requested model
+	FallbackHops int
+}
+
+// StreamHandler receives content deltas as they arrive from the API
+type StreamHandler func(delta string) error
+
 // streamResponse holds the structure for parsing streaming responses
 type streamResponse struct {
-	Choices []struct {
+	Model    string `json:"model"`
+	Provider string `json:"provider"`
+	Choices  []struct {
 		Delta struct {
 			Content string `json:"content"`
 		} `json:"delta"`
 	} `json:"choices"`
+	Usage *Usage `json:"usage"`
 }
 
 // completionResponse holds the structure for parsing completion responses
 type completionResponse struct {
-	Choices []struct {
+	Model    string `json:"model"`
+	Provider string `json:"provider"`
+	Choices  []struct {
 		Message struct {
 			Content string `json:"content"`
 		} `json:"message"`
 	} `json:"choices"`
+	Usage *Usage `json:"usage"`
 }
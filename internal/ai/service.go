@@ -1,8 +1,13 @@
 package ai
 
 import (
-	"github.com/gofiber/websocket/v2"
+	"context"
+	"time"
+
 	"github.com/hra42/7x42/internal/ai/service"
+	"github.com/hra42/7x42/internal/credits"
+	"github.com/hra42/7x42/internal/events"
+	"github.com/hra42/7x42/internal/providerkeys"
 	"gorm.io/gorm"
 )
 
@@ -17,6 +22,20 @@ type Config struct {
 	Model         string
 	Temperature   float64
 	MaxTokens     int
+	JobTimeout    time.Duration
+	// FallbackModels are tried in order when the requested model is unavailable
+	FallbackModels []string
+	// StreamFlushInterval and StreamFlushBytes control how streamed deltas are coalesced
+	StreamFlushInterval time.Duration
+	StreamFlushBytes    int
+	// Credits, if set, enforces budgets and charges generations
+	Credits *credits.Service
+	// ProviderKeys, if set, runs generations on users' own provider keys
+	ProviderKeys *providerkeys.Service
+	// Events, if set, receives message and generation events
+	Events *events.Bus
+	// Clustered is set when other instances share the database
+	Clustered bool
 }
 
 func NewService(db *gorm.DB) (*Service, error) {
@@ -37,6 +56,15 @@ func NewServiceWithConfig(config Config) (*Service, error) {
 		Model:         config.Model,
 		Temperature:   config.Temperature,
 		MaxTokens:     config.MaxTokens,
+		JobTimeout:    config.JobTimeout,
+
+		FallbackModels:      config.FallbackModels,
+		StreamFlushInterval: config.StreamFlushInterval,
+		StreamFlushBytes:    config.StreamFlushBytes,
+		Credits:             config.Credits,
+		ProviderKeys:        config.ProviderKeys,
+		Events:              config.Events,
+		Clustered:           config.Clustered,
 	})
 
 	if err != nil {
@@ -48,6 +76,46 @@ func NewServiceWithConfig(config Config) (*Service, error) {
 	}, nil
 }
 
-func (s *Service) HandleChatMessage(wsConn *websocket.Conn, chatID uint, content string, userID string) error {
-	return s.service.HandleChatMessage(wsConn, chatID, content, userID)
+func (s *Service) HandleChatMessage(w service.StreamWriter, chatID uint, content string, userID uint) error {
+	return s.service.HandleChatMessage(w, chatID, content, userID)
+}
+
+func (s *Service) StreamChatMessage(w service.StreamWriter, chatID uint, content string, userID uint, model string) (<-chan struct{}, error) {
+	return s.service.StreamChatMessage(w, chatID, content, userID, model)
+}
+
+func (s *Service) HandleCompareMessage(w service.StreamWriter, chatID uint, content string, userID uint, models []string) error {
+	return s.service.HandleCompareMessage(w, chatID, content, userID, models)
+}
+
+func (s *Service) SubscribeJobs(w service.StreamWriter, chatID uint, jobID uint, userID uint) error {
+	return s.service.SubscribeJobs(w, chatID, jobID, userID)
+}
+
+func (s *Service) CancelJob(jobID uint, userID uint) error {
+	return s.service.CancelJob(jobID, userID)
+}
+
+func (s *Service) RetryMessage(w service.StreamWriter, messageID uint, userID uint) error {
+	return s.service.RetryMessage(w, messageID, userID)
+}
+
+// SetChatWatchers registers who follows chats besides the writers starting generations
+func (s *Service) SetChatWatchers(watchers service.ChatWatchers) {
+	s.service.SetChatWatchers(watchers)
+}
+
+// ChatAccess reports whether a user may follow a chat and whether they may also prompt in it
+func (s *Service) ChatAccess(ctx context.Context, userID uint, chatID uint) (bool, error) {
+	return s.service.ChatAccess(ctx, userID, chatID)
+}
+
+// FollowJobs attaches a watcher the caller vouches for to the generations running in a chat
+func (s *Service) FollowJobs(w service.StreamWriter, chatID uint64) {
+	s.service.FollowJobs(w, chatID)
+}
+
+// Stop cancels running generation jobs
+func (s *Service) Stop() {
+	s.service.Stop()
 }
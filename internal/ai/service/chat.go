@@ -5,70 +5,299 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
-	"github.com/gofiber/websocket/v2"
 	"github.com/hra42/7x42/internal/ai/openrouter"
+	"github.com/hra42/7x42/internal/authz"
+	"github.com/hra42/7x42/internal/events"
 	"github.com/hra42/7x42/internal/models"
-	"gorm.io/gorm"
+	"github.com/hra42/7x42/internal/providerkeys"
+	"github.com/hra42/7x42/internal/repository"
 )
 
-// HandleChatMessage processes a chat message and generates a response
-func (s *Service) HandleChatMessage(wsConn *websocket.Conn, chatID uint, content string, userID string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+// HandleChatMessage saves the user's message and starts a background job generating the response.
+// The writer receives the streamed reply but the job keeps running if it goes away.
+func (s *Service) HandleChatMessage(w StreamWriter, chatID uint, content string, userID uint) error {
+	// Fall back to a blocking response if there is nobody to stream to
+	if w == nil {
+		return s.generateResponse(chatID, content, userID)
+	}
+
+	_, err := s.StreamChatMessage(w, chatID, content, userID, "")
+	return err
+}
+
+// StreamChatMessage is like HandleChatMessage with an optional model override.
+// The returned channel is closed once the generation has finished and its final frame was sent.
+func (s *Service) StreamChatMessage(w StreamWriter, chatID uint, content string, userID uint, model string) (<-chan struct{}, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	// Save user message
-	if err := s.saveUserMessage(ctx, chatID, content, userID); err != nil {
-		return fmt.Errorf("failed to save user message: %w", err)
+	// Load the history before saving the prompt so it is only sent once
+	chat, workspace, key, err := s.openChat(ctx, chatID, content, userID, nil)
+	if err != nil {
+		return nil, err
+	}
+	messages := withPersona(s.convertMessagesToOpenRouterFormat(chat.Messages), workspace)
+
+	userMsg, err := s.saveUserMessage(ctx, uint(chat.ID), content, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save user message: %w", err)
+	}
+	s.announcePrompt(w, userMsg)
+
+	j, err := s.startJob(ctx, jobRequest{
+		chatID:      uint64(chat.ID),
+		userID:      userID,
+		parentID:    userMsg.ID,
+		workspaceID: workspaceID(workspace),
+		model:       workspaceModel(model, workspace),
+		prompt:      content,
+		history:     messages,
+		key:         key,
+	}, w)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start generation: %w", err)
 	}
 
-	// Get or create chat
-	chat, err := s.getOrCreateChat(ctx, chatID, content, userID)
+	return j.done, nil
+}
+
+// RetryMessage regenerates a failed or cancelled reply in place, answering the same user message
+func (s *Service) RetryMessage(w StreamWriter, messageID uint, userID uint) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	message, err := s.authorizer.Message(ctx, userID, messageID, authz.ActionPrompt)
 	if err != nil {
-		return fmt.Errorf("failed to get or create chat: %w", err)
+		if repository.IsNotFound(err) {
+			return ErrMessageNotFound
+		}
+		return err
+	}
+
+	chat, err := s.authorizer.Chat(ctx, userID, message.ChatID, authz.ActionPrompt)
+	if err != nil {
+		if repository.IsNotFound(err) {
+			return ErrMessageNotFound
+		}
+		return err
+	}
+
+	if !message.CanRetry() || message.ParentID == nil {
+		return ErrNotRetryable
+	}
+	workspace, err := s.chatWorkspace(ctx, chat)
+	if err != nil {
+		return err
+	}
+	key, err := s.preflight(ctx, userID, workspace, nil)
+	if err != nil {
+		return err
+	}
+
+	// Rebuild the conversation as it was when the user message was sent
+	var prompt string
+	var previous []models.Message
+	var compare bool
+	for _, msg := range chat.Messages {
+		if msg.ID == *message.ParentID {
+			prompt = msg.Content
+			break
+		}
+		previous = append(previous, msg)
+	}
+	for _, msg := range chat.Messages {
+		if msg.ID != message.ID && msg.ParentID != nil && *msg.ParentID == *message.ParentID {
+			compare = true
+		}
+	}
+	if prompt == "" {
+		return ErrNotRetryable
+	}
+
+	if _, err := s.startJob(ctx, jobRequest{
+		chatID:      message.ChatID,
+		userID:      userID,
+		parentID:    *message.ParentID,
+		workspaceID: workspaceID(workspace),
+		model:       workspaceModel(message.Metadata.Model, workspace),
+		prompt:      prompt,
+		history:     withPersona(s.convertMessagesToOpenRouterFormat(previous), workspace),
+		compare:     compare,
+		messageID:   message.ID,
+		key:         key,
+	}, w); err != nil {
+		return fmt.Errorf("failed to start generation: %w", err)
+	}
+
+	return nil
+}
+
+// preflight runs before a generation reaches the provider. It returns the user's own provider key,
+// if they stored one; otherwise the generation runs on the server's key and must fit the user's
+// budget and, in a workspace chat, the workspace's. A request fanning out to several models, listed
+// in modelIDs, must fit what all of their generations are expected to cost.
+func (s *Service) preflight(ctx context.Context, userID uint, workspace *models.Workspace, modelIDs []string) (*providerkeys.Key, error) {
+	if s.config.ProviderKeys != nil {
+		key, err := s.config.ProviderKeys.Resolve(ctx, userID, models.ProviderOpenRouter)
+		if err != nil {
+			return nil, err
+		}
+		if key != nil {
+			return key, nil
+		}
+	}
+
+	if s.config.Credits != nil {
+		var err error
+		if len(modelIDs) > 1 {
+			err = s.config.Credits.CheckGenerations(ctx, userID, workspaceID(workspace), modelIDs)
+		} else {
+			err = s.config.Credits.Check(ctx, userID, workspaceID(workspace))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// settleGeneration records the outcome of a provider request. Generations on the server's key
+// are charged to the user's credits; the user's own key is billed by the provider, so only its
+// state is recorded. Failures to record are logged; the reply has been generated either way.
+func (s *Service) settleGeneration(userID uint, workspaceID uint, jobID uint, key *providerkeys.Key, completion *openrouter.Completion, genErr error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if key != nil {
+		switch {
+		case genErr == nil:
+			s.config.ProviderKeys.MarkUsed(ctx, key)
+		case openrouter.IsKeyError(genErr):
+			s.config.ProviderKeys.MarkFailed(ctx, key, genErr.Error())
+		}
+		return
 	}
 
-	// Convert messages to OpenRouter format
-	messages := s.convertMessagesToOpenRouterFormat(chat.Messages)
+	if genErr != nil || s.config.Credits == nil {
+		return
+	}
+	if err := s.config.Credits.Charge(ctx, userID, workspaceID, jobID, completion.Model, completion.Usage.Cost); err != nil {
+		log.Printf("Failed to charge user %d for %s: %v", userID, completion.Model, err)
+	}
+}
 
-	// Generate response using streaming if WebSocket is available
-	if wsConn != nil {
-		return s.streamResponse(ctx, wsConn, content, messages)
+// withProviderKey makes requests on ctx use the user's own key, if any
+func withProviderKey(ctx context.Context, key *providerkeys.Key) context.Context {
+	if key == nil {
+		return ctx
 	}
+	return openrouter.WithAPIKey(ctx, key.Secret)
+}
 
-	// Fall back to non-streaming response if WebSocket is not available
-	return s.generateResponse(ctx, chatID, content, messages)
+// describeProviderError explains a rejected API key, telling apart the user's key from the server's
+func describeProviderError(err error, key *providerkeys.Key) error {
+	switch {
+	case errors.Is(err, openrouter.ErrUnauthorized) && key != nil:
+		return fmt.Errorf("%w: OpenRouter rejected your API key; update or remove it in your settings", ErrProviderKey)
+	case errors.Is(err, openrouter.ErrPaymentRequired) && key != nil:
+		return fmt.Errorf("%w: your OpenRouter account is out of credits", ErrProviderKey)
+	case errors.Is(err, openrouter.ErrUnauthorized):
+		return fmt.Errorf("%w: OpenRouter rejected the server's API key; contact an administrator", ErrProviderKey)
+	case errors.Is(err, openrouter.ErrPaymentRequired):
+		return fmt.Errorf("%w: the server's OpenRouter account is out of credits; contact an administrator", ErrProviderKey)
+	}
+	return err
 }
 
 // saveUserMessage saves the user's message to the database
-func (s *Service) saveUserMessage(ctx context.Context, chatID uint, content string, userID string) error {
+func (s *Service) saveUserMessage(ctx context.Context, chatID uint, content string, userID uint) (*models.Message, error) {
 	userMsg := &models.Message{
 		ChatID:    uint64(chatID),
 		Content:   content,
 		Role:      "user",
 		Timestamp: time.Now(),
 		Metadata:  models.MessageMetadata{},
+		UserID:    &userID,
 	}
 
 	// Use messageRepo instead of chatRepo
-	return s.messageRepo.CreateMessage(ctx, userMsg)
+	if err := s.messageRepo.CreateMessage(ctx, userMsg); err != nil {
+		return nil, err
+	}
+	s.publishMessage(events.MessageCreated, userMsg)
+	return userMsg, nil
 }
 
-// getOrCreateChat gets an existing chat or creates a new one if it doesn't exist
-func (s *Service) getOrCreateChat(ctx context.Context, chatID uint, content string, userID string) (*models.Chat, error) {
+// openChat loads an existing chat the user may prompt in, with its workspace, and runs the preflight
+// for a generation with each of modelIDs, or a single one if none are given.
+// If chatID is 0 a new personal chat is created once the preflight has passed.
+func (s *Service) openChat(ctx context.Context, chatID uint, content string, userID uint, modelIDs []string) (*models.Chat, *models.Workspace, *providerkeys.Key, error) {
+	var chat *models.Chat
+	var workspace *models.Workspace
 	if chatID != 0 {
-		chat, err := s.chatRepo.GetChat(ctx, uint64(chatID))
-		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, err
+		var err error
+		chat, err = s.authorizer.Chat(ctx, userID, uint64(chatID), authz.ActionPrompt)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to get chat: %w", err)
 		}
+		workspace, err = s.chatWorkspace(ctx, chat)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
 
-		if chat != nil {
-			return chat, nil
+	key, err := s.preflight(ctx, userID, workspace, modelIDs)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if chat == nil {
+		chat, err = s.createChat(ctx, content, userID)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to create chat: %w", err)
 		}
 	}
 
-	// Create new chat if chatID is 0 or chat not found
+	return chat, workspace, key, nil
+}
+
+// chatWorkspace loads the workspace a chat belongs to, or returns nil for personal chats
+func (s *Service) chatWorkspace(ctx context.Context, chat *models.Chat) (*models.Workspace, error) {
+	if chat.WorkspaceID == nil {
+		return nil, nil
+	}
+	return s.workspaceRepo.GetWorkspace(ctx, *chat.WorkspaceID)
+}
+
+// withPersona puts a workspace's persona ahead of the conversation
+func withPersona(history []openrouter.ChatMessage, workspace *models.Workspace) []openrouter.ChatMessage {
+	if workspace == nil || strings.TrimSpace(workspace.SystemPrompt) == "" {
+		return history
+	}
+	return append([]openrouter.ChatMessage{{Role: models.RoleSystem, Content: workspace.SystemPrompt}}, history...)
+}
+
+// workspaceModel returns the requested model, falling back to the workspace's default
+func workspaceModel(model string, workspace *models.Workspace) string {
+	if model == "" && workspace != nil {
+		return workspace.DefaultModel
+	}
+	return model
+}
+
+// workspaceID returns the ID of a workspace, or 0 for none
+func workspaceID(workspace *models.Workspace) uint {
+	if workspace == nil {
+		return 0
+	}
+	return workspace.ID
+}
+
+// createChat creates a new personal chat titled after its first message
+func (s *Service) createChat(ctx context.Context, content string, userID uint) (*models.Chat, error) {
 	title := content
 	if len(title) > 30 {
 		title = title[:30]
@@ -83,61 +312,67 @@ func (s *Service) getOrCreateChat(ctx context.Context, chatID uint, content stri
 	if err := s.chatRepo.CreateChat(ctx, chat); err != nil {
 		return nil, err
 	}
+	s.publishChat(events.ChatCreated, chat)
 
 	return chat, nil
 }
 
-// streamResponse streams an AI response through a WebSocket connection
-func (s *Service) streamResponse(ctx context.Context, wsConn *websocket.Conn, content string, messages []openrouter.ChatMessage) error {
-	if err := s.openRouter.StreamResponse(ctx, wsConn, content, messages); err != nil {
-		log.Printf("Error streaming response: %v", err)
-
-		// Try fallback to non-streaming response
-		response, fallbackErr := s.openRouter.GenerateResponse(ctx, content, messages)
-		if fallbackErr != nil {
-			return fmt.Errorf("failed to generate response (fallback): %w", fallbackErr)
-		}
+// generateResponse generates a non-streaming AI response
+func (s *Service) generateResponse(chatID uint, content string, userID uint) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
 
-		// Send fallback response as a complete message
-		if err := wsConn.WriteJSON(map[string]interface{}{
-			"type": "chat_message",
-			"content": map[string]interface{}{
-				"content":   response,
-				"role":      "assistant",
-				"timestamp": time.Now(),
-			},
-		}); err != nil {
-			return fmt.Errorf("failed to send complete message: %w", err)
-		}
+	chat, workspace, key, err := s.openChat(ctx, chatID, content, userID, nil)
+	if err != nil {
+		return err
 	}
+	messages := withPersona(s.convertMessagesToOpenRouterFormat(chat.Messages), workspace)
 
-	return nil
-}
+	userMsg, err := s.saveUserMessage(ctx, uint(chat.ID), content, userID)
+	if err != nil {
+		return fmt.Errorf("failed to save user message: %w", err)
+	}
+	s.announcePrompt(nil, userMsg)
 
-// generateResponse generates a non-streaming AI response
-func (s *Service) generateResponse(ctx context.Context, chatID uint, content string, messages []openrouter.ChatMessage) error {
-	response, err := s.openRouter.GenerateResponse(ctx, content, messages)
+	completion, err := s.openRouter.GenerateCompletion(withProviderKey(ctx, key), workspaceModel("", workspace), content, messages)
+	s.settleGeneration(userID, workspaceID(workspace), 0, key, completion, err)
 	if err != nil {
-		return fmt.Errorf("failed to generate response: %w", err)
+		return fmt.Errorf("failed to generate response: %w", describeProviderError(err, key))
 	}
 
+	response := completion.Content
 	aiMsg := &models.Message{
-		ChatID:    uint64(chatID),
+		ChatID:    uint64(chat.ID),
 		Content:   response,
 		Role:      "assistant",
 		Timestamp: time.Now(),
+		ParentID:  &userMsg.ID,
 		Metadata: models.MessageMetadata{
-			Model:      s.config.Model,
+			Model:      completion.Model,
 			TokenCount: len(response) / 4,
+			Cost:       completion.Usage.Cost,
 		},
 	}
 
 	// Use messageRepo instead of chatRepo
-	return s.messageRepo.CreateMessage(ctx, aiMsg)
+	if err := s.messageRepo.CreateMessage(ctx, aiMsg); err != nil {
+		return err
+	}
+	s.publishMessage(events.MessageCreated, aiMsg)
+	return nil
 }
 
 // convertMessagesToOpenRouterFormat converts database messages to OpenRouter format
 func (s *Service) convertMessagesToOpenRouterFormat(messages []models.Message) []openrouter.ChatMessage {
+	// Replies that never completed are not part of the conversation
+	complete := make([]models.Message, 0, len(messages))
+	for _, msg := range messages {
+		if msg.Status == models.MessageStatusComplete || msg.Status == "" {
+			complete = append(complete, msg)
+		}
+	}
+	messages = complete
+
 	// Limit to last 10 messages to avoid context length issues
 	startIdx := 0
 	if len(messages) > 10 {
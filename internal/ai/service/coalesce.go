@@ -0,0 +1,78 @@
+package service
+
+import (
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultStreamFlushInterval is how long streamed deltas are buffered before being sent
+	DefaultStreamFlushInterval = 50 * time.Millisecond
+
+	// DefaultStreamFlushBytes is the buffered size at which deltas are sent immediately
+	DefaultStreamFlushBytes = 512
+)
+
+// coalescer batches streamed deltas into fewer, larger frames.
+// Buffered content is released once FlushInterval has passed since the first
+// buffered delta or once FlushBytes have accumulated, whichever comes first.
+// Every released batch gets the next sequence number so clients can detect gaps.
+// It is not safe for concurrent use; the owning job serializes access.
+type coalescer struct {
+	interval time.Duration
+	maxBytes int
+	pending  strings.Builder
+	seq      uint64
+	timer    *time.Timer
+}
+
+// newCoalescer creates a coalescer with the given flush thresholds
+func newCoalescer(interval time.Duration, maxBytes int) *coalescer {
+	return &coalescer{
+		interval: interval,
+		maxBytes: maxBytes,
+	}
+}
+
+// add buffers a delta and reports whether the buffer should be flushed right away
+func (c *coalescer) add(delta string) bool {
+	c.pending.WriteString(delta)
+	return c.pending.Len() >= c.maxBytes || c.interval <= 0
+}
+
+// schedule arranges for flush to run after the flush interval unless a flush is already pending
+func (c *coalescer) schedule(flush func()) {
+	if c.timer != nil || c.pending.Len() == 0 {
+		return
+	}
+	c.timer = time.AfterFunc(c.interval, flush)
+}
+
+// take returns the buffered content with its sequence number and resets the buffer.
+// ok is false if nothing was buffered.
+func (c *coalescer) take() (content string, seq uint64, ok bool) {
+	c.stop()
+
+	if c.pending.Len() == 0 {
+		return "", c.seq, false
+	}
+
+	content = c.pending.String()
+	c.pending.Reset()
+	c.seq++
+
+	return content, c.seq, true
+}
+
+// buffered returns the number of bytes not flushed yet
+func (c *coalescer) buffered() int {
+	return c.pending.Len()
+}
+
+// stop cancels a scheduled flush
+func (c *coalescer) stop() {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hra42/7x42/pkg/protocol"
+)
+
+// MaxCompareModels is the maximum number of models a single comparison can fan out to
+const MaxCompareModels = 4
+
+// HandleCompareMessage sends one prompt to several models concurrently and streams each reply tagged by model.
+// Every model runs as its own background job and stores its reply as a sibling assistant message.
+func (s *Service) HandleCompareMessage(w StreamWriter, chatID uint, content string, userID uint, modelIDs []string) error {
+	modelIDs = normalizeModels(modelIDs)
+	if len(modelIDs) == 0 {
+		return errors.New("at least one model is required")
+	}
+	if len(modelIDs) > MaxCompareModels {
+		return fmt.Errorf("at most %d models can be compared at once", MaxCompareModels)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// Load the history before saving the prompt so it is only sent once. Every model's
+	// generation is charged, so the preflight covers all of them before any starts.
+	chat, workspace, key, err := s.openChat(ctx, chatID, content, userID, modelIDs)
+	if err != nil {
+		return err
+	}
+	history := withPersona(s.convertMessagesToOpenRouterFormat(chat.Messages), workspace)
+
+	userMsg, err := s.saveUserMessage(ctx, uint(chat.ID), content, userID)
+	if err != nil {
+		return fmt.Errorf("failed to save user message: %w", err)
+	}
+	s.announcePrompt(w, userMsg)
+
+	jobs := make([]*job, 0, len(modelIDs))
+	for _, model := range modelIDs {
+		j, err := s.startJob(ctx, jobRequest{
+			chatID:      uint64(chat.ID),
+			userID:      userID,
+			parentID:    userMsg.ID,
+			workspaceID: workspaceID(workspace),
+			model:       model,
+			prompt:      content,
+			history:     history,
+			compare:     true,
+			key:         key,
+		}, w)
+		if err != nil {
+			log.Printf("Failed to start comparison job for %s: %v", model, err)
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+	if len(jobs) == 0 {
+		return errors.New("failed to start any comparison job")
+	}
+
+	// Announce the end of the comparison once every model has finished
+	correlationID := requestID(w)
+	go func() {
+		jobIDs := make([]uint, 0, len(jobs))
+		for _, j := range jobs {
+			<-j.done
+			jobIDs = append(jobIDs, j.record.ID)
+		}
+
+		frame := protocol.NewEnvelope(protocol.TypeCompareComplete, protocol.CompareComplete{
+			ChatID:    uint64(chat.ID),
+			ParentID:  userMsg.ID,
+			Models:    modelIDs,
+			JobIDs:    jobIDs,
+			Timestamp: time.Now(),
+		})
+		frame.ChatID = uint64(chat.ID)
+		frame.CorrelationID = correlationID
+		if err := w.SendJSON(frame); err != nil {
+			log.Printf("Error sending comparison completion: %v", err)
+		}
+		s.notifyWatchers(uint64(chat.ID), w, frame)
+	}()
+
+	return nil
+}
+
+// normalizeModels trims and de-duplicates the requested model IDs, preserving order
+func normalizeModels(modelIDs []string) []string {
+	seen := make(map[string]bool, len(modelIDs))
+	result := make([]string, 0, len(modelIDs))
+	for _, model := range modelIDs {
+		model = strings.TrimSpace(model)
+		if model == "" || seen[model] {
+			continue
+		}
+		seen[model] = true
+		result = append(result, model)
+	}
+	return result
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/hra42/7x42/internal/ai/openrouter"
 )
@@ -28,16 +30,39 @@ func CreateOpenRouterConfig(config Config) openrouter.Config {
 		Model:       config.Model,
 		Temperature: config.Temperature,
 		MaxTokens:   config.MaxTokens,
+
+		FallbackModels: config.FallbackModels,
+	}
+}
+
+// SetDefaults sets default values for optional configuration
+func (c *Config) SetDefaults() {
+	if c.JobTimeout == 0 {
+		c.JobTimeout = DefaultJobTimeout
+	}
+	if c.JobFlushInterval == 0 {
+		c.JobFlushInterval = DefaultJobFlushInterval
+	}
+	if c.StreamFlushInterval == 0 {
+		c.StreamFlushInterval = DefaultStreamFlushInterval
+	}
+	if c.StreamFlushBytes == 0 {
+		c.StreamFlushBytes = DefaultStreamFlushBytes
 	}
 }
 
 // LoadConfigFromEnv loads service configuration from environment variables
 func LoadConfigFromEnv() Config {
 	return Config{
-		OpenRouterKey: os.Getenv("OPENROUTER_API_KEY"),
-		Model:         getEnvWithDefault("OPENROUTER_MODEL", "google/gemini-2.0-flash-001"),
-		Temperature:   getEnvAsFloat("OPENROUTER_TEMPERATURE", 0.7),
-		MaxTokens:     getEnvAsInt("OPENROUTER_MAX_TOKENS", 1000),
+		OpenRouterKey:  os.Getenv("OPENROUTER_API_KEY"),
+		Model:          getEnvWithDefault("OPENROUTER_MODEL", "google/gemini-2.0-flash-001"),
+		Temperature:    getEnvAsFloat("OPENROUTER_TEMPERATURE", 0.7),
+		MaxTokens:      getEnvAsInt("OPENROUTER_MAX_TOKENS", 1000),
+		FallbackModels: getEnvAsList("OPENROUTER_FALLBACK_MODELS"),
+		JobTimeout:     getEnvAsDuration("GENERATION_JOB_TIMEOUT", DefaultJobTimeout),
+
+		StreamFlushInterval: getEnvAsDuration("STREAM_FLUSH_INTERVAL", DefaultStreamFlushInterval),
+		StreamFlushBytes:    getEnvAsInt("STREAM_FLUSH_BYTES", DefaultStreamFlushBytes),
 	}
 }
 
@@ -68,3 +93,24 @@ func getEnvAsInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+// getEnvAsDuration parses an environment variable as a time.Duration
+func getEnvAsDuration(key string, defaultValue time.Duration) time.Duration {
+	if value, exists := os.LookupEnv(key); exists {
+		if duration, err := time.ParseDuration(value); err == nil {
+			return duration
+		}
+	}
+	return defaultValue
+}
+
+// getEnvAsList parses a comma-separated environment variable, skipping empty entries
+func getEnvAsList(key string) []string {
+	var result []string
+	for _, item := range strings.Split(os.Getenv(key), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
@@ -1,21 +1,31 @@
 package service
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"time"
 
 	"github.com/hra42/7x42/internal/ai/openrouter"
+	"github.com/hra42/7x42/internal/authz"
 	"github.com/hra42/7x42/internal/repository"
 	"gorm.io/gorm"
 )
 
+// interruptedReason is recorded on work left unfinished by a previous process
+const interruptedReason = "interrupted by server restart"
+
 // New creates a new AI service with the given configuration
 func New(config Config) (*Service, error) {
 	if err := ValidateConfig(config); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
 	}
+	config.SetDefaults()
 
 	chatRepo := repository.NewChatRepository(config.DB)
 	messageRepo := repository.NewMessageRepository(config.DB)
+	jobRepo := repository.NewJobRepository(config.DB)
+	workspaceRepo := repository.NewWorkspaceRepository(config.DB)
 
 	openRouterConfig := CreateOpenRouterConfig(config)
 	openRouterClient, err := openrouter.New(openRouterConfig)
@@ -30,12 +40,74 @@ func New(config Config) (*Service, error) {
 	openRouterClient.SetChatRepository(chatRepo)
 	openRouterClient.SetMessageRepository(messageRepo)
 
-	return &Service{
-		openRouter:  openRouterClient,
-		chatRepo:    chatRepo,
-		messageRepo: messageRepo,
-		config:      config,
-	}, nil
+	// Jobs left running by a previous process can never finish; their partial replies stay retryable.
+	// In a cluster other instances may still be running theirs, so only jobs idle for longer
+	// than any job may run are failed.
+	interruptedBefore := time.Now()
+	if config.Clustered {
+		interruptedBefore = interruptedBefore.Add(-config.JobTimeout)
+	}
+	if err := failInterrupted(jobRepo, messageRepo, interruptedBefore); err != nil {
+		return nil, err
+	}
+
+	runCtx, stop := context.WithCancel(context.Background())
+
+	s := &Service{
+		openRouter:    openRouterClient,
+		chatRepo:      chatRepo,
+		messageRepo:   messageRepo,
+		jobRepo:       jobRepo,
+		workspaceRepo: workspaceRepo,
+		authorizer:    authz.NewAuthorizer(chatRepo, messageRepo, workspaceRepo),
+		config:        config,
+		jobs:          make(map[uint]*job),
+		ctx:           runCtx,
+		stop:          stop,
+	}
+
+	// An instance that crashed or was restarted leaves its jobs to the others
+	if config.Clustered {
+		go s.sweepInterrupted()
+	}
+
+	return s, nil
+}
+
+// sweepInterrupted periodically fails jobs and replies left unfinished by other instances,
+// using the same cutoff as at startup, until the service is stopped
+func (s *Service) sweepInterrupted() {
+	ticker := time.NewTicker(s.config.JobTimeout)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := failInterrupted(s.jobRepo, s.messageRepo, time.Now().Add(-s.config.JobTimeout)); err != nil {
+				log.Printf("Error sweeping interrupted jobs: %v", err)
+			}
+		}
+	}
+}
+
+// failInterrupted marks jobs and replies still running but last updated before the given time as failed
+func failInterrupted(jobRepo *repository.JobRepository, messageRepo *repository.MessageRepository, before time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if n, err := jobRepo.FailInterruptedJobs(ctx, interruptedReason, before); err != nil {
+		return fmt.Errorf("failed to recover interrupted jobs: %w", err)
+	} else if n > 0 {
+		log.Printf("Marked %d interrupted generation jobs as failed", n)
+	}
+	if n, err := messageRepo.FailInterruptedMessages(ctx, interruptedReason, before); err != nil {
+		return fmt.Errorf("failed to recover interrupted messages: %w", err)
+	} else if n > 0 {
+		log.Printf("Marked %d interrupted replies as failed", n)
+	}
+	return nil
 }
 
 // NewFromDB creates a new AI service from a database connection
@@ -0,0 +1,646 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hra42/7x42/internal/ai/openrouter"
+	"github.com/hra42/7x42/internal/authz"
+	"github.com/hra42/7x42/internal/events"
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/providerkeys"
+	"github.com/hra42/7x42/internal/repository"
+	"github.com/hra42/7x42/pkg/protocol"
+)
+
+const (
+	// DefaultJobTimeout bounds how long a background generation may run
+	DefaultJobTimeout = 5 * time.Minute
+
+	// DefaultJobFlushInterval is how often job progress is persisted while streaming
+	DefaultJobFlushInterval = 2 * time.Second
+)
+
+// Job and retry errors
+var (
+	ErrJobNotFound     = errors.New("generation job not found")
+	ErrMessageNotFound = errors.New("message not found")
+	ErrNotRetryable    = errors.New("only failed or cancelled replies can be retried")
+	ErrProviderKey     = errors.New("provider API key error")
+)
+
+// jobRequest describes a generation to run in the background
+type jobRequest struct {
+	chatID   uint64
+	userID   uint
+	parentID uint
+	// workspaceID is set for chats in a workspace, whose spending counts against its budgets
+	workspaceID uint
+	model       string
+	prompt      string
+	history     []openrouter.ChatMessage
+	compare     bool
+	// messageID reuses an existing assistant message, e.g. when retrying a failed reply
+	messageID uint
+	// key is the user's own provider key, or nil to use the server's
+	key *providerkeys.Key
+}
+
+// job is a running generation with its buffered output and live subscribers
+type job struct {
+	record      *models.GenerationJob
+	compare     bool
+	key         *providerkeys.Key
+	workspaceID uint
+	// correlationID is the ID of the request that started the job, if it carried one
+	correlationID string
+
+	// mu protects the buffered content, stream state and subscriber set
+	mu          sync.Mutex
+	content     strings.Builder
+	stream      *coalescer
+	subscribers map[StreamWriter]struct{}
+	lastFlush   time.Time
+	cancelled   bool
+
+	// startedAt and firstTokenAt feed the latency metrics of the reply
+	startedAt    time.Time
+	firstTokenAt time.Time
+	// fallbackHops counts fallbacks taken by the job itself, e.g. from streaming to a plain request
+	fallbackHops int
+
+	// cancel aborts the generation
+	cancel context.CancelFunc
+	// done is closed once the job has finished and its final state is stored
+	done chan struct{}
+}
+
+// startJob creates the assistant message and job record, then runs the generation in the background.
+// The writer, if any, and the chat's watchers are subscribed before the first token is produced.
+func (s *Service) startJob(ctx context.Context, req jobRequest, w StreamWriter) (*job, error) {
+	model := req.model
+	if model == "" {
+		model = s.openRouter.DefaultModel()
+	}
+	req.model = model
+
+	parentID := req.parentID
+	aiMsg := &models.Message{
+		ID:        req.messageID,
+		ChatID:    req.chatID,
+		Role:      models.RoleAssistant,
+		Status:    models.MessageStatusPending,
+		Timestamp: time.Now(),
+		ParentID:  &parentID,
+		Metadata: models.MessageMetadata{
+			Model: model,
+		},
+	}
+	if req.messageID != 0 {
+		if err := s.messageRepo.UpdateMessageState(ctx, aiMsg); err != nil {
+			return nil, err
+		}
+		s.publishMessage(events.MessageUpdated, aiMsg)
+	} else {
+		if err := s.messageRepo.CreateMessage(ctx, aiMsg); err != nil {
+			return nil, err
+		}
+		s.publishMessage(events.MessageCreated, aiMsg)
+	}
+
+	record := &models.GenerationJob{
+		ChatID:    req.chatID,
+		UserID:    req.userID,
+		Model:     model,
+		Status:    models.JobStatusRunning,
+		ParentID:  &parentID,
+		MessageID: &aiMsg.ID,
+	}
+	if err := s.jobRepo.CreateJob(ctx, record); err != nil {
+		return nil, err
+	}
+
+	jobCtx, cancel := context.WithTimeout(s.ctx, s.config.JobTimeout)
+	j := &job{
+		record:        record,
+		compare:       req.compare,
+		key:           req.key,
+		workspaceID:   req.workspaceID,
+		correlationID: requestID(w),
+		stream:        newCoalescer(s.config.StreamFlushInterval, s.config.StreamFlushBytes),
+		subscribers:   make(map[StreamWriter]struct{}),
+		cancel:        cancel,
+		done:          make(chan struct{}),
+	}
+	if w != nil {
+		j.subscribers[w] = struct{}{}
+	}
+	for _, watcher := range s.watchersOf(req.chatID) {
+		j.subscribers[watcher] = struct{}{}
+	}
+
+	s.jobsMu.Lock()
+	s.jobs[record.ID] = j
+	s.jobsMu.Unlock()
+	s.publishGeneration(events.GenerationStarted, j, 0)
+
+	go s.runJob(jobCtx, j, req)
+
+	return j, nil
+}
+
+// runJob performs the generation and stores its outcome
+func (s *Service) runJob(ctx context.Context, j *job, req jobRequest) {
+	defer func() {
+		j.cancel()
+		s.jobsMu.Lock()
+		delete(s.jobs, j.record.ID)
+		s.jobsMu.Unlock()
+		close(j.done)
+	}()
+
+	j.broadcast(j.frame(protocol.TypeTyping, protocol.Generating{
+		JobID:  j.record.ID,
+		ChatID: j.record.ChatID,
+		Model:  j.record.Model,
+	}))
+
+	ctx = withProviderKey(ctx, req.key)
+	j.startedAt = time.Now()
+	completion, err := s.openRouter.StreamCompletion(ctx, req.model, req.prompt, req.history, func(delta string) error {
+		s.appendJobContent(j, delta)
+		return nil
+	})
+	j.flushPending()
+
+	// Fall back to a non-streaming request if the stream failed before producing anything,
+	// unless the key was rejected, which no other request can fix
+	if err != nil && ctx.Err() == nil && j.contentLength() == 0 && !openrouter.IsKeyError(err) {
+		log.Printf("Error streaming job %d, falling back to non-streaming: %v", j.record.ID, err)
+
+		j.fallbackHops++
+		fallback, fallbackErr := s.openRouter.GenerateCompletion(ctx, req.model, req.prompt, req.history)
+		if fallbackErr == nil {
+			s.appendJobContent(j, fallback.Content)
+			j.flushPending()
+			completion, err = fallback, nil
+		}
+	}
+
+	s.settleGeneration(j.record.UserID, j.workspaceID, j.record.ID, j.key, completion, err)
+	if err != nil {
+		s.failJob(j, describeProviderError(err, j.key))
+		return
+	}
+
+	s.completeJob(j, completion)
+}
+
+// appendJobContent buffers a delta for coalesced delivery to subscribers and periodically persists progress.
+// The first delta is persisted right away so the message moves to the streaming state.
+func (s *Service) appendJobContent(j *job, delta string) {
+	j.mu.Lock()
+	if j.firstTokenAt.IsZero() {
+		j.firstTokenAt = time.Now()
+	}
+	j.content.WriteString(delta)
+	if j.stream.add(delta) {
+		j.flushLocked()
+	} else {
+		j.stream.schedule(j.flushPending)
+	}
+
+	var progress string
+	flush := time.Since(j.lastFlush) >= s.config.JobFlushInterval
+	if flush {
+		j.lastFlush = time.Now()
+		progress = j.content.String()
+	}
+	j.mu.Unlock()
+
+	if flush {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.messageRepo.UpdateMessageProgress(ctx, *j.record.MessageID, progress); err != nil {
+			log.Printf("Failed to persist progress of job %d: %v", j.record.ID, err)
+		}
+		s.publishGeneration(events.GenerationProgress, j, len(progress))
+	}
+}
+
+// completeJob stores the finished reply and notifies subscribers
+func (s *Service) completeJob(j *job, completion *openrouter.Completion) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	content := j.contentString()
+
+	aiMsg := &models.Message{
+		ID:       *j.record.MessageID,
+		ChatID:   j.record.ChatID,
+		Role:     models.RoleAssistant,
+		ParentID: j.record.ParentID,
+		Content:  content,
+		Status:   models.MessageStatusComplete,
+		Metadata: j.metadata(completion, content),
+	}
+
+	if err := s.messageRepo.UpdateMessageState(ctx, aiMsg); err != nil {
+		log.Printf("Failed to save AI response for job %d: %v", j.record.ID, err)
+		s.failJob(j, err)
+		return
+	}
+
+	j.record.Status = models.JobStatusCompleted
+	j.record.Content = content
+	if err := s.jobRepo.FinishJob(ctx, j.record); err != nil {
+		log.Printf("Failed to store completion of job %d: %v", j.record.ID, err)
+	}
+
+	s.publishMessage(events.MessageUpdated, aiMsg)
+	s.publishGeneration(events.GenerationFinished, j, 0)
+	j.broadcast(j.completeFrame(aiMsg, completion.Usage))
+}
+
+// failJob stores a failed or cancelled reply, keeping the partial content, and notifies subscribers
+func (s *Service) failJob(j *job, jobErr error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	j.mu.Lock()
+	cancelled := j.cancelled
+	j.mu.Unlock()
+
+	aiMsg := &models.Message{
+		ID:       *j.record.MessageID,
+		ChatID:   j.record.ChatID,
+		Role:     models.RoleAssistant,
+		ParentID: j.record.ParentID,
+		Content:  j.contentString(),
+		Status:   models.MessageStatusFailed,
+		Error:    jobErr.Error(),
+		Metadata: models.MessageMetadata{
+			Model: j.record.Model,
+		},
+	}
+	j.record.Status = models.JobStatusFailed
+	if cancelled {
+		aiMsg.Status = models.MessageStatusCancelled
+		aiMsg.Error = "cancelled by user"
+		j.record.Status = models.JobStatusCancelled
+	} else {
+		log.Printf("Generation job %d failed: %v", j.record.ID, jobErr)
+	}
+
+	if err := s.messageRepo.UpdateMessageState(ctx, aiMsg); err != nil {
+		log.Printf("Failed to store failed reply of job %d: %v", j.record.ID, err)
+	}
+
+	j.record.Content = aiMsg.Content
+	j.record.Error = aiMsg.Error
+	if err := s.jobRepo.FinishJob(ctx, j.record); err != nil {
+		log.Printf("Failed to store failure of job %d: %v", j.record.ID, err)
+	}
+
+	s.publishMessage(events.MessageUpdated, aiMsg)
+	s.publishGeneration(events.GenerationFinished, j, 0)
+
+	if cancelled {
+		j.broadcast(j.cancelledFrame())
+		return
+	}
+	code := "generation_failed"
+	if errors.Is(jobErr, ErrProviderKey) {
+		code = "provider_key_error"
+	}
+	j.broadcast(j.errorFrame(aiMsg.Error, code))
+}
+
+// metadata builds the usage and performance metrics of a completed reply
+func (j *job) metadata(completion *openrouter.Completion, content string) models.MessageMetadata {
+	finishedAt := time.Now()
+	latency := finishedAt.Sub(j.startedAt)
+
+	j.mu.Lock()
+	firstTokenAt := j.firstTokenAt
+	j.mu.Unlock()
+	if firstTokenAt.IsZero() {
+		firstTokenAt = finishedAt
+	}
+
+	metadata := models.MessageMetadata{
+		Model:            completion.Model,
+		TokenCount:       completion.Usage.CompletionTokens,
+		ProcessTime:      int(latency.Milliseconds()),
+		PromptTokens:     completion.Usage.PromptTokens,
+		CompletionTokens: completion.Usage.CompletionTokens,
+		Cost:             completion.Usage.Cost,
+		TimeToFirstToken: int(firstTokenAt.Sub(j.startedAt).Milliseconds()),
+		Latency:          int(latency.Milliseconds()),
+		RetryCount:       completion.Retries,
+		Provider:         completion.Provider,
+		FallbackHops:     j.fallbackHops + completion.FallbackHops,
+	}
+	if metadata.TokenCount == 0 {
+		metadata.TokenCount = models.EstimateTokenCount(content)
+	}
+
+	// Throughput is measured over the generation itself, after the first token
+	generation := finishedAt.Sub(firstTokenAt)
+	if generation <= 0 {
+		generation = latency
+	}
+	if generation > 0 {
+		metadata.TokensPerSecond = math.Round(float64(metadata.TokenCount)/generation.Seconds()*100) / 100
+	}
+
+	return metadata
+}
+
+// CancelJob stops a running job in a chat the user may prompt in; its partial reply is kept as cancelled
+func (s *Service) CancelJob(jobID uint, userID uint) error {
+	s.jobsMu.RLock()
+	j, ok := s.jobs[jobID]
+	s.jobsMu.RUnlock()
+
+	if !ok {
+		return ErrJobNotFound
+	}
+	if err := s.authorizeJob(j.record, userID, authz.ActionPrompt); err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	j.cancelled = true
+	j.mu.Unlock()
+	j.cancel()
+
+	return nil
+}
+
+// SubscribeJobs attaches a writer to running jobs, replaying the tokens buffered so far.
+// A non-zero jobID selects a single job; otherwise all running jobs in chatID are resumed.
+func (s *Service) SubscribeJobs(w StreamWriter, chatID uint, jobID uint, userID uint) error {
+	if jobID != 0 {
+		return s.subscribeJob(w, jobID, userID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := s.authorizer.ChatInfo(ctx, userID, uint64(chatID), authz.ActionRead); err != nil {
+		return err
+	}
+
+	s.jobsMu.RLock()
+	var running []*job
+	for _, j := range s.jobs {
+		if j.record.ChatID == uint64(chatID) {
+			running = append(running, j)
+		}
+	}
+	s.jobsMu.RUnlock()
+
+	for _, j := range running {
+		j.subscribe(w)
+	}
+
+	return nil
+}
+
+// subscribeJob attaches a writer to a single job, falling back to its stored state once finished
+func (s *Service) subscribeJob(w StreamWriter, jobID uint, userID uint) error {
+	s.jobsMu.RLock()
+	j, ok := s.jobs[jobID]
+	s.jobsMu.RUnlock()
+
+	if ok {
+		if err := s.authorizeJob(j.record, userID, authz.ActionRead); err != nil {
+			return err
+		}
+		j.subscribe(w)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	record, err := s.jobRepo.GetJob(ctx, jobID)
+	if err != nil {
+		if repository.IsNotFound(err) {
+			return ErrJobNotFound
+		}
+		return err
+	}
+	if err := s.authorizeJob(record, userID, authz.ActionRead); err != nil {
+		return err
+	}
+
+	// A job still running belongs to another instance; its output follows through the chat's room
+	finished := &job{record: record}
+	if err := w.SendJSON(finished.chunkFrame(record.Content, 0, true)); err != nil || record.IsRunning() {
+		return err
+	}
+	switch record.Status {
+	case models.JobStatusFailed:
+		return w.SendJSON(finished.errorFrame(record.Error, "generation_failed"))
+	case models.JobStatusCancelled:
+		return w.SendJSON(finished.cancelledFrame())
+	}
+
+	return w.SendJSON(finished.completeFrame(nil, openrouter.Usage{}))
+}
+
+// authorizeJob checks that the user may perform action on the chat a job belongs to
+func (s *Service) authorizeJob(record *models.GenerationJob, userID uint, action authz.Action) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := s.authorizer.ChatInfo(ctx, userID, record.ChatID, action); err != nil {
+		if repository.IsNotFound(err) {
+			return ErrJobNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// Stop cancels running jobs and waits briefly for them to store their final state
+func (s *Service) Stop() {
+	s.stop()
+
+	s.jobsMu.RLock()
+	pending := make([]*job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		pending = append(pending, j)
+	}
+	s.jobsMu.RUnlock()
+
+	timeout := time.After(10 * time.Second)
+	for _, j := range pending {
+		select {
+		case <-j.done:
+		case <-timeout:
+			log.Printf("Timed out waiting for generation jobs to stop")
+			return
+		}
+	}
+}
+
+// subscribe replays the content delivered so far to w and adds it to the live subscribers.
+// Content still held by the coalescer reaches w with the next flush.
+func (j *job) subscribe(w StreamWriter) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	delivered := j.content.String()
+	delivered = delivered[:len(delivered)-j.stream.buffered()]
+	if err := w.SendJSON(j.chunkFrame(delivered, j.stream.seq, true)); err != nil {
+		return
+	}
+	j.subscribers[w] = struct{}{}
+}
+
+// flushPending sends the content held by the coalescer to every subscriber
+func (j *job) flushPending() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.flushLocked()
+}
+
+// flushLocked sends the content held by the coalescer; j.mu must be held
+func (j *job) flushLocked() {
+	if content, seq, ok := j.stream.take(); ok {
+		j.sendLocked(j.chunkFrame(content, seq, false))
+	}
+}
+
+// broadcast sends a frame to every subscriber, stamped with the current sequence number
+func (j *job) broadcast(frame *protocol.Envelope) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.stream != nil {
+		frame.Seq = j.stream.seq
+	}
+	j.sendLocked(frame)
+}
+
+// sendLocked sends a frame to every subscriber, dropping the ones that fail.
+// The generation itself keeps running when a subscriber goes away.
+func (j *job) sendLocked(frame interface{}) {
+	for w := range j.subscribers {
+		if err := w.SendJSON(frame); err != nil {
+			delete(j.subscribers, w)
+		}
+	}
+}
+
+// contentString returns the content generated so far
+func (j *job) contentString() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.content.String()
+}
+
+// contentLength returns the number of bytes generated so far
+func (j *job) contentLength() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.content.Len()
+}
+
+// frameType returns the WebSocket message type used for this job's output
+func (j *job) frameType() protocol.Type {
+	if j.compare {
+		return protocol.TypeCompareMessage
+	}
+	return protocol.TypeChatMessage
+}
+
+// frame builds a frame about this job, naming its chat and the request that started it
+func (j *job) frame(t protocol.Type, content interface{}) *protocol.Envelope {
+	frame := protocol.NewEnvelope(t, content)
+	frame.ChatID = j.record.ChatID
+	frame.CorrelationID = j.correlationID
+	return frame
+}
+
+// chunkFrame builds a frame carrying generated content.
+// Replay frames carry everything delivered up to seq and replace what the client has shown.
+func (j *job) chunkFrame(content string, seq uint64, replay bool) *protocol.Envelope {
+	chunk := protocol.Chunk{
+		JobID:     j.record.ID,
+		ChatID:    j.record.ChatID,
+		MessageID: j.record.MessageID,
+		Content:   content,
+		Role:      models.RoleAssistant,
+		Replay:    replay,
+		Timestamp: time.Now(),
+	}
+	if j.compare {
+		chunk.Model = j.record.Model
+	}
+
+	frame := j.frame(j.frameType(), chunk)
+	frame.Seq = seq
+	return frame
+}
+
+// completeFrame builds the frame announcing that the job has finished
+func (j *job) completeFrame(message *models.Message, usage openrouter.Usage) *protocol.Envelope {
+	metadata := protocol.Completion{
+		Complete: true,
+		JobID:    j.record.ID,
+		ParentID: j.record.ParentID,
+		Usage:    (*protocol.Usage)(&usage),
+	}
+	if j.record.MessageID != nil {
+		metadata.MessageID = *j.record.MessageID
+	}
+	if message != nil {
+		metadata.ProcessingTime = message.Metadata.ProcessTime
+	}
+
+	var content interface{}
+	if j.compare {
+		content = protocol.ModelRef{Model: j.record.Model}
+	}
+	frame := j.frame(j.frameType(), content)
+	frame.Metadata, _ = json.Marshal(metadata)
+	return frame
+}
+
+// errorFrame builds the frame reporting a failed job
+func (j *job) errorFrame(message, code string) *protocol.Envelope {
+	content := protocol.Error{
+		Message:   message,
+		Code:      code,
+		JobID:     j.record.ID,
+		ChatID:    j.record.ChatID,
+		MessageID: j.record.MessageID,
+	}
+	if j.compare {
+		content.Model = j.record.Model
+	}
+
+	return j.frame(protocol.TypeError, content)
+}
+
+// cancelledFrame builds the frame reporting a job cancelled by the user
+func (j *job) cancelledFrame() *protocol.Envelope {
+	return j.frame(protocol.TypeGenerationCancelled, protocol.JobStatus{
+		JobID:     j.record.ID,
+		ChatID:    j.record.ChatID,
+		MessageID: j.record.MessageID,
+	})
+}
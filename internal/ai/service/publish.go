@@ -0,0 +1,33 @@
+package service
+
+import (
+	"github.com/hra42/7x42/internal/events"
+	"github.com/hra42/7x42/internal/models"
+)
+
+// publishMessage publishes a created or changed message to its chat's topic
+func (s *Service) publishMessage(name string, message *models.Message) {
+	s.config.Events.Publish(name, message.ToMap(), events.ChatTopic(message.ChatID))
+}
+
+// publishChat publishes a created or changed chat to its own topic and the one of its chat list
+func (s *Service) publishChat(name string, chat *models.Chat) {
+	s.config.Events.Publish(name, chat.ToMap(), events.ChatTopics(uint64(chat.ID), chat.UserID, chat.WorkspaceID)...)
+}
+
+// publishGeneration publishes a change in a job's state to its chat's topic.
+// Progress events carry the length generated so far, not the content; the content
+// streams to the chat's room.
+func (s *Service) publishGeneration(name string, j *job, length int) {
+	data := map[string]interface{}{
+		"jobId":     j.record.ID,
+		"chatId":    j.record.ChatID,
+		"messageId": j.record.MessageID,
+		"model":     j.record.Model,
+		"status":    j.record.Status,
+	}
+	if name == events.GenerationProgress {
+		data["length"] = length
+	}
+	s.config.Events.Publish(name, data, events.ChatTopic(j.record.ChatID))
+}
@@ -1,8 +1,15 @@
 package service
 
 import (
-	"github.com/gofiber/websocket/v2"
+	"context"
+	"sync"
+	"time"
+
 	"github.com/hra42/7x42/internal/ai/openrouter"
+	"github.com/hra42/7x42/internal/authz"
+	"github.com/hra42/7x42/internal/credits"
+	"github.com/hra42/7x42/internal/events"
+	"github.com/hra42/7x42/internal/providerkeys"
 	"github.com/hra42/7x42/internal/repository"
 	"gorm.io/gorm"
 )
@@ -10,7 +17,27 @@ import (
 // Provider defines the interface for AI service providers
 type Provider interface {
 	Initialize() error
-	HandleChatMessage(wsConn *websocket.Conn, chatID uint, content string, userID string) error
+	HandleChatMessage(w StreamWriter, chatID uint, content string, userID uint) error
+}
+
+// StreamWriter receives the frames produced by a generation job
+type StreamWriter interface {
+	SendJSON(data interface{}) error
+}
+
+// RequestWriter is a StreamWriter serving a request that carried an ID, such as a WebSocket
+// connection. The frames of the generations the request starts carry the ID as correlation ID.
+type RequestWriter interface {
+	StreamWriter
+	RequestID() string
+}
+
+// requestID returns the ID of the request a writer is serving, if any
+func requestID(w StreamWriter) string {
+	if rw, ok := w.(RequestWriter); ok {
+		return rw.RequestID()
+	}
+	return ""
 }
 
 // Config holds the configuration for the AI service
@@ -20,6 +47,24 @@ type Config struct {
 	Model         string
 	Temperature   float64
 	MaxTokens     int
+	// FallbackModels are tried in order when the requested model is unavailable
+	FallbackModels []string
+	// JobTimeout bounds how long a background generation may run
+	JobTimeout time.Duration
+	// JobFlushInterval controls how often job progress is persisted
+	JobFlushInterval time.Duration
+	// StreamFlushInterval is how long streamed deltas are coalesced before being sent
+	StreamFlushInterval time.Duration
+	// StreamFlushBytes sends coalesced deltas early once this many bytes are buffered
+	StreamFlushBytes int
+	// Credits, if set, checks budgets before each generation and charges its cost afterwards
+	Credits *credits.Service
+	// ProviderKeys, if set, runs generations of users who stored their own key on that key
+	ProviderKeys *providerkeys.Service
+	// Events, if set, receives the messages and generations of every chat as they change
+	Events *events.Bus
+	// Clustered is set when other instances share the database and may be running generations
+	Clustered bool
 }
 
 // Service is the main AI service that coordinates AI providers
@@ -27,5 +72,18 @@ type Service struct {
 	openRouter  *openrouter.Client
 	chatRepo    *repository.ChatRepository
 	messageRepo *repository.MessageRepository
-	config      Config
+	jobRepo     *repository.JobRepository
+	// workspaceRepo loads the persona and default model of workspace chats
+	workspaceRepo *repository.WorkspaceRepository
+	authorizer    *authz.Authorizer
+	config        Config
+	// watchers are the writers following chats besides the ones starting generations
+	watchers ChatWatchers
+
+	// jobs holds the generations currently running in this process
+	jobs   map[uint]*job
+	jobsMu sync.RWMutex
+	// ctx is cancelled when the service stops, aborting running jobs
+	ctx  context.Context
+	stop context.CancelFunc
 }
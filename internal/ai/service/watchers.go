@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"github.com/hra42/7x42/internal/authz"
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/pkg/protocol"
+)
+
+// ChatWatchers supplies the writers following a chat, such as the connections in a
+// collaborative chat room. Every generation in the chat streams to them as well as
+// to the writer that started it.
+type ChatWatchers interface {
+	ChatWatchers(chatID uint64) []StreamWriter
+}
+
+// SetChatWatchers registers who follows chats; it must be called before the service is used
+func (s *Service) SetChatWatchers(watchers ChatWatchers) {
+	s.watchers = watchers
+}
+
+// ChatAccess reports whether a user may follow a chat and whether they may also prompt in it
+func (s *Service) ChatAccess(ctx context.Context, userID uint, chatID uint) (bool, error) {
+	if _, err := s.authorizer.ChatInfo(ctx, userID, uint64(chatID), authz.ActionRead); err != nil {
+		return false, err
+	}
+	if _, err := s.authorizer.ChatInfo(ctx, userID, uint64(chatID), authz.ActionPrompt); err != nil {
+		if errors.Is(err, authz.ErrForbidden) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// watchersOf returns the writers following a chat
+func (s *Service) watchersOf(chatID uint64) []StreamWriter {
+	if s.watchers == nil {
+		return nil
+	}
+	return s.watchers.ChatWatchers(chatID)
+}
+
+// FollowJobs attaches a watcher to the generations already running in a chat, replaying
+// what they delivered so far. The caller vouches for the watcher's access to the chat.
+func (s *Service) FollowJobs(w StreamWriter, chatID uint64) {
+	s.jobsMu.RLock()
+	var running []*job
+	for _, j := range s.jobs {
+		if j.record.ChatID == chatID {
+			running = append(running, j)
+		}
+	}
+	s.jobsMu.RUnlock()
+
+	for _, j := range running {
+		j.subscribe(w)
+	}
+}
+
+// notifyWatchers sends a frame to the writers following a chat, skipping the one that caused it
+func (s *Service) notifyWatchers(chatID uint64, except StreamWriter, frame interface{}) {
+	for _, w := range s.watchersOf(chatID) {
+		if w == except {
+			continue
+		}
+		if err := w.SendJSON(frame); err != nil {
+			log.Printf("Error notifying watcher of chat %d: %v", chatID, err)
+		}
+	}
+}
+
+// announcePrompt shows a participant's prompt to everyone else following the chat
+func (s *Service) announcePrompt(w StreamWriter, message *models.Message) {
+	frame := protocol.NewEnvelope(protocol.TypeParticipantMessage, message.ToMap())
+	frame.ChatID = message.ChatID
+	frame.CorrelationID = requestID(w)
+	s.notifyWatchers(message.ChatID, w, frame)
+}
@@ -0,0 +1,116 @@
+package audit
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/repository"
+	"gorm.io/gorm"
+)
+
+// Audited actions, grouped by category prefix
+const (
+	ActionRegister      = "auth.register"
+	ActionLogin         = "auth.login"
+	ActionLoginFailed   = "auth.login_failed"
+	ActionLogout        = "auth.logout"
+	ActionTOTPEnable    = "auth.totp_enable"
+	ActionTOTPDisable   = "auth.totp_disable"
+	ActionRecoveryCodes = "auth.recovery_codes"
+
+	ActionAPIKeyCreate = "apikey.create"
+	ActionAPIKeyRevoke = "apikey.revoke"
+
+	ActionProviderKeySet    = "providerkey.set"
+	ActionProviderKeyDelete = "providerkey.delete"
+
+	ActionChatDelete            = "chat.delete"
+	ActionChatShare             = "chat.share"
+	ActionChatShareRevoke       = "chat.share_revoke"
+	ActionChatParticipantAdd    = "chat.participant_add"
+	ActionChatParticipantUpdate = "chat.participant_update"
+	ActionChatParticipantRemove = "chat.participant_remove"
+
+	ActionWorkspaceCreate       = "workspace.create"
+	ActionWorkspaceUpdate       = "workspace.update"
+	ActionWorkspaceDelete       = "workspace.delete"
+	ActionWorkspaceMemberAdd    = "workspace.member_add"
+	ActionWorkspaceMemberUpdate = "workspace.member_update"
+	ActionWorkspaceMemberRemove = "workspace.member_remove"
+
+	ActionUserUpdate     = "admin.user_update"
+	ActionPasswordReset  = "admin.password_reset"
+	ActionSessionsRevoke = "admin.sessions_revoke"
+	ActionTOTPReset      = "admin.totp_reset"
+	ActionPolicyUpdate   = "admin.policy_update"
+
+	ActionCreditGrant  = "credits.grant"
+	ActionBudgetUpdate = "credits.budget_update"
+	ActionBudgetDelete = "credits.budget_delete"
+
+	ActionAuditExport = "audit.export"
+)
+
+// Target types
+const (
+	TargetUser      = "user"
+	TargetChat      = "chat"
+	TargetAPIKey    = "api_key"
+	TargetRole      = "role"
+	TargetAuditLog  = "audit_log"
+	TargetBudget    = "budget"
+	TargetProvider  = "provider_key"
+	TargetWorkspace = "workspace"
+)
+
+// writeTimeout bounds how long recording an event may take
+const writeTimeout = 5 * time.Second
+
+// Logger appends events to the audit log
+type Logger struct {
+	repo *repository.AuditRepository
+}
+
+// NewLogger creates a new audit logger
+func NewLogger(db *gorm.DB) *Logger {
+	return &Logger{
+		repo: repository.NewAuditRepository(db),
+	}
+}
+
+// Record appends an event. It runs on its own context so an event is not lost when the
+// request that caused it is cancelled, and failures are logged rather than failing the request.
+func (l *Logger) Record(event *models.AuditEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+
+	if event.CreatedAt.IsZero() {
+		event.CreatedAt = time.Now()
+	}
+
+	if err := l.repo.CreateAuditEvent(ctx, event); err != nil {
+		log.Printf("Failed to record audit event %s for %s %s: %v", event.Action, event.TargetType, event.TargetID, err)
+	}
+}
+
+// List lists events matching the filter, newest first, with the total count
+func (l *Logger) List(ctx context.Context, filter repository.AuditFilter, page, pageSize int) ([]models.AuditEvent, int64, error) {
+	events, err := l.repo.ListAuditEvents(ctx, filter, page, pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total, err := l.repo.CountAuditEvents(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, nil
+}
+
+// Scan lists events matching the filter after an ID, oldest first, for exports
+func (l *Logger) Scan(ctx context.Context, filter repository.AuditFilter, afterID uint, limit int) ([]models.AuditEvent, error) {
+	return l.repo.ListAuditEventsAfter(ctx, filter, afterID, limit)
+}
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hra42/7x42/internal/models"
+)
+
+// Administration errors
+var (
+	ErrInvalidRole = errors.New("invalid role")
+	ErrLastAdmin   = errors.New("cannot remove the last active admin")
+)
+
+// UserUpdate describes an administrative change to an account; nil fields are left unchanged
+type UserUpdate struct {
+	Role   *string
+	Active *bool
+}
+
+// UpdateUser changes an account's role or active flag.
+// Disabling an account ends its sessions. The last active admin cannot be demoted or disabled.
+func (s *Service) UpdateUser(ctx context.Context, userID uint, update UserUpdate) (*models.User, error) {
+	user, err := s.userRepo.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]interface{})
+	if update.Role != nil && *update.Role != user.Role {
+		if !models.IsValidUserRole(*update.Role) {
+			return nil, ErrInvalidRole
+		}
+		fields["role"] = *update.Role
+	}
+	if update.Active != nil && *update.Active != user.Active {
+		fields["active"] = *update.Active
+	}
+	if len(fields) == 0 {
+		return user, nil
+	}
+
+	losesAdmin := user.IsAdmin() && user.Active &&
+		((update.Role != nil && *update.Role != models.UserRoleAdmin) || (update.Active != nil && !*update.Active))
+	if losesAdmin {
+		admins, err := s.userRepo.CountUsersByRole(ctx, models.UserRoleAdmin)
+		if err != nil {
+			return nil, err
+		}
+		if admins <= 1 {
+			return nil, ErrLastAdmin
+		}
+	}
+
+	if err := s.userRepo.UpdateUserFields(ctx, userID, fields); err != nil {
+		return nil, err
+	}
+
+	if update.Active != nil && !*update.Active {
+		if _, err := s.RevokeSessions(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.userRepo.GetUser(ctx, userID)
+}
+
+// ResetPassword sets a new password for an account and ends its sessions
+func (s *Service) ResetPassword(ctx context.Context, userID uint, password string) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	if err := s.userRepo.UpdateUserFields(ctx, userID, map[string]interface{}{
+		"password_hash": hash,
+	}); err != nil {
+		return err
+	}
+
+	_, err = s.RevokeSessions(ctx, userID)
+	return err
+}
+
+// RevokeSessions ends all login sessions of an account
+func (s *Service) RevokeSessions(ctx context.Context, userID uint) (int64, error) {
+	return s.sessionRepo.DeleteUserSessions(ctx, userID)
+}
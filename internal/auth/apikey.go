@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/repository"
+)
+
+// API key scopes
+const (
+	ScopeChatRead    = "chat:read"
+	ScopeChatWrite   = "chat:write"
+	ScopeCompletions = "completions"
+)
+
+// Scopes lists every scope an API key can be granted
+var Scopes = []string{ScopeChatRead, ScopeChatWrite, ScopeCompletions}
+
+// APIKeyPrefix marks a bearer token as an API key rather than a session token
+const APIKeyPrefix = "7x42_"
+
+// API key errors
+var (
+	ErrInvalidAPIKey = errors.New("invalid, expired or revoked API key")
+	ErrInvalidScope  = errors.New("invalid scope")
+	ErrMissingScope  = errors.New("API key lacks the required scope")
+	ErrKeyNameEmpty  = errors.New("API key name is required")
+	ErrInvalidExpiry = errors.New("API key expiry must be in the future")
+)
+
+// IsAPIKey reports whether a bearer token looks like an API key
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// CreateAPIKey mints a new API key for the user.
+// The returned key is only available here; the database stores its hash.
+func (s *Service) CreateAPIKey(ctx context.Context, userID uint, name string, scopes []string, expiresAt *time.Time) (string, *models.APIKey, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, ErrKeyNameEmpty
+	}
+	scopes, err := normalizeScopes(scopes)
+	if err != nil {
+		return "", nil, err
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return "", nil, ErrInvalidExpiry
+	}
+
+	id := make([]byte, 6)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+	secret, err := NewToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	// Hex keeps the separator unambiguous; the secret part may contain underscores
+	prefix := APIKeyPrefix + hex.EncodeToString(id)
+	key := prefix + "_" + secret
+
+	record := &models.APIKey{
+		UserID:    userID,
+		Name:      truncate(name, 255),
+		Prefix:    prefix,
+		KeyHash:   HashToken(key),
+		Scopes:    strings.Join(scopes, ","),
+		ExpiresAt: expiresAt,
+	}
+	if err := s.apiKeyRepo.CreateAPIKey(ctx, record); err != nil {
+		return "", nil, err
+	}
+
+	return key, record, nil
+}
+
+// ListAPIKeys lists the user's API keys
+func (s *Service) ListAPIKeys(ctx context.Context, userID uint) ([]models.APIKey, error) {
+	return s.apiKeyRepo.ListUserAPIKeys(ctx, userID)
+}
+
+// RevokeAPIKey revokes one of the user's API keys
+func (s *Service) RevokeAPIKey(ctx context.Context, userID, id uint) error {
+	return s.apiKeyRepo.RevokeAPIKey(ctx, userID, id)
+}
+
+// AuthenticateAPIKey resolves an API key to its user and records the use
+func (s *Service) AuthenticateAPIKey(ctx context.Context, key string) (*models.User, *models.APIKey, error) {
+	prefix, _, ok := strings.Cut(strings.TrimPrefix(key, APIKeyPrefix), "_")
+	if !IsAPIKey(key) || !ok {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	record, err := s.apiKeyRepo.GetAPIKeyByPrefix(ctx, APIKeyPrefix+prefix)
+	if err != nil {
+		if repository.IsNotFound(err) {
+			return nil, nil, ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+	if subtle.ConstantTimeCompare([]byte(record.KeyHash), []byte(HashToken(key))) != 1 {
+		return nil, nil, ErrInvalidAPIKey
+	}
+
+	user, err := s.validateAPIKey(ctx, record)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Avoid a write on every request; a minute of precision is plenty
+	if record.LastUsedAt == nil || time.Since(*record.LastUsedAt) > time.Minute {
+		now := time.Now()
+		if err := s.apiKeyRepo.TouchAPIKey(ctx, record.ID, now); err != nil {
+			log.Printf("Failed to touch API key %d: %v", record.ID, err)
+		} else {
+			record.LastUsedAt = &now
+		}
+	}
+
+	return user, record, nil
+}
+
+// ValidateAPIKey checks that an API key is still active and its user still enabled.
+// Long-lived connections call it periodically to notice revocation and expiry.
+func (s *Service) ValidateAPIKey(ctx context.Context, id uint) (*models.User, *models.APIKey, error) {
+	record, err := s.apiKeyRepo.GetAPIKey(ctx, id)
+	if err != nil {
+		if repository.IsNotFound(err) {
+			return nil, nil, ErrInvalidAPIKey
+		}
+		return nil, nil, err
+	}
+
+	user, err := s.validateAPIKey(ctx, record)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, record, nil
+}
+
+// validateAPIKey checks a loaded key and returns its user
+func (s *Service) validateAPIKey(ctx context.Context, record *models.APIKey) (*models.User, error) {
+	if !record.IsActive() {
+		return nil, ErrInvalidAPIKey
+	}
+
+	user, err := s.userRepo.GetUser(ctx, record.UserID)
+	if err != nil {
+		if repository.IsNotFound(err) {
+			return nil, ErrInvalidAPIKey
+		}
+		return nil, err
+	}
+	if !user.Active {
+		return nil, ErrAccountDisabled
+	}
+
+	return user, nil
+}
+
+// normalizeScopes validates and de-duplicates requested scopes
+func normalizeScopes(scopes []string) ([]string, error) {
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("%w: at least one scope is required", ErrInvalidScope)
+	}
+
+	seen := make(map[string]bool, len(scopes))
+	result := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if seen[scope] {
+			continue
+		}
+		if !validScope(scope) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+		}
+		seen[scope] = true
+		result = append(result, scope)
+	}
+
+	return result, nil
+}
+
+// validScope reports whether scope is a known scope
+func validScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
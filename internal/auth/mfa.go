@@ -0,0 +1,468 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/repository"
+)
+
+const (
+	// TOTPIssuer names the account in authenticator apps
+	TOTPIssuer = "7x42"
+	// ChallengeTTL is how long a user has to enter the second factor after the password
+	ChallengeTTL = 5 * time.Minute
+	// RecoveryCodeCount is how many recovery codes are issued at a time
+	RecoveryCodeCount = 10
+
+	// maxFactorFailures wrong codes within factorFailureWindow lock the second factor for the rest of the window
+	maxFactorFailures   = 5
+	factorFailureWindow = 5 * time.Minute
+	// policyCacheTTL bounds how stale role policies can be on other instances
+	policyCacheTTL = 30 * time.Second
+)
+
+// Two-factor authentication errors
+var (
+	ErrInvalidCode          = errors.New("invalid two-factor code")
+	ErrInvalidChallenge     = errors.New("login expired; sign in again")
+	ErrTooManyAttempts      = errors.New("too many wrong codes; try again in a few minutes")
+	ErrTOTPEnabled          = errors.New("two-factor authentication is already enabled")
+	ErrTOTPNotEnabled       = errors.New("two-factor authentication is not enabled")
+	ErrTOTPNotPending       = errors.New("start two-factor enrollment first")
+	ErrTOTPRequiredByRole   = errors.New("two-factor authentication is required for your role")
+	ErrSecondFactorRequired = errors.New("set up two-factor authentication to continue")
+)
+
+// challengePrefix separates login challenges from other signed payloads
+var challengePrefix = []byte("mfa:")
+
+// recoveryEncoding renders recovery codes in lowercase-friendly base32
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// LoginResult is the outcome of a password login.
+// Accounts with two-factor authentication get a challenge instead of a session.
+type LoginResult struct {
+	User    *models.User
+	Token   string
+	Session *models.Session
+	// Challenge is redeemed with a code at VerifyChallenge
+	Challenge        string
+	ChallengeExpires time.Time
+}
+
+// TOTPEnrollment is a pending authenticator setup
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// factorLimiter counts wrong second-factor codes per user
+type factorLimiter struct {
+	mu       sync.Mutex
+	failures map[uint][]time.Time
+}
+
+// policyCache caches which roles require two-factor authentication
+type policyCache struct {
+	mu       sync.Mutex
+	requires map[string]bool
+	loaded   time.Time
+}
+
+// VerifyChallenge completes a password login with an authenticator or recovery code
+func (s *Service) VerifyChallenge(ctx context.Context, challenge, code, ip, userAgent string) (*models.User, string, *models.Session, error) {
+	userID, err := s.parseChallenge(challenge)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	user, err := s.userRepo.GetUser(ctx, userID)
+	if err != nil {
+		if repository.IsNotFound(err) {
+			return nil, "", nil, ErrInvalidChallenge
+		}
+		return nil, "", nil, err
+	}
+	if !user.Active {
+		return nil, "", nil, ErrAccountDisabled
+	}
+	if !user.HasTOTP() {
+		return nil, "", nil, ErrInvalidChallenge
+	}
+
+	if err := s.checkSecondFactor(ctx, user, code); err != nil {
+		return nil, "", nil, err
+	}
+
+	token, session, err := s.createSession(ctx, user, ip, userAgent, true)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	return user, token, session, nil
+}
+
+// BeginTOTPEnrollment generates a new authenticator secret for the user.
+// The secret only takes effect once a code from it is confirmed.
+func (s *Service) BeginTOTPEnrollment(ctx context.Context, user *models.User) (*TOTPEnrollment, error) {
+	if user.HasTOTP() {
+		return nil, ErrTOTPEnabled
+	}
+
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.userRepo.UpdateUserFields(ctx, user.ID, map[string]interface{}{
+		"totp_secret":     secret,
+		"totp_enabled_at": nil,
+	}); err != nil {
+		return nil, err
+	}
+
+	return &TOTPEnrollment{
+		Secret: secret,
+		URI:    TOTPURI(TOTPIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTPEnrollment enables two-factor authentication once the user proves the authenticator works.
+// It returns the recovery codes, which are only shown this once, and signs out the user's other sessions.
+func (s *Service) ConfirmTOTPEnrollment(ctx context.Context, user *models.User, session *models.Session, code string) ([]string, error) {
+	if user.HasTOTP() {
+		return nil, ErrTOTPEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrTOTPNotPending
+	}
+	if err := s.factorAllowed(user.ID); err != nil {
+		return nil, err
+	}
+
+	step, ok := verifyTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		s.factorFailed(user.ID)
+		return nil, ErrInvalidCode
+	}
+
+	now := time.Now()
+	if err := s.userRepo.UpdateUserFields(ctx, user.ID, map[string]interface{}{
+		"totp_enabled_at": now,
+		"totp_last_step":  step,
+	}); err != nil {
+		return nil, err
+	}
+
+	codes, err := s.issueRecoveryCodes(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if session != nil {
+		if err := s.sessionRepo.MarkSecondFactor(ctx, session.ID); err != nil {
+			return nil, err
+		}
+		if _, err := s.sessionRepo.DeleteOtherUserSessions(ctx, user.ID, session.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP turns two-factor authentication off after checking a current code
+func (s *Service) DisableTOTP(ctx context.Context, user *models.User, code string) error {
+	if !user.HasTOTP() {
+		return ErrTOTPNotEnabled
+	}
+
+	required, err := s.RequiresTOTP(ctx, user.Role)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrTOTPRequiredByRole
+	}
+
+	if err := s.checkSecondFactor(ctx, user, code); err != nil {
+		return err
+	}
+
+	return s.clearTOTP(ctx, user.ID)
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking a current code
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, user *models.User, code string) ([]string, error) {
+	if !user.HasTOTP() {
+		return nil, ErrTOTPNotEnabled
+	}
+	if err := s.checkSecondFactor(ctx, user, code); err != nil {
+		return nil, err
+	}
+
+	return s.issueRecoveryCodes(ctx, user.ID)
+}
+
+// RecoveryCodesLeft counts the user's unused recovery codes
+func (s *Service) RecoveryCodesLeft(ctx context.Context, userID uint) (int64, error) {
+	return s.mfaRepo.CountUnusedRecoveryCodes(ctx, userID)
+}
+
+// ResetTOTP removes an account's two-factor authentication, e.g. after the user lost their device,
+// and ends its sessions. The user has to enroll again on next login if their role requires it.
+func (s *Service) ResetTOTP(ctx context.Context, userID uint) error {
+	if err := s.clearTOTP(ctx, userID); err != nil {
+		return err
+	}
+
+	_, err := s.RevokeSessions(ctx, userID)
+	return err
+}
+
+// CheckSecondFactor returns ErrSecondFactorRequired if the user's role requires two-factor authentication
+// and the credentials have not passed it. Sessions must have passed it at login; API keys only work
+// once their owner has enrolled.
+func (s *Service) CheckSecondFactor(ctx context.Context, user *models.User, session *models.Session) error {
+	if session != nil && session.SecondFactor {
+		return nil
+	}
+
+	required, err := s.RequiresTOTP(ctx, user.Role)
+	if err != nil {
+		return err
+	}
+	if !required {
+		return nil
+	}
+
+	if session == nil && user.HasTOTP() {
+		return nil
+	}
+	return ErrSecondFactorRequired
+}
+
+// RequiresTOTP reports whether the role requires two-factor authentication
+func (s *Service) RequiresTOTP(ctx context.Context, role string) (bool, error) {
+	cache := s.policies
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if cache.requires == nil || time.Since(cache.loaded) > policyCacheTTL {
+		policies, err := s.mfaRepo.ListRolePolicies(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		cache.requires = make(map[string]bool, len(policies))
+		for _, policy := range policies {
+			cache.requires[policy.Role] = policy.RequireTOTP
+		}
+		cache.loaded = time.Now()
+	}
+
+	return cache.requires[role], nil
+}
+
+// ListRolePolicies returns the policy of every role, including roles that were never configured
+func (s *Service) ListRolePolicies(ctx context.Context) ([]models.RolePolicy, error) {
+	stored, err := s.mfaRepo.ListRolePolicies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	byRole := make(map[string]models.RolePolicy, len(stored))
+	for _, policy := range stored {
+		byRole[policy.Role] = policy
+	}
+
+	policies := make([]models.RolePolicy, 0, len(models.UserRoles))
+	for _, role := range models.UserRoles {
+		policy, ok := byRole[role]
+		if !ok {
+			policy = models.RolePolicy{Role: role}
+		}
+		policies = append(policies, policy)
+	}
+
+	return policies, nil
+}
+
+// SetRolePolicy updates whether a role requires two-factor authentication
+func (s *Service) SetRolePolicy(ctx context.Context, role string, requireTOTP bool) (*models.RolePolicy, error) {
+	if !models.IsValidUserRole(role) {
+		return nil, ErrInvalidRole
+	}
+
+	policy := &models.RolePolicy{Role: role, RequireTOTP: requireTOTP}
+	if err := s.mfaRepo.SaveRolePolicy(ctx, policy); err != nil {
+		return nil, err
+	}
+
+	s.policies.mu.Lock()
+	s.policies.requires = nil
+	s.policies.mu.Unlock()
+
+	return policy, nil
+}
+
+// issueChallenge signs a challenge that lets the user finish logging in with a second factor
+func (s *Service) issueChallenge(user *models.User) (string, time.Time) {
+	expires := time.Now().Add(ChallengeTTL)
+
+	payload := make([]byte, len(challengePrefix)+16)
+	copy(payload, challengePrefix)
+	binary.BigEndian.PutUint64(payload[len(challengePrefix):], uint64(user.ID))
+	binary.BigEndian.PutUint64(payload[len(challengePrefix)+8:], uint64(expires.Unix()))
+
+	return base64.RawURLEncoding.EncodeToString(payload[len(challengePrefix):]) + "." +
+		base64.RawURLEncoding.EncodeToString(s.signTicket(payload)), expires
+}
+
+// parseChallenge verifies a challenge and returns its user ID
+func (s *Service) parseChallenge(challenge string) (uint, error) {
+	encodedPayload, encodedSig, ok := strings.Cut(challenge, ".")
+	if !ok {
+		return 0, ErrInvalidChallenge
+	}
+
+	body, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil || len(body) != 16 {
+		return 0, ErrInvalidChallenge
+	}
+	payload := append(append([]byte{}, challengePrefix...), body...)
+
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil || !hmac.Equal(sig, s.signTicket(payload)) {
+		return 0, ErrInvalidChallenge
+	}
+
+	expires := time.Unix(int64(binary.BigEndian.Uint64(body[8:16])), 0)
+	if time.Now().After(expires) {
+		return 0, ErrInvalidChallenge
+	}
+
+	return uint(binary.BigEndian.Uint64(body[0:8])), nil
+}
+
+// checkSecondFactor accepts an unused authenticator code or a recovery code
+func (s *Service) checkSecondFactor(ctx context.Context, user *models.User, code string) error {
+	if err := s.factorAllowed(user.ID); err != nil {
+		return err
+	}
+
+	if step, ok := verifyTOTP(user.TOTPSecret, code, time.Now()); ok {
+		fresh, err := s.userRepo.ConsumeTOTPStep(ctx, user.ID, step)
+		if err != nil {
+			return err
+		}
+		if fresh {
+			return nil
+		}
+	} else if normalized := normalizeRecoveryCode(code); normalized != "" {
+		used, err := s.mfaRepo.UseRecoveryCode(ctx, user.ID, HashToken(normalized))
+		if err != nil {
+			return err
+		}
+		if used {
+			return nil
+		}
+	}
+
+	s.factorFailed(user.ID)
+	return ErrInvalidCode
+}
+
+// issueRecoveryCodes replaces the user's recovery codes and returns the new ones in plain text
+func (s *Service) issueRecoveryCodes(ctx context.Context, userID uint) ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+		hashes[i] = HashToken(raw)
+	}
+
+	if err := s.mfaRepo.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// clearTOTP removes the authenticator secret and recovery codes
+func (s *Service) clearTOTP(ctx context.Context, userID uint) error {
+	if err := s.userRepo.UpdateUserFields(ctx, userID, map[string]interface{}{
+		"totp_secret":     "",
+		"totp_enabled_at": nil,
+	}); err != nil {
+		return err
+	}
+
+	return s.mfaRepo.ReplaceRecoveryCodes(ctx, userID, nil)
+}
+
+// normalizeRecoveryCode strips separators and case from a recovery code, or returns "" if it cannot be one
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+	if len(code) != 10 {
+		return ""
+	}
+	return code
+}
+
+// factorAllowed returns ErrTooManyAttempts while the user is locked out of second-factor checks
+func (s *Service) factorAllowed(userID uint) error {
+	limiter := s.factorLimiter
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	if len(limiter.recent(userID)) >= maxFactorFailures {
+		return ErrTooManyAttempts
+	}
+	return nil
+}
+
+// factorFailed records a wrong second-factor code
+func (s *Service) factorFailed(userID uint) {
+	limiter := s.factorLimiter
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	limiter.failures[userID] = append(limiter.recent(userID), time.Now())
+}
+
+// recent returns the user's failures within the window, dropping older ones; callers hold mu
+func (l *factorLimiter) recent(userID uint) []time.Time {
+	failures := l.failures[userID]
+	cutoff := time.Now().Add(-factorFailureWindow)
+
+	kept := failures[:0]
+	for _, at := range failures {
+		if at.After(cutoff) {
+			kept = append(kept, at)
+		}
+	}
+
+	if len(kept) == 0 {
+		delete(l.failures, userID)
+		return nil
+	}
+	l.failures[userID] = kept
+	return kept
+}
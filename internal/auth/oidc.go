@@ -0,0 +1,363 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/repository"
+	"golang.org/x/oauth2"
+)
+
+// oidcFlowTTL is how long a user has to complete the login at the identity provider
+const oidcFlowTTL = 10 * time.Minute
+
+// OIDC errors
+var (
+	ErrOIDCDisabled     = errors.New("single sign-on is not configured")
+	ErrOIDCFlow         = errors.New("single sign-on login expired or was tampered with")
+	ErrOIDCNoGroup      = errors.New("your account is not in a group allowed to use this instance")
+	ErrPasswordDisabled = errors.New("password login is disabled; sign in with single sign-on")
+)
+
+// OIDCConfig configures OpenID Connect single sign-on
+type OIDCConfig struct {
+	IssuerURL    string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the absolute URL of the callback endpoint, e.g. https://chat.example.com/auth/oidc/callback
+	RedirectURL string
+	// Scopes are requested in addition to openid; defaults to profile and email
+	Scopes []string
+	// GroupsClaim is the ID token claim holding the user's groups; defaults to "groups"
+	GroupsClaim string
+	// AdminGroups, MemberGroups and ReadOnlyGroups map groups to roles; the highest role wins.
+	AdminGroups    []string
+	MemberGroups   []string
+	ReadOnlyGroups []string
+}
+
+// Enabled returns true if single sign-on is configured
+func (c *OIDCConfig) Enabled() bool {
+	return c != nil && c.IssuerURL != "" && c.ClientID != ""
+}
+
+// mapsGroups returns true if any group-to-role mapping is configured
+func (c *OIDCConfig) mapsGroups() bool {
+	return len(c.AdminGroups)+len(c.MemberGroups)+len(c.ReadOnlyGroups) > 0
+}
+
+// OIDCFlow is the state of a login in progress. It is kept in a signed cookie between
+// the redirect to the identity provider and the callback.
+type OIDCFlow struct {
+	State    string    `json:"s"`
+	Nonce    string    `json:"n"`
+	Verifier string    `json:"v"`
+	Next     string    `json:"r"`
+	Expires  time.Time `json:"e"`
+}
+
+// oidcClient is the lazily discovered identity provider
+type oidcClient struct {
+	mu       sync.Mutex
+	provider *oidc.Provider
+	verifier *oidc.IDTokenVerifier
+	oauth    *oauth2.Config
+}
+
+// OIDCEnabled returns true if single sign-on is configured
+func (s *Service) OIDCEnabled() bool {
+	return s.config.OIDC.Enabled()
+}
+
+// StartOIDC begins a login at the identity provider.
+// It returns the URL to redirect to and the flow state to store until the callback.
+func (s *Service) StartOIDC(ctx context.Context, next string) (string, string, error) {
+	client, err := s.oidcClient(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	state, err := NewToken()
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := NewToken()
+	if err != nil {
+		return "", "", err
+	}
+
+	flow := OIDCFlow{
+		State:    state,
+		Nonce:    nonce,
+		Verifier: oauth2.GenerateVerifier(),
+		Next:     next,
+		Expires:  time.Now().Add(oidcFlowTTL),
+	}
+
+	authURL := client.oauth.AuthCodeURL(state,
+		oidc.Nonce(nonce),
+		oauth2.S256ChallengeOption(flow.Verifier))
+
+	encoded, err := s.encodeFlow(flow)
+	if err != nil {
+		return "", "", err
+	}
+
+	return authURL, encoded, nil
+}
+
+// FinishOIDC completes a login: it exchanges the code, verifies the ID token,
+// provisions or updates the user and opens a session.
+func (s *Service) FinishOIDC(ctx context.Context, encodedFlow, state, code, ip, userAgent string) (*models.User, string, *models.Session, string, error) {
+	client, err := s.oidcClient(ctx)
+	if err != nil {
+		return nil, "", nil, "", err
+	}
+
+	flow, err := s.decodeFlow(encodedFlow)
+	if err != nil || !hmac.Equal([]byte(flow.State), []byte(state)) {
+		return nil, "", nil, "", ErrOIDCFlow
+	}
+
+	token, err := client.oauth.Exchange(ctx, code, oauth2.VerifierOption(flow.Verifier))
+	if err != nil {
+		return nil, "", nil, "", fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, "", nil, "", errors.New("identity provider returned no ID token")
+	}
+
+	idToken, err := client.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, "", nil, "", fmt.Errorf("invalid ID token: %w", err)
+	}
+	if !hmac.Equal([]byte(idToken.Nonce), []byte(flow.Nonce)) {
+		return nil, "", nil, "", ErrOIDCFlow
+	}
+
+	user, err := s.provisionOIDCUser(ctx, idToken)
+	if err != nil {
+		return nil, "", nil, "", err
+	}
+	if !user.Active {
+		return nil, "", nil, "", ErrAccountDisabled
+	}
+
+	// The identity provider is responsible for the second factor
+	sessionToken, session, err := s.createSession(ctx, user, ip, userAgent, true)
+	if err != nil {
+		return nil, "", nil, "", err
+	}
+
+	return user, sessionToken, session, flow.Next, nil
+}
+
+// oidcClaims are the ID token claims used for provisioning
+type oidcClaims struct {
+	Email         string `json:"email"`
+	EmailVerified *bool  `json:"email_verified"`
+	Name          string `json:"name"`
+}
+
+// provisionOIDCUser finds the account linked to the token's subject, linking or creating one on first login.
+// Roles are re-derived from the group claim on every login so changes at the identity provider apply.
+func (s *Service) provisionOIDCUser(ctx context.Context, idToken *oidc.IDToken) (*models.User, error) {
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to parse ID token claims: %w", err)
+	}
+	var raw map[string]interface{}
+	if err := idToken.Claims(&raw); err != nil {
+		return nil, fmt.Errorf("failed to parse ID token claims: %w", err)
+	}
+
+	role, err := s.oidcRole(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetUserByOIDCSubject(ctx, idToken.Issuer, idToken.Subject)
+	if err == nil {
+		if role != "" && role != user.Role {
+			if err := s.userRepo.UpdateUserFields(ctx, user.ID, map[string]interface{}{"role": role}); err != nil {
+				return nil, err
+			}
+			user.Role = role
+		}
+		return user, nil
+	}
+	if !repository.IsNotFound(err) {
+		return nil, err
+	}
+
+	email, err := normalizeEmail(claims.Email)
+	if err != nil {
+		return nil, fmt.Errorf("identity provider returned no usable email: %w", err)
+	}
+	if role == "" {
+		role = models.UserRoleMember
+	}
+
+	issuer, subject := idToken.Issuer, idToken.Subject
+	fields := map[string]interface{}{
+		"oidc_issuer":  issuer,
+		"oidc_subject": subject,
+		"role":         role,
+	}
+
+	// Link an existing account with the same address, but only if the provider vouches for it
+	existing, err := s.userRepo.GetUserByEmail(ctx, email)
+	if err == nil {
+		if claims.EmailVerified == nil || !*claims.EmailVerified {
+			return nil, fmt.Errorf("%w: cannot link %s to an unverified email", ErrEmailTaken, email)
+		}
+		if existing.OIDCSubject != nil {
+			return nil, ErrEmailTaken
+		}
+		if err := s.userRepo.UpdateUserFields(ctx, existing.ID, fields); err != nil {
+			return nil, err
+		}
+		return s.userRepo.GetUser(ctx, existing.ID)
+	}
+	if !repository.IsNotFound(err) {
+		return nil, err
+	}
+
+	user = &models.User{
+		Email:       email,
+		Name:        strings.TrimSpace(claims.Name),
+		Role:        role,
+		Active:      true,
+		OIDCIssuer:  &issuer,
+		OIDCSubject: &subject,
+	}
+	if err := s.userRepo.CreateUser(ctx, user); err != nil {
+		if repository.IsAlreadyExists(err) {
+			return nil, ErrEmailTaken
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// oidcRole maps the group claim to a role. It returns "" if no mapping is configured.
+func (s *Service) oidcRole(claims map[string]interface{}) (string, error) {
+	cfg := s.config.OIDC
+	if !cfg.mapsGroups() {
+		return "", nil
+	}
+
+	groups := make(map[string]bool)
+	switch v := claims[cfg.GroupsClaim].(type) {
+	case []interface{}:
+		for _, g := range v {
+			if name, ok := g.(string); ok {
+				groups[name] = true
+			}
+		}
+	case string:
+		groups[v] = true
+	}
+
+	inAny := func(names []string) bool {
+		for _, name := range names {
+			if groups[name] {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case inAny(cfg.AdminGroups):
+		return models.UserRoleAdmin, nil
+	case inAny(cfg.MemberGroups):
+		return models.UserRoleMember, nil
+	case inAny(cfg.ReadOnlyGroups):
+		return models.UserRoleReadOnly, nil
+	}
+	return "", ErrOIDCNoGroup
+}
+
+// oidcClient discovers the identity provider on first use.
+// Failed discovery is retried on the next login so the provider may start after this server.
+func (s *Service) oidcClient(ctx context.Context) (*oidcClient, error) {
+	cfg := s.config.OIDC
+	if !cfg.Enabled() {
+		return nil, ErrOIDCDisabled
+	}
+
+	client := s.oidc
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if client.provider != nil {
+		return client, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, cfg.IssuerURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover identity provider: %w", err)
+	}
+
+	client.provider = provider
+	client.verifier = provider.Verifier(&oidc.Config{ClientID: cfg.ClientID})
+	client.oauth = &oauth2.Config{
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       append([]string{oidc.ScopeOpenID}, cfg.Scopes...),
+	}
+
+	return client, nil
+}
+
+// encodeFlow serializes and signs a login flow
+func (s *Service) encodeFlow(flow OIDCFlow) (string, error) {
+	payload, err := json.Marshal(flow)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(s.signTicket(payload)), nil
+}
+
+// decodeFlow verifies and parses a login flow
+func (s *Service) decodeFlow(encoded string) (*OIDCFlow, error) {
+	encodedPayload, encodedSig, ok := strings.Cut(encoded, ".")
+	if !ok {
+		return nil, ErrOIDCFlow
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, ErrOIDCFlow
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil || !hmac.Equal(sig, s.signTicket(payload)) {
+		return nil, ErrOIDCFlow
+	}
+
+	var flow OIDCFlow
+	if err := json.Unmarshal(payload, &flow); err != nil {
+		return nil, ErrOIDCFlow
+	}
+	if time.Now().After(flow.Expires) {
+		return nil, ErrOIDCFlow
+	}
+
+	return &flow, nil
+}
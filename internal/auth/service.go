@@ -0,0 +1,305 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/repository"
+	"gorm.io/gorm"
+)
+
+// DefaultSessionTTL is how long a login session stays valid
+const DefaultSessionTTL = 7 * 24 * time.Hour
+
+// Authentication errors
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrInvalidSession     = errors.New("invalid or expired session")
+	ErrEmailTaken         = errors.New("an account with this email already exists")
+	ErrInvalidEmail       = errors.New("invalid email address")
+	ErrWeakPassword       = errors.New("password must be at least 8 characters long")
+	ErrPasswordTooLong    = errors.New("password must be at most 72 bytes long")
+	ErrAccountDisabled    = errors.New("account is disabled")
+	ErrRegistrationClosed = errors.New("registration is disabled")
+)
+
+// Config holds the authentication configuration
+type Config struct {
+	// SessionTTL is how long a session is valid after login
+	SessionTTL time.Duration
+	// AllowRegistration lets anyone create an account
+	AllowRegistration bool
+	// SecureCookies marks session cookies as HTTPS-only
+	SecureCookies bool
+	// TicketSecret signs WebSocket tickets and single sign-on state. A random key is used if empty,
+	// which invalidates outstanding tickets on restart.
+	TicketSecret string
+	// DisablePasswords turns off password login and registration, e.g. when single sign-on is mandatory
+	DisablePasswords bool
+	// OIDC enables OpenID Connect single sign-on if set
+	OIDC *OIDCConfig
+}
+
+// Service handles registration, login and session lookup
+type Service struct {
+	config      Config
+	userRepo    *repository.UserRepository
+	sessionRepo *repository.SessionRepository
+	apiKeyRepo  *repository.APIKeyRepository
+	mfaRepo     *repository.MFARepository
+	ticketKey   []byte
+	oidc        *oidcClient
+
+	factorLimiter *factorLimiter
+	policies      *policyCache
+}
+
+// NewService creates a new authentication service
+func NewService(db *gorm.DB, config Config) *Service {
+	if config.SessionTTL <= 0 {
+		config.SessionTTL = DefaultSessionTTL
+	}
+	if config.OIDC != nil {
+		if len(config.OIDC.Scopes) == 0 {
+			config.OIDC.Scopes = []string{"profile", "email"}
+		}
+		if config.OIDC.GroupsClaim == "" {
+			config.OIDC.GroupsClaim = "groups"
+		}
+	}
+
+	return &Service{
+		config:      config,
+		userRepo:    repository.NewUserRepository(db),
+		sessionRepo: repository.NewSessionRepository(db),
+		apiKeyRepo:  repository.NewAPIKeyRepository(db),
+		mfaRepo:     repository.NewMFARepository(db),
+		ticketKey:   newTicketKey(config.TicketSecret),
+		oidc:        &oidcClient{},
+
+		factorLimiter: &factorLimiter{failures: make(map[uint][]time.Time)},
+		policies:      &policyCache{},
+	}
+}
+
+// Config returns the authentication configuration
+func (s *Service) Config() Config {
+	return s.config
+}
+
+// Register creates a new account with a password
+func (s *Service) Register(ctx context.Context, email, name, password string) (*models.User, error) {
+	if s.config.DisablePasswords {
+		return nil, ErrPasswordDisabled
+	}
+	if !s.config.AllowRegistration {
+		return nil, ErrRegistrationClosed
+	}
+
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	// The first account of a fresh instance administers it
+	role := models.UserRoleMember
+	admins, err := s.userRepo.CountUsersByRole(ctx, models.UserRoleAdmin)
+	if err != nil {
+		return nil, err
+	}
+	if admins == 0 {
+		role = models.UserRoleAdmin
+	}
+
+	user := &models.User{
+		Email:        email,
+		Name:         strings.TrimSpace(name),
+		PasswordHash: hash,
+		Role:         role,
+		Active:       true,
+	}
+
+	if err := s.userRepo.CreateUser(ctx, user); err != nil {
+		if repository.IsAlreadyExists(err) {
+			return nil, ErrEmailTaken
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// Login checks the credentials and opens a new session, or returns a challenge if the account
+// has two-factor authentication. The returned token is only available here; the database stores its hash.
+func (s *Service) Login(ctx context.Context, email, password, ip, userAgent string) (*LoginResult, error) {
+	if s.config.DisablePasswords {
+		return nil, ErrPasswordDisabled
+	}
+
+	user, err := s.userRepo.GetUserByEmail(ctx, strings.TrimSpace(email))
+	if err != nil {
+		if repository.IsNotFound(err) {
+			// Spend the same time as a real check so unknown emails are not revealed by timing
+			CheckPassword(dummyHash, password)
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	if !CheckPassword(user.PasswordHash, password) {
+		return nil, ErrInvalidCredentials
+	}
+	if !user.Active {
+		return nil, ErrAccountDisabled
+	}
+
+	if user.HasTOTP() {
+		challenge, expires := s.issueChallenge(user)
+		return &LoginResult{User: user, Challenge: challenge, ChallengeExpires: expires}, nil
+	}
+
+	token, session, err := s.CreateSession(ctx, user, ip, userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LoginResult{User: user, Token: token, Session: session}, nil
+}
+
+// CreateSession opens a new session for an already authenticated user
+func (s *Service) CreateSession(ctx context.Context, user *models.User, ip, userAgent string) (string, *models.Session, error) {
+	return s.createSession(ctx, user, ip, userAgent, false)
+}
+
+// createSession opens a new session, recording whether the login passed a second factor
+func (s *Service) createSession(ctx context.Context, user *models.User, ip, userAgent string, secondFactor bool) (string, *models.Session, error) {
+	token, err := NewToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	session := &models.Session{
+		UserID:       user.ID,
+		TokenHash:    HashToken(token),
+		ExpiresAt:    now.Add(s.config.SessionTTL),
+		LastSeenAt:   now,
+		IP:           truncate(ip, 64),
+		UserAgent:    truncate(userAgent, 512),
+		SecondFactor: secondFactor,
+	}
+
+	if err := s.sessionRepo.CreateSession(ctx, session); err != nil {
+		return "", nil, err
+	}
+
+	if err := s.userRepo.UpdateLastLogin(ctx, user.ID, now); err != nil {
+		log.Printf("Failed to record login for user %d: %v", user.ID, err)
+	}
+
+	return token, session, nil
+}
+
+// Authenticate resolves a session token to its user
+func (s *Service) Authenticate(ctx context.Context, token string) (*models.User, *models.Session, error) {
+	if token == "" {
+		return nil, nil, ErrInvalidSession
+	}
+
+	session, err := s.sessionRepo.GetSessionByTokenHash(ctx, HashToken(token))
+	if err != nil {
+		if repository.IsNotFound(err) {
+			return nil, nil, ErrInvalidSession
+		}
+		return nil, nil, err
+	}
+
+	user, err := s.userRepo.GetUser(ctx, session.UserID)
+	if err != nil {
+		if repository.IsNotFound(err) {
+			return nil, nil, ErrInvalidSession
+		}
+		return nil, nil, err
+	}
+	if !user.Active {
+		return nil, nil, ErrAccountDisabled
+	}
+
+	// Avoid a write on every request; a minute of precision is plenty
+	if time.Since(session.LastSeenAt) > time.Minute {
+		now := time.Now()
+		if err := s.sessionRepo.TouchSession(ctx, session.ID, now); err != nil {
+			log.Printf("Failed to touch session %d: %v", session.ID, err)
+		} else {
+			session.LastSeenAt = now
+		}
+	}
+
+	return user, session, nil
+}
+
+// ValidateSession checks that a session is still valid and its user still active.
+// Long-lived connections call it periodically to notice logouts and expiry.
+func (s *Service) ValidateSession(ctx context.Context, sessionID uint) (*models.User, *models.Session, error) {
+	session, err := s.sessionRepo.GetSession(ctx, sessionID)
+	if err != nil {
+		if repository.IsNotFound(err) {
+			return nil, nil, ErrInvalidSession
+		}
+		return nil, nil, err
+	}
+
+	user, err := s.userRepo.GetUser(ctx, session.UserID)
+	if err != nil {
+		if repository.IsNotFound(err) {
+			return nil, nil, ErrInvalidSession
+		}
+		return nil, nil, err
+	}
+	if !user.Active {
+		return nil, nil, ErrAccountDisabled
+	}
+
+	return user, session, nil
+}
+
+// Logout ends a session
+func (s *Service) Logout(ctx context.Context, session *models.Session) error {
+	return s.sessionRepo.DeleteSession(ctx, session.ID)
+}
+
+// CleanupExpiredSessions removes expired sessions from the database
+func (s *Service) CleanupExpiredSessions(ctx context.Context) (int64, error) {
+	return s.sessionRepo.DeleteExpiredSessions(ctx)
+}
+
+// dummyHash is compared against when a login names an unknown account
+var dummyHash, _ = HashPassword("not-a-real-password")
+
+// normalizeEmail validates an email address and lowercases it
+func normalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return "", ErrInvalidEmail
+	}
+	return email, nil
+}
+
+// truncate shortens s to at most n bytes
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
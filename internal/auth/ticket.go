@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/hra42/7x42/internal/models"
+)
+
+// TicketTTL is how long a WebSocket ticket can be used after it was issued
+const TicketTTL = 30 * time.Second
+
+// ErrInvalidTicket is returned for forged, malformed or expired tickets
+var ErrInvalidTicket = errors.New("invalid or expired ticket")
+
+// ticketPayloadSize is the size of the signed part: session ID, user ID and expiry
+const ticketPayloadSize = 24
+
+// IssueTicket creates a short-lived signed ticket that opens a WebSocket for the session.
+// Tickets let clients that cannot send cookies, such as scripts using a bearer token,
+// authenticate the upgrade request through the query string without exposing the session token.
+func (s *Service) IssueTicket(session *models.Session) (string, time.Time) {
+	expires := time.Now().Add(TicketTTL)
+
+	payload := make([]byte, ticketPayloadSize)
+	binary.BigEndian.PutUint64(payload[0:8], uint64(session.ID))
+	binary.BigEndian.PutUint64(payload[8:16], uint64(session.UserID))
+	binary.BigEndian.PutUint64(payload[16:24], uint64(expires.Unix()))
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(s.signTicket(payload)), expires
+}
+
+// AuthenticateTicket verifies a ticket and resolves it to its still valid session and user
+func (s *Service) AuthenticateTicket(ctx context.Context, ticket string) (*models.User, *models.Session, error) {
+	encodedPayload, encodedSig, ok := strings.Cut(ticket, ".")
+	if !ok {
+		return nil, nil, ErrInvalidTicket
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil || len(payload) != ticketPayloadSize {
+		return nil, nil, ErrInvalidTicket
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil || !hmac.Equal(sig, s.signTicket(payload)) {
+		return nil, nil, ErrInvalidTicket
+	}
+
+	sessionID := uint(binary.BigEndian.Uint64(payload[0:8]))
+	userID := uint(binary.BigEndian.Uint64(payload[8:16]))
+	expires := time.Unix(int64(binary.BigEndian.Uint64(payload[16:24])), 0)
+	if time.Now().After(expires) {
+		return nil, nil, ErrInvalidTicket
+	}
+
+	user, session, err := s.ValidateSession(ctx, sessionID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if user.ID != userID {
+		return nil, nil, ErrInvalidTicket
+	}
+
+	return user, session, nil
+}
+
+// signTicket computes the HMAC of a ticket payload
+func (s *Service) signTicket(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.ticketKey)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// newTicketKey returns the configured ticket secret, or a random one that lives as long as the process
+func newTicketKey(secret string) []byte {
+	if secret != "" {
+		return []byte(secret)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic("auth: failed to generate ticket key: " + err.Error())
+	}
+	return key
+}
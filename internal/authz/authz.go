@@ -0,0 +1,211 @@
+package authz
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/repository"
+)
+
+// Action is an operation a user wants to perform on a resource
+type Action string
+
+// Actions that can be authorized
+const (
+	ActionRead  Action = "read"
+	ActionWrite Action = "write"
+	// ActionPrompt sends messages to a chat, retries and cancels its generations
+	ActionPrompt Action = "prompt"
+	ActionDelete Action = "delete"
+	ActionShare  Action = "share"
+	// ActionManage changes a workspace's settings and members, or who takes part in a chat
+	ActionManage Action = "manage"
+)
+
+// ErrForbidden is returned when a user can see a resource but may not perform the action
+var ErrForbidden = errors.New("your role does not allow this")
+
+// Authorizer checks access to chats, messages and workspaces before they are returned.
+// Denied access is reported as a repository not-found error so callers cannot tell a
+// resource they may not see from one that does not exist. Workspace members and chat participants
+// who can see a resource but lack the role for an action get ErrForbidden instead.
+type Authorizer struct {
+	chatRepo      *repository.ChatRepository
+	messageRepo   *repository.MessageRepository
+	workspaceRepo *repository.WorkspaceRepository
+}
+
+// NewAuthorizer creates a new authorizer
+func NewAuthorizer(chatRepo *repository.ChatRepository, messageRepo *repository.MessageRepository, workspaceRepo *repository.WorkspaceRepository) *Authorizer {
+	return &Authorizer{
+		chatRepo:      chatRepo,
+		messageRepo:   messageRepo,
+		workspaceRepo: workspaceRepo,
+	}
+}
+
+// Chat loads a chat with its messages if the user may perform action on it
+func (a *Authorizer) Chat(ctx context.Context, userID uint, chatID uint64, action Action) (*models.Chat, error) {
+	chat, err := a.chatRepo.GetChat(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.checkChat(ctx, userID, chat, action); err != nil {
+		return nil, err
+	}
+	return chat, nil
+}
+
+// ChatInfo loads a chat without its messages if the user may perform action on it
+func (a *Authorizer) ChatInfo(ctx context.Context, userID uint, chatID uint64, action Action) (*models.Chat, error) {
+	chat, err := a.chatRepo.GetChatInfo(ctx, chatID)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.checkChat(ctx, userID, chat, action); err != nil {
+		return nil, err
+	}
+	return chat, nil
+}
+
+// Message loads a message if the user may perform action on the chat it belongs to
+func (a *Authorizer) Message(ctx context.Context, userID uint, messageID uint, action Action) (*models.Message, error) {
+	message, err := a.messageRepo.GetMessage(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := a.ChatInfo(ctx, userID, message.ChatID, action); err != nil {
+		if repository.IsNotFound(err) {
+			return nil, denied("message")
+		}
+		return nil, err
+	}
+	return message, nil
+}
+
+// Workspace loads a workspace and the user's membership if they may perform action on it
+func (a *Authorizer) Workspace(ctx context.Context, userID uint, workspaceID uint, action Action) (*models.Workspace, *models.WorkspaceMember, error) {
+	member, err := a.member(ctx, userID, workspaceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if member == nil {
+		return nil, nil, denied("workspace")
+	}
+	if !canInWorkspace(member, action) {
+		return nil, nil, ErrForbidden
+	}
+
+	workspace, err := a.workspaceRepo.GetWorkspace(ctx, workspaceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return workspace, member, nil
+}
+
+// checkChat is the access policy for chats. Owners may do anything with their personal chats.
+// In a workspace every member may read, members and above may prompt, and renaming, deleting,
+// sharing or managing a chat is up to the member who started it and the workspace's admins.
+// Participants invited into a chat may follow it, and prompt in it if they are prompters.
+func (a *Authorizer) checkChat(ctx context.Context, userID uint, chat *models.Chat, action Action) error {
+	if userID == 0 {
+		return denied("chat")
+	}
+	if chat.WorkspaceID == nil && chat.UserID == userID {
+		return nil
+	}
+
+	participant, err := a.participant(ctx, userID, uint64(chat.ID))
+	if err != nil {
+		return err
+	}
+	var member *models.WorkspaceMember
+	if chat.WorkspaceID != nil {
+		if member, err = a.member(ctx, userID, *chat.WorkspaceID); err != nil {
+			return err
+		}
+	}
+	if member == nil && participant == nil {
+		return denied("chat")
+	}
+
+	if canAsMember(chat, userID, member, action) || canAsParticipant(participant, action) {
+		return nil
+	}
+	return ErrForbidden
+}
+
+// participant returns the user's participation in a chat, or nil if they were not invited
+func (a *Authorizer) participant(ctx context.Context, userID uint, chatID uint64) (*models.ChatParticipant, error) {
+	participant, err := a.chatRepo.GetParticipant(ctx, chatID, userID)
+	if err != nil {
+		if repository.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return participant, nil
+}
+
+// canAsMember is the access policy for workspace chats by member role
+func canAsMember(chat *models.Chat, userID uint, member *models.WorkspaceMember, action Action) bool {
+	if member == nil {
+		return false
+	}
+	switch action {
+	case ActionPrompt:
+		return member.CanWrite()
+	case ActionDelete, ActionShare, ActionManage:
+		return member.CanManage() || (chat.UserID == userID && member.CanWrite())
+	}
+	return canInWorkspace(member, action)
+}
+
+// canAsParticipant is the access policy for chats by participant role
+func canAsParticipant(participant *models.ChatParticipant, action Action) bool {
+	if participant == nil {
+		return false
+	}
+	switch action {
+	case ActionRead:
+		return true
+	case ActionPrompt:
+		return participant.CanPrompt()
+	}
+	return false
+}
+
+// member returns the user's membership in a workspace, or nil if they are not a member
+func (a *Authorizer) member(ctx context.Context, userID uint, workspaceID uint) (*models.WorkspaceMember, error) {
+	if userID == 0 {
+		return nil, nil
+	}
+	member, err := a.workspaceRepo.GetMember(ctx, workspaceID, userID)
+	if err != nil {
+		if repository.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return member, nil
+}
+
+// canInWorkspace is the access policy for workspaces by member role
+func canInWorkspace(member *models.WorkspaceMember, action Action) bool {
+	switch action {
+	case ActionRead:
+		return true
+	case ActionWrite:
+		return member.CanWrite()
+	case ActionDelete:
+		return member.Role == models.WorkspaceRoleOwner
+	default:
+		return member.CanManage()
+	}
+}
+
+// denied returns the error for a resource the user may not access
+func denied(entity string) error {
+	return repository.NewError("get", entity, repository.ErrNotFound)
+}
@@ -0,0 +1,309 @@
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/repository"
+	"github.com/jackc/pgx/v5"
+	"gorm.io/gorm"
+)
+
+// DefaultChannel is the Postgres notification channel instances talk on
+const DefaultChannel = "cluster_7x42"
+
+const (
+	// maxNotifyPayload keeps notifications below Postgres' limit of 8000 bytes;
+	// larger messages are stored in a table and only their ID is sent
+	maxNotifyPayload = 7500
+	// payloadRetention is how long stored payloads are kept for slow instances
+	payloadRetention = 5 * time.Minute
+	// outboxSize bounds the messages waiting to be sent
+	outboxSize = 1024
+	// maxReconnectDelay bounds the wait between attempts to listen again
+	maxReconnectDelay = 30 * time.Second
+)
+
+// ErrOutboxFull is returned when messages are published faster than they can be sent
+var ErrOutboxFull = errors.New("cluster outbox full")
+
+// Handler receives the data of messages published by other instances
+type Handler func(data json.RawMessage)
+
+// envelope is a message as it travels between instances
+type envelope struct {
+	// Node is the instance that published the message; it ignores its own messages
+	Node string          `json:"node"`
+	Kind string          `json:"kind"`
+	Data json.RawMessage `json:"data,omitempty"`
+	// Ref is the ClusterPayload holding Data when it was too large to be sent directly
+	Ref uint64 `json:"ref,omitempty"`
+}
+
+// Config holds the configuration for the cluster bus
+type Config struct {
+	DB *gorm.DB
+	// DSN opens the dedicated connection that listens for notifications
+	DSN string
+	// NodeID identifies this instance; a random ID is used if empty
+	NodeID string
+	// Channel is the notification channel, DefaultChannel if empty
+	Channel string
+}
+
+// Bus exchanges messages between the instances sharing a database through Postgres
+// LISTEN/NOTIFY, so that clients connected to any instance see what happens on the others.
+// Delivery is best effort: messages sent while an instance is reconnecting are lost to it.
+// A nil bus discards everything, so a single instance works without one.
+type Bus struct {
+	repo    *repository.ClusterRepository
+	dsn     string
+	nodeID  string
+	channel string
+
+	handlers   map[string]Handler
+	handlersMu sync.RWMutex
+
+	outbox chan envelope
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates a new cluster bus; Start begins listening and sending
+func New(config Config) (*Bus, error) {
+	if config.DB == nil {
+		return nil, errors.New("database is required")
+	}
+	if config.DSN == "" {
+		return nil, errors.New("DSN is required")
+	}
+	if config.Channel == "" {
+		config.Channel = DefaultChannel
+	}
+	if config.NodeID == "" {
+		config.NodeID = randomNodeID()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Bus{
+		repo:     repository.NewClusterRepository(config.DB),
+		dsn:      config.DSN,
+		nodeID:   config.NodeID,
+		channel:  config.Channel,
+		handlers: make(map[string]Handler),
+		outbox:   make(chan envelope, outboxSize),
+		ctx:      ctx,
+		cancel:   cancel,
+	}, nil
+}
+
+// NodeID returns the ID of this instance
+func (b *Bus) NodeID() string {
+	return b.nodeID
+}
+
+// Handle registers the handler for messages of a kind; it must be called before Start.
+// Handlers run one at a time in the order messages arrive.
+func (b *Bus) Handle(kind string, handler Handler) {
+	b.handlersMu.Lock()
+	defer b.handlersMu.Unlock()
+
+	b.handlers[kind] = handler
+}
+
+// Publish sends a message to the other instances without waiting for it to be sent
+func (b *Bus) Publish(kind string, data interface{}) error {
+	if b == nil {
+		return nil
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s message: %w", kind, err)
+	}
+
+	select {
+	case b.outbox <- envelope{Node: b.nodeID, Kind: kind, Data: raw}:
+		return nil
+	default:
+		return ErrOutboxFull
+	}
+}
+
+// Start begins listening for and sending messages
+func (b *Bus) Start() {
+	b.wg.Add(3)
+	go b.listen()
+	go b.send()
+	go b.cleanup()
+
+	log.Printf("Cluster bus started as node %s", b.nodeID)
+}
+
+// Stop stops the bus; messages still waiting to be sent are dropped
+func (b *Bus) Stop() {
+	b.cancel()
+	b.wg.Wait()
+}
+
+// listen keeps a connection listening on the channel, reconnecting with a growing delay
+func (b *Bus) listen() {
+	defer b.wg.Done()
+
+	delay := time.Second
+	for {
+		err := b.listenOnce()
+		if b.ctx.Err() != nil {
+			return
+		}
+		log.Printf("Cluster listener disconnected, retrying in %s: %v", delay, err)
+
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// listenOnce listens on a new connection until it fails or the bus stops
+func (b *Bus) listenOnce() error {
+	conn, err := pgx.Connect(b.ctx, b.dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(b.ctx, "LISTEN "+pgx.Identifier{b.channel}.Sanitize()); err != nil {
+		return err
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(b.ctx)
+		if err != nil {
+			return err
+		}
+		b.receive(notification.Payload)
+	}
+}
+
+// receive passes a notification from another instance on to the handler of its kind
+func (b *Bus) receive(payload string) {
+	var env envelope
+	if err := json.Unmarshal([]byte(payload), &env); err != nil {
+		log.Printf("Ignoring malformed cluster message: %v", err)
+		return
+	}
+	if env.Node == b.nodeID {
+		return
+	}
+
+	b.handlersMu.RLock()
+	handler, ok := b.handlers[env.Kind]
+	b.handlersMu.RUnlock()
+	if !ok {
+		return
+	}
+
+	if env.Ref != 0 {
+		ctx, cancel := context.WithTimeout(b.ctx, 5*time.Second)
+		stored, err := b.repo.GetPayload(ctx, env.Ref)
+		cancel()
+		if err != nil {
+			log.Printf("Error loading %s message %d from node %s: %v", env.Kind, env.Ref, env.Node, err)
+			return
+		}
+		env.Data = json.RawMessage(stored.Data)
+	}
+
+	handler(env.Data)
+}
+
+// send sends published messages one at a time, keeping them in order
+func (b *Bus) send() {
+	defer b.wg.Done()
+
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case env := <-b.outbox:
+			if err := b.notify(env); err != nil {
+				log.Printf("Error sending %s message to the cluster: %v", env.Kind, err)
+			}
+		}
+	}
+}
+
+// notify sends a message as a notification, storing its data first if it is too large
+func (b *Bus) notify(env envelope) error {
+	ctx, cancel := context.WithTimeout(b.ctx, 5*time.Second)
+	defer cancel()
+
+	payload, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	if len(payload) > maxNotifyPayload {
+		stored := &models.ClusterPayload{NodeID: env.Node, Data: string(env.Data)}
+		if err := b.repo.CreatePayload(ctx, stored); err != nil {
+			return err
+		}
+		env.Data = nil
+		env.Ref = stored.ID
+		if payload, err = json.Marshal(env); err != nil {
+			return err
+		}
+	}
+
+	return b.repo.Notify(ctx, b.channel, string(payload))
+}
+
+// cleanup periodically deletes stored payloads every instance had time to read
+func (b *Bus) cleanup() {
+	defer b.wg.Done()
+
+	ticker := time.NewTicker(payloadRetention)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.ctx.Done():
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(b.ctx, 10*time.Second)
+			if _, err := b.repo.DeletePayloadsBefore(ctx, time.Now().Add(-payloadRetention)); err != nil {
+				log.Printf("Error deleting old cluster payloads: %v", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// randomNodeID returns the host name followed by a random suffix, unique for every process
+func randomNodeID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "node"
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return host + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return host + "-" + hex.EncodeToString(suffix)
+}
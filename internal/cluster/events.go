@@ -0,0 +1,30 @@
+package cluster
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/hra42/7x42/internal/events"
+)
+
+// kindEvent carries the domain events published on an events.Bus
+const kindEvent = "event"
+
+// RelayEvents shares an event bus with the other instances: events published on it are
+// sent to them, and theirs are delivered to its local subscribers
+func (b *Bus) RelayEvents(bus *events.Bus) {
+	bus.SetRelay(func(event events.Event) {
+		if err := b.Publish(kindEvent, event); err != nil {
+			log.Printf("Error relaying %s to the cluster: %v", event.Name, err)
+		}
+	})
+
+	b.Handle(kindEvent, func(data json.RawMessage) {
+		var event events.Event
+		if err := json.Unmarshal(data, &event); err != nil {
+			log.Printf("Ignoring malformed cluster event: %v", err)
+			return
+		}
+		bus.Deliver(event)
+	})
+}
@@ -0,0 +1,424 @@
+package credits
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/repository"
+	"gorm.io/gorm"
+)
+
+// Credit and budget errors
+var (
+	ErrInsufficientCredits = errors.New("insufficient credits")
+	ErrBudgetExceeded      = errors.New("spending budget exceeded")
+	ErrInvalidAmount       = errors.New("invalid credit amount")
+	ErrInvalidBudget       = errors.New("invalid budget")
+)
+
+// BudgetError is returned when a user, or the workspace they generate in, has spent
+// their budget for a period
+type BudgetError struct {
+	// Scope is BudgetScopeWorkspace for workspace budgets and BudgetScopeUser otherwise
+	Scope    string
+	Period   string
+	Limit    int64
+	ResetsAt time.Time
+}
+
+// Error implements the error interface
+func (e *BudgetError) Error() string {
+	period := e.Period
+	if e.Scope == models.BudgetScopeWorkspace {
+		period = "workspace " + period
+	}
+	return fmt.Sprintf("%v: %s limit of %.2f credits reached, resets at %s",
+		ErrBudgetExceeded, period, models.CreditsFromMicros(e.Limit), e.ResetsAt.Format(time.RFC3339))
+}
+
+// Unwrap returns ErrBudgetExceeded
+func (e *BudgetError) Unwrap() error {
+	return ErrBudgetExceeded
+}
+
+// estimateWindow is how far back charges are averaged to estimate what a generation will cost
+const estimateWindow = 30 * 24 * time.Hour
+
+// Config holds the configuration for credits and budgets
+type Config struct {
+	// RequireCredits rejects generations from users without a positive balance.
+	// Otherwise charges are still recorded and only budgets are enforced.
+	RequireCredits bool
+}
+
+// Service keeps the credit ledger and enforces spending budgets
+type Service struct {
+	repo          *repository.CreditRepository
+	userRepo      *repository.UserRepository
+	workspaceRepo *repository.WorkspaceRepository
+	config        Config
+}
+
+// NewService creates a new credits service
+func NewService(db *gorm.DB, config Config) *Service {
+	return &Service{
+		repo:          repository.NewCreditRepository(db),
+		userRepo:      repository.NewUserRepository(db),
+		workspaceRepo: repository.NewWorkspaceRepository(db),
+		config:        config,
+	}
+}
+
+// PeriodUsage is what a user spent in the current budget period and the limit that applies
+type PeriodUsage struct {
+	Period   string
+	Spent    int64
+	Limit    *int64
+	ResetsAt time.Time
+}
+
+// ToMap converts the usage to a map for API responses
+func (u *PeriodUsage) ToMap() map[string]interface{} {
+	result := map[string]interface{}{
+		"period":   u.Period,
+		"spent":    models.CreditsFromMicros(u.Spent),
+		"limit":    nil,
+		"resetsAt": u.ResetsAt,
+	}
+	if u.Limit != nil {
+		result["limit"] = models.CreditsFromMicros(*u.Limit)
+		result["remaining"] = models.CreditsFromMicros(max(*u.Limit-u.Spent, 0))
+	}
+	return result
+}
+
+// Summary is a user's balance and spending in each budget period
+type Summary struct {
+	Balance        int64
+	RequireCredits bool
+	Periods        []PeriodUsage
+}
+
+// ToMap converts the summary to a map for API responses
+func (s *Summary) ToMap() map[string]interface{} {
+	periods := make([]map[string]interface{}, len(s.Periods))
+	for i := range s.Periods {
+		periods[i] = s.Periods[i].ToMap()
+	}
+	return map[string]interface{}{
+		"balance":        models.CreditsFromMicros(s.Balance),
+		"requireCredits": s.RequireCredits,
+		"periods":        periods,
+	}
+}
+
+// Check is the pre-flight check before a generation reaches the provider.
+// It fails if the user has no credits left, when credits are required, or has spent a budget.
+// Generations in a workspace chat, with workspaceID set, must also fit the workspace's budgets.
+// Concurrent generations are checked against what was charged so far, so a cap can be overshot
+// by the generations already running when it is reached.
+func (s *Service) Check(ctx context.Context, userID uint, workspaceID uint) error {
+	return s.check(ctx, userID, workspaceID, 0)
+}
+
+// CheckGenerations is the pre-flight check before a request starts a generation with each of
+// several models at once, e.g. a comparison. Besides what Check requires, the balance and every
+// budget must have room for what the generations are expected to cost together, estimated from
+// what each model was charged recently.
+func (s *Service) CheckGenerations(ctx context.Context, userID uint, workspaceID uint, modelIDs []string) error {
+	expected, err := s.estimate(ctx, modelIDs)
+	if err != nil {
+		return err
+	}
+	return s.check(ctx, userID, workspaceID, expected)
+}
+
+// check runs the pre-flight check for generations expected to cost the given amount together
+func (s *Service) check(ctx context.Context, userID uint, workspaceID uint, expected int64) error {
+	summary, err := s.Summary(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if s.config.RequireCredits && (summary.Balance <= 0 || summary.Balance < expected) {
+		return ErrInsufficientCredits
+	}
+	for _, usage := range summary.Periods {
+		if usage.Limit != nil && (usage.Spent >= *usage.Limit || usage.Spent+expected > *usage.Limit) {
+			return &BudgetError{Scope: models.BudgetScopeUser, Period: usage.Period, Limit: *usage.Limit, ResetsAt: usage.ResetsAt}
+		}
+	}
+
+	if workspaceID == 0 {
+		return nil
+	}
+	periods, err := s.WorkspaceUsage(ctx, workspaceID)
+	if err != nil {
+		return err
+	}
+	for _, usage := range periods {
+		if usage.Limit != nil && (usage.Spent >= *usage.Limit || usage.Spent+expected > *usage.Limit) {
+			return &BudgetError{Scope: models.BudgetScopeWorkspace, Period: usage.Period, Limit: *usage.Limit, ResetsAt: usage.ResetsAt}
+		}
+	}
+
+	return nil
+}
+
+// estimate adds up what a generation with each model is expected to cost. Models without recent
+// charges are expected to cost what generations cost on average.
+func (s *Service) estimate(ctx context.Context, modelIDs []string) (int64, error) {
+	since := time.Now().Add(-estimateWindow)
+
+	var total int64
+	var fallback *int64
+	for _, model := range modelIDs {
+		cost, err := s.repo.AverageCharge(ctx, model, since)
+		if err != nil {
+			return 0, err
+		}
+		if cost == 0 {
+			if fallback == nil {
+				average, err := s.repo.AverageCharge(ctx, "", since)
+				if err != nil {
+					return 0, err
+				}
+				fallback = &average
+			}
+			cost = *fallback
+		}
+		total += cost
+	}
+	return total, nil
+}
+
+// Summary returns a user's balance and their spending against each budget period
+func (s *Service) Summary(ctx context.Context, userID uint) (*Summary, error) {
+	user, err := s.userRepo.GetUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := s.repo.GetBalance(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	budgets, err := s.repo.BudgetsFor(ctx, userID, user.Role)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	summary := &Summary{Balance: balance, RequireCredits: s.config.RequireCredits}
+	for _, period := range models.BudgetPeriods {
+		start, end := periodBounds(period, now)
+		spent, err := s.repo.SumCharges(ctx, userID, start)
+		if err != nil {
+			return nil, err
+		}
+
+		usage := PeriodUsage{Period: period, Spent: spent, ResetsAt: end}
+		if limit, ok := effectiveLimit(budgets, period); ok {
+			usage.Limit = &limit
+		}
+		summary.Periods = append(summary.Periods, usage)
+	}
+
+	return summary, nil
+}
+
+// WorkspaceUsage returns what a workspace's members spent in its chats in each budget period
+func (s *Service) WorkspaceUsage(ctx context.Context, workspaceID uint) ([]PeriodUsage, error) {
+	budgets, err := s.repo.WorkspaceBudgets(ctx, workspaceID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	periods := make([]PeriodUsage, 0, len(models.BudgetPeriods))
+	for _, period := range models.BudgetPeriods {
+		start, end := periodBounds(period, now)
+		spent, err := s.repo.SumWorkspaceCharges(ctx, workspaceID, start)
+		if err != nil {
+			return nil, err
+		}
+
+		usage := PeriodUsage{Period: period, Spent: spent, ResetsAt: end}
+		if limit, ok := effectiveLimit(budgets, period); ok {
+			usage.Limit = &limit
+		}
+		periods = append(periods, usage)
+	}
+
+	return periods, nil
+}
+
+// Charge records the provider cost of a finished generation to the user who ran it.
+// jobID is 0 for generations without a job and workspaceID is 0 outside workspace chats.
+func (s *Service) Charge(ctx context.Context, userID uint, workspaceID uint, jobID uint, model string, cost float64) error {
+	amount := models.MicrosFromCredits(cost)
+	if amount <= 0 {
+		return nil
+	}
+
+	entry := &models.CreditEntry{
+		UserID: userID,
+		Kind:   models.CreditKindCharge,
+		Amount: -amount,
+		Model:  model,
+	}
+	if jobID != 0 {
+		entry.JobID = &jobID
+	}
+	if workspaceID != 0 {
+		entry.WorkspaceID = &workspaceID
+	}
+	return s.repo.PostEntry(ctx, entry)
+}
+
+// Grant adds credits to a user's balance on behalf of an administrator.
+// Negative amounts are recorded as adjustments, e.g. to correct a mistaken grant.
+func (s *Service) Grant(ctx context.Context, userID uint, credits float64, actorID uint, note string) (*models.CreditEntry, error) {
+	amount := models.MicrosFromCredits(credits)
+	if amount == 0 {
+		return nil, ErrInvalidAmount
+	}
+	if _, err := s.userRepo.GetUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	kind := models.CreditKindGrant
+	if amount < 0 {
+		kind = models.CreditKindAdjustment
+	}
+	if note = strings.TrimSpace(note); len(note) > 255 {
+		note = note[:255]
+	}
+
+	entry := &models.CreditEntry{
+		UserID:  userID,
+		Kind:    kind,
+		Amount:  amount,
+		ActorID: &actorID,
+		Note:    note,
+	}
+	if err := s.repo.PostEntry(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	return entry, nil
+}
+
+// History lists a user's ledger entries, newest first, with the total count
+func (s *Service) History(ctx context.Context, userID uint, page, pageSize int) ([]models.CreditEntry, int64, error) {
+	entries, err := s.repo.ListEntries(ctx, userID, page, pageSize)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total, err := s.repo.CountEntries(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return entries, total, nil
+}
+
+// ListBudgets lists every role, user and workspace budget
+func (s *Service) ListBudgets(ctx context.Context) ([]models.Budget, error) {
+	return s.repo.ListBudgets(ctx)
+}
+
+// SetBudget creates or updates a budget. The subject is a role name, a user ID or a workspace ID.
+func (s *Service) SetBudget(ctx context.Context, scope, subject, period string, credits float64) (*models.Budget, error) {
+	if err := s.validateBudget(ctx, scope, subject, period); err != nil {
+		return nil, err
+	}
+	if credits < 0 {
+		return nil, fmt.Errorf("%w: amount must not be negative", ErrInvalidBudget)
+	}
+
+	budget := &models.Budget{
+		Scope:   scope,
+		Subject: subject,
+		Period:  period,
+		Amount:  models.MicrosFromCredits(credits),
+	}
+	if err := s.repo.SaveBudget(ctx, budget); err != nil {
+		return nil, err
+	}
+
+	return budget, nil
+}
+
+// DeleteBudget removes a budget, leaving the subject without a cap for that period
+// unless a role budget applies
+func (s *Service) DeleteBudget(ctx context.Context, scope, subject, period string) error {
+	return s.repo.DeleteBudget(ctx, scope, subject, period)
+}
+
+// validateBudget checks the scope, subject and period of a budget
+func (s *Service) validateBudget(ctx context.Context, scope, subject, period string) error {
+	if period != models.BudgetPeriodDaily && period != models.BudgetPeriodMonthly {
+		return fmt.Errorf("%w: period must be daily or monthly", ErrInvalidBudget)
+	}
+
+	switch scope {
+	case models.BudgetScopeRole:
+		if !models.IsValidUserRole(subject) {
+			return fmt.Errorf("%w: unknown role %q", ErrInvalidBudget, subject)
+		}
+	case models.BudgetScopeUser:
+		id, err := strconv.ParseUint(subject, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: invalid user ID %q", ErrInvalidBudget, subject)
+		}
+		if _, err := s.userRepo.GetUser(ctx, uint(id)); err != nil {
+			return err
+		}
+	case models.BudgetScopeWorkspace:
+		id, err := strconv.ParseUint(subject, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%w: invalid workspace ID %q", ErrInvalidBudget, subject)
+		}
+		if _, err := s.workspaceRepo.GetWorkspace(ctx, uint(id)); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: scope must be role, user or workspace", ErrInvalidBudget)
+	}
+
+	return nil
+}
+
+// effectiveLimit returns the limit for a period, preferring the user's own budget over their role's
+func effectiveLimit(budgets []models.Budget, period string) (int64, bool) {
+	var limit int64
+	var found bool
+	for _, budget := range budgets {
+		if budget.Period != period {
+			continue
+		}
+		if budget.Scope == models.BudgetScopeUser {
+			return budget.Amount, true
+		}
+		limit, found = budget.Amount, true
+	}
+	return limit, found
+}
+
+// periodBounds returns the start and end of the budget period containing t, in UTC
+func periodBounds(period string, t time.Time) (time.Time, time.Time) {
+	if period == models.BudgetPeriodMonthly {
+		start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0)
+	}
+	start := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	return start, start.AddDate(0, 0, 1)
+}
@@ -21,11 +21,16 @@ type Config struct {
 	SSLMode  string
 }
 
-func NewConnection(config *Config) (*gorm.DB, error) {
-	dsn := fmt.Sprintf(
+// DSN returns the connection string for the configured database
+func (c *Config) DSN() string {
+	return fmt.Sprintf(
 		"host=%s user=%s password=%s dbname=%s port=%s sslmode=%s",
-		config.Host, config.User, config.Password, config.DBName, config.Port, config.SSLMode,
+		c.Host, c.User, c.Password, c.DBName, c.Port, c.SSLMode,
 	)
+}
+
+func NewConnection(config *Config) (*gorm.DB, error) {
+	dsn := config.DSN()
 
 	gormLogger := logger.New(
 		log.New(os.Stdout, "\r\n", log.LstdFlags),
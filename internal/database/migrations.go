@@ -1,6 +1,12 @@
 package database
 
 import (
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
 	"github.com/hra42/7x42/internal/models"
 	"gorm.io/gorm"
 )
@@ -10,9 +16,172 @@ func AutoMigrate(db *gorm.DB) error {
 	if err := db.Exec("CREATE EXTENSION IF NOT EXISTS \"uuid-ossp\"").Error; err != nil {
 		return err
 	}
+
+	// Accounts must exist before owner columns can reference them
+	if err := db.AutoMigrate(&models.User{}, &models.Session{}, &models.APIKey{},
+		&models.RecoveryCode{}, &models.RolePolicy{}); err != nil {
+		return err
+	}
+	if err := migrateLegacyUserIDs(db); err != nil {
+		return fmt.Errorf("failed to migrate legacy user IDs: %w", err)
+	}
+
 	// Run migrations
-	return db.AutoMigrate(
+	if err := db.AutoMigrate(
+		&models.Workspace{},
+		&models.WorkspaceMember{},
 		&models.Chat{},
 		&models.Message{},
-	)
+		&models.ChatParticipant{},
+		&models.ChatShare{},
+		&models.GenerationJob{},
+		&models.AuditEvent{},
+		&models.CreditAccount{},
+		&models.CreditEntry{},
+		&models.Budget{},
+		&models.ProviderKey{},
+		&models.ClusterPayload{},
+	); err != nil {
+		return err
+	}
+
+	return protectAuditLog(db)
+}
+
+// protectAuditLog makes the audit log append-only at the database level,
+// so not even a bug or a compromised application path can rewrite history
+func protectAuditLog(db *gorm.DB) error {
+	if err := db.Exec(`
+		CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_events is append-only';
+		END;
+		$$ LANGUAGE plpgsql`).Error; err != nil {
+		return fmt.Errorf("failed to create audit log trigger function: %w", err)
+	}
+
+	if err := db.Exec("DROP TRIGGER IF EXISTS audit_events_append_only ON audit_events").Error; err != nil {
+		return fmt.Errorf("failed to replace audit log trigger: %w", err)
+	}
+	if err := db.Exec(`
+		CREATE TRIGGER audit_events_append_only
+			BEFORE UPDATE OR DELETE OR TRUNCATE ON audit_events
+			FOR EACH STATEMENT EXECUTE FUNCTION audit_events_append_only()`).Error; err != nil {
+		return fmt.Errorf("failed to create audit log trigger: %w", err)
+	}
+
+	return nil
+}
+
+// legacyUserTables are the tables whose user_id column used to hold free-form strings
+var legacyUserTables = []string{"chats", "generation_jobs"}
+
+// migrateLegacyUserIDs converts free-form string user IDs into references to real users.
+// Every distinct legacy ID becomes a password-less account that an administrator can claim later.
+func migrateLegacyUserIDs(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, table := range legacyUserTables {
+			legacy, err := hasTextUserID(tx, table)
+			if err != nil {
+				return err
+			}
+			if !legacy {
+				continue
+			}
+
+			// Map into a new column so numeric legacy IDs cannot collide with new user IDs
+			if err := tx.Exec(fmt.Sprintf(
+				"ALTER TABLE %s ADD COLUMN user_ref bigint", table)).Error; err != nil {
+				return err
+			}
+
+			var ids []string
+			if err := tx.Table(table).
+				Distinct("user_id").
+				Where("user_id IS NOT NULL AND user_id <> ''").
+				Pluck("user_id", &ids).Error; err != nil {
+				return err
+			}
+
+			for _, id := range ids {
+				user, err := legacyUser(tx, id)
+				if err != nil {
+					return err
+				}
+				if err := tx.Table(table).
+					Where("user_id = ?", id).
+					Update("user_ref", user.ID).Error; err != nil {
+					return err
+				}
+			}
+
+			if err := tx.Exec(fmt.Sprintf(
+				"ALTER TABLE %s DROP COLUMN user_id", table)).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec(fmt.Sprintf(
+				"ALTER TABLE %s RENAME COLUMN user_ref TO user_id", table)).Error; err != nil {
+				return err
+			}
+
+			log.Printf("Migrated %d legacy user IDs in %s", len(ids), table)
+		}
+		return nil
+	})
+}
+
+// hasTextUserID reports whether table.user_id still has a character type
+func hasTextUserID(tx *gorm.DB, table string) (bool, error) {
+	var dataType string
+	err := tx.Raw(`SELECT data_type FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = ? AND column_name = 'user_id'`, table).
+		Scan(&dataType).Error
+	if err != nil {
+		return false, err
+	}
+	return dataType == "character varying" || dataType == "text", nil
+}
+
+// legacyEmailChars matches characters not allowed in the local part of a generated email
+var legacyEmailChars = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// legacyUser finds or creates the account standing in for a legacy user ID
+func legacyUser(tx *gorm.DB, legacyID string) (*models.User, error) {
+	var user models.User
+	err := tx.Where("legacy_id = ?", legacyID).First(&user).Error
+	if err == nil {
+		return &user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	local := strings.Trim(legacyEmailChars.ReplaceAllString(strings.ToLower(legacyID), "-"), "-.")
+	if local == "" {
+		local = "user"
+	}
+
+	// Different legacy IDs can sanitize to the same address; suffix until unique
+	email := local + "@legacy.invalid"
+	for i := 2; ; i++ {
+		var count int64
+		if err := tx.Model(&models.User{}).Where("email = ?", email).Count(&count).Error; err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			break
+		}
+		email = fmt.Sprintf("%s-%d@legacy.invalid", local, i)
+	}
+
+	user = models.User{
+		Email:    email,
+		Name:     legacyID,
+		Active:   true,
+		LegacyID: legacyID,
+	}
+	if err := tx.Create(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
 }
@@ -0,0 +1,159 @@
+package events
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SystemTopic carries events for every connected user
+const SystemTopic = "system"
+
+// Event names
+const (
+	ChatCreated = "chat.created"
+	ChatUpdated = "chat.updated"
+	ChatDeleted = "chat.deleted"
+
+	MessageCreated = "message.created"
+	MessageUpdated = "message.updated"
+
+	GenerationStarted  = "generation.started"
+	GenerationProgress = "generation.progress"
+	GenerationFinished = "generation.finished"
+
+	WorkspaceUpdated       = "workspace.updated"
+	WorkspaceDeleted       = "workspace.deleted"
+	WorkspaceMemberAdded   = "workspace.member_added"
+	WorkspaceMemberUpdated = "workspace.member_updated"
+	WorkspaceMemberRemoved = "workspace.member_removed"
+)
+
+// Topic kinds, the part of a topic before the colon
+const (
+	KindChat      = "chat"
+	KindUser      = "user"
+	KindWorkspace = "workspace"
+)
+
+// Event is a change published to a topic
+type Event struct {
+	Topic     string      `json:"topic"`
+	Name      string      `json:"event"`
+	Data      interface{} `json:"data"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// Handler receives published events
+type Handler func(Event)
+
+// Relay passes the events published on a bus on to other instances
+type Relay func(Event)
+
+// Bus passes the events published by server components on to its subscribers,
+// such as the WebSocket manager delivering them to connected clients.
+// A nil bus discards everything, so components work without one.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers []Handler
+	relay    Relay
+}
+
+// NewBus creates a new event bus
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe registers a handler for every event published on the bus
+func (b *Bus) Subscribe(handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers = append(b.handlers, handler)
+}
+
+// SetRelay registers where published events go besides the local handlers
+func (b *Bus) SetRelay(relay Relay) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.relay = relay
+}
+
+// Publish publishes an event to each of the given topics
+func (b *Bus) Publish(name string, data interface{}, topics ...string) {
+	if b == nil {
+		return
+	}
+
+	b.mu.RLock()
+	relay := b.relay
+	b.mu.RUnlock()
+
+	now := time.Now()
+	for _, topic := range topics {
+		event := Event{Topic: topic, Name: name, Data: data, Timestamp: now}
+		b.Deliver(event)
+		if relay != nil {
+			relay(event)
+		}
+	}
+}
+
+// Deliver passes an event to the local handlers only, e.g. one relayed from another instance
+func (b *Bus) Deliver(event Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.RLock()
+	handlers := b.handlers
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+// ChatTopic returns the topic of a single chat
+func ChatTopic(chatID uint64) string {
+	return fmt.Sprintf("%s:%d", KindChat, chatID)
+}
+
+// UserTopic returns the topic of a user, for changes to their own chats and account
+func UserTopic(userID uint) string {
+	return fmt.Sprintf("%s:%d", KindUser, userID)
+}
+
+// WorkspaceTopic returns the topic of a workspace, for changes to it and its chats
+func WorkspaceTopic(workspaceID uint) string {
+	return fmt.Sprintf("%s:%d", KindWorkspace, workspaceID)
+}
+
+// ChatTopics returns the topics a change to a chat is published to: the chat's own and
+// the one its chat list belongs to, its workspace's or else its owner's
+func ChatTopics(chatID uint64, ownerID uint, workspaceID *uint) []string {
+	if workspaceID != nil {
+		return []string{ChatTopic(chatID), WorkspaceTopic(*workspaceID)}
+	}
+	return []string{ChatTopic(chatID), UserTopic(ownerID)}
+}
+
+// ParseTopic splits a topic into its kind and ID. The system topic has no ID.
+func ParseTopic(topic string) (string, uint64, error) {
+	if topic == SystemTopic {
+		return SystemTopic, 0, nil
+	}
+
+	kind, rawID, ok := strings.Cut(topic, ":")
+	if !ok || (kind != KindChat && kind != KindUser && kind != KindWorkspace) {
+		return "", 0, fmt.Errorf("unknown topic %q", topic)
+	}
+	id, err := strconv.ParseUint(rawID, 10, 64)
+	if err != nil || id == 0 {
+		return "", 0, fmt.Errorf("invalid ID in topic %q", topic)
+	}
+	return kind, id, nil
+}
@@ -0,0 +1,176 @@
+package markdown
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// Inline patterns, applied to text that is already HTML-escaped
+var (
+	linkPattern   = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	boldPattern   = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	italicPattern = regexp.MustCompile(`(^|[^*\w])\*([^*\s][^*]*)\*|(^|[^_\w])_([^_\s][^_]*)_`)
+)
+
+// Block patterns
+var (
+	headingPattern   = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	unorderedPattern = regexp.MustCompile(`^\s*[-*+]\s+(.*)$`)
+	orderedPattern   = regexp.MustCompile(`^\s*\d+[.)]\s+(.*)$`)
+	rulePattern      = regexp.MustCompile(`^\s*([-*_])(\s*[-*_]){2,}\s*$`)
+	fencePattern     = regexp.MustCompile("^\\s*```\\s*([\\w+-]*)")
+)
+
+// Render converts the Markdown of a chat message to HTML.
+// Everything is escaped first and only the tags produced here are emitted, so raw HTML in
+// the source is shown as text and links are limited to http, https and mailto.
+// It covers what models commonly write: paragraphs, headings, lists, quotes, rules,
+// fenced and inline code, links, bold and italics.
+func Render(src string) template.HTML {
+	var out strings.Builder
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+
+	var paragraph []string
+	var list []string
+	listTag := ""
+
+	flushParagraph := func() {
+		if len(paragraph) > 0 {
+			out.WriteString("<p>" + strings.Join(paragraph, "<br>") + "</p>\n")
+			paragraph = nil
+		}
+	}
+	flushList := func() {
+		if len(list) > 0 {
+			out.WriteString("<" + listTag + ">")
+			for _, item := range list {
+				out.WriteString("<li>" + item + "</li>")
+			}
+			out.WriteString("</" + listTag + ">\n")
+			list = nil
+		}
+	}
+	flush := func() {
+		flushParagraph()
+		flushList()
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		if m := fencePattern.FindStringSubmatch(line); m != nil {
+			flush()
+			var code []string
+			for i++; i < len(lines) && !fencePattern.MatchString(lines[i]); i++ {
+				code = append(code, lines[i])
+			}
+			out.WriteString("<pre><code")
+			if m[1] != "" {
+				out.WriteString(` class="language-` + html.EscapeString(m[1]) + `"`)
+			}
+			out.WriteString(">" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			flush()
+
+		case rulePattern.MatchString(line):
+			flush()
+			out.WriteString("<hr>\n")
+
+		case headingPattern.MatchString(trimmed):
+			flush()
+			m := headingPattern.FindStringSubmatch(trimmed)
+			level := string(rune('0' + len(m[1])))
+			out.WriteString("<h" + level + ">" + inline(m[2]) + "</h" + level + ">\n")
+
+		case strings.HasPrefix(trimmed, ">"):
+			flush()
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				quote = append(quote, inline(strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(lines[i]), ">"))))
+			}
+			i--
+			out.WriteString("<blockquote>" + strings.Join(quote, "<br>") + "</blockquote>\n")
+
+		case unorderedPattern.MatchString(line):
+			flushParagraph()
+			if listTag != "ul" {
+				flushList()
+				listTag = "ul"
+			}
+			list = append(list, inline(unorderedPattern.FindStringSubmatch(line)[1]))
+
+		case orderedPattern.MatchString(line):
+			flushParagraph()
+			if listTag != "ol" {
+				flushList()
+				listTag = "ol"
+			}
+			list = append(list, inline(orderedPattern.FindStringSubmatch(line)[1]))
+
+		default:
+			flushList()
+			paragraph = append(paragraph, inline(trimmed))
+		}
+	}
+	flush()
+
+	return template.HTML(out.String())
+}
+
+// inline renders code spans, links and emphasis within a line
+func inline(text string) string {
+	var out strings.Builder
+
+	// Code spans are split off first so nothing inside them is formatted
+	parts := strings.Split(text, "`")
+	for i, part := range parts {
+		if i%2 == 1 && i < len(parts)-1 {
+			out.WriteString("<code>" + html.EscapeString(part) + "</code>")
+			continue
+		}
+		if i%2 == 1 {
+			// An unmatched backtick is kept as text
+			out.WriteString("`")
+		}
+		out.WriteString(emphasis(html.EscapeString(part)))
+	}
+
+	return out.String()
+}
+
+// emphasis renders links, bold and italics in escaped text. Bold and italics are applied to the
+// text around and inside links separately, so markers never pair up with the URL or attributes.
+func emphasis(escaped string) string {
+	var out strings.Builder
+	last := 0
+	for _, m := range linkPattern.FindAllStringSubmatchIndex(escaped, -1) {
+		label, url := escaped[m[2]:m[3]], escaped[m[4]:m[5]]
+		if !safeURL(html.UnescapeString(url)) {
+			continue
+		}
+		out.WriteString(styles(escaped[last:m[0]]))
+		out.WriteString(`<a href="` + url + `" rel="nofollow noopener noreferrer" target="_blank">` + styles(label) + `</a>`)
+		last = m[1]
+	}
+	out.WriteString(styles(escaped[last:]))
+	return out.String()
+}
+
+// styles renders bold and italics in escaped text
+func styles(escaped string) string {
+	escaped = boldPattern.ReplaceAllString(escaped, "<strong>$1$2</strong>")
+	return italicPattern.ReplaceAllString(escaped, "$1$3<em>$2$4</em>")
+}
+
+// safeURL returns true for link targets that cannot run script
+func safeURL(url string) bool {
+	lower := strings.ToLower(strings.TrimSpace(url))
+	return strings.HasPrefix(lower, "https://") || strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "mailto:")
+}
@@ -0,0 +1,135 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "raw script is escaped",
+			src:     `<script>alert(1)</script>`,
+			want:    []string{"&lt;script&gt;alert(1)&lt;/script&gt;"},
+			notWant: []string{"<script"},
+		},
+		{
+			name:    "raw html attributes are escaped",
+			src:     `<img src=x onerror="alert(1)">`,
+			want:    []string{"&lt;img src=x onerror=&#34;alert(1)&#34;&gt;"},
+			notWant: []string{"<img"},
+		},
+		{
+			name: "http link",
+			src:  `[site](https://example.com/a)`,
+			want: []string{`<a href="https://example.com/a" rel="nofollow noopener noreferrer" target="_blank">site</a>`},
+		},
+		{
+			name:    "javascript link is kept as text",
+			src:     `[click](javascript:alert(1))`,
+			notWant: []string{"<a ", "href"},
+		},
+		{
+			name:    "data link is kept as text",
+			src:     `[click](data:text/html,x)`,
+			notWant: []string{"<a ", "href"},
+		},
+		{
+			name:    "quote in url cannot break out of the attribute",
+			src:     `[x](https://example.com/"onmouseover="alert(1))`,
+			notWant: []string{`"onmouseover="`},
+		},
+		{
+			name: "markers inside urls are left alone",
+			src:  `[a](https://example.com/_x_) and [b](https://example.com/*y*)`,
+			want: []string{
+				`href="https://example.com/_x_"`,
+				`href="https://example.com/*y*"`,
+			},
+			notWant: []string{"<em>", "<strong>"},
+		},
+		{
+			name: "two links on a line keep their attributes",
+			src:  `[a](https://a.example) [b](https://b.example)`,
+			want: []string{
+				`<a href="https://a.example" rel="nofollow noopener noreferrer" target="_blank">a</a>`,
+				`<a href="https://b.example" rel="nofollow noopener noreferrer" target="_blank">b</a>`,
+			},
+			notWant: []string{"<em>"},
+		},
+		{
+			name: "emphasis in link labels",
+			src:  `[**bold**](https://example.com)`,
+			want: []string{`target="_blank"><strong>bold</strong></a>`},
+		},
+		{
+			name: "emphasis",
+			src:  `**bold** and _italic_ and *also*`,
+			want: []string{"<strong>bold</strong>", "<em>italic</em>", "<em>also</em>"},
+		},
+		{
+			name:    "code spans are not formatted",
+			src:     "`**x** <b>`",
+			want:    []string{"<code>**x** &lt;b&gt;</code>"},
+			notWant: []string{"<strong>"},
+		},
+		{
+			name:    "fenced code is escaped",
+			src:     "```html\n<script>x</script>\n```",
+			want:    []string{`<pre><code class="language-html">&lt;script&gt;x&lt;/script&gt;</code></pre>`},
+			notWant: []string{"<script"},
+		},
+		{
+			name:    "fence language cannot inject attributes",
+			src:     "```\"onload=\"x\nbody\n```",
+			notWant: []string{`"onload="`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(Render(tt.src))
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("Render(%q) = %q, want it to contain %q", tt.src, got, want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("Render(%q) = %q, want it not to contain %q", tt.src, got, notWant)
+				}
+			}
+		})
+	}
+}
+
+func TestSafeURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://example.com", true},
+		{"http://example.com", true},
+		{"mailto:someone@example.com", true},
+		{"  HTTPS://example.com", true},
+		{"javascript:alert(1)", false},
+		{"JavaScript:alert(1)", false},
+		{" javascript:alert(1)", false},
+		{"data:text/html;base64,PHNjcmlwdD4=", false},
+		{"vbscript:msgbox", false},
+		{"//example.com", false},
+		{"/relative", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := safeURL(tt.url); got != tt.want {
+			t.Errorf("safeURL(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
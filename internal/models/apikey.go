@@ -0,0 +1,62 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// APIKey is a personal access token for programmatic access.
+// Only a hash of the key is stored; the prefix identifies it in listings and lookups.
+type APIKey struct {
+	ID         uint `gorm:"primarykey"`
+	CreatedAt  time.Time
+	UserID     uint   `gorm:"index;not null"`
+	Name       string `gorm:"type:varchar(255);not null"`
+	Prefix     string `gorm:"type:varchar(32);uniqueIndex;not null"`
+	KeyHash    string `gorm:"type:char(64);not null"`
+	Scopes     string `gorm:"type:varchar(255);not null"`
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+}
+
+// ScopeList returns the key's scopes
+func (k *APIKey) ScopeList() []string {
+	if k.Scopes == "" {
+		return []string{}
+	}
+	return strings.Split(k.Scopes, ",")
+}
+
+// HasScope returns true if the key was granted the scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.ScopeList() {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsActive returns true if the key is neither revoked nor expired
+func (k *APIKey) IsActive() bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || time.Now().Before(*k.ExpiresAt)
+}
+
+// ToMap converts the key to a map for API responses; the secret is never included
+func (k *APIKey) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"id":         k.ID,
+		"name":       k.Name,
+		"prefix":     k.Prefix,
+		"scopes":     k.ScopeList(),
+		"createdAt":  k.CreatedAt,
+		"expiresAt":  k.ExpiresAt,
+		"lastUsedAt": k.LastUsedAt,
+		"revokedAt":  k.RevokedAt,
+		"active":     k.IsActive(),
+	}
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// AuditDetails holds event-specific context such as changed fields
+type AuditDetails map[string]interface{}
+
+// Value implements the driver.Valuer interface for GORM
+func (d AuditDetails) Value() (driver.Value, error) {
+	if d == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(d)
+}
+
+// Scan implements the sql.Scanner interface for GORM
+func (d *AuditDetails) Scan(value interface{}) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New("type assertion to []byte failed")
+	}
+	return json.Unmarshal(bytes, d)
+}
+
+// AuditEvent is an append-only record of a security-relevant or data-changing action.
+// Actor fields are copied rather than referenced so entries survive account changes.
+type AuditEvent struct {
+	ID         uint      `gorm:"primarykey"`
+	CreatedAt  time.Time `gorm:"index;not null"`
+	Action     string    `gorm:"type:varchar(64);index;not null"`
+	Success    bool      `gorm:"not null;default:true"`
+	ActorID    *uint     `gorm:"index"`
+	ActorEmail string    `gorm:"type:varchar(255)"`
+	// ActorKeyID is set when the actor authenticated with an API key
+	ActorKeyID *uint
+	IP         string       `gorm:"type:varchar(64)"`
+	UserAgent  string       `gorm:"type:varchar(512)"`
+	TargetType string       `gorm:"type:varchar(32);index:idx_audit_target"`
+	TargetID   string       `gorm:"type:varchar(64);index:idx_audit_target"`
+	Details    AuditDetails `gorm:"type:jsonb;not null;default:'{}'"`
+}
+
+// ToMap converts the event to a map for API responses
+func (e *AuditEvent) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"id":         e.ID,
+		"createdAt":  e.CreatedAt,
+		"action":     e.Action,
+		"success":    e.Success,
+		"actorId":    e.ActorID,
+		"actorEmail": e.ActorEmail,
+		"actorKeyId": e.ActorKeyID,
+		"ip":         e.IP,
+		"userAgent":  e.UserAgent,
+		"targetType": e.TargetType,
+		"targetId":   e.TargetID,
+		"details":    e.Details,
+	}
+}
@@ -12,7 +12,9 @@ type Chat struct {
 	Title       string    `gorm:"type:varchar(255);not null"`
 	Messages    []Message `gorm:"foreignKey:ChatID;constraint:OnDelete:CASCADE"`
 	LastMessage time.Time `gorm:"index"`
-	UserID      string    `gorm:"type:varchar(255);index"`
+	UserID      uint      `gorm:"index"`
+	// WorkspaceID is set for chats shared with a workspace; UserID is then the member who started it
+	WorkspaceID *uint `gorm:"index"`
 }
 
 // BeforeCreate is a GORM hook that sets default values before creating a chat
@@ -58,3 +60,16 @@ func (c *Chat) Summary() map[string]interface{} {
 		"preview":      lastMessageContent,
 	}
 }
+
+// ToMap converts the chat, without its messages, to a map for API responses and events
+func (c *Chat) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"id":          c.ID,
+		"title":       c.Title,
+		"userId":      c.UserID,
+		"workspaceId": c.WorkspaceID,
+		"createdAt":   c.CreatedAt,
+		"updatedAt":   c.UpdatedAt,
+		"lastMessage": c.LastMessage,
+	}
+}
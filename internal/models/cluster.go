@@ -0,0 +1,14 @@
+package models
+
+import (
+	"time"
+)
+
+// ClusterPayload holds a cluster message too large for a Postgres notification.
+// The notification carries only its ID; payloads are deleted once every instance had time to read them.
+type ClusterPayload struct {
+	ID        uint64    `gorm:"primarykey"`
+	NodeID    string    `gorm:"type:varchar(255);not null"`
+	Data      string    `gorm:"type:text;not null"`
+	CreatedAt time.Time `gorm:"index"`
+}
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"time"
+)
+
+// Credit amounts are stored in micro-credits; one credit is one US dollar of provider cost
+const MicrosPerCredit = 1_000_000
+
+// Credit ledger entry kinds
+const (
+	CreditKindGrant      = "grant"
+	CreditKindAdjustment = "adjustment"
+	CreditKindCharge     = "charge"
+)
+
+// Budget scopes and periods
+const (
+	BudgetScopeRole      = "role"
+	BudgetScopeUser      = "user"
+	BudgetScopeWorkspace = "workspace"
+
+	BudgetPeriodDaily   = "daily"
+	BudgetPeriodMonthly = "monthly"
+)
+
+// BudgetPeriods lists every budget period
+var BudgetPeriods = []string{BudgetPeriodDaily, BudgetPeriodMonthly}
+
+// CreditAccount holds a user's current credit balance; the ledger is the history behind it
+type CreditAccount struct {
+	UserID    uint  `gorm:"primaryKey"`
+	Balance   int64 `gorm:"not null;default:0"`
+	UpdatedAt time.Time
+}
+
+// CreditEntry is an append-only ledger entry changing a user's balance
+type CreditEntry struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"index"`
+	UserID    uint      `gorm:"index:idx_credit_entries_user_kind;not null"`
+	Kind      string    `gorm:"type:varchar(20);index:idx_credit_entries_user_kind;not null"`
+	// Amount is negative for charges
+	Amount       int64 `gorm:"not null"`
+	BalanceAfter int64 `gorm:"not null"`
+	// JobID and Model are set for charges of a generation
+	JobID *uint
+	Model string `gorm:"type:varchar(255)"`
+	// WorkspaceID is set for charges of generations in a workspace chat
+	WorkspaceID *uint `gorm:"index"`
+	// ActorID is the administrator who granted or adjusted credits
+	ActorID *uint
+	Note    string `gorm:"type:varchar(255)"`
+}
+
+// ToMap converts the entry to a map for API responses
+func (e *CreditEntry) ToMap() map[string]interface{} {
+	result := map[string]interface{}{
+		"id":           e.ID,
+		"createdAt":    e.CreatedAt,
+		"kind":         e.Kind,
+		"amount":       CreditsFromMicros(e.Amount),
+		"balanceAfter": CreditsFromMicros(e.BalanceAfter),
+	}
+	if e.JobID != nil {
+		result["jobId"] = *e.JobID
+	}
+	if e.Model != "" {
+		result["model"] = e.Model
+	}
+	if e.WorkspaceID != nil {
+		result["workspaceId"] = *e.WorkspaceID
+	}
+	if e.ActorID != nil {
+		result["actorId"] = *e.ActorID
+	}
+	if e.Note != "" {
+		result["note"] = e.Note
+	}
+	return result
+}
+
+// Budget caps how much a role or a single user may spend per day or month.
+// A user budget replaces the role budget of the same period. A workspace budget caps
+// what all members together spend in the workspace's chats, on top of their own budgets.
+type Budget struct {
+	Scope string `gorm:"type:varchar(10);primaryKey"`
+	// Subject is the role name, the user ID or the workspace ID
+	Subject   string `gorm:"type:varchar(64);primaryKey"`
+	Period    string `gorm:"type:varchar(10);primaryKey"`
+	Amount    int64  `gorm:"not null"`
+	UpdatedAt time.Time
+}
+
+// ToMap converts the budget to a map for API responses
+func (b *Budget) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"scope":     b.Scope,
+		"subject":   b.Subject,
+		"period":    b.Period,
+		"amount":    CreditsFromMicros(b.Amount),
+		"updatedAt": b.UpdatedAt,
+	}
+}
+
+// CreditsFromMicros converts micro-credits to credits
+func CreditsFromMicros(micros int64) float64 {
+	return float64(micros) / MicrosPerCredit
+}
+
+// MicrosFromCredits converts credits to micro-credits, rounding to the nearest micro-credit
+func MicrosFromCredits(credits float64) int64 {
+	if credits < 0 {
+		return -int64(-credits*MicrosPerCredit + 0.5)
+	}
+	return int64(credits*MicrosPerCredit + 0.5)
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+)
+
+// Status constants for generation jobs
+const (
+	JobStatusRunning   = "running"
+	JobStatusCompleted = "completed"
+	JobStatusFailed    = "failed"
+	JobStatusCancelled = "cancelled"
+)
+
+// GenerationJob tracks an AI generation that runs server-side, independent of any WebSocket connection
+type GenerationJob struct {
+	ID         uint `gorm:"primarykey"`
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	ChatID     uint64 `gorm:"index;not null"`
+	UserID     uint   `gorm:"index"`
+	Model      string `gorm:"type:varchar(255)"`
+	Status     string `gorm:"type:varchar(20);index;not null"`
+	Content    string `gorm:"type:text"`
+	Error      string `gorm:"type:text"`
+	ParentID   *uint  // User message the job answers
+	MessageID  *uint  // Assistant message the job streams into
+	FinishedAt *time.Time
+}
+
+// IsRunning returns true if the job has not finished yet
+func (j *GenerationJob) IsRunning() bool {
+	return j.Status == JobStatusRunning
+}
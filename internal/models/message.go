@@ -13,6 +13,17 @@ const (
 	RoleSystem    = "system"
 )
 
+// Status constants for the message lifecycle.
+// Assistant replies start pending, become streaming with the first token and end
+// complete, failed or cancelled. User and system messages are always complete.
+const (
+	MessageStatusPending   = "pending"
+	MessageStatusStreaming = "streaming"
+	MessageStatusComplete  = "complete"
+	MessageStatusFailed    = "failed"
+	MessageStatusCancelled = "cancelled"
+)
+
 // Message represents a single message in a chat
 type Message struct {
 	ID        uint `gorm:"primarykey"`
@@ -24,6 +35,14 @@ type Message struct {
 	ChatID    uint64          `gorm:"index;not null"`
 	Timestamp time.Time       `gorm:"index;not null;default:CURRENT_TIMESTAMP"`
 	Metadata  MessageMetadata `gorm:"type:jsonb"`
+	Status    string          `gorm:"type:varchar(20);not null;default:'complete';index"`
+	Error     string          `gorm:"type:text"`
+	// ParentID links an assistant reply to the user message it answers.
+	// Replies generated side by side in comparison mode share the same parent.
+	ParentID *uint `gorm:"index"`
+	// UserID is the author of a user message, which matters once several people prompt in a chat.
+	// It is nil for generated replies.
+	UserID *uint `gorm:"index"`
 }
 
 // BeforeCreate is a GORM hook that sets default values before creating a message
@@ -33,6 +52,11 @@ func (m *Message) BeforeCreate(tx *gorm.DB) error {
 		m.Timestamp = time.Now()
 	}
 
+	// Messages that are not generated are complete on creation
+	if m.Status == "" {
+		m.Status = MessageStatusComplete
+	}
+
 	// Validate message role
 	switch m.Role {
 	case RoleUser, RoleAssistant, RoleSystem:
@@ -54,6 +78,21 @@ func (m *Message) IsFromAssistant() bool {
 	return m.Role == RoleAssistant
 }
 
+// IsFinished returns true if the message will not receive more content
+func (m *Message) IsFinished() bool {
+	switch m.Status {
+	case MessageStatusPending, MessageStatusStreaming:
+		return false
+	default:
+		return true
+	}
+}
+
+// CanRetry returns true if the message is a reply whose generation did not complete
+func (m *Message) CanRetry() bool {
+	return m.IsFromAssistant() && (m.Status == MessageStatusFailed || m.Status == MessageStatusCancelled)
+}
+
 // ToMap converts the message to a map for API responses
 func (m *Message) ToMap() map[string]interface{} {
 	return map[string]interface{}{
@@ -63,5 +102,9 @@ func (m *Message) ToMap() map[string]interface{} {
 		"chatId":    m.ChatID,
 		"timestamp": m.Timestamp,
 		"metadata":  m.Metadata,
+		"parentId":  m.ParentID,
+		"userId":    m.UserID,
+		"status":    m.Status,
+		"error":     m.Error,
 	}
 }
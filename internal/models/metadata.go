@@ -8,9 +8,20 @@ import (
 
 // MessageMetadata contains additional information about a message
 type MessageMetadata struct {
-	Model       string `json:"model,omitempty"`
-	TokenCount  int    `json:"token_count,omitempty"`
-	ProcessTime int    `json:"process_time,omitempty"`
+	Model            string  `json:"model,omitempty"`
+	TokenCount       int     `json:"token_count,omitempty"`
+	ProcessTime      int     `json:"process_time,omitempty"`
+	PromptTokens     int     `json:"prompt_tokens,omitempty"`
+	CompletionTokens int     `json:"completion_tokens,omitempty"`
+	Cost             float64 `json:"cost,omitempty"`
+
+	// Performance metrics; durations are in milliseconds
+	TimeToFirstToken int     `json:"time_to_first_token,omitempty"`
+	Latency          int     `json:"latency,omitempty"`
+	TokensPerSecond  float64 `json:"tokens_per_second,omitempty"`
+	RetryCount       int     `json:"retry_count,omitempty"`
+	Provider         string  `json:"provider,omitempty"`
+	FallbackHops     int     `json:"fallback_hops,omitempty"`
 }
 
 // Value implements the driver.Valuer interface for GORM
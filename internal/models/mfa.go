@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+)
+
+// RecoveryCode is a hashed one-time code that replaces an authenticator code
+type RecoveryCode struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"index;not null"`
+	CodeHash  string `gorm:"type:char(64);not null"`
+	UsedAt    *time.Time
+}
+
+// RolePolicy holds the security requirements an administrator set for a role
+type RolePolicy struct {
+	Role        string `gorm:"type:varchar(20);primaryKey"`
+	RequireTOTP bool   `gorm:"not null;default:false"`
+	UpdatedAt   time.Time
+}
+
+// ToMap converts the policy to a map for API responses
+func (p *RolePolicy) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"role":        p.Role,
+		"requireTotp": p.RequireTOTP,
+		"updatedAt":   p.UpdatedAt,
+	}
+}
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+// Chat participant roles. Prompters send messages and start generations,
+// watchers follow the conversation and its streamed replies.
+const (
+	ParticipantRolePrompter = "prompter"
+	ParticipantRoleWatcher  = "watcher"
+)
+
+// IsValidParticipantRole returns true if role is a known chat participant role
+func IsValidParticipantRole(role string) bool {
+	return role == ParticipantRolePrompter || role == ParticipantRoleWatcher
+}
+
+// ChatParticipant invites a user into a single chat, in addition to its owner
+// and, for workspace chats, the workspace's members
+type ChatParticipant struct {
+	ChatID    uint64 `gorm:"primaryKey"`
+	UserID    uint   `gorm:"primaryKey;index"`
+	Role      string `gorm:"type:varchar(20);not null"`
+	CreatedAt time.Time
+	User      *User `gorm:"foreignKey:UserID"`
+}
+
+// CanPrompt returns true if the participant may send messages to the chat
+func (p *ChatParticipant) CanPrompt() bool {
+	return p.Role == ParticipantRolePrompter
+}
+
+// ToMap converts the participant to a map for API responses
+func (p *ChatParticipant) ToMap() map[string]interface{} {
+	result := map[string]interface{}{
+		"chatId":    p.ChatID,
+		"userId":    p.UserID,
+		"role":      p.Role,
+		"createdAt": p.CreatedAt,
+	}
+	if p.User != nil {
+		result["email"] = p.User.Email
+		result["name"] = p.User.Name
+	}
+	return result
+}
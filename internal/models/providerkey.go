@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+)
+
+// Providers users can store their own API key for
+const (
+	ProviderOpenRouter = "openrouter"
+)
+
+// Providers lists every provider that accepts a user's own API key
+var Providers = []string{ProviderOpenRouter}
+
+// ProviderKey is a user's own API key for an AI provider, encrypted with a server master key.
+// Generations of the user are billed to this key instead of the server's.
+type ProviderKey struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserID    uint   `gorm:"uniqueIndex:idx_provider_keys_user_provider;not null"`
+	Provider  string `gorm:"type:varchar(32);uniqueIndex:idx_provider_keys_user_provider;not null"`
+	// Ciphertext is the encrypted key; MasterKeyID names the master key it was encrypted with
+	Ciphertext  []byte `gorm:"type:bytea;not null"`
+	MasterKeyID string `gorm:"type:varchar(32);index;not null"`
+	// Hint is the end of the key so users can tell which one they stored
+	Hint       string `gorm:"type:varchar(16)"`
+	LastUsedAt *time.Time
+	// LastError is the provider's last complaint about the key, cleared once it works again
+	LastError   string `gorm:"type:varchar(255)"`
+	LastErrorAt *time.Time
+}
+
+// ToMap converts the key to a map for API responses; the secret is never included
+func (k *ProviderKey) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"provider":    k.Provider,
+		"hint":        k.Hint,
+		"createdAt":   k.CreatedAt,
+		"updatedAt":   k.UpdatedAt,
+		"lastUsedAt":  k.LastUsedAt,
+		"lastError":   k.LastError,
+		"lastErrorAt": k.LastErrorAt,
+	}
+}
+
+// IsValidProvider returns true if users can store a key for the provider
+func IsValidProvider(provider string) bool {
+	for _, p := range Providers {
+		if p == provider {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+)
+
+// Session is a server-side login session identified by a hashed bearer token
+type Session struct {
+	ID         uint `gorm:"primarykey"`
+	CreatedAt  time.Time
+	UserID     uint      `gorm:"index;not null"`
+	TokenHash  string    `gorm:"type:char(64);uniqueIndex;not null"`
+	ExpiresAt  time.Time `gorm:"index;not null"`
+	LastSeenAt time.Time
+	IP         string `gorm:"type:varchar(64)"`
+	UserAgent  string `gorm:"type:varchar(512)"`
+	// SecondFactor is true if the login passed a second factor or came from single sign-on
+	SecondFactor bool `gorm:"not null;default:false"`
+}
+
+// IsExpired returns true if the session can no longer be used
+func (s *Session) IsExpired() bool {
+	return time.Now().After(s.ExpiresAt)
+}
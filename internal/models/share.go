@@ -0,0 +1,95 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// SharedMessage is a message as it appears on a public share page
+type SharedMessage struct {
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	Model     string    `json:"model,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ShareSnapshot is the frozen copy of a conversation kept by a snapshot share
+type ShareSnapshot struct {
+	Title    string          `json:"title"`
+	Messages []SharedMessage `json:"messages"`
+}
+
+// Value implements the driver.Valuer interface for GORM
+func (s ShareSnapshot) Value() (driver.Value, error) {
+	return json.Marshal(s)
+}
+
+// Scan implements the sql.Scanner interface for GORM
+func (s *ShareSnapshot) Scan(value interface{}) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return errors.New("type assertion to []byte failed")
+	}
+	return json.Unmarshal(bytes, s)
+}
+
+// ChatShare is a public, read-only link to a chat.
+// Only a hash of the token is stored. A share made at a specific message keeps a snapshot
+// of the conversation up to it; other shares show the chat as it currently is.
+type ChatShare struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	ChatID    uint64 `gorm:"index;not null"`
+	UserID    uint   `gorm:"index;not null"`
+	TokenHash string `gorm:"type:char(64);uniqueIndex;not null"`
+	// MessageID is the last message included in the snapshot
+	MessageID    *uint
+	Snapshot     *ShareSnapshot `gorm:"type:jsonb"`
+	ExpiresAt    *time.Time
+	RevokedAt    *time.Time
+	ViewCount    int64 `gorm:"not null;default:0"`
+	LastViewedAt *time.Time
+}
+
+// IsActive returns true if the share is neither revoked nor expired
+func (s *ChatShare) IsActive() bool {
+	if s.RevokedAt != nil {
+		return false
+	}
+	return s.ExpiresAt == nil || time.Now().Before(*s.ExpiresAt)
+}
+
+// ToMap converts the share to a map for API responses; the token is never included
+func (s *ChatShare) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"id":           s.ID,
+		"chatId":       s.ChatID,
+		"messageId":    s.MessageID,
+		"snapshot":     s.Snapshot != nil,
+		"createdAt":    s.CreatedAt,
+		"expiresAt":    s.ExpiresAt,
+		"revokedAt":    s.RevokedAt,
+		"viewCount":    s.ViewCount,
+		"lastViewedAt": s.LastViewedAt,
+		"active":       s.IsActive(),
+	}
+}
+
+// NewSharedMessages copies the finished, successful messages of a conversation for sharing
+func NewSharedMessages(messages []Message) []SharedMessage {
+	shared := make([]SharedMessage, 0, len(messages))
+	for _, m := range messages {
+		if m.Status != MessageStatusComplete || m.Role == RoleSystem {
+			continue
+		}
+		shared = append(shared, SharedMessage{
+			Role:      m.Role,
+			Content:   m.Content,
+			Model:     m.Metadata.Model,
+			Timestamp: m.Timestamp,
+		})
+	}
+	return shared
+}
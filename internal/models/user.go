@@ -0,0 +1,82 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// User roles
+const (
+	UserRoleAdmin    = "admin"
+	UserRoleMember   = "member"
+	UserRoleReadOnly = "read-only"
+)
+
+// UserRoles lists every valid user role
+var UserRoles = []string{UserRoleAdmin, UserRoleMember, UserRoleReadOnly}
+
+// User represents an account that can sign in and own chats
+type User struct {
+	gorm.Model
+	Email        string `gorm:"type:varchar(255);uniqueIndex;not null"`
+	Name         string `gorm:"type:varchar(255)"`
+	PasswordHash string `gorm:"type:varchar(255)"`
+	Role         string `gorm:"type:varchar(20);not null;default:'member'"`
+	Active       bool   `gorm:"not null;default:true"`
+	LastLogin    *time.Time
+	// LegacyID is the free-form user ID this account was migrated from, if any
+	LegacyID string `gorm:"type:varchar(255);index"`
+	// OIDCIssuer and OIDCSubject identify the single sign-on identity linked to this account
+	OIDCIssuer  *string `gorm:"type:varchar(255);uniqueIndex:idx_users_oidc"`
+	OIDCSubject *string `gorm:"type:varchar(255);uniqueIndex:idx_users_oidc"`
+	// TOTPSecret is the base32 authenticator secret; it is pending until TOTPEnabledAt is set
+	TOTPSecret    string `gorm:"type:varchar(64)"`
+	TOTPEnabledAt *time.Time
+	// TOTPLastStep is the last accepted time step, so a code cannot be replayed
+	TOTPLastStep int64 `gorm:"not null;default:0"`
+}
+
+// HasPassword returns true if the user can sign in with a password
+func (u *User) HasPassword() bool {
+	return u.PasswordHash != ""
+}
+
+// HasTOTP returns true if the user has completed two-factor enrollment
+func (u *User) HasTOTP() bool {
+	return u.TOTPEnabledAt != nil && u.TOTPSecret != ""
+}
+
+// IsAdmin returns true if the user administers the instance
+func (u *User) IsAdmin() bool {
+	return u.Role == UserRoleAdmin
+}
+
+// CanWrite returns true if the user may create chats and run generations
+func (u *User) CanWrite() bool {
+	return u.Role != UserRoleReadOnly
+}
+
+// IsValidUserRole returns true if role is a known user role
+func IsValidUserRole(role string) bool {
+	for _, r := range UserRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// ToMap converts the user to a map for API responses
+func (u *User) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"id":        u.ID,
+		"email":     u.Email,
+		"name":      u.Name,
+		"role":      u.Role,
+		"active":    u.Active,
+		"totp":      u.HasTOTP(),
+		"lastLogin": u.LastLogin,
+		"createdAt": u.CreatedAt,
+	}
+}
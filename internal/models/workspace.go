@@ -0,0 +1,90 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Workspace member roles, from most to least privileged.
+// Owners and admins manage the workspace and its members, members chat in it
+// and viewers can only read its chats.
+const (
+	WorkspaceRoleOwner  = "owner"
+	WorkspaceRoleAdmin  = "admin"
+	WorkspaceRoleMember = "member"
+	WorkspaceRoleViewer = "viewer"
+)
+
+// WorkspaceRoles lists every workspace role
+var WorkspaceRoles = []string{WorkspaceRoleOwner, WorkspaceRoleAdmin, WorkspaceRoleMember, WorkspaceRoleViewer}
+
+// IsValidWorkspaceRole returns true if role is a known workspace role
+func IsValidWorkspaceRole(role string) bool {
+	for _, r := range WorkspaceRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Workspace is a team sharing chats. Its persona and default model apply to every
+// generation in its chats, and its budgets cap what its members spend there together.
+type Workspace struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+	Name      string         `gorm:"type:varchar(255);not null"`
+	// SystemPrompt is the persona sent ahead of every conversation in the workspace
+	SystemPrompt string `gorm:"type:text"`
+	// DefaultModel is used when a generation does not name a model
+	DefaultModel string `gorm:"type:varchar(255)"`
+}
+
+// ToMap converts the workspace to a map for API responses
+func (w *Workspace) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		"id":           w.ID,
+		"name":         w.Name,
+		"systemPrompt": w.SystemPrompt,
+		"defaultModel": w.DefaultModel,
+		"createdAt":    w.CreatedAt,
+		"updatedAt":    w.UpdatedAt,
+	}
+}
+
+// WorkspaceMember grants a user a role in a workspace
+type WorkspaceMember struct {
+	WorkspaceID uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"primaryKey;index"`
+	Role        string `gorm:"type:varchar(20);not null"`
+	CreatedAt   time.Time
+	User        *User `gorm:"foreignKey:UserID"`
+}
+
+// CanManage returns true if the member may change the workspace and its members
+func (m *WorkspaceMember) CanManage() bool {
+	return m.Role == WorkspaceRoleOwner || m.Role == WorkspaceRoleAdmin
+}
+
+// CanWrite returns true if the member may create chats and send messages
+func (m *WorkspaceMember) CanWrite() bool {
+	return m.Role != WorkspaceRoleViewer
+}
+
+// ToMap converts the membership to a map for API responses
+func (m *WorkspaceMember) ToMap() map[string]interface{} {
+	result := map[string]interface{}{
+		"workspaceId": m.WorkspaceID,
+		"userId":      m.UserID,
+		"role":        m.Role,
+		"createdAt":   m.CreatedAt,
+	}
+	if m.User != nil {
+		result["email"] = m.User.Email
+		result["name"] = m.User.Name
+	}
+	return result
+}
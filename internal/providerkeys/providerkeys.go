@@ -0,0 +1,194 @@
+package providerkeys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/repository"
+	"github.com/hra42/7x42/internal/secrets"
+	"gorm.io/gorm"
+)
+
+// Provider key errors
+var (
+	ErrDisabled        = errors.New("storing provider keys is not enabled on this server")
+	ErrInvalidProvider = errors.New("unknown provider")
+	ErrInvalidKey      = errors.New("invalid provider API key")
+	ErrUnreadable      = errors.New("your stored provider key can no longer be decrypted; please enter it again")
+)
+
+// rewrapBatchSize is how many keys are re-encrypted per query after a master key rotation
+const rewrapBatchSize = 100
+
+// Key is a decrypted provider key, ready to make requests with
+type Key struct {
+	ID       uint
+	Provider string
+	Secret   string
+}
+
+// Service stores users' own provider API keys encrypted at rest
+type Service struct {
+	repo    *repository.ProviderKeyRepository
+	keyring *secrets.Keyring
+}
+
+// NewService creates a new provider key service. Without a master key, users cannot store
+// keys, and keys stored earlier are ignored.
+func NewService(db *gorm.DB, keyring *secrets.Keyring) *Service {
+	return &Service{
+		repo:    repository.NewProviderKeyRepository(db),
+		keyring: keyring,
+	}
+}
+
+// Enabled returns true if users can store their own keys
+func (s *Service) Enabled() bool {
+	return s.keyring.Enabled()
+}
+
+// List lists the keys a user has stored, without their secrets
+func (s *Service) List(ctx context.Context, userID uint) ([]models.ProviderKey, error) {
+	return s.repo.ListProviderKeys(ctx, userID)
+}
+
+// Set encrypts and stores a user's key for a provider, replacing any previous one
+func (s *Service) Set(ctx context.Context, userID uint, provider, secret string) (*models.ProviderKey, error) {
+	if !s.Enabled() {
+		return nil, ErrDisabled
+	}
+	if !models.IsValidProvider(provider) {
+		return nil, ErrInvalidProvider
+	}
+
+	secret = strings.TrimSpace(secret)
+	if len(secret) < 16 || len(secret) > 512 || strings.ContainsAny(secret, " \t\r\n") {
+		return nil, ErrInvalidKey
+	}
+
+	ciphertext, keyID, err := s.keyring.Encrypt([]byte(secret), keyContext(userID, provider))
+	if err != nil {
+		return nil, err
+	}
+
+	key := &models.ProviderKey{
+		UserID:      userID,
+		Provider:    provider,
+		Ciphertext:  ciphertext,
+		MasterKeyID: keyID,
+		Hint:        "…" + secret[len(secret)-4:],
+	}
+	if err := s.repo.SaveProviderKey(ctx, key); err != nil {
+		return nil, err
+	}
+
+	return s.repo.GetProviderKey(ctx, userID, provider)
+}
+
+// Delete removes a user's key for a provider; their generations use the server's key again
+func (s *Service) Delete(ctx context.Context, userID uint, provider string) error {
+	return s.repo.DeleteProviderKey(ctx, userID, provider)
+}
+
+// Resolve returns the user's decrypted key for a provider, or nil if they have none.
+// A key that cannot be decrypted, e.g. because its master key was removed, is an error
+// rather than a silent fallback to the server's key.
+func (s *Service) Resolve(ctx context.Context, userID uint, provider string) (*Key, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+
+	stored, err := s.repo.GetProviderKey(ctx, userID, provider)
+	if err != nil {
+		if repository.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	secret, err := s.keyring.Decrypt(stored.Ciphertext, stored.MasterKeyID, keyContext(userID, provider))
+	if err != nil {
+		log.Printf("Failed to decrypt %s key of user %d: %v", provider, userID, err)
+		return nil, ErrUnreadable
+	}
+
+	return &Key{ID: stored.ID, Provider: provider, Secret: string(secret)}, nil
+}
+
+// MarkUsed records that a key worked
+func (s *Service) MarkUsed(ctx context.Context, key *Key) {
+	if err := s.repo.MarkProviderKeyUsed(ctx, key.ID, time.Now()); err != nil {
+		log.Printf("Failed to record use of provider key %d: %v", key.ID, err)
+	}
+}
+
+// MarkFailed records that the provider rejected a key, so the user can see why in their settings
+func (s *Service) MarkFailed(ctx context.Context, key *Key, message string) {
+	if len(message) > 255 {
+		message = message[:255]
+	}
+	if err := s.repo.MarkProviderKeyFailed(ctx, key.ID, message, time.Now()); err != nil {
+		log.Printf("Failed to record error of provider key %d: %v", key.ID, err)
+	}
+}
+
+// Rewrap re-encrypts keys stored under an older master key with the current one.
+// Run it after rotating; once it reports no failures, the old master key can be removed.
+func (s *Service) Rewrap(ctx context.Context) (rewrapped int, failed int, err error) {
+	if !s.Enabled() {
+		return 0, 0, nil
+	}
+
+	var afterID uint
+	for {
+		keys, err := s.repo.ListStaleProviderKeys(ctx, s.keyring.CurrentKeyID(), afterID, rewrapBatchSize)
+		if err != nil {
+			return rewrapped, failed, err
+		}
+
+		for i := range keys {
+			key := &keys[i]
+			afterID = key.ID
+
+			ok, err := s.rewrapKey(ctx, key)
+			if err != nil {
+				log.Printf("Failed to re-encrypt provider key %d: %v", key.ID, err)
+				failed++
+				continue
+			}
+			if ok {
+				rewrapped++
+			}
+		}
+
+		if len(keys) < rewrapBatchSize {
+			return rewrapped, failed, nil
+		}
+	}
+}
+
+// rewrapKey re-encrypts a single key with the current master key
+func (s *Service) rewrapKey(ctx context.Context, key *models.ProviderKey) (bool, error) {
+	aad := keyContext(key.UserID, key.Provider)
+
+	secret, err := s.keyring.Decrypt(key.Ciphertext, key.MasterKeyID, aad)
+	if err != nil {
+		return false, err
+	}
+	ciphertext, keyID, err := s.keyring.Encrypt(secret, aad)
+	if err != nil {
+		return false, err
+	}
+
+	return s.repo.UpdateCiphertext(ctx, key, ciphertext, keyID)
+}
+
+// keyContext binds a ciphertext to its owner and provider
+func keyContext(userID uint, provider string) []byte {
+	return []byte(fmt.Sprintf("provider-key:%d:%s", userID, provider))
+}
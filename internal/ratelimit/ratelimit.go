@@ -0,0 +1,196 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hra42/7x42/internal/models"
+)
+
+// Classes of limited traffic
+const (
+	// ClassRequests is REST API requests
+	ClassRequests = "requests"
+	// ClassMessages is messages received over WebSocket connections
+	ClassMessages = "messages"
+	// ClassGenerations is AI generations, which cost money; a comparison counts once per model
+	ClassGenerations = "generations"
+)
+
+// Classes lists every class of limited traffic
+var Classes = []string{ClassRequests, ClassMessages, ClassGenerations}
+
+// sweepInterval is how often buckets that have refilled are dropped; a full bucket is the same as no bucket
+const sweepInterval = 10 * time.Minute
+
+// Limit is a token bucket: Burst tokens at most, refilled at PerMinute tokens per minute.
+// The zero Limit never blocks.
+type Limit struct {
+	PerMinute float64
+	Burst     int
+}
+
+// ParseLimit parses a limit written as "perMinute/burst", e.g. "60/10", or "off"
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "off" || s == "unlimited" {
+		return Limit{}, nil
+	}
+
+	rate, burst, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: want perMinute/burst", s)
+	}
+	perMinute, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+	if err != nil || perMinute < 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: bad rate", s)
+	}
+	b, err := strconv.Atoi(strings.TrimSpace(burst))
+	if err != nil || b < 0 {
+		return Limit{}, fmt.Errorf("invalid rate limit %q: bad burst", s)
+	}
+
+	return Limit{PerMinute: perMinute, Burst: b}, nil
+}
+
+// unlimited returns true if the limit never blocks
+func (l Limit) unlimited() bool {
+	return l.PerMinute <= 0 || l.Burst <= 0
+}
+
+// Config holds the limits per role and per client IP
+type Config struct {
+	// Roles maps a role to its limits per class; roles or classes without an entry are unlimited
+	Roles map[string]map[string]Limit
+	// IP limits requests per client address, whether authenticated or not
+	IP Limit
+}
+
+// DefaultConfig returns limits generous enough for interactive use but not for runaway scripts
+func DefaultConfig() Config {
+	return Config{
+		Roles: map[string]map[string]Limit{
+			models.UserRoleAdmin: {
+				ClassRequests:    {PerMinute: 600, Burst: 120},
+				ClassMessages:    {PerMinute: 240, Burst: 60},
+				ClassGenerations: {PerMinute: 60, Burst: 15},
+			},
+			models.UserRoleMember: {
+				ClassRequests:    {PerMinute: 300, Burst: 60},
+				ClassMessages:    {PerMinute: 120, Burst: 30},
+				ClassGenerations: {PerMinute: 20, Burst: 6},
+			},
+			// Read-only users cannot start generations at all
+			models.UserRoleReadOnly: {
+				ClassRequests: {PerMinute: 120, Burst: 30},
+				ClassMessages: {PerMinute: 60, Burst: 15},
+			},
+		},
+		IP: Limit{PerMinute: 600, Burst: 120},
+	}
+}
+
+// Limiter enforces token-bucket limits per user and per IP.
+// State is kept in memory, so each server instance limits independently.
+type Limiter struct {
+	config Config
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// bucket is the state of one token bucket
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// full is when the bucket will have refilled completely
+	full time.Time
+}
+
+// NewLimiter creates a new limiter
+func NewLimiter(config Config) *Limiter {
+	return &Limiter{
+		config:    config,
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// AllowUser takes n tokens of a class from the user's bucket.
+// If the bucket is empty it returns false and how long until n tokens are available.
+func (l *Limiter) AllowUser(userID uint, role, class string, n int) (bool, time.Duration) {
+	limit, ok := l.config.Roles[role][class]
+	if !ok {
+		return true, 0
+	}
+	return l.take(fmt.Sprintf("u:%d:%s", userID, class), limit, n)
+}
+
+// AllowIP takes a request token from the client address's bucket
+func (l *Limiter) AllowIP(ip string) (bool, time.Duration) {
+	return l.take("ip:"+ip, l.config.IP, 1)
+}
+
+// take removes n tokens from a bucket, refilling it for the time since it was last used
+func (l *Limiter) take(key string, limit Limit, n int) (bool, time.Duration) {
+	if limit.unlimited() {
+		return true, 0
+	}
+	if n > limit.Burst {
+		// The request can never succeed, however long the caller waits
+		return false, retryNever
+	}
+
+	now := time.Now()
+	rate := limit.PerMinute / 60
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = b
+	} else {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+		b.last = now
+	}
+
+	if b.tokens >= float64(n) {
+		b.tokens -= float64(n)
+		b.full = now.Add(time.Duration((float64(limit.Burst) - b.tokens) / rate * float64(time.Second)))
+		return true, 0
+	}
+
+	missing := float64(n) - b.tokens
+	return false, time.Duration(math.Ceil(missing/rate*1000)) * time.Millisecond
+}
+
+// retryNever is the retry delay reported for requests larger than the burst
+const retryNever = time.Hour
+
+// sweep drops refilled buckets now and then so memory does not grow with every address seen; callers hold mu
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		if now.After(b.full) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// RetryAfterSeconds rounds a retry delay up to whole seconds for Retry-After headers
+func RetryAfterSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
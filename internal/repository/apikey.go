@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hra42/7x42/internal/models"
+	"gorm.io/gorm"
+)
+
+// APIKeyRepository handles database operations for API keys
+type APIKeyRepository struct {
+	*BaseRepository
+}
+
+// NewAPIKeyRepository creates a new API key repository
+func NewAPIKeyRepository(db *gorm.DB) *APIKeyRepository {
+	return &APIKeyRepository{
+		BaseRepository: NewBaseRepository(db),
+	}
+}
+
+// CreateAPIKey creates a new API key
+func (r *APIKeyRepository) CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	if err := r.DB().WithContext(ctx).Create(key).Error; err != nil {
+		return NewError("create", "api_key", err)
+	}
+
+	return nil
+}
+
+// GetAPIKey retrieves an API key by ID
+func (r *APIKeyRepository) GetAPIKey(ctx context.Context, id uint) (*models.APIKey, error) {
+	var key models.APIKey
+
+	err := r.DB().WithContext(ctx).First(&key, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewError("get", "api_key", ErrNotFound)
+		}
+		return nil, NewError("get", "api_key", err)
+	}
+
+	return &key, nil
+}
+
+// GetAPIKeyByPrefix retrieves an API key by its public prefix
+func (r *APIKeyRepository) GetAPIKeyByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	var key models.APIKey
+
+	err := r.DB().WithContext(ctx).
+		Where("prefix = ?", prefix).
+		First(&key).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewError("get", "api_key", ErrNotFound)
+		}
+		return nil, NewError("get", "api_key", err)
+	}
+
+	return &key, nil
+}
+
+// ListUserAPIKeys lists all API keys of a user, newest first
+func (r *APIKeyRepository) ListUserAPIKeys(ctx context.Context, userID uint) ([]models.APIKey, error) {
+	var keys []models.APIKey
+
+	err := r.DB().WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&keys).Error
+
+	if err != nil {
+		return nil, NewError("list", "api_keys", err)
+	}
+
+	return keys, nil
+}
+
+// RevokeAPIKey revokes a user's API key
+func (r *APIKeyRepository) RevokeAPIKey(ctx context.Context, userID, id uint) error {
+	result := r.DB().WithContext(ctx).
+		Model(&models.APIKey{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		Update("revoked_at", time.Now())
+
+	if result.Error != nil {
+		return NewError("revoke", "api_key", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return NewError("revoke", "api_key", ErrNotFound)
+	}
+
+	return nil
+}
+
+// TouchAPIKey records a use of an API key
+func (r *APIKeyRepository) TouchAPIKey(ctx context.Context, id uint, at time.Time) error {
+	result := r.DB().WithContext(ctx).
+		Model(&models.APIKey{}).
+		Where("id = ?", id).
+		Update("last_used_at", at)
+
+	if result.Error != nil {
+		return NewError("update", "api_key.last_used_at", result.Error)
+	}
+
+	return nil
+}
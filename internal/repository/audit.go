@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hra42/7x42/internal/models"
+	"gorm.io/gorm"
+)
+
+// AuditFilter narrows an audit log query; zero fields match everything
+type AuditFilter struct {
+	ActorID *uint
+	// Action matches exactly, or every action in a category when it ends in ".", e.g. "admin."
+	Action     string
+	TargetType string
+	TargetID   string
+	Success    *bool
+	IP         string
+	From       time.Time
+	To         time.Time
+}
+
+// AuditRepository handles database operations for the audit log.
+// It can only append and read; entries are never changed or deleted.
+type AuditRepository struct {
+	*BaseRepository
+}
+
+// NewAuditRepository creates a new audit repository
+func NewAuditRepository(db *gorm.DB) *AuditRepository {
+	return &AuditRepository{
+		BaseRepository: NewBaseRepository(db),
+	}
+}
+
+// CreateAuditEvent appends an event to the audit log
+func (r *AuditRepository) CreateAuditEvent(ctx context.Context, event *models.AuditEvent) error {
+	if err := r.DB().WithContext(ctx).Create(event).Error; err != nil {
+		return NewError("create", "audit event", err)
+	}
+
+	return nil
+}
+
+// ListAuditEvents lists events matching the filter, newest first
+func (r *AuditRepository) ListAuditEvents(ctx context.Context, filter AuditFilter, page, pageSize int) ([]models.AuditEvent, error) {
+	var events []models.AuditEvent
+	offset := (page - 1) * pageSize
+
+	err := r.filterEvents(ctx, filter).
+		Order("id DESC").
+		Offset(offset).
+		Limit(pageSize).
+		Find(&events).Error
+
+	if err != nil {
+		return nil, NewError("list", "audit events", err)
+	}
+
+	return events, nil
+}
+
+// CountAuditEvents counts events matching the filter
+func (r *AuditRepository) CountAuditEvents(ctx context.Context, filter AuditFilter) (int64, error) {
+	var count int64
+
+	err := r.filterEvents(ctx, filter).
+		Model(&models.AuditEvent{}).
+		Count(&count).Error
+
+	if err != nil {
+		return 0, NewError("count", "audit events", err)
+	}
+
+	return count, nil
+}
+
+// ListAuditEventsAfter lists up to limit events matching the filter with an ID above afterID, oldest first.
+// Exports page through the log with it so new entries do not shift the pages.
+func (r *AuditRepository) ListAuditEventsAfter(ctx context.Context, filter AuditFilter, afterID uint, limit int) ([]models.AuditEvent, error) {
+	var events []models.AuditEvent
+
+	err := r.filterEvents(ctx, filter).
+		Where("id > ?", afterID).
+		Order("id ASC").
+		Limit(limit).
+		Find(&events).Error
+
+	if err != nil {
+		return nil, NewError("list", "audit events", err)
+	}
+
+	return events, nil
+}
+
+// filterEvents scopes a query to events matching the filter
+func (r *AuditRepository) filterEvents(ctx context.Context, filter AuditFilter) *gorm.DB {
+	db := r.DB().WithContext(ctx)
+
+	if filter.ActorID != nil {
+		db = db.Where("actor_id = ?", *filter.ActorID)
+	}
+	if filter.Action != "" {
+		if strings.HasSuffix(filter.Action, ".") {
+			db = db.Where("action LIKE ?", escapeLike(filter.Action)+"%")
+		} else {
+			db = db.Where("action = ?", filter.Action)
+		}
+	}
+	if filter.TargetType != "" {
+		db = db.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.TargetID != "" {
+		db = db.Where("target_id = ?", filter.TargetID)
+	}
+	if filter.Success != nil {
+		db = db.Where("success = ?", *filter.Success)
+	}
+	if filter.IP != "" {
+		db = db.Where("ip = ?", filter.IP)
+	}
+	if !filter.From.IsZero() {
+		db = db.Where("created_at >= ?", filter.From)
+	}
+	if !filter.To.IsZero() {
+		db = db.Where("created_at < ?", filter.To)
+	}
+
+	return db
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/hra42/7x42/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // ChatRepository handles database operations for chat entities
@@ -51,8 +52,23 @@ func (r *ChatRepository) GetChat(ctx context.Context, id uint64) (*models.Chat,
 	return &chat, nil
 }
 
+// GetChatInfo retrieves a chat by ID without loading its messages
+func (r *ChatRepository) GetChatInfo(ctx context.Context, id uint64) (*models.Chat, error) {
+	var chat models.Chat
+
+	err := r.DB().WithContext(ctx).First(&chat, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewError("get", "chat", ErrNotFound)
+		}
+		return nil, NewError("get", "chat", err)
+	}
+
+	return &chat, nil
+}
+
 // GetChatByUser retrieves a chat by user ID
-func (r *ChatRepository) GetChatByUser(ctx context.Context, userID string, chatID uint64) (*models.Chat, error) {
+func (r *ChatRepository) GetChatByUser(ctx context.Context, userID uint, chatID uint64) (*models.Chat, error) {
 	var chat models.Chat
 
 	err := r.DB().WithContext(ctx).
@@ -108,13 +124,21 @@ func (r *ChatRepository) DeleteChat(ctx context.Context, id uint64) error {
 	return nil
 }
 
-// ListChats lists chats for a user
-func (r *ChatRepository) ListChats(ctx context.Context, userID string, page, pageSize int) ([]models.Chat, error) {
+// ChatScope selects the chats listed for a user. The zero value selects the user's
+// own chats, the chats of every workspace they are a member of and the chats they were invited into.
+type ChatScope struct {
+	// WorkspaceID selects the chats of one workspace, if the user is a member
+	WorkspaceID uint
+	// Personal selects only the user's own chats outside workspaces
+	Personal bool
+}
+
+// ListChats lists the chats a user can see in a scope, most recently active first
+func (r *ChatRepository) ListChats(ctx context.Context, userID uint, scope ChatScope, page, pageSize int) ([]models.Chat, error) {
 	var chats []models.Chat
 	offset := (page - 1) * pageSize
 
-	err := r.DB().WithContext(ctx).
-		Where("user_id = ?", userID).
+	err := r.scoped(ctx, userID, scope).
 		Order("last_message DESC").
 		Offset(offset).
 		Limit(pageSize).
@@ -127,14 +151,11 @@ func (r *ChatRepository) ListChats(ctx context.Context, userID string, page, pag
 	return chats, nil
 }
 
-// CountChats counts the number of chats for a user
-func (r *ChatRepository) CountChats(ctx context.Context, userID string) (int64, error) {
+// CountChats counts the chats a user can see in a scope
+func (r *ChatRepository) CountChats(ctx context.Context, userID uint, scope ChatScope) (int64, error) {
 	var count int64
 
-	err := r.DB().WithContext(ctx).
-		Model(&models.Chat{}).
-		Where("user_id = ?", userID).
-		Count(&count).Error
+	err := r.scoped(ctx, userID, scope).Count(&count).Error
 
 	if err != nil {
 		return 0, NewError("count", "chats", err)
@@ -142,3 +163,91 @@ func (r *ChatRepository) CountChats(ctx context.Context, userID string) (int64,
 
 	return count, nil
 }
+
+// scoped filters chats by ownership, workspace membership and participation
+func (r *ChatRepository) scoped(ctx context.Context, userID uint, scope ChatScope) *gorm.DB {
+	db := r.DB().WithContext(ctx).Model(&models.Chat{})
+	workspaces := memberWorkspaces(r.DB(), userID)
+
+	switch {
+	case scope.WorkspaceID != 0:
+		return db.Where("workspace_id = ? AND workspace_id IN (?)", scope.WorkspaceID, workspaces)
+	case scope.Personal:
+		return db.Where("user_id = ? AND workspace_id IS NULL", userID)
+	default:
+		return db.Where("id IN (?)", readableChats(r.DB(), userID))
+	}
+}
+
+// GetParticipant retrieves a user's participation in a chat
+func (r *ChatRepository) GetParticipant(ctx context.Context, chatID uint64, userID uint) (*models.ChatParticipant, error) {
+	var participant models.ChatParticipant
+
+	err := r.DB().WithContext(ctx).
+		Where("chat_id = ? AND user_id = ?", chatID, userID).
+		First(&participant).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewError("get", "chat_participant", ErrNotFound)
+		}
+		return nil, NewError("get", "chat_participant", err)
+	}
+
+	return &participant, nil
+}
+
+// ListParticipants lists the participants of a chat with their accounts, oldest first
+func (r *ChatRepository) ListParticipants(ctx context.Context, chatID uint64) ([]models.ChatParticipant, error) {
+	var participants []models.ChatParticipant
+
+	err := r.DB().WithContext(ctx).
+		Preload("User").
+		Where("chat_id = ?", chatID).
+		Order("created_at, user_id").
+		Find(&participants).Error
+
+	if err != nil {
+		return nil, NewError("list", "chat_participants", err)
+	}
+
+	return participants, nil
+}
+
+// SaveParticipant adds a participant to a chat or changes their role
+func (r *ChatRepository) SaveParticipant(ctx context.Context, participant *models.ChatParticipant) error {
+	if err := r.DB().WithContext(ctx).Omit(clause.Associations).Save(participant).Error; err != nil {
+		return NewError("save", "chat_participant", err)
+	}
+
+	return nil
+}
+
+// RemoveParticipant removes a user from a chat
+func (r *ChatRepository) RemoveParticipant(ctx context.Context, chatID uint64, userID uint) error {
+	result := r.DB().WithContext(ctx).
+		Where("chat_id = ? AND user_id = ?", chatID, userID).
+		Delete(&models.ChatParticipant{})
+
+	if result.Error != nil {
+		return NewError("delete", "chat_participant", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return NewError("delete", "chat_participant", ErrNotFound)
+	}
+
+	return nil
+}
+
+// participantChats is a subquery selecting the IDs of the chats a user was invited into
+func participantChats(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Model(&models.ChatParticipant{}).Select("chat_id").Where("user_id = ?", userID)
+}
+
+// readableChats is a subquery selecting the chats a user can read: their personal chats,
+// those of their workspaces and those they take part in
+func readableChats(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Model(&models.Chat{}).
+		Select("id").
+		Where("((user_id = ? AND workspace_id IS NULL) OR workspace_id IN (?) OR id IN (?))", userID, memberWorkspaces(db, userID), participantChats(db, userID))
+}
@@ -0,0 +1,67 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hra42/7x42/internal/models"
+	"gorm.io/gorm"
+)
+
+// ClusterRepository handles the database side of the messages exchanged between instances
+type ClusterRepository struct {
+	*BaseRepository
+}
+
+// NewClusterRepository creates a new cluster repository
+func NewClusterRepository(db *gorm.DB) *ClusterRepository {
+	return &ClusterRepository{
+		BaseRepository: NewBaseRepository(db),
+	}
+}
+
+// Notify sends a notification to every connection listening on channel
+func (r *ClusterRepository) Notify(ctx context.Context, channel, payload string) error {
+	if err := r.DB().WithContext(ctx).Exec("SELECT pg_notify(?, ?)", channel, payload).Error; err != nil {
+		return NewError("notify", "cluster", err)
+	}
+
+	return nil
+}
+
+// CreatePayload stores a payload too large to be sent in a notification
+func (r *ClusterRepository) CreatePayload(ctx context.Context, payload *models.ClusterPayload) error {
+	if err := r.DB().WithContext(ctx).Create(payload).Error; err != nil {
+		return NewError("create", "cluster_payload", err)
+	}
+
+	return nil
+}
+
+// GetPayload retrieves a stored payload
+func (r *ClusterRepository) GetPayload(ctx context.Context, id uint64) (*models.ClusterPayload, error) {
+	var payload models.ClusterPayload
+
+	if err := r.DB().WithContext(ctx).First(&payload, id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewError("get", "cluster_payload", ErrNotFound)
+		}
+		return nil, NewError("get", "cluster_payload", err)
+	}
+
+	return &payload, nil
+}
+
+// DeletePayloadsBefore deletes the payloads stored before the given time
+func (r *ClusterRepository) DeletePayloadsBefore(ctx context.Context, before time.Time) (int64, error) {
+	result := r.DB().WithContext(ctx).
+		Where("created_at < ?", before).
+		Delete(&models.ClusterPayload{})
+
+	if result.Error != nil {
+		return 0, NewError("delete", "cluster_payloads", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
@@ -0,0 +1,221 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/hra42/7x42/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CreditRepository handles database operations for credit balances, the ledger and budgets
+type CreditRepository struct {
+	*BaseRepository
+}
+
+// NewCreditRepository creates a new credit repository
+func NewCreditRepository(db *gorm.DB) *CreditRepository {
+	return &CreditRepository{
+		BaseRepository: NewBaseRepository(db),
+	}
+}
+
+// PostEntry appends a ledger entry and applies its amount to the user's balance in one transaction.
+// The account row is locked so concurrent entries see each other's balance.
+func (r *CreditRepository) PostEntry(ctx context.Context, entry *models.CreditEntry) error {
+	err := r.DB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.CreditAccount{UserID: entry.UserID}).Error; err != nil {
+			return err
+		}
+
+		var account models.CreditAccount
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ?", entry.UserID).
+			First(&account).Error; err != nil {
+			return err
+		}
+
+		account.Balance += entry.Amount
+		if err := tx.Model(&account).Update("balance", account.Balance).Error; err != nil {
+			return err
+		}
+
+		entry.BalanceAfter = account.Balance
+		return tx.Create(entry).Error
+	})
+	if err != nil {
+		return NewError("create", "credit entry", err)
+	}
+
+	return nil
+}
+
+// GetBalance retrieves a user's balance; users without an account have none
+func (r *CreditRepository) GetBalance(ctx context.Context, userID uint) (int64, error) {
+	var account models.CreditAccount
+
+	err := r.DB().WithContext(ctx).Where("user_id = ?", userID).First(&account).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+		return 0, NewError("get", "credit account", err)
+	}
+
+	return account.Balance, nil
+}
+
+// SumCharges adds up what a user was charged since a point in time
+func (r *CreditRepository) SumCharges(ctx context.Context, userID uint, since time.Time) (int64, error) {
+	var spent int64
+
+	err := r.DB().WithContext(ctx).
+		Model(&models.CreditEntry{}).
+		Select("COALESCE(-SUM(amount), 0)").
+		Where("user_id = ? AND kind = ? AND created_at >= ?", userID, models.CreditKindCharge, since).
+		Scan(&spent).Error
+	if err != nil {
+		return 0, NewError("sum", "credit charges", err)
+	}
+
+	return spent, nil
+}
+
+// SumWorkspaceCharges adds up what was charged for generations in a workspace's chats since a point in time
+func (r *CreditRepository) SumWorkspaceCharges(ctx context.Context, workspaceID uint, since time.Time) (int64, error) {
+	var spent int64
+
+	err := r.DB().WithContext(ctx).
+		Model(&models.CreditEntry{}).
+		Select("COALESCE(-SUM(amount), 0)").
+		Where("workspace_id = ? AND kind = ? AND created_at >= ?", workspaceID, models.CreditKindCharge, since).
+		Scan(&spent).Error
+	if err != nil {
+		return 0, NewError("sum", "workspace credit charges", err)
+	}
+
+	return spent, nil
+}
+
+// AverageCharge returns what a generation with a model was charged on average since a point in time,
+// over every user; an empty model averages over all models. It is zero without any charges.
+func (r *CreditRepository) AverageCharge(ctx context.Context, model string, since time.Time) (int64, error) {
+	var average float64
+
+	query := r.DB().WithContext(ctx).
+		Model(&models.CreditEntry{}).
+		Select("COALESCE(-AVG(amount), 0)").
+		Where("kind = ? AND created_at >= ?", models.CreditKindCharge, since)
+	if model != "" {
+		query = query.Where("model = ?", model)
+	}
+
+	if err := query.Scan(&average).Error; err != nil {
+		return 0, NewError("average", "credit charges", err)
+	}
+
+	return int64(math.Round(average)), nil
+}
+
+// ListEntries lists a user's ledger entries, newest first
+func (r *CreditRepository) ListEntries(ctx context.Context, userID uint, page, pageSize int) ([]models.CreditEntry, error) {
+	var entries []models.CreditEntry
+
+	err := r.DB().WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC, id DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&entries).Error
+	if err != nil {
+		return nil, NewError("list", "credit entries", err)
+	}
+
+	return entries, nil
+}
+
+// CountEntries counts a user's ledger entries
+func (r *CreditRepository) CountEntries(ctx context.Context, userID uint) (int64, error) {
+	var count int64
+
+	err := r.DB().WithContext(ctx).
+		Model(&models.CreditEntry{}).
+		Where("user_id = ?", userID).
+		Count(&count).Error
+	if err != nil {
+		return 0, NewError("count", "credit entries", err)
+	}
+
+	return count, nil
+}
+
+// ListBudgets retrieves every role, user and workspace budget
+func (r *CreditRepository) ListBudgets(ctx context.Context) ([]models.Budget, error) {
+	var budgets []models.Budget
+
+	if err := r.DB().WithContext(ctx).Order("scope, subject, period").Find(&budgets).Error; err != nil {
+		return nil, NewError("list", "budgets", err)
+	}
+
+	return budgets, nil
+}
+
+// BudgetsFor retrieves the budgets that apply to a user: their own and their role's
+func (r *CreditRepository) BudgetsFor(ctx context.Context, userID uint, role string) ([]models.Budget, error) {
+	var budgets []models.Budget
+
+	err := r.DB().WithContext(ctx).
+		Where("(scope = ? AND subject = ?) OR (scope = ? AND subject = ?)",
+			models.BudgetScopeUser, strconv.FormatUint(uint64(userID), 10),
+			models.BudgetScopeRole, role).
+		Find(&budgets).Error
+	if err != nil {
+		return nil, NewError("list", "budgets", err)
+	}
+
+	return budgets, nil
+}
+
+// WorkspaceBudgets retrieves the budgets of a workspace
+func (r *CreditRepository) WorkspaceBudgets(ctx context.Context, workspaceID uint) ([]models.Budget, error) {
+	var budgets []models.Budget
+
+	err := r.DB().WithContext(ctx).
+		Where("scope = ? AND subject = ?", models.BudgetScopeWorkspace, strconv.FormatUint(uint64(workspaceID), 10)).
+		Find(&budgets).Error
+	if err != nil {
+		return nil, NewError("list", "budgets", err)
+	}
+
+	return budgets, nil
+}
+
+// SaveBudget creates or updates a budget
+func (r *CreditRepository) SaveBudget(ctx context.Context, budget *models.Budget) error {
+	if err := r.DB().WithContext(ctx).Save(budget).Error; err != nil {
+		return NewError("save", "budget", err)
+	}
+
+	return nil
+}
+
+// DeleteBudget removes a budget
+func (r *CreditRepository) DeleteBudget(ctx context.Context, scope, subject, period string) error {
+	result := r.DB().WithContext(ctx).
+		Where("scope = ? AND subject = ? AND period = ?", scope, subject, period).
+		Delete(&models.Budget{})
+
+	if result.Error != nil {
+		return NewError("delete", "budget", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return NewError("delete", "budget", ErrNotFound)
+	}
+
+	return nil
+}
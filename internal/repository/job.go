@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hra42/7x42/internal/models"
+	"gorm.io/gorm"
+)
+
+// JobRepository handles database operations for generation jobs
+type JobRepository struct {
+	*BaseRepository
+}
+
+// NewJobRepository creates a new job repository
+func NewJobRepository(db *gorm.DB) *JobRepository {
+	return &JobRepository{
+		BaseRepository: NewBaseRepository(db),
+	}
+}
+
+// CreateJob creates a new generation job
+func (r *JobRepository) CreateJob(ctx context.Context, job *models.GenerationJob) error {
+	if err := r.DB().WithContext(ctx).Create(job).Error; err != nil {
+		return NewError("create", "job", err)
+	}
+
+	return nil
+}
+
+// GetJob retrieves a generation job by ID
+func (r *JobRepository) GetJob(ctx context.Context, id uint) (*models.GenerationJob, error) {
+	var job models.GenerationJob
+
+	err := r.DB().WithContext(ctx).First(&job, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewError("get", "job", ErrNotFound)
+		}
+		return nil, NewError("get", "job", err)
+	}
+
+	return &job, nil
+}
+
+// FinishJob stores the final state of a generation job
+func (r *JobRepository) FinishJob(ctx context.Context, job *models.GenerationJob) error {
+	now := time.Now()
+	job.FinishedAt = &now
+
+	result := r.DB().WithContext(ctx).
+		Model(job).
+		Updates(map[string]interface{}{
+			"status":      job.Status,
+			"content":     job.Content,
+			"error":       job.Error,
+			"message_id":  job.MessageID,
+			"finished_at": job.FinishedAt,
+		})
+
+	if result.Error != nil {
+		return NewError("update", "job", result.Error)
+	}
+
+	return nil
+}
+
+// FailInterruptedJobs marks jobs left running by a previous process, and last updated
+// before the given time, as failed.
+//
+// updated_at is not refreshed while a job streams, so a live job looks idle from the moment
+// it starts. Sweeping with a cutoff of now minus the job timeout is only safe because startJob
+// bounds every job with context.WithTimeout(s.ctx, s.config.JobTimeout); if that deadline and
+// the cutoff ever drift apart, the sweep fails jobs still running on other instances.
+func (r *JobRepository) FailInterruptedJobs(ctx context.Context, reason string, before time.Time) (int64, error) {
+	result := r.DB().WithContext(ctx).
+		Model(&models.GenerationJob{}).
+		Where("status = ? AND updated_at < ?", models.JobStatusRunning, before).
+		Updates(map[string]interface{}{
+			"status":      models.JobStatusFailed,
+			"error":       reason,
+			"finished_at": time.Now(),
+		})
+
+	if result.Error != nil {
+		return 0, NewError("update", "jobs", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/hra42/7x42/internal/models"
 	"gorm.io/gorm"
@@ -81,6 +82,29 @@ func (r *MessageRepository) GetChatMessages(ctx context.Context, chatID uint64,
 	return messages, nil
 }
 
+// GetMessagesBefore retrieves up to limit messages of a chat sent before the message with ID before,
+// newest first. A zero before starts from the newest message.
+func (r *MessageRepository) GetMessagesBefore(ctx context.Context, chatID uint64, before uint, limit int) ([]models.Message, error) {
+	var messages []models.Message
+
+	query := r.DB().WithContext(ctx).Where("chat_id = ?", chatID)
+	if before != 0 {
+		query = query.Where("(timestamp, id) < (SELECT timestamp, id FROM messages WHERE id = ? AND chat_id = ?)", before, chatID)
+	}
+
+	err := query.
+		Order("timestamp DESC").
+		Order("id DESC").
+		Limit(limit).
+		Find(&messages).Error
+
+	if err != nil {
+		return nil, NewError("list", "messages", err)
+	}
+
+	return messages, nil
+}
+
 // CountChatMessages counts the number of messages in a chat
 func (r *MessageRepository) CountChatMessages(ctx context.Context, chatID uint64) (int64, error) {
 	var count int64
@@ -97,6 +121,63 @@ func (r *MessageRepository) CountChatMessages(ctx context.Context, chatID uint64
 	return count, nil
 }
 
+// UpdateMessageProgress stores the content generated so far for a streaming reply
+func (r *MessageRepository) UpdateMessageProgress(ctx context.Context, id uint, content string) error {
+	result := r.DB().WithContext(ctx).
+		Model(&models.Message{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"content": content,
+			"status":  models.MessageStatusStreaming,
+		})
+
+	if result.Error != nil {
+		return NewError("update", "message.content", result.Error)
+	}
+
+	return nil
+}
+
+// UpdateMessageState stores the content, status, error and metadata of a generated reply
+func (r *MessageRepository) UpdateMessageState(ctx context.Context, message *models.Message) error {
+	result := r.DB().WithContext(ctx).
+		Model(message).
+		Updates(map[string]interface{}{
+			"content":  message.Content,
+			"status":   message.Status,
+			"error":    message.Error,
+			"metadata": message.Metadata,
+		})
+
+	if result.Error != nil {
+		return NewError("update", "message", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return NewError("update", "message", ErrNotFound)
+	}
+
+	return nil
+}
+
+// FailInterruptedMessages marks replies left pending or streaming by a previous process, and last
+// updated before the given time, as failed. Their partial content is kept so it can be read or retried.
+func (r *MessageRepository) FailInterruptedMessages(ctx context.Context, reason string, before time.Time) (int64, error) {
+	result := r.DB().WithContext(ctx).
+		Model(&models.Message{}).
+		Where("status IN ? AND updated_at < ?", []string{models.MessageStatusPending, models.MessageStatusStreaming}, before).
+		Updates(map[string]interface{}{
+			"status": models.MessageStatusFailed,
+			"error":  reason,
+		})
+
+	if result.Error != nil {
+		return 0, NewError("update", "messages", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
+
 // DeleteChatMessages deletes all messages for a chat
 func (r *MessageRepository) DeleteChatMessages(ctx context.Context, chatID uint64) error {
 	result := r.DB().WithContext(ctx).
@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hra42/7x42/internal/models"
+	"gorm.io/gorm"
+)
+
+// MFARepository handles database operations for recovery codes and role policies
+type MFARepository struct {
+	*BaseRepository
+}
+
+// NewMFARepository creates a new MFA repository
+func NewMFARepository(db *gorm.DB) *MFARepository {
+	return &MFARepository{
+		BaseRepository: NewBaseRepository(db),
+	}
+}
+
+// ReplaceRecoveryCodes deletes a user's recovery codes and stores new ones
+func (r *MFARepository) ReplaceRecoveryCodes(ctx context.Context, userID uint, codeHashes []string) error {
+	err := r.DB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if len(codeHashes) == 0 {
+			return nil
+		}
+
+		codes := make([]models.RecoveryCode, len(codeHashes))
+		for i, hash := range codeHashes {
+			codes[i] = models.RecoveryCode{UserID: userID, CodeHash: hash}
+		}
+		return tx.Create(&codes).Error
+	})
+	if err != nil {
+		return NewError("replace", "recovery codes", err)
+	}
+
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code as used.
+// It returns false if the user has no such unused code.
+func (r *MFARepository) UseRecoveryCode(ctx context.Context, userID uint, codeHash string) (bool, error) {
+	result := r.DB().WithContext(ctx).
+		Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+
+	if result.Error != nil {
+		return false, NewError("update", "recovery code", result.Error)
+	}
+
+	return result.RowsAffected > 0, nil
+}
+
+// CountUnusedRecoveryCodes counts the recovery codes a user has left
+func (r *MFARepository) CountUnusedRecoveryCodes(ctx context.Context, userID uint) (int64, error) {
+	var count int64
+
+	err := r.DB().WithContext(ctx).
+		Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(&count).Error
+	if err != nil {
+		return 0, NewError("count", "recovery codes", err)
+	}
+
+	return count, nil
+}
+
+// ListRolePolicies retrieves the policies of all roles that have one
+func (r *MFARepository) ListRolePolicies(ctx context.Context) ([]models.RolePolicy, error) {
+	var policies []models.RolePolicy
+
+	if err := r.DB().WithContext(ctx).Order("role").Find(&policies).Error; err != nil {
+		return nil, NewError("list", "role policies", err)
+	}
+
+	return policies, nil
+}
+
+// GetRolePolicy retrieves the policy of a role
+func (r *MFARepository) GetRolePolicy(ctx context.Context, role string) (*models.RolePolicy, error) {
+	var policy models.RolePolicy
+
+	err := r.DB().WithContext(ctx).Where("role = ?", role).First(&policy).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewError("get", "role policy", ErrNotFound)
+		}
+		return nil, NewError("get", "role policy", err)
+	}
+
+	return &policy, nil
+}
+
+// SaveRolePolicy creates or updates the policy of a role
+func (r *MFARepository) SaveRolePolicy(ctx context.Context, policy *models.RolePolicy) error {
+	if err := r.DB().WithContext(ctx).Save(policy).Error; err != nil {
+		return NewError("save", "role policy", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hra42/7x42/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ProviderKeyRepository handles database operations for users' own provider API keys
+type ProviderKeyRepository struct {
+	*BaseRepository
+}
+
+// NewProviderKeyRepository creates a new provider key repository
+func NewProviderKeyRepository(db *gorm.DB) *ProviderKeyRepository {
+	return &ProviderKeyRepository{
+		BaseRepository: NewBaseRepository(db),
+	}
+}
+
+// SaveProviderKey stores a user's key for a provider, replacing the previous one and its error state
+func (r *ProviderKeyRepository) SaveProviderKey(ctx context.Context, key *models.ProviderKey) error {
+	err := r.DB().WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "user_id"}, {Name: "provider"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"ciphertext":    key.Ciphertext,
+				"master_key_id": key.MasterKeyID,
+				"hint":          key.Hint,
+				"updated_at":    time.Now(),
+				"last_used_at":  nil,
+				"last_error":    "",
+				"last_error_at": nil,
+			}),
+		}).
+		Create(key).Error
+	if err != nil {
+		return NewError("save", "provider key", err)
+	}
+
+	return nil
+}
+
+// GetProviderKey retrieves a user's key for a provider
+func (r *ProviderKeyRepository) GetProviderKey(ctx context.Context, userID uint, provider string) (*models.ProviderKey, error) {
+	var key models.ProviderKey
+
+	err := r.DB().WithContext(ctx).
+		Where("user_id = ? AND provider = ?", userID, provider).
+		First(&key).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewError("get", "provider key", ErrNotFound)
+		}
+		return nil, NewError("get", "provider key", err)
+	}
+
+	return &key, nil
+}
+
+// ListProviderKeys retrieves a user's keys for all providers
+func (r *ProviderKeyRepository) ListProviderKeys(ctx context.Context, userID uint) ([]models.ProviderKey, error) {
+	var keys []models.ProviderKey
+
+	err := r.DB().WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("provider").
+		Find(&keys).Error
+	if err != nil {
+		return nil, NewError("list", "provider keys", err)
+	}
+
+	return keys, nil
+}
+
+// DeleteProviderKey removes a user's key for a provider
+func (r *ProviderKeyRepository) DeleteProviderKey(ctx context.Context, userID uint, provider string) error {
+	result := r.DB().WithContext(ctx).
+		Where("user_id = ? AND provider = ?", userID, provider).
+		Delete(&models.ProviderKey{})
+
+	if result.Error != nil {
+		return NewError("delete", "provider key", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return NewError("delete", "provider key", ErrNotFound)
+	}
+
+	return nil
+}
+
+// MarkProviderKeyUsed records a successful use of a key and clears its error
+func (r *ProviderKeyRepository) MarkProviderKeyUsed(ctx context.Context, id uint, at time.Time) error {
+	err := r.DB().WithContext(ctx).
+		Model(&models.ProviderKey{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"last_used_at":  at,
+			"last_error":    "",
+			"last_error_at": nil,
+		}).Error
+	if err != nil {
+		return NewError("update", "provider key", err)
+	}
+
+	return nil
+}
+
+// MarkProviderKeyFailed records the provider rejecting a key
+func (r *ProviderKeyRepository) MarkProviderKeyFailed(ctx context.Context, id uint, message string, at time.Time) error {
+	err := r.DB().WithContext(ctx).
+		Model(&models.ProviderKey{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"last_error":    message,
+			"last_error_at": at,
+		}).Error
+	if err != nil {
+		return NewError("update", "provider key", err)
+	}
+
+	return nil
+}
+
+// ListStaleProviderKeys retrieves keys encrypted with a master key other than the current one
+func (r *ProviderKeyRepository) ListStaleProviderKeys(ctx context.Context, currentKeyID string, afterID uint, limit int) ([]models.ProviderKey, error) {
+	var keys []models.ProviderKey
+
+	err := r.DB().WithContext(ctx).
+		Where("master_key_id <> ? AND id > ?", currentKeyID, afterID).
+		Order("id").
+		Limit(limit).
+		Find(&keys).Error
+	if err != nil {
+		return nil, NewError("list", "provider keys", err)
+	}
+
+	return keys, nil
+}
+
+// UpdateCiphertext replaces a key's ciphertext after re-encrypting it with another master key.
+// It only applies if the key was not replaced in the meantime.
+func (r *ProviderKeyRepository) UpdateCiphertext(ctx context.Context, key *models.ProviderKey, ciphertext []byte, masterKeyID string) (bool, error) {
+	result := r.DB().WithContext(ctx).
+		Model(&models.ProviderKey{}).
+		Where("id = ? AND master_key_id = ?", key.ID, key.MasterKeyID).
+		Updates(map[string]interface{}{
+			"ciphertext":    ciphertext,
+			"master_key_id": masterKeyID,
+		})
+
+	if result.Error != nil {
+		return false, NewError("update", "provider key", result.Error)
+	}
+
+	return result.RowsAffected > 0, nil
+}
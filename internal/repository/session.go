@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hra42/7x42/internal/models"
+	"gorm.io/gorm"
+)
+
+// SessionRepository handles database operations for login sessions
+type SessionRepository struct {
+	*BaseRepository
+}
+
+// NewSessionRepository creates a new session repository
+func NewSessionRepository(db *gorm.DB) *SessionRepository {
+	return &SessionRepository{
+		BaseRepository: NewBaseRepository(db),
+	}
+}
+
+// CreateSession creates a new session
+func (r *SessionRepository) CreateSession(ctx context.Context, session *models.Session) error {
+	if err := r.DB().WithContext(ctx).Create(session).Error; err != nil {
+		return NewError("create", "session", err)
+	}
+
+	return nil
+}
+
+// GetSession retrieves an unexpired session by ID
+func (r *SessionRepository) GetSession(ctx context.Context, id uint) (*models.Session, error) {
+	var session models.Session
+
+	err := r.DB().WithContext(ctx).
+		Where("id = ? AND expires_at > ?", id, time.Now()).
+		First(&session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewError("get", "session", ErrNotFound)
+		}
+		return nil, NewError("get", "session", err)
+	}
+
+	return &session, nil
+}
+
+// GetSessionByTokenHash retrieves an unexpired session by its token hash
+func (r *SessionRepository) GetSessionByTokenHash(ctx context.Context, tokenHash string) (*models.Session, error) {
+	var session models.Session
+
+	err := r.DB().WithContext(ctx).
+		Where("token_hash = ? AND expires_at > ?", tokenHash, time.Now()).
+		First(&session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewError("get", "session", ErrNotFound)
+		}
+		return nil, NewError("get", "session", err)
+	}
+
+	return &session, nil
+}
+
+// TouchSession records activity on a session
+func (r *SessionRepository) TouchSession(ctx context.Context, id uint, at time.Time) error {
+	result := r.DB().WithContext(ctx).
+		Model(&models.Session{}).
+		Where("id = ?", id).
+		Update("last_seen_at", at)
+
+	if result.Error != nil {
+		return NewError("update", "session.last_seen_at", result.Error)
+	}
+
+	return nil
+}
+
+// MarkSecondFactor records that a session passed a second factor
+func (r *SessionRepository) MarkSecondFactor(ctx context.Context, id uint) error {
+	result := r.DB().WithContext(ctx).
+		Model(&models.Session{}).
+		Where("id = ?", id).
+		Update("second_factor", true)
+
+	if result.Error != nil {
+		return NewError("update", "session.second_factor", result.Error)
+	}
+
+	return nil
+}
+
+// DeleteSession deletes a session
+func (r *SessionRepository) DeleteSession(ctx context.Context, id uint) error {
+	result := r.DB().WithContext(ctx).Delete(&models.Session{}, id)
+
+	if result.Error != nil {
+		return NewError("delete", "session", result.Error)
+	}
+
+	return nil
+}
+
+// DeleteUserSessions deletes all sessions of a user
+func (r *SessionRepository) DeleteUserSessions(ctx context.Context, userID uint) (int64, error) {
+	result := r.DB().WithContext(ctx).
+		Where("user_id = ?", userID).
+		Delete(&models.Session{})
+
+	if result.Error != nil {
+		return 0, NewError("delete", "sessions", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
+
+// DeleteOtherUserSessions deletes all sessions of a user except one
+func (r *SessionRepository) DeleteOtherUserSessions(ctx context.Context, userID, keepID uint) (int64, error) {
+	result := r.DB().WithContext(ctx).
+		Where("user_id = ? AND id <> ?", userID, keepID).
+		Delete(&models.Session{})
+
+	if result.Error != nil {
+		return 0, NewError("delete", "sessions", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
+
+// DeleteExpiredSessions removes sessions past their expiry
+func (r *SessionRepository) DeleteExpiredSessions(ctx context.Context) (int64, error) {
+	result := r.DB().WithContext(ctx).
+		Where("expires_at <= ?", time.Now()).
+		Delete(&models.Session{})
+
+	if result.Error != nil {
+		return 0, NewError("delete", "sessions", result.Error)
+	}
+
+	return result.RowsAffected, nil
+}
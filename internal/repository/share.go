@@ -0,0 +1,99 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/hra42/7x42/internal/models"
+	"gorm.io/gorm"
+)
+
+// ShareRepository handles database operations for chat shares
+type ShareRepository struct {
+	*BaseRepository
+}
+
+// NewShareRepository creates a new share repository
+func NewShareRepository(db *gorm.DB) *ShareRepository {
+	return &ShareRepository{
+		BaseRepository: NewBaseRepository(db),
+	}
+}
+
+// CreateShare creates a new chat share
+func (r *ShareRepository) CreateShare(ctx context.Context, share *models.ChatShare) error {
+	if err := r.DB().WithContext(ctx).Create(share).Error; err != nil {
+		return NewError("create", "chat_share", err)
+	}
+
+	return nil
+}
+
+// GetShareByTokenHash retrieves a share by the hash of its token
+func (r *ShareRepository) GetShareByTokenHash(ctx context.Context, tokenHash string) (*models.ChatShare, error) {
+	var share models.ChatShare
+
+	err := r.DB().WithContext(ctx).
+		Where("token_hash = ?", tokenHash).
+		First(&share).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewError("get", "chat_share", ErrNotFound)
+		}
+		return nil, NewError("get", "chat_share", err)
+	}
+
+	return &share, nil
+}
+
+// ListChatShares lists all shares of a chat, newest first
+func (r *ShareRepository) ListChatShares(ctx context.Context, chatID uint64) ([]models.ChatShare, error) {
+	var shares []models.ChatShare
+
+	err := r.DB().WithContext(ctx).
+		Where("chat_id = ?", chatID).
+		Order("created_at DESC").
+		Find(&shares).Error
+
+	if err != nil {
+		return nil, NewError("list", "chat_shares", err)
+	}
+
+	return shares, nil
+}
+
+// RevokeShare revokes a share of a chat
+func (r *ShareRepository) RevokeShare(ctx context.Context, chatID uint64, id uint) error {
+	result := r.DB().WithContext(ctx).
+		Model(&models.ChatShare{}).
+		Where("id = ? AND chat_id = ? AND revoked_at IS NULL", id, chatID).
+		Update("revoked_at", time.Now())
+
+	if result.Error != nil {
+		return NewError("revoke", "chat_share", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return NewError("revoke", "chat_share", ErrNotFound)
+	}
+
+	return nil
+}
+
+// RecordShareView counts a view of a share
+func (r *ShareRepository) RecordShareView(ctx context.Context, id uint, at time.Time) error {
+	err := r.DB().WithContext(ctx).
+		Model(&models.ChatShare{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"view_count":     gorm.Expr("view_count + 1"),
+			"last_viewed_at": at,
+		}).Error
+
+	if err != nil {
+		return NewError("update", "chat_share.view_count", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/hra42/7x42/internal/models"
+	"gorm.io/gorm"
+)
+
+// ModelLatencyStats holds latency percentiles for one model
+type ModelLatencyStats struct {
+	Model               string  `json:"model"`
+	Count               int64   `json:"count"`
+	LatencyP50          float64 `json:"latencyP50"`
+	LatencyP95          float64 `json:"latencyP95"`
+	TimeToFirstTokenP50 float64 `json:"timeToFirstTokenP50"`
+	TimeToFirstTokenP95 float64 `json:"timeToFirstTokenP95"`
+	TokensPerSecondP50  float64 `json:"tokensPerSecondP50"`
+}
+
+// StatsRepository runs aggregate queries over stored messages
+type StatsRepository struct {
+	*BaseRepository
+}
+
+// NewStatsRepository creates a new stats repository
+func NewStatsRepository(db *gorm.DB) *StatsRepository {
+	return &StatsRepository{
+		BaseRepository: NewBaseRepository(db),
+	}
+}
+
+// LatencyByModel returns latency percentiles per model for completed replies since the given time,
+// in the chats the user can read
+func (r *StatsRepository) LatencyByModel(ctx context.Context, userID uint, since time.Time) ([]ModelLatencyStats, error) {
+	var stats []ModelLatencyStats
+
+	err := r.DB().WithContext(ctx).
+		Model(&models.Message{}).
+		Select(`metadata->>'model' AS model,
+			COUNT(*) AS count,
+			percentile_cont(0.5) WITHIN GROUP (ORDER BY (metadata->>'latency')::float) AS latency_p50,
+			percentile_cont(0.95) WITHIN GROUP (ORDER BY (metadata->>'latency')::float) AS latency_p95,
+			percentile_cont(0.5) WITHIN GROUP (ORDER BY (metadata->>'time_to_first_token')::float) AS time_to_first_token_p50,
+			percentile_cont(0.95) WITHIN GROUP (ORDER BY (metadata->>'time_to_first_token')::float) AS time_to_first_token_p95,
+			percentile_cont(0.5) WITHIN GROUP (ORDER BY (metadata->>'tokens_per_second')::float) AS tokens_per_second_p50`).
+		Where("role = ? AND status = ?", models.RoleAssistant, models.MessageStatusComplete).
+		Where("metadata->>'latency' IS NOT NULL").
+		Where("timestamp >= ?", since).
+		Where("chat_id IN (?)", readableChats(r.DB(), userID)).
+		Group("metadata->>'model'").
+		Order("count DESC").
+		Scan(&stats).Error
+
+	if err != nil {
+		return nil, NewError("aggregate", "message latency", err)
+	}
+
+	return stats, nil
+}
+
+// UserUsage holds the chat count and token usage of one user
+type UserUsage struct {
+	UserID           uint    `json:"userId"`
+	ChatCount        int64   `json:"chatCount"`
+	MessageCount     int64   `json:"messageCount"`
+	PromptTokens     int64   `json:"promptTokens"`
+	CompletionTokens int64   `json:"completionTokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// UsageByUser returns chat counts and token usage for the given users.
+// Users without chats are missing from the result.
+func (r *StatsRepository) UsageByUser(ctx context.Context, userIDs []uint) (map[uint]UserUsage, error) {
+	result := make(map[uint]UserUsage, len(userIDs))
+	if len(userIDs) == 0 {
+		return result, nil
+	}
+
+	var usage []UserUsage
+	err := r.DB().WithContext(ctx).
+		Table("chats").
+		Select(`chats.user_id AS user_id,
+			COUNT(DISTINCT chats.id) AS chat_count,
+			COUNT(messages.id) AS message_count,
+			COALESCE(SUM((messages.metadata->>'prompt_tokens')::bigint), 0) AS prompt_tokens,
+			COALESCE(SUM((messages.metadata->>'completion_tokens')::bigint), 0) AS completion_tokens,
+			COALESCE(SUM((messages.metadata->>'cost')::float), 0) AS cost`).
+		Joins("LEFT JOIN messages ON messages.chat_id = chats.id AND messages.deleted_at IS NULL").
+		Where("chats.user_id IN ? AND chats.deleted_at IS NULL", userIDs).
+		Group("chats.user_id").
+		Scan(&usage).Error
+
+	if err != nil {
+		return nil, NewError("aggregate", "user usage", err)
+	}
+
+	for _, u := range usage {
+		result[u.UserID] = u
+	}
+
+	return result, nil
+}
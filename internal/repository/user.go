@@ -0,0 +1,195 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/hra42/7x42/internal/models"
+	"gorm.io/gorm"
+)
+
+// UserRepository handles database operations for user entities
+type UserRepository struct {
+	*BaseRepository
+}
+
+// NewUserRepository creates a new user repository
+func NewUserRepository(db *gorm.DB) *UserRepository {
+	return &UserRepository{
+		BaseRepository: NewBaseRepository(db),
+	}
+}
+
+// CreateUser creates a new user
+func (r *UserRepository) CreateUser(ctx context.Context, user *models.User) error {
+	if err := r.DB().WithContext(ctx).Create(user).Error; err != nil {
+		if IsAlreadyExists(err) {
+			return NewError("create", "user", ErrAlreadyExists)
+		}
+		return NewError("create", "user", err)
+	}
+
+	return nil
+}
+
+// GetUser retrieves a user by ID
+func (r *UserRepository) GetUser(ctx context.Context, id uint) (*models.User, error) {
+	var user models.User
+
+	err := r.DB().WithContext(ctx).First(&user, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewError("get", "user", ErrNotFound)
+		}
+		return nil, NewError("get", "user", err)
+	}
+
+	return &user, nil
+}
+
+// GetUserByEmail retrieves a user by email address, ignoring case
+func (r *UserRepository) GetUserByEmail(ctx context.Context, email string) (*models.User, error) {
+	var user models.User
+
+	err := r.DB().WithContext(ctx).
+		Where("LOWER(email) = ?", strings.ToLower(email)).
+		First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewError("get", "user", ErrNotFound)
+		}
+		return nil, NewError("get", "user", err)
+	}
+
+	return &user, nil
+}
+
+// GetUserByOIDCSubject retrieves the user linked to a single sign-on identity
+func (r *UserRepository) GetUserByOIDCSubject(ctx context.Context, issuer, subject string) (*models.User, error) {
+	var user models.User
+
+	err := r.DB().WithContext(ctx).
+		Where("oidc_issuer = ? AND oidc_subject = ?", issuer, subject).
+		First(&user).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewError("get", "user", ErrNotFound)
+		}
+		return nil, NewError("get", "user", err)
+	}
+
+	return &user, nil
+}
+
+// UpdateLastLogin records a successful login
+func (r *UserRepository) UpdateLastLogin(ctx context.Context, id uint, at time.Time) error {
+	result := r.DB().WithContext(ctx).
+		Model(&models.User{}).
+		Where("id = ?", id).
+		Update("last_login", at)
+
+	if result.Error != nil {
+		return NewError("update", "user.last_login", result.Error)
+	}
+
+	return nil
+}
+
+// ListUsers lists users whose email or name contains query, oldest first
+func (r *UserRepository) ListUsers(ctx context.Context, query string, page, pageSize int) ([]models.User, error) {
+	var users []models.User
+	offset := (page - 1) * pageSize
+
+	err := r.searchUsers(ctx, query).
+		Order("id ASC").
+		Offset(offset).
+		Limit(pageSize).
+		Find(&users).Error
+
+	if err != nil {
+		return nil, NewError("list", "users", err)
+	}
+
+	return users, nil
+}
+
+// CountUsers counts users whose email or name contains query
+func (r *UserRepository) CountUsers(ctx context.Context, query string) (int64, error) {
+	var count int64
+
+	err := r.searchUsers(ctx, query).
+		Model(&models.User{}).
+		Count(&count).Error
+
+	if err != nil {
+		return 0, NewError("count", "users", err)
+	}
+
+	return count, nil
+}
+
+// CountUsersByRole counts active users with the given role
+func (r *UserRepository) CountUsersByRole(ctx context.Context, role string) (int64, error) {
+	var count int64
+
+	err := r.DB().WithContext(ctx).
+		Model(&models.User{}).
+		Where("role = ? AND active", role).
+		Count(&count).Error
+
+	if err != nil {
+		return 0, NewError("count", "users", err)
+	}
+
+	return count, nil
+}
+
+// UpdateUserFields updates the given columns of a user
+func (r *UserRepository) UpdateUserFields(ctx context.Context, id uint, fields map[string]interface{}) error {
+	result := r.DB().WithContext(ctx).
+		Model(&models.User{}).
+		Where("id = ?", id).
+		Updates(fields)
+
+	if result.Error != nil {
+		return NewError("update", "user", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return NewError("update", "user", ErrNotFound)
+	}
+
+	return nil
+}
+
+// ConsumeTOTPStep records an accepted authenticator time step.
+// It returns false if the step, or a later one, was already used, so each code works once.
+func (r *UserRepository) ConsumeTOTPStep(ctx context.Context, id uint, step int64) (bool, error) {
+	result := r.DB().WithContext(ctx).
+		Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", id, step).
+		Update("totp_last_step", step)
+
+	if result.Error != nil {
+		return false, NewError("update", "user.totp_last_step", result.Error)
+	}
+
+	return result.RowsAffected == 1, nil
+}
+
+// searchUsers scopes a query to users matching a case-insensitive search term
+func (r *UserRepository) searchUsers(ctx context.Context, query string) *gorm.DB {
+	db := r.DB().WithContext(ctx)
+	if query = strings.TrimSpace(query); query != "" {
+		like := "%" + escapeLike(strings.ToLower(query)) + "%"
+		db = db.Where("LOWER(email) LIKE ? OR LOWER(name) LIKE ?", like, like)
+	}
+	return db
+}
+
+// escapeLike escapes LIKE wildcards in a search term
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,197 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hra42/7x42/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// WorkspaceRepository handles database operations for workspaces and their members
+type WorkspaceRepository struct {
+	*BaseRepository
+}
+
+// NewWorkspaceRepository creates a new workspace repository
+func NewWorkspaceRepository(db *gorm.DB) *WorkspaceRepository {
+	return &WorkspaceRepository{
+		BaseRepository: NewBaseRepository(db),
+	}
+}
+
+// CreateWorkspace creates a workspace with its first member, who owns it
+func (r *WorkspaceRepository) CreateWorkspace(ctx context.Context, workspace *models.Workspace, ownerID uint) error {
+	err := r.DB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(workspace).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.WorkspaceMember{
+			WorkspaceID: workspace.ID,
+			UserID:      ownerID,
+			Role:        models.WorkspaceRoleOwner,
+		}).Error
+	})
+	if err != nil {
+		return NewError("create", "workspace", err)
+	}
+
+	return nil
+}
+
+// GetWorkspace retrieves a workspace by ID
+func (r *WorkspaceRepository) GetWorkspace(ctx context.Context, id uint) (*models.Workspace, error) {
+	var workspace models.Workspace
+
+	err := r.DB().WithContext(ctx).First(&workspace, id).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewError("get", "workspace", ErrNotFound)
+		}
+		return nil, NewError("get", "workspace", err)
+	}
+
+	return &workspace, nil
+}
+
+// ListUserWorkspaces lists the workspaces a user is a member of, by name
+func (r *WorkspaceRepository) ListUserWorkspaces(ctx context.Context, userID uint) ([]models.Workspace, error) {
+	var workspaces []models.Workspace
+
+	err := r.DB().WithContext(ctx).
+		Where("id IN (?)", memberWorkspaces(r.DB(), userID)).
+		Order("name, id").
+		Find(&workspaces).Error
+
+	if err != nil {
+		return nil, NewError("list", "workspaces", err)
+	}
+
+	return workspaces, nil
+}
+
+// UpdateWorkspace updates a workspace's name, persona and default model
+func (r *WorkspaceRepository) UpdateWorkspace(ctx context.Context, workspace *models.Workspace) error {
+	result := r.DB().WithContext(ctx).
+		Model(workspace).
+		Updates(map[string]interface{}{
+			"name":          workspace.Name,
+			"system_prompt": workspace.SystemPrompt,
+			"default_model": workspace.DefaultModel,
+		})
+
+	if result.Error != nil {
+		return NewError("update", "workspace", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return NewError("update", "workspace", ErrNotFound)
+	}
+
+	return nil
+}
+
+// DeleteWorkspace deletes a workspace together with its chats and memberships
+func (r *WorkspaceRepository) DeleteWorkspace(ctx context.Context, id uint) error {
+	err := r.DB().WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&models.Workspace{}, id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrNotFound
+		}
+
+		if err := tx.Where("workspace_id = ?", id).Delete(&models.Chat{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("workspace_id = ?", id).Delete(&models.WorkspaceMember{}).Error
+	})
+	if err != nil {
+		return NewError("delete", "workspace", err)
+	}
+
+	return nil
+}
+
+// GetMember retrieves a user's membership in a workspace
+func (r *WorkspaceRepository) GetMember(ctx context.Context, workspaceID, userID uint) (*models.WorkspaceMember, error) {
+	var member models.WorkspaceMember
+
+	err := r.DB().WithContext(ctx).
+		Where("workspace_id = ? AND user_id = ?", workspaceID, userID).
+		First(&member).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, NewError("get", "workspace_member", ErrNotFound)
+		}
+		return nil, NewError("get", "workspace_member", err)
+	}
+
+	return &member, nil
+}
+
+// ListMembers lists the members of a workspace with their accounts, oldest first
+func (r *WorkspaceRepository) ListMembers(ctx context.Context, workspaceID uint) ([]models.WorkspaceMember, error) {
+	var members []models.WorkspaceMember
+
+	err := r.DB().WithContext(ctx).
+		Preload("User").
+		Where("workspace_id = ?", workspaceID).
+		Order("created_at, user_id").
+		Find(&members).Error
+
+	if err != nil {
+		return nil, NewError("list", "workspace_members", err)
+	}
+
+	return members, nil
+}
+
+// SaveMember adds a member to a workspace or changes their role
+func (r *WorkspaceRepository) SaveMember(ctx context.Context, member *models.WorkspaceMember) error {
+	if err := r.DB().WithContext(ctx).Omit(clause.Associations).Save(member).Error; err != nil {
+		return NewError("save", "workspace_member", err)
+	}
+
+	return nil
+}
+
+// RemoveMember removes a user from a workspace
+func (r *WorkspaceRepository) RemoveMember(ctx context.Context, workspaceID, userID uint) error {
+	result := r.DB().WithContext(ctx).
+		Where("workspace_id = ? AND user_id = ?", workspaceID, userID).
+		Delete(&models.WorkspaceMember{})
+
+	if result.Error != nil {
+		return NewError("delete", "workspace_member", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return NewError("delete", "workspace_member", ErrNotFound)
+	}
+
+	return nil
+}
+
+// CountOwners counts the owners of a workspace
+func (r *WorkspaceRepository) CountOwners(ctx context.Context, workspaceID uint) (int64, error) {
+	var count int64
+
+	err := r.DB().WithContext(ctx).
+		Model(&models.WorkspaceMember{}).
+		Where("workspace_id = ? AND role = ?", workspaceID, models.WorkspaceRoleOwner).
+		Count(&count).Error
+
+	if err != nil {
+		return 0, NewError("count", "workspace_owners", err)
+	}
+
+	return count, nil
+}
+
+// memberWorkspaces is a subquery selecting the IDs of the workspaces a user is a member of
+func memberWorkspaces(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Model(&models.WorkspaceMember{}).Select("workspace_id").Where("user_id = ?", userID)
+}
@@ -0,0 +1,123 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Keyring errors
+var (
+	ErrNoKeys     = errors.New("no master key configured")
+	ErrUnknownKey = errors.New("secret was encrypted with an unknown master key")
+	ErrDecrypt    = errors.New("secret could not be decrypted")
+)
+
+// keySize is the size of an AES-256 master key
+const keySize = 32
+
+// Keyring encrypts secrets with the current master key and decrypts them with any known one.
+// Rotating means adding a new current key while keeping the old ones until every secret
+// has been re-encrypted.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// ParseKeyring parses master keys written as "id:base64key,id:base64key".
+// The first key is current; the others are only used to decrypt. Keys are 32 random bytes,
+// e.g. from "openssl rand -base64 32". An empty spec gives an empty keyring.
+func ParseKeyring(spec string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string]cipher.AEAD)}
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		id, encoded, ok := strings.Cut(item, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("invalid master key %q: want id:base64key", item)
+		}
+		if _, exists := k.keys[id]; exists {
+			return nil, fmt.Errorf("duplicate master key ID %q", id)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("invalid master key %q: want %d base64-encoded bytes", id, keySize)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+
+		k.keys[id] = aead
+		if k.current == "" {
+			k.current = id
+		}
+	}
+
+	return k, nil
+}
+
+// Enabled returns true if the keyring has a key to encrypt with
+func (k *Keyring) Enabled() bool {
+	return k != nil && k.current != ""
+}
+
+// CurrentKeyID returns the ID of the key new secrets are encrypted with
+func (k *Keyring) CurrentKeyID() string {
+	if k == nil {
+		return ""
+	}
+	return k.current
+}
+
+// Encrypt encrypts plaintext with the current key, binding it to context so the ciphertext
+// cannot be moved to another record. It returns the ciphertext and the ID of the key used.
+func (k *Keyring) Encrypt(plaintext, context []byte) ([]byte, string, error) {
+	if !k.Enabled() {
+		return nil, "", ErrNoKeys
+	}
+
+	aead := k.keys[k.current]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, "", err
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, context), k.current, nil
+}
+
+// Decrypt decrypts a ciphertext produced by Encrypt with the same key ID and context
+func (k *Keyring) Decrypt(ciphertext []byte, keyID string, context []byte) ([]byte, error) {
+	if k == nil {
+		return nil, ErrNoKeys
+	}
+
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+
+	nonce, sealed := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, sealed, context)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,299 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/hra42/7x42/internal/audit"
+	"github.com/hra42/7x42/internal/auth"
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/repository"
+	"github.com/hra42/7x42/internal/server/responses"
+	"github.com/hra42/7x42/internal/websocket"
+)
+
+// AdminHandler handles user management requests from administrators
+type AdminHandler struct {
+	authService *auth.Service
+	userRepo    *repository.UserRepository
+	statsRepo   *repository.StatsRepository
+	wsManager   *websocket.Manager
+	auditLog    *audit.Logger
+}
+
+// NewAdminHandler creates a new admin handler
+func NewAdminHandler(authService *auth.Service, userRepo *repository.UserRepository, statsRepo *repository.StatsRepository, wsManager *websocket.Manager, auditLog *audit.Logger) *AdminHandler {
+	return &AdminHandler{
+		authService: authService,
+		userRepo:    userRepo,
+		statsRepo:   statsRepo,
+		wsManager:   wsManager,
+		auditLog:    auditLog,
+	}
+}
+
+// ListUsers handles the list users endpoint. ?q= searches email and name.
+func (h *AdminHandler) ListUsers(c *fiber.Ctx) error {
+	query := c.Query("q")
+	page, pageSize := ParsePagination(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users, err := h.userRepo.ListUsers(ctx, query, page, pageSize)
+	if err != nil {
+		return err
+	}
+
+	total, err := h.userRepo.CountUsers(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	ids := make([]uint, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+	usage, err := h.statsRepo.UsageByUser(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	result := make([]fiber.Map, len(users))
+	for i := range users {
+		result[i] = userWithUsage(&users[i], usage[users[i].ID])
+	}
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"users": result,
+		"pagination": fiber.Map{
+			"page":       page,
+			"pageSize":   pageSize,
+			"total":      total,
+			"totalPages": (total + int64(pageSize) - 1) / int64(pageSize),
+		},
+	})
+}
+
+// GetUser handles the get user endpoint
+func (h *AdminHandler) GetUser(c *fiber.Ctx) error {
+	userID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, err := h.userRepo.GetUser(ctx, uint(userID))
+	if err != nil {
+		return err
+	}
+
+	usage, err := h.statsRepo.UsageByUser(ctx, []uint{user.ID})
+	if err != nil {
+		return err
+	}
+
+	return responses.JSON(c, fiber.StatusOK, userWithUsage(user, usage[user.ID]))
+}
+
+// UpdateUser handles the update user endpoint, changing role or disabling the account
+func (h *AdminHandler) UpdateUser(c *fiber.Ctx) error {
+	userID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+
+	type request struct {
+		Role   *string `json:"role"`
+		Active *bool   `json:"active"`
+	}
+
+	var req request
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.authService.UpdateUser(ctx, uint(userID), auth.UserUpdate{
+		Role:   req.Role,
+		Active: req.Active,
+	})
+	if err != nil {
+		return adminError(err)
+	}
+
+	event := auditEvent(c, audit.ActionUserUpdate, audit.TargetUser, auditID(userID))
+	event.Details = models.AuditDetails{}
+	if req.Role != nil {
+		event.Details["role"] = *req.Role
+	}
+	if req.Active != nil {
+		event.Details["active"] = *req.Active
+	}
+	h.auditLog.Record(event)
+
+	// Open sockets were authorized under the old role or account state
+	if req.Role != nil || req.Active != nil {
+		h.wsManager.DisconnectUser(user.ID, "account changed")
+	}
+
+	return responses.JSON(c, fiber.StatusOK, user.ToMap())
+}
+
+// ResetPassword handles the reset password endpoint; the user is signed out everywhere
+func (h *AdminHandler) ResetPassword(c *fiber.Ctx) error {
+	userID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+
+	type request struct {
+		Password string `json:"password"`
+	}
+
+	var req request
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.authService.ResetPassword(ctx, uint(userID), req.Password); err != nil {
+		return adminError(err)
+	}
+	h.auditLog.Record(auditEvent(c, audit.ActionPasswordReset, audit.TargetUser, auditID(userID)))
+	h.wsManager.DisconnectUser(uint(userID), "password reset")
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"success": true,
+	})
+}
+
+// RevokeSessions handles the force logout endpoint, ending all sessions and closing open sockets
+func (h *AdminHandler) RevokeSessions(c *fiber.Ctx) error {
+	userID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sessions, err := h.authService.RevokeSessions(ctx, uint(userID))
+	if err != nil {
+		return err
+	}
+	connections := h.wsManager.DisconnectUser(uint(userID), "signed out by an administrator")
+
+	event := auditEvent(c, audit.ActionSessionsRevoke, audit.TargetUser, auditID(userID))
+	event.Details = models.AuditDetails{"sessions": sessions, "connections": connections}
+	h.auditLog.Record(event)
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"sessions":    sessions,
+		"connections": connections,
+	})
+}
+
+// ResetTOTP handles removing a user's two-factor authentication, e.g. after a lost device.
+// The user is signed out everywhere.
+func (h *AdminHandler) ResetTOTP(c *fiber.Ctx) error {
+	userID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := h.userRepo.GetUser(ctx, uint(userID)); err != nil {
+		return err
+	}
+	if err := h.authService.ResetTOTP(ctx, uint(userID)); err != nil {
+		return adminError(err)
+	}
+	h.auditLog.Record(auditEvent(c, audit.ActionTOTPReset, audit.TargetUser, auditID(userID)))
+	h.wsManager.DisconnectUser(uint(userID), "two-factor authentication reset")
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"success": true,
+	})
+}
+
+// ListPolicies handles the role policies endpoint
+func (h *AdminHandler) ListPolicies(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	policies, err := h.authService.ListRolePolicies(ctx)
+	if err != nil {
+		return err
+	}
+
+	result := make([]map[string]interface{}, len(policies))
+	for i := range policies {
+		result[i] = policies[i].ToMap()
+	}
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"policies": result,
+	})
+}
+
+// UpdatePolicy handles changing a role's policy. Requiring two-factor authentication takes effect
+// on the next request of every affected user, who must then enroll before continuing.
+func (h *AdminHandler) UpdatePolicy(c *fiber.Ctx) error {
+	type request struct {
+		RequireTOTP bool `json:"requireTotp"`
+	}
+
+	var req request
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	policy, err := h.authService.SetRolePolicy(ctx, c.Params("role"), req.RequireTOTP)
+	if err != nil {
+		return adminError(err)
+	}
+
+	event := auditEvent(c, audit.ActionPolicyUpdate, audit.TargetRole, policy.Role)
+	event.Details = models.AuditDetails{"requireTotp": policy.RequireTOTP}
+	h.auditLog.Record(event)
+
+	return responses.JSON(c, fiber.StatusOK, policy.ToMap())
+}
+
+// userWithUsage formats a user with their chat count and usage
+func userWithUsage(user *models.User, usage repository.UserUsage) fiber.Map {
+	result := fiber.Map(user.ToMap())
+	result["chatCount"] = usage.ChatCount
+	result["usage"] = fiber.Map{
+		"messages":         usage.MessageCount,
+		"promptTokens":     usage.PromptTokens,
+		"completionTokens": usage.CompletionTokens,
+		"cost":             usage.Cost,
+	}
+	return result
+}
+
+// adminError maps user management errors to HTTP errors
+func adminError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrInvalidRole):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	case errors.Is(err, auth.ErrLastAdmin):
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	}
+	return authError(err)
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/hra42/7x42/internal/audit"
+	"github.com/hra42/7x42/internal/auth"
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/server/responses"
+)
+
+// APIKeyHandler handles API key management requests
+type APIKeyHandler struct {
+	authService *auth.Service
+	auditLog    *audit.Logger
+}
+
+// NewAPIKeyHandler creates a new API key handler
+func NewAPIKeyHandler(authService *auth.Service, auditLog *audit.Logger) *APIKeyHandler {
+	return &APIKeyHandler{
+		authService: authService,
+		auditLog:    auditLog,
+	}
+}
+
+// List handles the list API keys endpoint
+func (h *APIKeyHandler) List(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	keys, err := h.authService.ListAPIKeys(ctx, GetUserID(c))
+	if err != nil {
+		return err
+	}
+
+	result := make([]fiber.Map, len(keys))
+	for i := range keys {
+		result[i] = keys[i].ToMap()
+	}
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"keys":   result,
+		"scopes": auth.Scopes,
+	})
+}
+
+// Create handles the create API key endpoint.
+// The key itself is only returned in this response.
+func (h *APIKeyHandler) Create(c *fiber.Ctx) error {
+	type request struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+		// ExpiresIn is an alternative to ExpiresAt, e.g. "720h"
+		ExpiresIn string `json:"expiresIn"`
+	}
+
+	var req request
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	expiresAt := req.ExpiresAt
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid expiresIn duration")
+		}
+		t := time.Now().Add(d)
+		expiresAt = &t
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key, record, err := h.authService.CreateAPIKey(ctx, GetUserID(c), req.Name, req.Scopes, expiresAt)
+	if err != nil {
+		return authError(err)
+	}
+
+	event := auditEvent(c, audit.ActionAPIKeyCreate, audit.TargetAPIKey, auditID(uint64(record.ID)))
+	event.Details = models.AuditDetails{
+		"name":      record.Name,
+		"prefix":    record.Prefix,
+		"scopes":    record.ScopeList(),
+		"expiresAt": record.ExpiresAt,
+	}
+	h.auditLog.Record(event)
+
+	result := record.ToMap()
+	result["key"] = key
+
+	return responses.JSON(c, fiber.StatusCreated, result)
+}
+
+// Revoke handles the revoke API key endpoint
+func (h *APIKeyHandler) Revoke(c *fiber.Ctx) error {
+	keyID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.authService.RevokeAPIKey(ctx, GetUserID(c), uint(keyID)); err != nil {
+		return err
+	}
+	h.auditLog.Record(auditEvent(c, audit.ActionAPIKeyRevoke, audit.TargetAPIKey, auditID(keyID)))
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"success": true,
+	})
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/hra42/7x42/internal/audit"
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/repository"
+	"github.com/hra42/7x42/internal/server/responses"
+)
+
+// exportBatchSize is how many events an export reads per query
+const exportBatchSize = 500
+
+// AuditHandler handles audit log queries and exports from administrators
+type AuditHandler struct {
+	auditLog *audit.Logger
+}
+
+// NewAuditHandler creates a new audit handler
+func NewAuditHandler(auditLog *audit.Logger) *AuditHandler {
+	return &AuditHandler{
+		auditLog: auditLog,
+	}
+}
+
+// List handles the audit log endpoint.
+// Filters: actor, action (a trailing "." matches a category), targetType, targetId, success, ip,
+// and from/to as RFC 3339 timestamps.
+func (h *AuditHandler) List(c *fiber.Ctx) error {
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		return err
+	}
+	page, pageSize := ParsePagination(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	events, total, err := h.auditLog.List(ctx, filter, page, pageSize)
+	if err != nil {
+		return err
+	}
+
+	result := make([]map[string]interface{}, len(events))
+	for i := range events {
+		result[i] = events[i].ToMap()
+	}
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"events": result,
+		"pagination": fiber.Map{
+			"page":     page,
+			"pageSize": pageSize,
+			"total":    total,
+		},
+	})
+}
+
+// Export handles the audit log export endpoint, streaming every matching event as JSON Lines, oldest first.
+// It takes the same filters as List; the export itself is audited.
+func (h *AuditHandler) Export(c *fiber.Ctx) error {
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		return err
+	}
+
+	event := auditEvent(c, audit.ActionAuditExport, audit.TargetAuditLog, "")
+	event.Details = models.AuditDetails{"query": string(c.Request().URI().QueryString())}
+	h.auditLog.Record(event)
+
+	c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	c.Set(fiber.HeaderContentDisposition,
+		fmt.Sprintf(`attachment; filename="audit-%s.jsonl"`, time.Now().UTC().Format("20060102-150405")))
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		encoder := json.NewEncoder(w)
+		var afterID uint
+
+		for {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			events, err := h.auditLog.Scan(ctx, filter, afterID, exportBatchSize)
+			cancel()
+			if err != nil {
+				log.Printf("Audit export failed after event %d: %v", afterID, err)
+				return
+			}
+
+			for i := range events {
+				if err := encoder.Encode(events[i].ToMap()); err != nil {
+					return
+				}
+				afterID = events[i].ID
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+
+			if len(events) < exportBatchSize {
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+// parseAuditFilter reads audit log filters from the query string
+func parseAuditFilter(c *fiber.Ctx) (repository.AuditFilter, error) {
+	filter := repository.AuditFilter{
+		Action:     c.Query("action"),
+		TargetType: c.Query("targetType"),
+		TargetID:   c.Query("targetId"),
+		IP:         c.Query("ip"),
+	}
+
+	if actor := c.Query("actor"); actor != "" {
+		id, err := strconv.ParseUint(actor, 10, 64)
+		if err != nil {
+			return filter, fiber.NewError(fiber.StatusBadRequest, "Invalid actor")
+		}
+		actorID := uint(id)
+		filter.ActorID = &actorID
+	}
+
+	if success := c.Query("success"); success != "" {
+		value, err := strconv.ParseBool(success)
+		if err != nil {
+			return filter, fiber.NewError(fiber.StatusBadRequest, "Invalid success filter")
+		}
+		filter.Success = &value
+	}
+
+	for name, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := c.Query(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return filter, fiber.NewError(fiber.StatusBadRequest, "Invalid "+name+" timestamp; use RFC 3339")
+			}
+			*target = t
+		}
+	}
+
+	return filter, nil
+}
+
+// auditEvent starts an audit event for the request, with the signed-in user as actor if there is one
+func auditEvent(c *fiber.Ctx, action, targetType, targetID string) *models.AuditEvent {
+	event := &models.AuditEvent{
+		Action:     action,
+		Success:    true,
+		IP:         truncate(c.IP(), 64),
+		UserAgent:  truncate(c.Get(fiber.HeaderUserAgent), 512),
+		TargetType: targetType,
+		TargetID:   targetID,
+	}
+
+	if user := CurrentUser(c); user != nil {
+		setAuditActor(event, user)
+	}
+	if key := CurrentAPIKey(c); key != nil {
+		event.ActorKeyID = &key.ID
+	}
+
+	return event
+}
+
+// setAuditActor records the user as the event's actor
+func setAuditActor(event *models.AuditEvent, user *models.User) {
+	event.ActorID = &user.ID
+	event.ActorEmail = user.Email
+}
+
+// auditID formats a numeric ID as an audit target ID
+func auditID(id uint64) string {
+	return strconv.FormatUint(id, 10)
+}
+
+// truncate shortens s to at most n bytes
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/hra42/7x42/internal/audit"
+	"github.com/hra42/7x42/internal/auth"
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/server/responses"
+)
+
+// SessionCookie is the name of the cookie carrying the session token
+const SessionCookie = "7x42_session"
+
+// oidcCookie carries the single sign-on state between the redirect and the callback
+const oidcCookie = "7x42_oidc"
+
+// AuthHandler handles registration, login and logout requests
+type AuthHandler struct {
+	authService *auth.Service
+	auditLog    *audit.Logger
+}
+
+// NewAuthHandler creates a new auth handler
+func NewAuthHandler(authService *auth.Service, auditLog *audit.Logger) *AuthHandler {
+	return &AuthHandler{
+		authService: authService,
+		auditLog:    auditLog,
+	}
+}
+
+// Register handles the register endpoint and signs the new user in
+func (h *AuthHandler) Register(c *fiber.Ctx) error {
+	type request struct {
+		Email    string `json:"email"`
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}
+
+	var req request
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := h.authService.Register(ctx, req.Email, req.Name, req.Password)
+	if err != nil {
+		return authError(err)
+	}
+
+	event := auditEvent(c, audit.ActionRegister, audit.TargetUser, auditID(uint64(user.ID)))
+	setAuditActor(event, user)
+	event.Details = models.AuditDetails{"role": user.Role}
+	h.auditLog.Record(event)
+
+	token, session, err := h.authService.CreateSession(ctx, user, c.IP(), c.Get(fiber.HeaderUserAgent))
+	if err != nil {
+		return err
+	}
+	h.setSessionCookie(c, token, session.ExpiresAt)
+
+	return responses.JSON(c, fiber.StatusCreated, fiber.Map{
+		"user": user.ToMap(),
+	})
+}
+
+// Login handles the login endpoint
+func (h *AuthHandler) Login(c *fiber.Ctx) error {
+	type request struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+
+	var req request
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := h.authService.Login(ctx, req.Email, req.Password, c.IP(), c.Get(fiber.HeaderUserAgent))
+	if err != nil {
+		h.recordLoginFailure(c, req.Email, "password", err)
+		return authError(err)
+	}
+
+	// The password was right but the account needs a second factor before a session is opened
+	if result.Challenge != "" {
+		return responses.JSON(c, fiber.StatusOK, fiber.Map{
+			"secondFactor": true,
+			"challenge":    result.Challenge,
+			"expiresAt":    result.ChallengeExpires,
+		})
+	}
+
+	user, token, session := result.User, result.Token, result.Session
+	h.setSessionCookie(c, token, session.ExpiresAt)
+	h.recordLogin(c, user, "password")
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"user":      user.ToMap(),
+		"token":     token,
+		"expiresAt": session.ExpiresAt,
+	})
+}
+
+// VerifyLogin handles the second step of a login with an authenticator or recovery code
+func (h *AuthHandler) VerifyLogin(c *fiber.Ctx) error {
+	type request struct {
+		Challenge string `json:"challenge"`
+		Code      string `json:"code"`
+	}
+
+	var req request
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, token, session, err := h.authService.VerifyChallenge(ctx, req.Challenge, req.Code, c.IP(), c.Get(fiber.HeaderUserAgent))
+	if err != nil {
+		h.recordLoginFailure(c, "", "totp", err)
+		return authError(err)
+	}
+	h.setSessionCookie(c, token, session.ExpiresAt)
+	h.recordLogin(c, user, "totp")
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"user":      user.ToMap(),
+		"token":     token,
+		"expiresAt": session.ExpiresAt,
+	})
+}
+
+// Logout handles the logout endpoint
+func (h *AuthHandler) Logout(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if session := CurrentSession(c); session != nil {
+		if err := h.authService.Logout(ctx, session); err != nil {
+			return err
+		}
+		h.auditLog.Record(auditEvent(c, audit.ActionLogout, audit.TargetUser, auditID(uint64(session.UserID))))
+	}
+	h.setSessionCookie(c, "", time.Unix(0, 0))
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"success": true,
+	})
+}
+
+// Me handles the current user endpoint
+func (h *AuthHandler) Me(c *fiber.Ctx) error {
+	user := CurrentUser(c)
+	if user == nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Authentication required")
+	}
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"user": user.ToMap(),
+	})
+}
+
+// Ticket handles the WebSocket ticket endpoint.
+// The ticket authenticates a single upgrade request via the ticket query parameter.
+func (h *AuthHandler) Ticket(c *fiber.Ctx) error {
+	session := CurrentSession(c)
+	if session == nil {
+		return fiber.NewError(fiber.StatusUnauthorized, "Authentication required")
+	}
+
+	ticket, expires := h.authService.IssueTicket(session)
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"ticket":    ticket,
+		"expiresAt": expires,
+	})
+}
+
+// OIDCLogin redirects to the identity provider to start a single sign-on login
+func (h *AuthHandler) OIDCLogin(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	authURL, flow, err := h.authService.StartOIDC(ctx, localRedirect(c.Query("next")))
+	if err != nil {
+		if errors.Is(err, auth.ErrOIDCDisabled) {
+			return fiber.NewError(fiber.StatusNotFound, err.Error())
+		}
+		return err
+	}
+
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcCookie,
+		Value:    flow,
+		Path:     "/auth/oidc",
+		MaxAge:   600,
+		HTTPOnly: true,
+		Secure:   h.authService.Config().SecureCookies,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+
+	return c.Redirect(authURL, fiber.StatusFound)
+}
+
+// OIDCCallback completes a single sign-on login and redirects to the page the user came from.
+// Failures are shown on the login page rather than as a bare error response.
+func (h *AuthHandler) OIDCCallback(c *fiber.Ctx) error {
+	flow := c.Cookies(oidcCookie)
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcCookie,
+		Value:    "",
+		Path:     "/auth/oidc",
+		Expires:  time.Unix(0, 0),
+		HTTPOnly: true,
+		Secure:   h.authService.Config().SecureCookies,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+
+	if errParam := c.Query("error"); errParam != "" {
+		message := c.Query("error_description", errParam)
+		return c.Redirect("/login?error="+url.QueryEscape(message), fiber.StatusFound)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, token, session, next, err := h.authService.FinishOIDC(ctx, flow, c.Query("state"), c.Query("code"),
+		c.IP(), c.Get(fiber.HeaderUserAgent))
+	if err != nil {
+		log.Printf("Single sign-on failed: %v", err)
+		h.recordLoginFailure(c, "", "oidc", err)
+		return c.Redirect("/login?error="+url.QueryEscape(oidcErrorMessage(err)), fiber.StatusFound)
+	}
+	h.setSessionCookie(c, token, session.ExpiresAt)
+	h.recordLogin(c, user, "oidc")
+
+	return c.Redirect(localRedirect(next), fiber.StatusFound)
+}
+
+// recordLogin audits a successful login
+func (h *AuthHandler) recordLogin(c *fiber.Ctx, user *models.User, method string) {
+	event := auditEvent(c, audit.ActionLogin, audit.TargetUser, auditID(uint64(user.ID)))
+	setAuditActor(event, user)
+	event.Details = models.AuditDetails{"method": method}
+	h.auditLog.Record(event)
+}
+
+// recordLoginFailure audits a failed login; email is the address that was tried, if known
+func (h *AuthHandler) recordLoginFailure(c *fiber.Ctx, email, method string, err error) {
+	event := auditEvent(c, audit.ActionLoginFailed, audit.TargetUser, "")
+	event.Success = false
+	event.ActorEmail = truncate(strings.ToLower(strings.TrimSpace(email)), 255)
+	event.Details = models.AuditDetails{"method": method, "reason": err.Error()}
+	h.auditLog.Record(event)
+}
+
+// setSessionCookie sets or clears the session cookie
+func (h *AuthHandler) setSessionCookie(c *fiber.Ctx, token string, expires time.Time) {
+	c.Cookie(&fiber.Cookie{
+		Name:     SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HTTPOnly: true,
+		Secure:   h.authService.Config().SecureCookies,
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+}
+
+// SessionToken extracts the session token or API key from the bearer header or the session cookie
+func SessionToken(c *fiber.Ctx) string {
+	if token := BearerToken(c); token != "" {
+		return token
+	}
+	return c.Cookies(SessionCookie)
+}
+
+// BearerToken extracts the token from the Authorization header, if any
+func BearerToken(c *fiber.Ctx) string {
+	if token, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer "); ok {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
+
+// localRedirect returns next if it is a local path, or the chat page otherwise
+func localRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/chat"
+	}
+	return next
+}
+
+// oidcErrorMessage returns a message safe to show on the login page
+func oidcErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, auth.ErrOIDCFlow), errors.Is(err, auth.ErrOIDCNoGroup),
+		errors.Is(err, auth.ErrAccountDisabled), errors.Is(err, auth.ErrEmailTaken):
+		return err.Error()
+	}
+	return "Single sign-on failed"
+}
+
+// authError maps authentication errors to HTTP errors
+func authError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrInvalidCredentials), errors.Is(err, auth.ErrInvalidSession),
+		errors.Is(err, auth.ErrInvalidTicket), errors.Is(err, auth.ErrInvalidAPIKey),
+		errors.Is(err, auth.ErrInvalidChallenge), errors.Is(err, auth.ErrInvalidCode):
+		return fiber.NewError(fiber.StatusUnauthorized, err.Error())
+	case errors.Is(err, auth.ErrTooManyAttempts):
+		return fiber.NewError(fiber.StatusTooManyRequests, err.Error())
+	case errors.Is(err, auth.ErrTOTPEnabled), errors.Is(err, auth.ErrTOTPNotEnabled),
+		errors.Is(err, auth.ErrTOTPNotPending):
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	case errors.Is(err, auth.ErrAccountDisabled), errors.Is(err, auth.ErrRegistrationClosed),
+		errors.Is(err, auth.ErrMissingScope), errors.Is(err, auth.ErrPasswordDisabled),
+		errors.Is(err, auth.ErrTOTPRequiredByRole), errors.Is(err, auth.ErrSecondFactorRequired):
+		return fiber.NewError(fiber.StatusForbidden, err.Error())
+	case errors.Is(err, auth.ErrEmailTaken):
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	case errors.Is(err, auth.ErrInvalidEmail), errors.Is(err, auth.ErrWeakPassword),
+		errors.Is(err, auth.ErrPasswordTooLong), errors.Is(err, auth.ErrInvalidScope),
+		errors.Is(err, auth.ErrKeyNameEmpty), errors.Is(err, auth.ErrInvalidExpiry):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return err
+}
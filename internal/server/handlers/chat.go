@@ -2,9 +2,13 @@ package handlers
 
 import (
 	"context"
+	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/hra42/7x42/internal/audit"
+	"github.com/hra42/7x42/internal/authz"
+	"github.com/hra42/7x42/internal/events"
 	"github.com/hra42/7x42/internal/models"
 	"github.com/hra42/7x42/internal/repository"
 	"github.com/hra42/7x42/internal/server/responses"
@@ -14,13 +18,19 @@ import (
 type ChatHandler struct {
 	chatRepo    *repository.ChatRepository
 	messageRepo *repository.MessageRepository
+	authorizer  *authz.Authorizer
+	auditLog    *audit.Logger
+	events      *events.Bus
 }
 
 // NewChatHandler creates a new chat handler
-func NewChatHandler(chatRepo *repository.ChatRepository, messageRepo *repository.MessageRepository) *ChatHandler {
+func NewChatHandler(chatRepo *repository.ChatRepository, messageRepo *repository.MessageRepository, authorizer *authz.Authorizer, auditLog *audit.Logger, eventBus *events.Bus) *ChatHandler {
 	return &ChatHandler{
 		chatRepo:    chatRepo,
 		messageRepo: messageRepo,
+		authorizer:  authorizer,
+		auditLog:    auditLog,
+		events:      eventBus,
 	}
 }
 
@@ -32,14 +42,31 @@ func (h *ChatHandler) List(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// ?workspace=personal lists only the user's own chats, ?workspace=<id> one workspace's
+	var scope repository.ChatScope
+	switch workspace := c.Query("workspace"); workspace {
+	case "":
+	case "personal":
+		scope.Personal = true
+	default:
+		id, err := strconv.ParseUint(workspace, 10, 64)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid workspace parameter")
+		}
+		if _, _, err := h.authorizer.Workspace(ctx, userID, uint(id), authz.ActionRead); err != nil {
+			return err
+		}
+		scope.WorkspaceID = uint(id)
+	}
+
 	// Get chats
-	chats, err := h.chatRepo.ListChats(ctx, userID, page, pageSize)
+	chats, err := h.chatRepo.ListChats(ctx, userID, scope, page, pageSize)
 	if err != nil {
 		return err
 	}
 
 	// Get total count
-	total, err := h.chatRepo.CountChats(ctx, userID)
+	total, err := h.chatRepo.CountChats(ctx, userID, scope)
 	if err != nil {
 		return err
 	}
@@ -52,6 +79,8 @@ func (h *ChatHandler) List(c *fiber.Ctx) error {
 			"title":       chat.Title,
 			"lastMessage": chat.LastMessage,
 			"createdAt":   chat.CreatedAt,
+			"userId":      chat.UserID,
+			"workspaceId": chat.WorkspaceID,
 		}
 	}
 
@@ -77,7 +106,7 @@ func (h *ChatHandler) Get(c *fiber.Ctx) error {
 	defer cancel()
 
 	// Get chat
-	chat, err := h.chatRepo.GetChat(ctx, chatID)
+	chat, err := h.authorizer.Chat(ctx, GetUserID(c), chatID, authz.ActionRead)
 	if err != nil {
 		return err
 	}
@@ -91,6 +120,10 @@ func (h *ChatHandler) Get(c *fiber.Ctx) error {
 			"role":      msg.Role,
 			"timestamp": msg.Timestamp,
 			"metadata":  msg.Metadata,
+			"parentId":  msg.ParentID,
+			"userId":    msg.UserID,
+			"status":    msg.Status,
+			"error":     msg.Error,
 		}
 	}
 
@@ -99,6 +132,8 @@ func (h *ChatHandler) Get(c *fiber.Ctx) error {
 		"title":       chat.Title,
 		"createdAt":   chat.CreatedAt,
 		"lastMessage": chat.LastMessage,
+		"userId":      chat.UserID,
+		"workspaceId": chat.WorkspaceID,
 		"messages":    messages,
 	})
 }
@@ -106,8 +141,9 @@ func (h *ChatHandler) Get(c *fiber.Ctx) error {
 // Create handles the create chat endpoint
 func (h *ChatHandler) Create(c *fiber.Ctx) error {
 	type request struct {
-		Title  string `json:"title"`
-		UserID string `json:"userId"`
+		Title string `json:"title"`
+		// WorkspaceID shares the chat with a workspace the user may write in
+		WorkspaceID *uint `json:"workspaceId"`
 	}
 
 	var req request
@@ -115,28 +151,32 @@ func (h *ChatHandler) Create(c *fiber.Ctx) error {
 		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
 	}
 
-	userID := req.UserID
-	if userID == "" {
-		userID = GetUserID(c)
-	}
-
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	if req.WorkspaceID != nil {
+		if _, _, err := h.authorizer.Workspace(ctx, GetUserID(c), *req.WorkspaceID, authz.ActionWrite); err != nil {
+			return err
+		}
+	}
+
 	// Create chat
 	chat := &models.Chat{
-		Title:  req.Title,
-		UserID: userID,
+		Title:       req.Title,
+		UserID:      GetUserID(c),
+		WorkspaceID: req.WorkspaceID,
 	}
 
 	if err := h.chatRepo.CreateChat(ctx, chat); err != nil {
 		return err
 	}
+	h.publishChat(events.ChatCreated, chat)
 
 	return responses.JSON(c, fiber.StatusCreated, fiber.Map{
-		"id":        chat.ID,
-		"title":     chat.Title,
-		"createdAt": chat.CreatedAt,
+		"id":          chat.ID,
+		"title":       chat.Title,
+		"createdAt":   chat.CreatedAt,
+		"workspaceId": chat.WorkspaceID,
 	})
 }
 
@@ -160,7 +200,7 @@ func (h *ChatHandler) Update(c *fiber.Ctx) error {
 	defer cancel()
 
 	// Get chat
-	chat, err := h.chatRepo.GetChat(ctx, chatID)
+	chat, err := h.authorizer.ChatInfo(ctx, GetUserID(c), chatID, authz.ActionManage)
 	if err != nil {
 		return err
 	}
@@ -170,6 +210,7 @@ func (h *ChatHandler) Update(c *fiber.Ctx) error {
 	if err := h.chatRepo.UpdateChat(ctx, chat); err != nil {
 		return err
 	}
+	h.publishChat(events.ChatUpdated, chat)
 
 	return responses.JSON(c, fiber.StatusOK, fiber.Map{
 		"id":        chat.ID,
@@ -188,10 +229,20 @@ func (h *ChatHandler) Delete(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	chat, err := h.authorizer.ChatInfo(ctx, GetUserID(c), chatID, authz.ActionDelete)
+	if err != nil {
+		return err
+	}
+
 	// Delete chat
 	if err := h.chatRepo.DeleteChat(ctx, chatID); err != nil {
 		return err
 	}
+	h.publishChat(events.ChatDeleted, chat)
+
+	event := auditEvent(c, audit.ActionChatDelete, audit.TargetChat, auditID(chatID))
+	event.Details = models.AuditDetails{"title": chat.Title, "ownerId": chat.UserID}
+	h.auditLog.Record(event)
 
 	return responses.JSON(c, fiber.StatusOK, fiber.Map{
 		"success": true,
@@ -217,17 +268,24 @@ func (h *ChatHandler) SendMessage(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	if _, err := h.authorizer.ChatInfo(ctx, GetUserID(c), chatID, authz.ActionPrompt); err != nil {
+		return err
+	}
+
 	// Create message
+	userID := GetUserID(c)
 	message := &models.Message{
 		ChatID:    chatID,
 		Content:   req.Content,
 		Role:      models.RoleUser,
 		Timestamp: time.Now(),
+		UserID:    &userID,
 	}
 
 	if err := h.messageRepo.CreateMessage(ctx, message); err != nil {
 		return err
 	}
+	h.events.Publish(events.MessageCreated, message.ToMap(), events.ChatTopic(chatID))
 
 	return responses.JSON(c, fiber.StatusCreated, fiber.Map{
 		"id":        message.ID,
@@ -249,6 +307,10 @@ func (h *ChatHandler) ListMessages(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	if _, err := h.authorizer.ChatInfo(ctx, GetUserID(c), chatID, authz.ActionRead); err != nil {
+		return err
+	}
+
 	// Get messages
 	messages, err := h.messageRepo.GetChatMessages(ctx, chatID, page, pageSize)
 	if err != nil {
@@ -270,6 +332,10 @@ func (h *ChatHandler) ListMessages(c *fiber.Ctx) error {
 			"role":      msg.Role,
 			"timestamp": msg.Timestamp,
 			"metadata":  msg.Metadata,
+			"parentId":  msg.ParentID,
+			"userId":    msg.UserID,
+			"status":    msg.Status,
+			"error":     msg.Error,
 		}
 	}
 
@@ -283,3 +349,8 @@ func (h *ChatHandler) ListMessages(c *fiber.Ctx) error {
 		},
 	})
 }
+
+// publishChat publishes a change to a chat to its own topic and the one of its chat list
+func (h *ChatHandler) publishChat(name string, chat *models.Chat) {
+	h.events.Publish(name, chat.ToMap(), events.ChatTopics(uint64(chat.ID), chat.UserID, chat.WorkspaceID)...)
+}
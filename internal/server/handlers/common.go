@@ -6,6 +6,10 @@ import (
 	"strconv"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/hra42/7x42/internal/authz"
+	"github.com/hra42/7x42/internal/credits"
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/providerkeys"
 	"github.com/hra42/7x42/internal/repository"
 	"github.com/hra42/7x42/internal/server/responses"
 )
@@ -23,6 +27,12 @@ func ErrorHandler(c *fiber.Ctx, err error) error {
 		code = fiber.StatusNotFound
 	} else if repository.IsAlreadyExists(err) {
 		code = fiber.StatusConflict
+	} else if errors.Is(err, credits.ErrInsufficientCredits) || errors.Is(err, credits.ErrBudgetExceeded) {
+		code = fiber.StatusPaymentRequired
+	} else if errors.Is(err, providerkeys.ErrUnreadable) {
+		code = fiber.StatusConflict
+	} else if errors.Is(err, authz.ErrForbidden) {
+		code = fiber.StatusForbidden
 	}
 
 	// Return error response
@@ -54,8 +64,37 @@ func ParsePagination(c *fiber.Ctx) (page, pageSize int) {
 	return page, pageSize
 }
 
-// GetUserID gets the user ID from the request
-func GetUserID(c *fiber.Ctx) string {
-	// In a real application, this would come from authentication
-	return c.Query("userId", "default-user")
+// Locals keys set by the authentication middleware
+const (
+	LocalUser    = "user"
+	LocalSession = "session"
+	LocalAPIKey  = "apiKey"
+	// LocalIP is the client address of a WebSocket upgrade request
+	LocalIP = "ip"
+)
+
+// CurrentUser returns the authenticated user of the request, or nil
+func CurrentUser(c *fiber.Ctx) *models.User {
+	user, _ := c.Locals(LocalUser).(*models.User)
+	return user
+}
+
+// CurrentSession returns the session the request was authenticated with, or nil
+func CurrentSession(c *fiber.Ctx) *models.Session {
+	session, _ := c.Locals(LocalSession).(*models.Session)
+	return session
+}
+
+// CurrentAPIKey returns the API key the request was authenticated with, or nil
+func CurrentAPIKey(c *fiber.Ctx) *models.APIKey {
+	key, _ := c.Locals(LocalAPIKey).(*models.APIKey)
+	return key
+}
+
+// GetUserID gets the authenticated user ID from the request
+func GetUserID(c *fiber.Ctx) uint {
+	if user := CurrentUser(c); user != nil {
+		return user.ID
+	}
+	return 0
 }
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/hra42/7x42/internal/ai"
+	"github.com/hra42/7x42/pkg/protocol"
+)
+
+// sseKeepAlive is how often a comment is sent on idle event streams so proxies keep them open
+const sseKeepAlive = 15 * time.Second
+
+// errStreamClosed is returned to the generation job once the HTTP client has gone away
+var errStreamClosed = errors.New("event stream closed")
+
+// CompletionHandler handles prompt submissions that stream the reply as server-sent events
+type CompletionHandler struct {
+	aiService *ai.Service
+}
+
+// NewCompletionHandler creates a new completion handler
+func NewCompletionHandler(aiService *ai.Service) *CompletionHandler {
+	return &CompletionHandler{
+		aiService: aiService,
+	}
+}
+
+// Stream handles the chat completions endpoint.
+// The prompt is saved to the chat and the reply is streamed as server-sent events using the
+// same frames as the WebSocket; the generation keeps running if the client disconnects.
+func (h *CompletionHandler) Stream(c *fiber.Ctx) error {
+	chatID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+
+	type request struct {
+		Content string `json:"content"`
+		Model   string `json:"model"`
+	}
+
+	var req request
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+	if strings.TrimSpace(req.Content) == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Content is required")
+	}
+
+	w := newSSEWriter()
+	done, err := h.aiService.StreamChatMessage(w, uint(chatID), req.Content, GetUserID(c), req.Model)
+	if err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(bw *bufio.Writer) {
+		w.stream(bw, done)
+	})
+
+	return nil
+}
+
+// sseWriter adapts a generation job's frames to a server-sent event stream.
+// Frames are queued by the job and written by the response's stream goroutine.
+type sseWriter struct {
+	frames chan interface{}
+	closed chan struct{}
+}
+
+// newSSEWriter creates a new event stream writer
+func newSSEWriter() *sseWriter {
+	return &sseWriter{
+		frames: make(chan interface{}, 64),
+		closed: make(chan struct{}),
+	}
+}
+
+// SendJSON queues a frame for the client
+func (w *sseWriter) SendJSON(data interface{}) error {
+	select {
+	case w.frames <- data:
+		return nil
+	case <-w.closed:
+		return errStreamClosed
+	}
+}
+
+// stream writes queued frames until the job is done or the client goes away
+func (w *sseWriter) stream(bw *bufio.Writer, done <-chan struct{}) {
+	defer close(w.closed)
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case frame := <-w.frames:
+			if err := writeEvent(bw, frame); err != nil {
+				return
+			}
+
+		case <-keepAlive.C:
+			if _, err := bw.WriteString(": keep-alive\n\n"); err != nil {
+				return
+			}
+			if err := bw.Flush(); err != nil {
+				return
+			}
+
+		case <-done:
+			// The final frame is queued before done is closed
+			for {
+				select {
+				case frame := <-w.frames:
+					if err := writeEvent(bw, frame); err != nil {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// writeEvent writes a frame as a server-sent event named after its type
+func writeEvent(bw *bufio.Writer, frame interface{}) error {
+	data, err := json.Marshal(frame)
+	if err != nil {
+		log.Printf("Error encoding event: %v", err)
+		return nil
+	}
+
+	event := "message"
+	if envelope, ok := frame.(*protocol.Envelope); ok && envelope.Type != "" {
+		event = string(envelope.Type)
+	}
+
+	if _, err := fmt.Fprintf(bw, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/hra42/7x42/internal/audit"
+	"github.com/hra42/7x42/internal/credits"
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/server/responses"
+)
+
+// CreditHandler handles credit balances and history, and credit grants and budgets from administrators
+type CreditHandler struct {
+	credits  *credits.Service
+	auditLog *audit.Logger
+}
+
+// NewCreditHandler creates a new credit handler
+func NewCreditHandler(creditService *credits.Service, auditLog *audit.Logger) *CreditHandler {
+	return &CreditHandler{
+		credits:  creditService,
+		auditLog: auditLog,
+	}
+}
+
+// Summary handles the balance endpoint of the current user
+func (h *CreditHandler) Summary(c *fiber.Ctx) error {
+	return h.summary(c, GetUserID(c))
+}
+
+// History handles the ledger history endpoint of the current user
+func (h *CreditHandler) History(c *fiber.Ctx) error {
+	return h.history(c, GetUserID(c))
+}
+
+// UserSummary handles the balance endpoint of any user
+func (h *CreditHandler) UserSummary(c *fiber.Ctx) error {
+	userID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+	return h.summary(c, uint(userID))
+}
+
+// UserHistory handles the ledger history endpoint of any user
+func (h *CreditHandler) UserHistory(c *fiber.Ctx) error {
+	userID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+	return h.history(c, uint(userID))
+}
+
+// Grant handles granting credits to a user. Negative amounts correct an earlier grant.
+func (h *CreditHandler) Grant(c *fiber.Ctx) error {
+	userID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+
+	type request struct {
+		Amount float64 `json:"amount"`
+		Note   string  `json:"note"`
+	}
+
+	var req request
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	entry, err := h.credits.Grant(ctx, uint(userID), req.Amount, GetUserID(c), req.Note)
+	if err != nil {
+		return creditError(err)
+	}
+
+	event := auditEvent(c, audit.ActionCreditGrant, audit.TargetUser, auditID(userID))
+	event.Details = models.AuditDetails{
+		"amount":       models.CreditsFromMicros(entry.Amount),
+		"balanceAfter": models.CreditsFromMicros(entry.BalanceAfter),
+	}
+	if entry.Note != "" {
+		event.Details["note"] = entry.Note
+	}
+	h.auditLog.Record(event)
+
+	return responses.JSON(c, fiber.StatusCreated, entry.ToMap())
+}
+
+// ListBudgets handles the budgets endpoint
+func (h *CreditHandler) ListBudgets(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	budgets, err := h.credits.ListBudgets(ctx)
+	if err != nil {
+		return err
+	}
+
+	result := make([]map[string]interface{}, len(budgets))
+	for i := range budgets {
+		result[i] = budgets[i].ToMap()
+	}
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"budgets": result,
+	})
+}
+
+// UpdateBudget handles setting the budget of a role or user for a period.
+// It applies to the next generation; running ones are charged as usual.
+func (h *CreditHandler) UpdateBudget(c *fiber.Ctx) error {
+	type request struct {
+		Amount float64 `json:"amount"`
+	}
+
+	var req request
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	budget, err := h.credits.SetBudget(ctx, c.Params("scope"), c.Params("subject"), c.Params("period"), req.Amount)
+	if err != nil {
+		return creditError(err)
+	}
+
+	event := auditEvent(c, audit.ActionBudgetUpdate, audit.TargetBudget, budgetID(budget.Scope, budget.Subject, budget.Period))
+	event.Details = models.AuditDetails{"amount": models.CreditsFromMicros(budget.Amount)}
+	h.auditLog.Record(event)
+
+	return responses.JSON(c, fiber.StatusOK, budget.ToMap())
+}
+
+// DeleteBudget handles removing the budget of a role or user for a period
+func (h *CreditHandler) DeleteBudget(c *fiber.Ctx) error {
+	scope, subject, period := c.Params("scope"), c.Params("subject"), c.Params("period")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.credits.DeleteBudget(ctx, scope, subject, period); err != nil {
+		return err
+	}
+	h.auditLog.Record(auditEvent(c, audit.ActionBudgetDelete, audit.TargetBudget, budgetID(scope, subject, period)))
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"success": true,
+	})
+}
+
+// summary responds with a user's balance and budget usage
+func (h *CreditHandler) summary(c *fiber.Ctx, userID uint) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	summary, err := h.credits.Summary(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return responses.JSON(c, fiber.StatusOK, summary.ToMap())
+}
+
+// history responds with a page of a user's ledger entries
+func (h *CreditHandler) history(c *fiber.Ctx, userID uint) error {
+	page, pageSize := ParsePagination(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	entries, total, err := h.credits.History(ctx, userID, page, pageSize)
+	if err != nil {
+		return err
+	}
+
+	result := make([]map[string]interface{}, len(entries))
+	for i := range entries {
+		result[i] = entries[i].ToMap()
+	}
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"entries": result,
+		"pagination": fiber.Map{
+			"page":       page,
+			"pageSize":   pageSize,
+			"total":      total,
+			"totalPages": (total + int64(pageSize) - 1) / int64(pageSize),
+		},
+	})
+}
+
+// budgetID formats a budget's key as an audit target ID
+func budgetID(scope, subject, period string) string {
+	return scope + ":" + subject + ":" + period
+}
+
+// creditError maps credit and budget validation errors to HTTP errors
+func creditError(err error) error {
+	if errors.Is(err, credits.ErrInvalidAmount) || errors.Is(err, credits.ErrInvalidBudget) {
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return err
+}
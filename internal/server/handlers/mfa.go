@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/hra42/7x42/internal/audit"
+	"github.com/hra42/7x42/internal/auth"
+	"github.com/hra42/7x42/internal/server/responses"
+)
+
+// MFAHandler handles two-factor enrollment and management for the current user
+type MFAHandler struct {
+	authService *auth.Service
+	auditLog    *audit.Logger
+}
+
+// NewMFAHandler creates a new MFA handler
+func NewMFAHandler(authService *auth.Service, auditLog *audit.Logger) *MFAHandler {
+	return &MFAHandler{
+		authService: authService,
+		auditLog:    auditLog,
+	}
+}
+
+// codeRequest is a request carrying an authenticator or recovery code
+type codeRequest struct {
+	Code string `json:"code"`
+}
+
+// Status handles the two-factor status endpoint
+func (h *MFAHandler) Status(c *fiber.Ctx) error {
+	user := CurrentUser(c)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	required, err := h.authService.RequiresTOTP(ctx, user.Role)
+	if err != nil {
+		return err
+	}
+
+	var codesLeft int64
+	if user.HasTOTP() {
+		if codesLeft, err = h.authService.RecoveryCodesLeft(ctx, user.ID); err != nil {
+			return err
+		}
+	}
+
+	session := CurrentSession(c)
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"enabled":           user.HasTOTP(),
+		"required":          required,
+		"verified":          session != nil && session.SecondFactor,
+		"recoveryCodesLeft": codesLeft,
+	})
+}
+
+// Enroll handles the start of two-factor enrollment, returning the secret and its provisioning URI
+func (h *MFAHandler) Enroll(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	enrollment, err := h.authService.BeginTOTPEnrollment(ctx, CurrentUser(c))
+	if err != nil {
+		return authError(err)
+	}
+
+	return responses.JSON(c, fiber.StatusOK, enrollment)
+}
+
+// Confirm handles the end of two-factor enrollment and returns the recovery codes
+func (h *MFAHandler) Confirm(c *fiber.Ctx) error {
+	var req codeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user := CurrentUser(c)
+	codes, err := h.authService.ConfirmTOTPEnrollment(ctx, user, CurrentSession(c), req.Code)
+	if err != nil {
+		return authError(err)
+	}
+	h.auditLog.Record(auditEvent(c, audit.ActionTOTPEnable, audit.TargetUser, auditID(uint64(user.ID))))
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"recoveryCodes": codes,
+	})
+}
+
+// Disable handles turning two-factor authentication off
+func (h *MFAHandler) Disable(c *fiber.Ctx) error {
+	var req codeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user := CurrentUser(c)
+	if err := h.authService.DisableTOTP(ctx, user, req.Code); err != nil {
+		return authError(err)
+	}
+	h.auditLog.Record(auditEvent(c, audit.ActionTOTPDisable, audit.TargetUser, auditID(uint64(user.ID))))
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"success": true,
+	})
+}
+
+// RecoveryCodes handles replacing the recovery codes
+func (h *MFAHandler) RecoveryCodes(c *fiber.Ctx) error {
+	var req codeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user := CurrentUser(c)
+	codes, err := h.authService.RegenerateRecoveryCodes(ctx, user, req.Code)
+	if err != nil {
+		return authError(err)
+	}
+	h.auditLog.Record(auditEvent(c, audit.ActionRecoveryCodes, audit.TargetUser, auditID(uint64(user.ID))))
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"recoveryCodes": codes,
+	})
+}
@@ -1,21 +1,30 @@
 package handlers
 
 import (
+	"context"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
+	"github.com/hra42/7x42/internal/auth"
 )
 
 // PageHandler handles page rendering requests
-type PageHandler struct{}
+type PageHandler struct {
+	authService *auth.Service
+}
 
 // NewPageHandler creates a new page handler
-func NewPageHandler() *PageHandler {
-	return &PageHandler{}
+func NewPageHandler(authService *auth.Service) *PageHandler {
+	return &PageHandler{
+		authService: authService,
+	}
 }
 
 // Index handles the index page
 func (h *PageHandler) Index(c *fiber.Ctx) error {
-	return c.Render("base", fiber.Map{
+	return c.Render("chat", fiber.Map{
 		"title": "7x42 - Home",
+		"user":  CurrentUser(c),
 	})
 }
 
@@ -23,10 +32,35 @@ func (h *PageHandler) Index(c *fiber.Ctx) error {
 func (h *PageHandler) Chat(c *fiber.Ctx) error {
 	return c.Render("chat", fiber.Map{
 		"title": "7x42 - Chat",
+		"user":  CurrentUser(c),
+	})
+}
+
+// Login handles the login page, sending users who are already signed in onwards
+func (h *PageHandler) Login(c *fiber.Ctx) error {
+	next := localRedirect(c.Query("next"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, _, err := h.authService.Authenticate(ctx, SessionToken(c)); err == nil {
+		return c.Redirect(next)
+	}
+
+	return c.Render("login", fiber.Map{
+		"title":             "7x42 - Sign in",
+		"next":              next,
+		"allowRegistration": h.authService.Config().AllowRegistration && !h.authService.Config().DisablePasswords,
+		"passwordLogin":     !h.authService.Config().DisablePasswords,
+		"sso":               h.authService.OIDCEnabled(),
+		"error":             c.Query("error"),
 	})
 }
 
 // Settings handles the settings page
 func (h *PageHandler) Settings(c *fiber.Ctx) error {
-	return c.SendString("Settings page")
+	return c.Render("settings", fiber.Map{
+		"title": "7x42 - Settings",
+		"user":  CurrentUser(c),
+	})
 }
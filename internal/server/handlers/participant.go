@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/hra42/7x42/internal/audit"
+	"github.com/hra42/7x42/internal/authz"
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/repository"
+	"github.com/hra42/7x42/internal/server/responses"
+	"github.com/hra42/7x42/internal/websocket"
+)
+
+// ParticipantHandler handles inviting users into a single chat
+type ParticipantHandler struct {
+	chatRepo   *repository.ChatRepository
+	userRepo   *repository.UserRepository
+	authorizer *authz.Authorizer
+	wsManager  *websocket.Manager
+	auditLog   *audit.Logger
+}
+
+// NewParticipantHandler creates a new participant handler
+func NewParticipantHandler(chatRepo *repository.ChatRepository, userRepo *repository.UserRepository, authorizer *authz.Authorizer, wsManager *websocket.Manager, auditLog *audit.Logger) *ParticipantHandler {
+	return &ParticipantHandler{
+		chatRepo:   chatRepo,
+		userRepo:   userRepo,
+		authorizer: authorizer,
+		wsManager:  wsManager,
+		auditLog:   auditLog,
+	}
+}
+
+// List handles the list participants endpoint
+func (h *ParticipantHandler) List(c *fiber.Ctx) error {
+	chatID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	chat, err := h.authorizer.ChatInfo(ctx, GetUserID(c), chatID, authz.ActionRead)
+	if err != nil {
+		return err
+	}
+
+	participants, err := h.chatRepo.ListParticipants(ctx, chatID)
+	if err != nil {
+		return err
+	}
+
+	result := make([]fiber.Map, len(participants))
+	for i := range participants {
+		result[i] = participants[i].ToMap()
+	}
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"ownerId":      chat.UserID,
+		"participants": result,
+	})
+}
+
+// Add handles inviting a user into a chat by email, as a prompter by default.
+// The response is the same whether or not an active account has the email, and whether
+// or not it already takes part, so the endpoint cannot be used to find out who has an account.
+func (h *ParticipantHandler) Add(c *fiber.Ctx) error {
+	chatID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+
+	type request struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	var req request
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+	if req.Role == "" {
+		req.Role = models.ParticipantRolePrompter
+	}
+	if !models.IsValidParticipantRole(req.Role) {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid participant role")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	chat, err := h.authorizer.ChatInfo(ctx, GetUserID(c), chatID, authz.ActionManage)
+	if err != nil {
+		return err
+	}
+
+	accepted := func() error {
+		return responses.JSON(c, fiber.StatusAccepted, fiber.Map{
+			"success": true,
+		})
+	}
+
+	// Unknown and disabled accounts, the owner and existing participants are all answered alike
+	user, err := h.userRepo.GetUserByEmail(ctx, strings.TrimSpace(req.Email))
+	if repository.IsNotFound(err) {
+		return accepted()
+	} else if err != nil {
+		return err
+	}
+	if !user.Active || user.ID == chat.UserID {
+		return accepted()
+	}
+	if _, err := h.chatRepo.GetParticipant(ctx, chatID, user.ID); err == nil {
+		return accepted()
+	} else if !repository.IsNotFound(err) {
+		return err
+	}
+
+	participant := &models.ChatParticipant{
+		ChatID: chatID,
+		UserID: user.ID,
+		Role:   req.Role,
+		User:   user,
+	}
+	if err := h.chatRepo.SaveParticipant(ctx, participant); err != nil {
+		return err
+	}
+
+	event := auditEvent(c, audit.ActionChatParticipantAdd, audit.TargetChat, auditID(chatID))
+	event.Details = models.AuditDetails{"userId": user.ID, "role": participant.Role}
+	h.auditLog.Record(event)
+
+	return accepted()
+}
+
+// Update handles changing a participant's role; watchers in the chat's room learn at once
+func (h *ParticipantHandler) Update(c *fiber.Ctx) error {
+	chatID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+	userID, err := ParseUint64Param(c, "userId")
+	if err != nil {
+		return err
+	}
+
+	type request struct {
+		Role string `json:"role"`
+	}
+
+	var req request
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+	if !models.IsValidParticipantRole(req.Role) {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid participant role")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := h.authorizer.ChatInfo(ctx, GetUserID(c), chatID, authz.ActionManage); err != nil {
+		return err
+	}
+
+	participant, err := h.chatRepo.GetParticipant(ctx, chatID, uint(userID))
+	if err != nil {
+		return err
+	}
+	participant.Role = req.Role
+	if err := h.chatRepo.SaveParticipant(ctx, participant); err != nil {
+		return err
+	}
+	h.wsManager.RefreshRoom(uint(chatID))
+
+	event := auditEvent(c, audit.ActionChatParticipantUpdate, audit.TargetChat, auditID(chatID))
+	event.Details = models.AuditDetails{"userId": participant.UserID, "role": participant.Role}
+	h.auditLog.Record(event)
+
+	return responses.JSON(c, fiber.StatusOK, participant.ToMap())
+}
+
+// Remove handles removing a participant; participants may also leave on their own
+func (h *ParticipantHandler) Remove(c *fiber.Ctx) error {
+	chatID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+	userID, err := ParseUint64Param(c, "userId")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	action := authz.ActionManage
+	if uint(userID) == GetUserID(c) {
+		action = authz.ActionRead
+	}
+	if _, err := h.authorizer.ChatInfo(ctx, GetUserID(c), chatID, action); err != nil {
+		return err
+	}
+
+	if err := h.chatRepo.RemoveParticipant(ctx, chatID, uint(userID)); err != nil {
+		return err
+	}
+	h.wsManager.RefreshRoom(uint(chatID))
+
+	event := auditEvent(c, audit.ActionChatParticipantRemove, audit.TargetChat, auditID(chatID))
+	event.Details = models.AuditDetails{"userId": userID}
+	h.auditLog.Record(event)
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"success": true,
+	})
+}
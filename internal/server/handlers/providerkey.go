@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/hra42/7x42/internal/audit"
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/providerkeys"
+	"github.com/hra42/7x42/internal/server/responses"
+)
+
+// ProviderKeyHandler handles users' own provider API keys
+type ProviderKeyHandler struct {
+	providerKeys *providerkeys.Service
+	auditLog     *audit.Logger
+}
+
+// NewProviderKeyHandler creates a new provider key handler
+func NewProviderKeyHandler(providerKeys *providerkeys.Service, auditLog *audit.Logger) *ProviderKeyHandler {
+	return &ProviderKeyHandler{
+		providerKeys: providerKeys,
+		auditLog:     auditLog,
+	}
+}
+
+// List handles the list provider keys endpoint; secrets are never returned
+func (h *ProviderKeyHandler) List(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	keys, err := h.providerKeys.List(ctx, GetUserID(c))
+	if err != nil {
+		return err
+	}
+
+	result := make([]fiber.Map, len(keys))
+	for i := range keys {
+		result[i] = keys[i].ToMap()
+	}
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"keys":      result,
+		"providers": models.Providers,
+		"enabled":   h.providerKeys.Enabled(),
+	})
+}
+
+// Set handles storing the user's key for a provider, replacing any previous one
+func (h *ProviderKeyHandler) Set(c *fiber.Ctx) error {
+	type request struct {
+		APIKey string `json:"apiKey"`
+	}
+
+	var req request
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	provider := c.Params("provider")
+	key, err := h.providerKeys.Set(ctx, GetUserID(c), provider, req.APIKey)
+	if err != nil {
+		return providerKeyError(err)
+	}
+
+	event := auditEvent(c, audit.ActionProviderKeySet, audit.TargetProvider, providerKeyID(c, provider))
+	event.Details = models.AuditDetails{"hint": key.Hint}
+	h.auditLog.Record(event)
+
+	return responses.JSON(c, fiber.StatusOK, key.ToMap())
+}
+
+// Delete handles removing the user's key for a provider
+func (h *ProviderKeyHandler) Delete(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	provider := c.Params("provider")
+	if err := h.providerKeys.Delete(ctx, GetUserID(c), provider); err != nil {
+		return err
+	}
+	h.auditLog.Record(auditEvent(c, audit.ActionProviderKeyDelete, audit.TargetProvider, providerKeyID(c, provider)))
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"success": true,
+	})
+}
+
+// providerKeyID formats the current user's key for a provider as an audit target ID
+func providerKeyID(c *fiber.Ctx, provider string) string {
+	return auditID(uint64(GetUserID(c))) + ":" + provider
+}
+
+// providerKeyError maps provider key errors to HTTP errors
+func providerKeyError(err error) error {
+	switch {
+	case errors.Is(err, providerkeys.ErrDisabled):
+		return fiber.NewError(fiber.StatusServiceUnavailable, err.Error())
+	case errors.Is(err, providerkeys.ErrInvalidProvider), errors.Is(err, providerkeys.ErrInvalidKey):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	}
+	return err
+}
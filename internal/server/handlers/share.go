@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/hra42/7x42/internal/audit"
+	"github.com/hra42/7x42/internal/auth"
+	"github.com/hra42/7x42/internal/authz"
+	"github.com/hra42/7x42/internal/markdown"
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/repository"
+	"github.com/hra42/7x42/internal/server/responses"
+)
+
+// ShareHandler handles public share links for chats
+type ShareHandler struct {
+	chatRepo   *repository.ChatRepository
+	shareRepo  *repository.ShareRepository
+	authorizer *authz.Authorizer
+	auditLog   *audit.Logger
+}
+
+// NewShareHandler creates a new share handler
+func NewShareHandler(chatRepo *repository.ChatRepository, shareRepo *repository.ShareRepository, authorizer *authz.Authorizer, auditLog *audit.Logger) *ShareHandler {
+	return &ShareHandler{
+		chatRepo:   chatRepo,
+		shareRepo:  shareRepo,
+		authorizer: authorizer,
+		auditLog:   auditLog,
+	}
+}
+
+// Create handles the create share endpoint.
+// With a messageId the conversation up to that message is frozen into the share;
+// without one the share follows the chat. The link is only returned in this response.
+func (h *ShareHandler) Create(c *fiber.Ctx) error {
+	chatID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+
+	type request struct {
+		MessageID *uint      `json:"messageId"`
+		ExpiresAt *time.Time `json:"expiresAt"`
+		// ExpiresIn is an alternative to ExpiresAt, e.g. "168h"
+		ExpiresIn string `json:"expiresIn"`
+	}
+
+	var req request
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+	}
+
+	expiresAt := req.ExpiresAt
+	if req.ExpiresIn != "" {
+		d, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid expiresIn duration")
+		}
+		t := time.Now().Add(d)
+		expiresAt = &t
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return fiber.NewError(fiber.StatusBadRequest, "Expiry must be in the future")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	share := &models.ChatShare{
+		ChatID:    chatID,
+		UserID:    GetUserID(c),
+		ExpiresAt: expiresAt,
+	}
+
+	if req.MessageID != nil {
+		chat, err := h.authorizer.Chat(ctx, GetUserID(c), chatID, authz.ActionShare)
+		if err != nil {
+			return err
+		}
+
+		end := -1
+		for i := range chat.Messages {
+			if chat.Messages[i].ID == *req.MessageID {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "Message is not part of this chat")
+		}
+
+		share.MessageID = req.MessageID
+		share.Snapshot = &models.ShareSnapshot{
+			Title:    chat.Title,
+			Messages: models.NewSharedMessages(chat.Messages[:end+1]),
+		}
+	} else if _, err := h.authorizer.ChatInfo(ctx, GetUserID(c), chatID, authz.ActionShare); err != nil {
+		return err
+	}
+
+	token, err := auth.NewToken()
+	if err != nil {
+		return err
+	}
+	share.TokenHash = auth.HashToken(token)
+
+	if err := h.shareRepo.CreateShare(ctx, share); err != nil {
+		return err
+	}
+
+	event := auditEvent(c, audit.ActionChatShare, audit.TargetChat, auditID(chatID))
+	event.Details = models.AuditDetails{
+		"shareId":   share.ID,
+		"messageId": share.MessageID,
+		"expiresAt": share.ExpiresAt,
+	}
+	h.auditLog.Record(event)
+
+	result := share.ToMap()
+	result["token"] = token
+	result["url"] = c.BaseURL() + "/share/" + token
+
+	return responses.JSON(c, fiber.StatusCreated, result)
+}
+
+// List handles the list shares endpoint
+func (h *ShareHandler) List(c *fiber.Ctx) error {
+	chatID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := h.authorizer.ChatInfo(ctx, GetUserID(c), chatID, authz.ActionShare); err != nil {
+		return err
+	}
+
+	shares, err := h.shareRepo.ListChatShares(ctx, chatID)
+	if err != nil {
+		return err
+	}
+
+	result := make([]fiber.Map, len(shares))
+	for i := range shares {
+		result[i] = shares[i].ToMap()
+	}
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"shares": result,
+	})
+}
+
+// Revoke handles the revoke share endpoint; the link stops working immediately
+func (h *ShareHandler) Revoke(c *fiber.Ctx) error {
+	chatID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+	shareID, err := ParseUint64Param(c, "shareId")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := h.authorizer.ChatInfo(ctx, GetUserID(c), chatID, authz.ActionShare); err != nil {
+		return err
+	}
+
+	if err := h.shareRepo.RevokeShare(ctx, chatID, uint(shareID)); err != nil {
+		return err
+	}
+
+	event := auditEvent(c, audit.ActionChatShareRevoke, audit.TargetChat, auditID(chatID))
+	event.Details = models.AuditDetails{"shareId": shareID}
+	h.auditLog.Record(event)
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"success": true,
+	})
+}
+
+// Page renders a shared chat for anyone with the link.
+// Unknown, revoked and expired links and deleted chats all look the same.
+func (h *ShareHandler) Page(c *fiber.Ctx) error {
+	// The token is in the URL; keep it out of referrers, caches and search indexes
+	c.Set(fiber.HeaderReferrerPolicy, "no-referrer")
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set("X-Robots-Tag", "noindex, nofollow")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	snapshot, share, err := h.sharedChat(ctx, c.Params("token"))
+	if err != nil {
+		if !repository.IsNotFound(err) {
+			return err
+		}
+		return c.Status(fiber.StatusNotFound).Render("share", fiber.Map{
+			"title": "7x42 - Shared chat",
+		})
+	}
+
+	if err := h.shareRepo.RecordShareView(ctx, share.ID, time.Now()); err != nil {
+		log.Printf("Failed to record view of share %d: %v", share.ID, err)
+	}
+
+	messages := make([]fiber.Map, len(snapshot.Messages))
+	for i, msg := range snapshot.Messages {
+		messages[i] = fiber.Map{
+			"role":      msg.Role,
+			"model":     msg.Model,
+			"timestamp": msg.Timestamp,
+			"html":      markdown.Render(msg.Content),
+		}
+	}
+
+	return c.Render("share", fiber.Map{
+		"title":     "7x42 - Shared chat",
+		"shared":    true,
+		"chatTitle": snapshot.Title,
+		"messages":  messages,
+		"sharedAt":  share.CreatedAt,
+		"snapshot":  share.Snapshot != nil,
+	})
+}
+
+// sharedChat resolves a share token to the conversation it shows
+func (h *ShareHandler) sharedChat(ctx context.Context, token string) (*models.ShareSnapshot, *models.ChatShare, error) {
+	notFound := repository.NewError("get", "chat_share", repository.ErrNotFound)
+	if token == "" {
+		return nil, nil, notFound
+	}
+
+	share, err := h.shareRepo.GetShareByTokenHash(ctx, auth.HashToken(token))
+	if err != nil {
+		return nil, nil, err
+	}
+	if !share.IsActive() {
+		return nil, nil, notFound
+	}
+
+	// Deleting the chat takes its shares down, snapshots included
+	if share.Snapshot != nil {
+		if _, err := h.chatRepo.GetChatInfo(ctx, share.ChatID); err != nil {
+			return nil, nil, err
+		}
+		return share.Snapshot, share, nil
+	}
+
+	chat, err := h.chatRepo.GetChat(ctx, share.ChatID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &models.ShareSnapshot{
+		Title:    chat.Title,
+		Messages: models.NewSharedMessages(chat.Messages),
+	}, share, nil
+}
@@ -0,0 +1,50 @@
+package handlers
+
+import (
+	"context"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/hra42/7x42/internal/repository"
+	"github.com/hra42/7x42/internal/server/responses"
+)
+
+// StatsHandler handles aggregate statistics requests
+type StatsHandler struct {
+	statsRepo *repository.StatsRepository
+}
+
+// NewStatsHandler creates a new stats handler
+func NewStatsHandler(statsRepo *repository.StatsRepository) *StatsHandler {
+	return &StatsHandler{
+		statsRepo: statsRepo,
+	}
+}
+
+// Latency handles the per-model latency percentiles endpoint, over the replies in the chats
+// the user can read. The window defaults to 7 days and can be changed with ?window=24h.
+func (h *StatsHandler) Latency(c *fiber.Ctx) error {
+	window := 7 * 24 * time.Hour
+	if raw := c.Query("window"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed <= 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid window parameter")
+		}
+		window = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	since := time.Now().Add(-window)
+	stats, err := h.statsRepo.LatencyByModel(ctx, GetUserID(c), since)
+	if err != nil {
+		return err
+	}
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"models": stats,
+		"since":  since,
+		"unit":   "ms",
+	})
+}
@@ -1,7 +1,12 @@
 package handlers
 
 import (
+	"net"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
 	fiberws "github.com/gofiber/websocket/v2"
+	"github.com/hra42/7x42/internal/models"
 	"github.com/hra42/7x42/internal/websocket"
 )
 
@@ -17,8 +22,51 @@ func NewWebSocketHandler(manager *websocket.Manager) *WebSocketHandler {
 	}
 }
 
-// HandleConnection handles a WebSocket connection
+// HandleConnection handles a WebSocket connection.
+// The user and their session or API key were authenticated by the upgrade middleware.
 func (h *WebSocketHandler) HandleConnection(c *fiberws.Conn) {
-	userId := c.Params("userId")
-	h.manager.HandleConnection(c, userId)
+	user, _ := c.Locals(LocalUser).(*models.User)
+	session, _ := c.Locals(LocalSession).(*models.Session)
+	key, _ := c.Locals(LocalAPIKey).(*models.APIKey)
+
+	if user == nil || (session == nil && key == nil) {
+		_ = c.Close()
+		return
+	}
+
+	identity := websocket.Identity{
+		UserID:    user.ID,
+		Name:      user.Name,
+		ReadOnly:  !user.CanWrite(),
+		Role:      user.Role,
+		Email:     user.Email,
+		IP:        truncate(remoteIP(c), 64),
+		UserAgent: truncate(c.Headers(fiber.HeaderUserAgent), 512),
+	}
+	if session != nil {
+		identity.SessionID = session.ID
+	} else {
+		identity.APIKeyID = key.ID
+		identity.Scopes = key.ScopeList()
+	}
+
+	// A reconnecting client continues its session with ?resume=<token>&lastSeq=<n>
+	resume := websocket.Resume{Token: c.Query("resume")}
+	if lastSeq, err := strconv.ParseUint(c.Query("lastSeq"), 10, 64); err == nil {
+		resume.LastSeq = lastSeq
+	}
+	h.manager.HandleConnection(c, identity, resume)
+}
+
+// remoteIP returns the address the connection comes from, as resolved from trusted proxy
+// headers by the upgrade middleware
+func remoteIP(c *fiberws.Conn) string {
+	if ip, ok := c.Locals(LocalIP).(string); ok && ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(c.RemoteAddr().String())
+	if err != nil {
+		return c.RemoteAddr().String()
+	}
+	return host
 }
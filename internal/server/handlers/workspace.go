@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/hra42/7x42/internal/audit"
+	"github.com/hra42/7x42/internal/authz"
+	"github.com/hra42/7x42/internal/credits"
+	"github.com/hra42/7x42/internal/events"
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/server/responses"
+	"github.com/hra42/7x42/internal/workspaces"
+)
+
+// WorkspaceHandler handles workspace and membership requests
+type WorkspaceHandler struct {
+	workspaces *workspaces.Service
+	credits    *credits.Service
+	authorizer *authz.Authorizer
+	auditLog   *audit.Logger
+	events     *events.Bus
+}
+
+// NewWorkspaceHandler creates a new workspace handler
+func NewWorkspaceHandler(workspaceService *workspaces.Service, creditService *credits.Service, authorizer *authz.Authorizer, auditLog *audit.Logger, eventBus *events.Bus) *WorkspaceHandler {
+	return &WorkspaceHandler{
+		workspaces: workspaceService,
+		credits:    creditService,
+		authorizer: authorizer,
+		auditLog:   auditLog,
+		events:     eventBus,
+	}
+}
+
+// workspaceRequest is the body of the create and update workspace endpoints
+type workspaceRequest struct {
+	Name         string `json:"name"`
+	SystemPrompt string `json:"systemPrompt"`
+	DefaultModel string `json:"defaultModel"`
+}
+
+// settings converts the request to workspace settings
+func (r *workspaceRequest) settings() workspaces.Settings {
+	return workspaces.Settings{
+		Name:         r.Name,
+		SystemPrompt: r.SystemPrompt,
+		DefaultModel: r.DefaultModel,
+	}
+}
+
+// List handles the list workspaces endpoint
+func (h *WorkspaceHandler) List(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	list, err := h.workspaces.List(ctx, GetUserID(c))
+	if err != nil {
+		return err
+	}
+
+	result := make([]fiber.Map, len(list))
+	for i := range list {
+		result[i] = list[i].ToMap()
+	}
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"workspaces": result,
+	})
+}
+
+// Create handles the create workspace endpoint; the creator becomes its owner
+func (h *WorkspaceHandler) Create(c *fiber.Ctx) error {
+	var req workspaceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	workspace, err := h.workspaces.Create(ctx, GetUserID(c), req.settings())
+	if err != nil {
+		return workspaceError(err)
+	}
+
+	event := auditEvent(c, audit.ActionWorkspaceCreate, audit.TargetWorkspace, auditID(uint64(workspace.ID)))
+	event.Details = models.AuditDetails{"name": workspace.Name}
+	h.auditLog.Record(event)
+
+	result := workspace.ToMap()
+	result["role"] = models.WorkspaceRoleOwner
+
+	return responses.JSON(c, fiber.StatusCreated, result)
+}
+
+// Get handles the get workspace endpoint with its members and spending
+func (h *WorkspaceHandler) Get(c *fiber.Ctx) error {
+	workspaceID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	workspace, member, err := h.authorizer.Workspace(ctx, GetUserID(c), uint(workspaceID), authz.ActionRead)
+	if err != nil {
+		return err
+	}
+
+	members, err := h.workspaces.Members(ctx, workspace.ID)
+	if err != nil {
+		return err
+	}
+	memberList := make([]fiber.Map, len(members))
+	for i := range members {
+		memberList[i] = members[i].ToMap()
+	}
+
+	usage, err := h.credits.WorkspaceUsage(ctx, workspace.ID)
+	if err != nil {
+		return err
+	}
+	periods := make([]map[string]interface{}, len(usage))
+	for i := range usage {
+		periods[i] = usage[i].ToMap()
+	}
+
+	result := workspace.ToMap()
+	result["role"] = member.Role
+	result["members"] = memberList
+	result["periods"] = periods
+
+	return responses.JSON(c, fiber.StatusOK, result)
+}
+
+// Update handles the update workspace endpoint
+func (h *WorkspaceHandler) Update(c *fiber.Ctx) error {
+	workspaceID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+
+	var req workspaceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	workspace, _, err := h.authorizer.Workspace(ctx, GetUserID(c), uint(workspaceID), authz.ActionManage)
+	if err != nil {
+		return err
+	}
+
+	if err := h.workspaces.Update(ctx, workspace, req.settings()); err != nil {
+		return workspaceError(err)
+	}
+	h.events.Publish(events.WorkspaceUpdated, workspace.ToMap(), events.WorkspaceTopic(workspace.ID))
+
+	event := auditEvent(c, audit.ActionWorkspaceUpdate, audit.TargetWorkspace, auditID(workspaceID))
+	event.Details = models.AuditDetails{
+		"name":         workspace.Name,
+		"defaultModel": workspace.DefaultModel,
+	}
+	h.auditLog.Record(event)
+
+	return responses.JSON(c, fiber.StatusOK, workspace.ToMap())
+}
+
+// Delete handles the delete workspace endpoint; its chats are deleted with it
+func (h *WorkspaceHandler) Delete(c *fiber.Ctx) error {
+	workspaceID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	workspace, _, err := h.authorizer.Workspace(ctx, GetUserID(c), uint(workspaceID), authz.ActionDelete)
+	if err != nil {
+		return err
+	}
+
+	if err := h.workspaces.Delete(ctx, workspace.ID); err != nil {
+		return err
+	}
+	h.events.Publish(events.WorkspaceDeleted, workspace.ToMap(), events.WorkspaceTopic(workspace.ID))
+
+	event := auditEvent(c, audit.ActionWorkspaceDelete, audit.TargetWorkspace, auditID(workspaceID))
+	event.Details = models.AuditDetails{"name": workspace.Name}
+	h.auditLog.Record(event)
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"success": true,
+	})
+}
+
+// AddMember handles adding a user to a workspace by email
+func (h *WorkspaceHandler) AddMember(c *fiber.Ctx) error {
+	workspaceID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+
+	type request struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	var req request
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+	if req.Role == "" {
+		req.Role = models.WorkspaceRoleMember
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, actor, err := h.authorizer.Workspace(ctx, GetUserID(c), uint(workspaceID), authz.ActionManage)
+	if err != nil {
+		return err
+	}
+
+	member, err := h.workspaces.AddMember(ctx, actor, req.Email, req.Role)
+	if err != nil {
+		return workspaceError(err)
+	}
+	h.publishMember(events.WorkspaceMemberAdded, member.WorkspaceID, member.UserID, member.ToMap())
+
+	event := auditEvent(c, audit.ActionWorkspaceMemberAdd, audit.TargetWorkspace, auditID(workspaceID))
+	event.Details = models.AuditDetails{"userId": member.UserID, "role": member.Role}
+	h.auditLog.Record(event)
+
+	return responses.JSON(c, fiber.StatusCreated, member.ToMap())
+}
+
+// UpdateMember handles changing a member's role
+func (h *WorkspaceHandler) UpdateMember(c *fiber.Ctx) error {
+	workspaceID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+	userID, err := ParseUint64Param(c, "userId")
+	if err != nil {
+		return err
+	}
+
+	type request struct {
+		Role string `json:"role"`
+	}
+
+	var req request
+	if err := c.BodyParser(&req); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, actor, err := h.authorizer.Workspace(ctx, GetUserID(c), uint(workspaceID), authz.ActionManage)
+	if err != nil {
+		return err
+	}
+
+	member, err := h.workspaces.UpdateMember(ctx, actor, uint(userID), req.Role)
+	if err != nil {
+		return workspaceError(err)
+	}
+	h.publishMember(events.WorkspaceMemberUpdated, member.WorkspaceID, member.UserID, member.ToMap())
+
+	event := auditEvent(c, audit.ActionWorkspaceMemberUpdate, audit.TargetWorkspace, auditID(workspaceID))
+	event.Details = models.AuditDetails{"userId": member.UserID, "role": member.Role}
+	h.auditLog.Record(event)
+
+	return responses.JSON(c, fiber.StatusOK, member.ToMap())
+}
+
+// RemoveMember handles removing a member; members may leave on their own
+func (h *WorkspaceHandler) RemoveMember(c *fiber.Ctx) error {
+	workspaceID, err := ParseUint64Param(c, "id")
+	if err != nil {
+		return err
+	}
+	userID, err := ParseUint64Param(c, "userId")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, actor, err := h.authorizer.Workspace(ctx, GetUserID(c), uint(workspaceID), authz.ActionRead)
+	if err != nil {
+		return err
+	}
+
+	if err := h.workspaces.RemoveMember(ctx, actor, uint(userID)); err != nil {
+		return workspaceError(err)
+	}
+	h.publishMember(events.WorkspaceMemberRemoved, actor.WorkspaceID, uint(userID), fiber.Map{
+		"workspaceId": actor.WorkspaceID,
+		"userId":      userID,
+	})
+
+	event := auditEvent(c, audit.ActionWorkspaceMemberRemove, audit.TargetWorkspace, auditID(workspaceID))
+	event.Details = models.AuditDetails{"userId": userID}
+	h.auditLog.Record(event)
+
+	return responses.JSON(c, fiber.StatusOK, fiber.Map{
+		"success": true,
+	})
+}
+
+// publishMember publishes a membership change to the workspace and to the member themselves
+func (h *WorkspaceHandler) publishMember(name string, workspaceID, userID uint, data interface{}) {
+	h.events.Publish(name, data, events.WorkspaceTopic(workspaceID), events.UserTopic(userID))
+}
+
+// workspaceError maps workspace validation and membership errors to HTTP errors
+func workspaceError(err error) error {
+	switch {
+	case errors.Is(err, workspaces.ErrInvalidName), errors.Is(err, workspaces.ErrInvalidRole):
+		return fiber.NewError(fiber.StatusBadRequest, err.Error())
+	case errors.Is(err, workspaces.ErrLastOwner), errors.Is(err, workspaces.ErrAlreadyMember):
+		return fiber.NewError(fiber.StatusConflict, err.Error())
+	}
+	return err
+}
@@ -1,14 +1,22 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/fiber/v2/middleware/recover"
 	fiberws "github.com/gofiber/websocket/v2"
-	"log"
-	"os"
-	"time"
+	"github.com/hra42/7x42/internal/auth"
+	"github.com/hra42/7x42/internal/ratelimit"
+	"github.com/hra42/7x42/internal/server/handlers"
 )
 
 // setupMiddleware configures the middleware for the server
@@ -50,13 +58,237 @@ func (s *Server) setupMiddleware() {
 	})
 }
 
-// WebSocketMiddleware is a middleware that upgrades the connection to WebSocket
-func WebSocketMiddleware() fiber.Handler {
+// WebSocketMiddleware authenticates WebSocket upgrade requests.
+// Browsers authenticate with the session cookie; other clients can pass a session token
+// or API key as bearer token, or a short-lived ticket from /api/v1/auth/ws-ticket
+// in the ticket query parameter. API keys need the completions scope.
+func WebSocketMiddleware(authService *auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !fiberws.IsWebSocketUpgrade(c) {
+			return fiber.ErrUpgradeRequired
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if ticket := c.Query("ticket"); ticket != "" {
+			user, session, err := authService.AuthenticateTicket(ctx, ticket)
+			if err != nil {
+				return authFailure(err)
+			}
+			c.Locals(handlers.LocalUser, user)
+			c.Locals(handlers.LocalSession, session)
+		} else {
+			// Cookies are sent on cross-site upgrades too, so the origin must match
+			if handlers.BearerToken(c) == "" && !sameOrigin(c) {
+				return fiber.NewError(fiber.StatusForbidden, "Cross-origin WebSocket requests are not allowed")
+			}
+			if err := authenticateToken(ctx, c, authService); err != nil {
+				return err
+			}
+			if key := handlers.CurrentAPIKey(c); key != nil && !key.HasScope(auth.ScopeCompletions) {
+				return fiber.NewError(fiber.StatusForbidden, auth.ErrMissingScope.Error())
+			}
+		}
+
+		if err := authService.CheckSecondFactor(ctx, handlers.CurrentUser(c), handlers.CurrentSession(c)); err != nil {
+			return secondFactorFailure(err)
+		}
+
+		// The connection only knows its peer, which is the proxy when there is one
+		c.Locals(handlers.LocalIP, c.IP())
+		c.Locals("allowed", true)
+		return c.Next()
+	}
+}
+
+// sameOrigin reports whether the request's Origin header, if any, matches its host
+func sameOrigin(c *fiber.Ctx) bool {
+	origin := c.Get(fiber.HeaderOrigin)
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return u.Host == c.Hostname()
+}
+
+// AuthMiddleware authenticates the request from its session token or API key and stores the user in locals.
+// Requests without valid credentials are rejected with 401.
+func AuthMiddleware(authService *auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := authenticateToken(ctx, c, authService); err != nil {
+			return err
+		}
+		return c.Next()
+	}
+}
+
+// PageAuthMiddleware is like AuthMiddleware but redirects browsers to the login page
+func PageAuthMiddleware(authService *auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := authenticateToken(ctx, c, authService); err != nil {
+			var e *fiber.Error
+			if errors.As(err, &e) && e.Code == fiber.StatusUnauthorized {
+				return c.Redirect("/login?next=" + url.QueryEscape(c.OriginalURL()))
+			}
+			return err
+		}
+		return c.Next()
+	}
+}
+
+// RequireSecondFactor rejects requests from users whose role requires two-factor authentication
+// until they have passed it. It runs after AuthMiddleware; enrollment endpoints leave it out.
+func RequireSecondFactor(authService *auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := authService.CheckSecondFactor(ctx, handlers.CurrentUser(c), handlers.CurrentSession(c)); err != nil {
+			return secondFactorFailure(err)
+		}
+		return c.Next()
+	}
+}
+
+// RequirePageSecondFactor is like RequireSecondFactor but sends browsers to the settings page to enroll
+func RequirePageSecondFactor(authService *auth.Service) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if err := authService.CheckSecondFactor(ctx, handlers.CurrentUser(c), handlers.CurrentSession(c)); err != nil {
+			if errors.Is(err, auth.ErrSecondFactorRequired) {
+				return c.Redirect("/settings")
+			}
+			return err
+		}
+		return c.Next()
+	}
+}
+
+// RateLimitIP limits requests per client address, before authentication,
+// so unauthenticated endpoints such as login cannot be hammered either
+func RateLimitIP(limiter *ratelimit.Limiter) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if ok, retryAfter := limiter.AllowIP(c.IP()); !ok {
+			return tooManyRequests(c, retryAfter)
+		}
+		return c.Next()
+	}
+}
+
+// RateLimitUser takes n tokens of a class from the authenticated user's limit.
+// It runs after AuthMiddleware.
+func RateLimitUser(limiter *ratelimit.Limiter, class string, n int) fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		if fiberws.IsWebSocketUpgrade(c) {
-			c.Locals("allowed", true)
+		user := handlers.CurrentUser(c)
+		if user == nil {
 			return c.Next()
 		}
-		return fiber.ErrUpgradeRequired
+		if ok, retryAfter := limiter.AllowUser(user.ID, user.Role, class, n); !ok {
+			return tooManyRequests(c, retryAfter)
+		}
+		return c.Next()
+	}
+}
+
+// tooManyRequests rejects a rate-limited request with 429 and a Retry-After header
+func tooManyRequests(c *fiber.Ctx, retryAfter time.Duration) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(ratelimit.RetryAfterSeconds(retryAfter)))
+	return fiber.NewError(fiber.StatusTooManyRequests, "Rate limit exceeded; retry later")
+}
+
+// RequireScope rejects requests authenticated with an API key that lacks the scope,
+// and requests from read-only users for anything but reading.
+// Session-authenticated requests otherwise act with the user's full rights.
+func RequireScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !hasScope(c, scope) {
+			return fiber.NewError(fiber.StatusForbidden, auth.ErrMissingScope.Error()+": "+scope)
+		}
+		return c.Next()
+	}
+}
+
+// RequireRole rejects requests from users without the role
+func RequireRole(role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if user := handlers.CurrentUser(c); user == nil || user.Role != role {
+			return fiber.NewError(fiber.StatusForbidden, "Insufficient permissions")
+		}
+		return c.Next()
+	}
+}
+
+// RequireSession rejects requests that were not authenticated with a login session,
+// e.g. so API keys cannot be used to mint or revoke other keys
+func RequireSession() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if handlers.CurrentSession(c) == nil {
+			return fiber.NewError(fiber.StatusForbidden, "This endpoint requires a login session")
+		}
+		return c.Next()
+	}
+}
+
+// hasScope reports whether the request's user and credentials grant the scope.
+// Read-only users only have the chat:read scope whatever their API key says.
+func hasScope(c *fiber.Ctx, scope string) bool {
+	if user := handlers.CurrentUser(c); user != nil && !user.CanWrite() && scope != auth.ScopeChatRead {
+		return false
+	}
+	if key := handlers.CurrentAPIKey(c); key != nil {
+		return key.HasScope(scope)
+	}
+	return true
+}
+
+// authenticateToken resolves the request's session token or API key and stores the credentials in locals
+func authenticateToken(ctx context.Context, c *fiber.Ctx, authService *auth.Service) error {
+	token := handlers.SessionToken(c)
+
+	if auth.IsAPIKey(token) {
+		user, key, err := authService.AuthenticateAPIKey(ctx, token)
+		if err != nil {
+			return authFailure(err)
+		}
+		c.Locals(handlers.LocalUser, user)
+		c.Locals(handlers.LocalAPIKey, key)
+		return nil
+	}
+
+	user, session, err := authService.Authenticate(ctx, token)
+	if err != nil {
+		return authFailure(err)
+	}
+	c.Locals(handlers.LocalUser, user)
+	c.Locals(handlers.LocalSession, session)
+	return nil
+}
+
+// secondFactorFailure maps a missing second factor to 403 and passes other errors through
+func secondFactorFailure(err error) error {
+	if errors.Is(err, auth.ErrSecondFactorRequired) {
+		return fiber.NewError(fiber.StatusForbidden, err.Error())
+	}
+	return err
+}
+
+// authFailure maps credential errors to 401 and passes other errors through
+func authFailure(err error) error {
+	if errors.Is(err, auth.ErrInvalidSession) || errors.Is(err, auth.ErrInvalidTicket) ||
+		errors.Is(err, auth.ErrInvalidAPIKey) || errors.Is(err, auth.ErrAccountDisabled) {
+		return fiber.NewError(fiber.StatusUnauthorized, "Authentication required")
 	}
+	return err
 }
@@ -2,8 +2,15 @@ package server
 
 import (
 	fiberws "github.com/gofiber/websocket/v2"
+	"github.com/hra42/7x42/internal/audit"
+	"github.com/hra42/7x42/internal/auth"
+	"github.com/hra42/7x42/internal/authz"
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/ratelimit"
 	"github.com/hra42/7x42/internal/repository"
 	"github.com/hra42/7x42/internal/server/handlers"
+	"github.com/hra42/7x42/internal/workspaces"
+	"github.com/hra42/7x42/pkg/protocol"
 )
 
 // setupRoutes configures the routes for the server
@@ -11,36 +18,154 @@ func (s *Server) setupRoutes() {
 	// Create repositories
 	chatRepo := repository.NewChatRepository(s.db)
 	messageRepo := repository.NewMessageRepository(s.db)
+	shareRepo := repository.NewShareRepository(s.db)
+	statsRepo := repository.NewStatsRepository(s.db)
+	userRepo := repository.NewUserRepository(s.db)
+	workspaceRepo := repository.NewWorkspaceRepository(s.db)
+	authorizer := authz.NewAuthorizer(chatRepo, messageRepo, workspaceRepo)
+	auditLog := audit.NewLogger(s.db)
 
 	// Create handlers
 	healthHandler := handlers.NewHealthHandler(s.db)
-	chatHandler := handlers.NewChatHandler(chatRepo, messageRepo)
-	pageHandler := handlers.NewPageHandler()
+	chatHandler := handlers.NewChatHandler(chatRepo, messageRepo, authorizer, auditLog, s.events)
+	shareHandler := handlers.NewShareHandler(chatRepo, shareRepo, authorizer, auditLog)
+	participantHandler := handlers.NewParticipantHandler(chatRepo, userRepo, authorizer, s.wsManager, auditLog)
+	workspaceHandler := handlers.NewWorkspaceHandler(workspaces.NewService(s.db), s.credits, authorizer, auditLog, s.events)
+	statsHandler := handlers.NewStatsHandler(statsRepo)
+	authHandler := handlers.NewAuthHandler(s.authService, auditLog)
+	apiKeyHandler := handlers.NewAPIKeyHandler(s.authService, auditLog)
+	mfaHandler := handlers.NewMFAHandler(s.authService, auditLog)
+	adminHandler := handlers.NewAdminHandler(s.authService, userRepo, statsRepo, s.wsManager, auditLog)
+	auditHandler := handlers.NewAuditHandler(auditLog)
+	creditHandler := handlers.NewCreditHandler(s.credits, auditLog)
+	providerKeyHandler := handlers.NewProviderKeyHandler(s.providerKeys, auditLog)
+	completionHandler := handlers.NewCompletionHandler(s.aiService)
+	pageHandler := handlers.NewPageHandler(s.authService)
 	wsHandler := handlers.NewWebSocketHandler(s.wsManager)
 
 	// Health routes
 	s.app.Get("/health", healthHandler.Check)
 
+	// Authentication middleware. Routes without the second factor check are the ones
+	// a user needs to set up two-factor authentication when their role requires it.
+	requireAuth := AuthMiddleware(s.authService)
+	requirePageAuth := PageAuthMiddleware(s.authService)
+	secondFactor := RequireSecondFactor(s.authService)
+	pageSecondFactor := RequirePageSecondFactor(s.authService)
+
+	// Rate limits: every request counts against its client address, and authenticated
+	// requests against their user as well. Generations are limited separately.
+	limitIP := RateLimitIP(s.rateLimiter)
+	limitUser := RateLimitUser(s.rateLimiter, ratelimit.ClassRequests, 1)
+	limitGeneration := RateLimitUser(s.rateLimiter, ratelimit.ClassGenerations, 1)
+
 	// Page routes
-	s.app.Get("/", pageHandler.Index)
-	s.app.Get("/chat", pageHandler.Chat)
-	s.app.Get("/settings", pageHandler.Settings)
+	s.app.Get("/login", limitIP, pageHandler.Login)
+	s.app.Get("/auth/oidc/login", limitIP, authHandler.OIDCLogin)
+	s.app.Get("/auth/oidc/callback", limitIP, authHandler.OIDCCallback)
+	s.app.Get("/", requirePageAuth, pageSecondFactor, pageHandler.Index)
+	s.app.Get("/chat", requirePageAuth, pageSecondFactor, pageHandler.Chat)
+	s.app.Get("/settings", requirePageAuth, pageHandler.Settings)
+
+	// Shared chats are public; the token in the link is the only credential
+	s.app.Get("/share/:token", limitIP, shareHandler.Page)
 
 	// API routes
-	api := s.app.Group("/api")
+	api := s.app.Group("/api", limitIP)
 	v1 := api.Group("/v1")
 
+	// Auth routes
+	authRoutes := v1.Group("/auth")
+	authRoutes.Post("/register", authHandler.Register)
+	authRoutes.Post("/login", authHandler.Login)
+	authRoutes.Post("/login/verify", authHandler.VerifyLogin)
+	authRoutes.Post("/logout", requireAuth, limitUser, authHandler.Logout)
+	authRoutes.Get("/me", requireAuth, limitUser, authHandler.Me)
+	authRoutes.Post("/ws-ticket", requireAuth, limitUser, RequireSession(), secondFactor, authHandler.Ticket)
+
+	// Two-factor routes for the signed-in user
+	totp := authRoutes.Group("/totp", requireAuth, limitUser, RequireSession())
+	totp.Get("/", mfaHandler.Status)
+	totp.Post("/enroll", mfaHandler.Enroll)
+	totp.Post("/confirm", mfaHandler.Confirm)
+	totp.Post("/disable", secondFactor, mfaHandler.Disable)
+	totp.Post("/recovery-codes", secondFactor, mfaHandler.RecoveryCodes)
+
+	// API key routes; keys cannot manage keys
+	keys := v1.Group("/keys", requireAuth, limitUser, RequireSession(), secondFactor)
+	keys.Get("/", apiKeyHandler.List)
+	keys.Post("/", apiKeyHandler.Create)
+	keys.Delete("/:id", apiKeyHandler.Revoke)
+
+	// Provider key routes; like API keys they need a login session
+	providerKeys := v1.Group("/provider-keys", requireAuth, limitUser, RequireSession(), secondFactor)
+	providerKeys.Get("/", providerKeyHandler.List)
+	providerKeys.Put("/:provider", providerKeyHandler.Set)
+	providerKeys.Delete("/:provider", providerKeyHandler.Delete)
+
 	// Chat routes
-	chat := v1.Group("/chat")
-	chat.Get("/", chatHandler.List)
-	chat.Post("/", chatHandler.Create)
-	chat.Get("/:id", chatHandler.Get)
-	chat.Put("/:id", chatHandler.Update)
-	chat.Delete("/:id", chatHandler.Delete)
-	chat.Post("/:id/messages", chatHandler.SendMessage)
-	chat.Get("/:id/messages", chatHandler.ListMessages)
+	canRead := RequireScope(auth.ScopeChatRead)
+	canWrite := RequireScope(auth.ScopeChatWrite)
+	chat := v1.Group("/chat", requireAuth, limitUser, secondFactor)
+	chat.Get("/", canRead, chatHandler.List)
+	chat.Post("/", canWrite, chatHandler.Create)
+	chat.Get("/:id", canRead, chatHandler.Get)
+	chat.Put("/:id", canWrite, chatHandler.Update)
+	chat.Delete("/:id", canWrite, chatHandler.Delete)
+	chat.Post("/:id/messages", canWrite, chatHandler.SendMessage)
+	chat.Get("/:id/messages", canRead, chatHandler.ListMessages)
+	chat.Post("/:id/share", canWrite, shareHandler.Create)
+	chat.Get("/:id/share", canRead, shareHandler.List)
+	chat.Delete("/:id/share/:shareId", canWrite, shareHandler.Revoke)
+	chat.Get("/:id/participants", canRead, participantHandler.List)
+	chat.Post("/:id/participants", canWrite, participantHandler.Add)
+	chat.Put("/:id/participants/:userId", canWrite, participantHandler.Update)
+	chat.Delete("/:id/participants/:userId", canWrite, participantHandler.Remove)
+	chat.Post("/:id/completions", RequireScope(auth.ScopeCompletions), limitGeneration, completionHandler.Stream)
+
+	// Workspace routes
+	workspaceRoutes := v1.Group("/workspaces", requireAuth, limitUser, secondFactor)
+	workspaceRoutes.Get("/", canRead, workspaceHandler.List)
+	workspaceRoutes.Post("/", canWrite, workspaceHandler.Create)
+	workspaceRoutes.Get("/:id", canRead, workspaceHandler.Get)
+	workspaceRoutes.Put("/:id", canWrite, workspaceHandler.Update)
+	workspaceRoutes.Delete("/:id", canWrite, workspaceHandler.Delete)
+	workspaceRoutes.Post("/:id/members", canWrite, workspaceHandler.AddMember)
+	workspaceRoutes.Put("/:id/members/:userId", canWrite, workspaceHandler.UpdateMember)
+	workspaceRoutes.Delete("/:id/members/:userId", canWrite, workspaceHandler.RemoveMember)
+
+	// Stats routes
+	stats := v1.Group("/stats", requireAuth, limitUser, secondFactor, canRead)
+	stats.Get("/latency", statsHandler.Latency)
+
+	// Credit routes
+	creditRoutes := v1.Group("/credits", requireAuth, limitUser, secondFactor, canRead)
+	creditRoutes.Get("/", creditHandler.Summary)
+	creditRoutes.Get("/history", creditHandler.History)
+
+	// Admin routes
+	admin := v1.Group("/admin", requireAuth, limitUser, RequireSession(), secondFactor, RequireRole(models.UserRoleAdmin))
+	admin.Get("/users", adminHandler.ListUsers)
+	admin.Get("/users/:id", adminHandler.GetUser)
+	admin.Put("/users/:id", adminHandler.UpdateUser)
+	admin.Post("/users/:id/password", adminHandler.ResetPassword)
+	admin.Delete("/users/:id/sessions", adminHandler.RevokeSessions)
+	admin.Delete("/users/:id/totp", adminHandler.ResetTOTP)
+	admin.Get("/users/:id/credits", creditHandler.UserSummary)
+	admin.Get("/users/:id/credits/history", creditHandler.UserHistory)
+	admin.Post("/users/:id/credits", creditHandler.Grant)
+	admin.Get("/policies", adminHandler.ListPolicies)
+	admin.Put("/policies/:role", adminHandler.UpdatePolicy)
+	admin.Get("/budgets", creditHandler.ListBudgets)
+	admin.Put("/budgets/:scope/:subject/:period", creditHandler.UpdateBudget)
+	admin.Delete("/budgets/:scope/:subject/:period", creditHandler.DeleteBudget)
+	admin.Get("/audit", auditHandler.List)
+	admin.Get("/audit/export", auditHandler.Export)
 
 	// WebSocket routes
-	s.app.Use("/ws", WebSocketMiddleware())
-	s.app.Get("/ws/:userId", fiberws.New(wsHandler.HandleConnection))
+	s.app.Use("/ws", limitIP, WebSocketMiddleware(s.authService))
+	// Clients choose the protocol version with the subprotocol they offer
+	s.app.Get("/ws", fiberws.New(wsHandler.HandleConnection, fiberws.Config{
+		Subprotocols: protocol.Subprotocols(),
+	}))
 }
@@ -1,9 +1,22 @@
 package server
 
 import (
+	"context"
+	"log"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/template/html/v2"
 	"github.com/hra42/7x42/internal/ai"
+	"github.com/hra42/7x42/internal/audit"
+	"github.com/hra42/7x42/internal/auth"
+	"github.com/hra42/7x42/internal/authz"
+	"github.com/hra42/7x42/internal/cluster"
+	"github.com/hra42/7x42/internal/credits"
+	"github.com/hra42/7x42/internal/events"
+	"github.com/hra42/7x42/internal/providerkeys"
+	"github.com/hra42/7x42/internal/ratelimit"
+	"github.com/hra42/7x42/internal/repository"
 	"github.com/hra42/7x42/internal/server/handlers"
 	"github.com/hra42/7x42/internal/websocket"
 	"gorm.io/gorm"
@@ -11,16 +24,39 @@ import (
 
 // Server represents the HTTP server
 type Server struct {
-	app       *fiber.App
-	db        *gorm.DB
-	wsManager *websocket.Manager
-	aiService *ai.Service
+	app          *fiber.App
+	db           *gorm.DB
+	wsManager    *websocket.Manager
+	aiService    *ai.Service
+	authService  *auth.Service
+	rateLimiter  *ratelimit.Limiter
+	credits      *credits.Service
+	providerKeys *providerkeys.Service
+	events       *events.Bus
+	cluster      *cluster.Bus
 }
 
 // Config holds the server configuration
 type Config struct {
 	DB        *gorm.DB
 	AIService *ai.Service
+	Auth      auth.Config
+	RateLimit ratelimit.Config
+	// Credits should be the service the AI service charges; one is created if unset
+	Credits *credits.Service
+	// ProviderKeys should be the service the AI service resolves keys with; one without
+	// a master key, which stores nothing, is created if unset
+	ProviderKeys *providerkeys.Service
+	// Events should be the bus the AI service publishes to; one is created if unset
+	Events *events.Bus
+	// Cluster, if set, connects this instance to the others sharing the database.
+	// The server starts and stops it.
+	Cluster *cluster.Bus
+	// TrustedProxies are the addresses or CIDR ranges of the load balancers in front of the
+	// server. Requests from them are attributed to the client named in ProxyHeader,
+	// X-Forwarded-For by default; without any, the connection's peer is the client.
+	TrustedProxies []string
+	ProxyHeader    string
 }
 
 // New creates a new server instance
@@ -29,24 +65,99 @@ func New(config *Config) *Server {
 	viewEngine := html.New("./web/templates", ".html")
 
 	// Create Fiber app with custom error handler
-	app := fiber.New(fiber.Config{
+	fiberConfig := fiber.Config{
 		Views:        viewEngine,
 		ViewsLayout:  "base",
 		ErrorHandler: handlers.ErrorHandler,
-	})
+	}
+	if len(config.TrustedProxies) > 0 {
+		fiberConfig.EnableTrustedProxyCheck = true
+		fiberConfig.TrustedProxies = config.TrustedProxies
+		fiberConfig.ProxyHeader = config.ProxyHeader
+		if fiberConfig.ProxyHeader == "" {
+			fiberConfig.ProxyHeader = fiber.HeaderXForwardedFor
+		}
+		// Only a valid address is taken from the header
+		fiberConfig.EnableIPValidation = true
+	}
+	app := fiber.New(fiberConfig)
+
+	authService := auth.NewService(config.DB, config.Auth)
+	rateLimiter := ratelimit.NewLimiter(config.RateLimit)
+	creditService := config.Credits
+	if creditService == nil {
+		creditService = credits.NewService(config.DB, credits.Config{})
+	}
+	providerKeys := config.ProviderKeys
+	if providerKeys == nil {
+		providerKeys = providerkeys.NewService(config.DB, nil)
+	}
+	eventBus := config.Events
+	if eventBus == nil {
+		eventBus = events.NewBus()
+	}
+	if config.Cluster != nil {
+		config.Cluster.RelayEvents(eventBus)
+	}
 
 	// Create WebSocket manager
-	wsManager := websocket.NewManager(config.AIService)
+	chatRepo := repository.NewChatRepository(config.DB)
+	messageRepo := repository.NewMessageRepository(config.DB)
+	wsManager := websocket.NewManager(config.AIService, &websocket.ManagerConfig{
+		AuthService: authService,
+		RateLimiter: rateLimiter,
+		Authorizer:  authz.NewAuthorizer(chatRepo, messageRepo, repository.NewWorkspaceRepository(config.DB)),
+		ChatRepo:    chatRepo,
+		MessageRepo: messageRepo,
+		AuditLog:    audit.NewLogger(config.DB),
+		Events:      eventBus,
+		Cluster:     config.Cluster,
+	})
 	wsManager.Start()
 
+	// Handlers are registered, so messages from other instances can be received
+	if config.Cluster != nil {
+		config.Cluster.Start()
+	}
+
 	// Create server instance
 	s := &Server{
-		app:       app,
-		db:        config.DB,
-		wsManager: wsManager,
-		aiService: config.AIService,
+		app:          app,
+		db:           config.DB,
+		wsManager:    wsManager,
+		aiService:    config.AIService,
+		authService:  authService,
+		rateLimiter:  rateLimiter,
+		credits:      creditService,
+		providerKeys: providerKeys,
+		events:       eventBus,
+		cluster:      config.Cluster,
 	}
 
+	// Drop sessions that expired while the server was down
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if n, err := s.authService.CleanupExpiredSessions(ctx); err != nil {
+			log.Printf("Failed to clean up expired sessions: %v", err)
+		} else if n > 0 {
+			log.Printf("Removed %d expired sessions", n)
+		}
+	}()
+
+	// Re-encrypt provider keys stored under a master key that is no longer current
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+		rewrapped, failed, err := s.providerKeys.Rewrap(ctx)
+		if err != nil {
+			log.Printf("Failed to re-encrypt provider keys: %v", err)
+		}
+		if rewrapped > 0 || failed > 0 {
+			log.Printf("Re-encrypted %d provider keys with the current master key, %d failed", rewrapped, failed)
+		}
+	}()
+
 	// Setup middleware and routes
 	s.setupMiddleware()
 	s.setupRoutes()
@@ -64,6 +175,14 @@ func (s *Server) Shutdown() error {
 	// Stop the WebSocket manager
 	s.wsManager.Stop()
 
+	// Stop running generation jobs so they record their final state
+	s.aiService.Stop()
+
+	// Stop exchanging messages with other instances
+	if s.cluster != nil {
+		s.cluster.Stop()
+	}
+
 	// Shutdown the Fiber app
 	return s.app.Shutdown()
 }
@@ -0,0 +1,305 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/hra42/7x42/internal/audit"
+	"github.com/hra42/7x42/internal/auth"
+	"github.com/hra42/7x42/internal/authz"
+	"github.com/hra42/7x42/internal/events"
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/repository"
+	"github.com/hra42/7x42/pkg/protocol"
+)
+
+const (
+	// DefaultChatPageSize is how many chats list_chats returns per page by default
+	DefaultChatPageSize = 20
+
+	// DefaultMessagePageSize is how many messages get_chat and list_messages load by default
+	DefaultMessagePageSize = 50
+
+	// MaxPageSize bounds the chats or messages loaded by a single request
+	MaxPageSize = 100
+)
+
+// handleListChats lists the chats the user can see, like GET /api/v1/chat
+func (m *Manager) handleListChats(client *Client, content json.RawMessage) error {
+	if err := m.chatAccess(client, auth.ScopeChatRead); err != nil {
+		return err
+	}
+
+	var request protocol.ListChatsRequest
+	if err := decodeRequest(content, &request, "invalid_list_format"); err != nil {
+		return err
+	}
+
+	page, pageSize := request.Page, request.PageSize
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > MaxPageSize {
+		pageSize = DefaultChatPageSize
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	scope := repository.ChatScope{Personal: request.Personal}
+	if request.WorkspaceID != 0 {
+		if _, _, err := m.authorizer.Workspace(ctx, client.UserID, uint(request.WorkspaceID), authz.ActionRead); err != nil {
+			return NewError("list_chats", err, "workspace_not_found")
+		}
+		scope.WorkspaceID = uint(request.WorkspaceID)
+	}
+
+	chats, err := m.chatRepo.ListChats(ctx, client.UserID, scope, page, pageSize)
+	if err != nil {
+		return NewError("list_chats", err, "list_failed")
+	}
+	total, err := m.chatRepo.CountChats(ctx, client.UserID, scope)
+	if err != nil {
+		return NewError("list_chats", err, "list_failed")
+	}
+
+	result := make([]protocol.ChatInfo, len(chats))
+	for i := range chats {
+		result[i] = chatInfo(&chats[i])
+	}
+
+	return client.SendJSON(reply(client, protocol.TypeChats, protocol.Chats{
+		Chats: result,
+		Pagination: protocol.Pagination{
+			Page:       page,
+			PageSize:   pageSize,
+			Total:      total,
+			TotalPages: (total + int64(pageSize) - 1) / int64(pageSize),
+		},
+	}))
+}
+
+// handleGetChat loads a chat with its latest messages. Unlike GET /api/v1/chat/:id it
+// loads a page of them; older ones follow with list_messages.
+func (m *Manager) handleGetChat(client *Client, content json.RawMessage) error {
+	if err := m.chatAccess(client, auth.ScopeChatRead); err != nil {
+		return err
+	}
+
+	var request protocol.GetChatRequest
+	if err := decodeRequest(content, &request, "invalid_get_format"); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	chat, err := m.authorizer.ChatInfo(ctx, client.UserID, uint64(request.ChatID), authz.ActionRead)
+	if err != nil {
+		return NewError("get_chat", err, "chat_not_found")
+	}
+
+	messages, hasMore, err := m.messagesBefore(ctx, uint64(chat.ID), 0, request.Limit)
+	if err != nil {
+		return NewError("get_chat", err, "list_failed")
+	}
+
+	frame := reply(client, protocol.TypeChat, protocol.Chat{
+		ChatInfo: chatInfo(chat),
+		Messages: messages,
+		HasMore:  hasMore,
+	})
+	frame.ChatID = uint64(chat.ID)
+	return client.SendJSON(frame)
+}
+
+// handleListMessages loads the messages of a chat sent before a given one
+func (m *Manager) handleListMessages(client *Client, content json.RawMessage) error {
+	if err := m.chatAccess(client, auth.ScopeChatRead); err != nil {
+		return err
+	}
+
+	var request protocol.ListMessagesRequest
+	if err := decodeRequest(content, &request, "invalid_list_format"); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	chatID := uint64(request.ChatID)
+	if _, err := m.authorizer.ChatInfo(ctx, client.UserID, chatID, authz.ActionRead); err != nil {
+		return NewError("list_messages", err, "chat_not_found")
+	}
+
+	messages, hasMore, err := m.messagesBefore(ctx, chatID, request.Before, request.Limit)
+	if err != nil {
+		return NewError("list_messages", err, "list_failed")
+	}
+
+	frame := reply(client, protocol.TypeMessages, protocol.Messages{
+		ChatID:   chatID,
+		Messages: messages,
+		HasMore:  hasMore,
+	})
+	frame.ChatID = chatID
+	return client.SendJSON(frame)
+}
+
+// handleRenameChat changes the title of a chat, like PUT /api/v1/chat/:id
+func (m *Manager) handleRenameChat(client *Client, content json.RawMessage) error {
+	if err := m.chatAccess(client, auth.ScopeChatWrite); err != nil {
+		return err
+	}
+
+	var request protocol.RenameChatRequest
+	if err := decodeRequest(content, &request, "invalid_rename_format"); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	chat, err := m.authorizer.ChatInfo(ctx, client.UserID, uint64(request.ChatID), authz.ActionManage)
+	if err != nil {
+		return NewError("rename_chat", err, "chat_not_found")
+	}
+
+	chat.Title = request.Title
+	if err := m.chatRepo.UpdateChat(ctx, chat); err != nil {
+		return NewError("rename_chat", err, "update_failed")
+	}
+	m.publishChat(events.ChatUpdated, chat)
+
+	frame := reply(client, protocol.TypeChat, protocol.Chat{ChatInfo: chatInfo(chat)})
+	frame.ChatID = uint64(chat.ID)
+	return client.SendJSON(frame)
+}
+
+// handleDeleteChat deletes a chat, like DELETE /api/v1/chat/:id
+func (m *Manager) handleDeleteChat(client *Client, content json.RawMessage) error {
+	if err := m.chatAccess(client, auth.ScopeChatWrite); err != nil {
+		return err
+	}
+
+	var ref protocol.ChatRef
+	if err := decodeRequest(content, &ref, "invalid_delete_format"); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	chatID := uint64(ref.ChatID)
+	chat, err := m.authorizer.ChatInfo(ctx, client.UserID, chatID, authz.ActionDelete)
+	if err != nil {
+		return NewError("delete_chat", err, "chat_not_found")
+	}
+
+	if err := m.chatRepo.DeleteChat(ctx, chatID); err != nil {
+		return NewError("delete_chat", err, "delete_failed")
+	}
+	m.publishChat(events.ChatDeleted, chat)
+
+	event := auditEvent(client, audit.ActionChatDelete, audit.TargetChat, strconv.FormatUint(chatID, 10))
+	event.Details = models.AuditDetails{"title": chat.Title, "ownerId": chat.UserID}
+	m.auditLog.Record(event)
+
+	frame := reply(client, protocol.TypeChatDeleted, protocol.ChatRef{ChatID: ref.ChatID})
+	frame.ChatID = chatID
+	return client.SendJSON(frame)
+}
+
+// chatAccess checks that chat operations are available and that the client's API key,
+// if it connected with one, has the scope the REST API requires for them
+func (m *Manager) chatAccess(client *Client, scope string) error {
+	if m.chatRepo == nil || m.messageRepo == nil || m.authorizer == nil || m.auditLog == nil {
+		return NewError("chats", ErrChatsUnavailable, "unavailable")
+	}
+	if client.APIKeyID != 0 && !slices.Contains(client.Scopes, scope) {
+		return NewError("authorize", auth.ErrMissingScope, "missing_scope")
+	}
+	return nil
+}
+
+// messagesBefore loads up to limit messages of a chat sent before the message with ID before,
+// oldest first, and reports whether there are older ones
+func (m *Manager) messagesBefore(ctx context.Context, chatID uint64, before uint, limit int) ([]protocol.Message, bool, error) {
+	if limit < 1 || limit > MaxPageSize {
+		limit = DefaultMessagePageSize
+	}
+
+	// One more than needed tells whether older ones remain
+	messages, err := m.messageRepo.GetMessagesBefore(ctx, chatID, before, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+	hasMore := len(messages) > limit
+	if hasMore {
+		messages = messages[:limit]
+	}
+
+	result := make([]protocol.Message, len(messages))
+	for i, msg := range messages {
+		result[len(messages)-1-i] = protocol.Message{
+			ID:        msg.ID,
+			Content:   msg.Content,
+			Role:      msg.Role,
+			Timestamp: msg.Timestamp,
+			Metadata:  msg.Metadata,
+			ParentID:  msg.ParentID,
+			UserID:    msg.UserID,
+			Status:    msg.Status,
+			Error:     msg.Error,
+		}
+	}
+	return result, hasMore, nil
+}
+
+// publishChat publishes a change to a chat to its own topic and the one of its chat list
+func (m *Manager) publishChat(name string, chat *models.Chat) {
+	m.events.Publish(name, chat.ToMap(), events.ChatTopics(uint64(chat.ID), chat.UserID, chat.WorkspaceID)...)
+}
+
+// chatInfo describes a chat without its messages
+func chatInfo(chat *models.Chat) protocol.ChatInfo {
+	return protocol.ChatInfo{
+		ID:          chat.ID,
+		Title:       chat.Title,
+		CreatedAt:   chat.CreatedAt,
+		UpdatedAt:   chat.UpdatedAt,
+		LastMessage: chat.LastMessage,
+		UserID:      chat.UserID,
+		WorkspaceID: chat.WorkspaceID,
+	}
+}
+
+// reply creates the frame answering the request the client sent
+func reply(client *Client, t protocol.Type, content interface{}) *Message {
+	message := protocol.NewEnvelope(t, content)
+	message.CorrelationID = client.RequestID()
+	return message
+}
+
+// auditEvent builds an audit event for an action performed over the client's connection
+func auditEvent(client *Client, action, targetType, targetID string) *models.AuditEvent {
+	userID := client.UserID
+	event := &models.AuditEvent{
+		Action:     action,
+		Success:    true,
+		ActorID:    &userID,
+		ActorEmail: client.Email,
+		IP:         client.IP,
+		UserAgent:  client.UserAgent,
+		TargetType: targetType,
+		TargetID:   targetID,
+	}
+	if client.APIKeyID != 0 {
+		keyID := client.APIKeyID
+		event.ActorKeyID = &keyID
+	}
+	return event
+}
@@ -1,10 +1,28 @@
 package websocket
 
 import (
+	"crypto/subtle"
+	"encoding/json"
 	"sync"
 	"time"
 
 	"github.com/gofiber/websocket/v2"
+	"github.com/hra42/7x42/pkg/protocol"
+)
+
+const (
+	// DefaultSendQueueSize is how many outgoing messages a client may fall behind by
+	// before its connection is dropped as a slow consumer
+	DefaultSendQueueSize = 256
+
+	// DefaultWriteTimeout bounds how long a single write to a client may take
+	DefaultWriteTimeout = 10 * time.Second
+
+	// CloseSlowConsumer is the close code sent to clients that cannot keep up
+	CloseSlowConsumer = 4408
+
+	// CloseResumedElsewhere is the close code sent to a connection whose session was resumed on another one
+	CloseResumedElsewhere = 4409
 )
 
 // ClientStatus represents the status of a WebSocket client
@@ -13,20 +31,66 @@ type ClientStatus int
 const (
 	// StatusConnected indicates the client is connected and ready
 	StatusConnected ClientStatus = iota
-	// StatusDisconnecting indicates the client is in the process of disconnecting
+	// StatusDisconnecting indicates the client's connection dropped; it may still resume its session
 	StatusDisconnecting
-	// StatusDisconnected indicates the client has disconnected
+	// StatusDisconnected indicates the client's session has ended
 	StatusDisconnected
 	// StatusError indicates the client encountered an error
 	StatusError
 )
 
-// Client represents a WebSocket client connection
+// Identity describes who a connection was authenticated as
+type Identity struct {
+	UserID uint
+	// Name is shown to the other users following a chat
+	Name string
+	// SessionID or APIKeyID is set, depending on the credentials used
+	SessionID uint
+	APIKeyID  uint
+	// ReadOnly connections may follow chats but not send prompts
+	ReadOnly bool
+	// Role selects the user's rate limits
+	Role string
+	// Scopes are the scopes of the API key, if one was used
+	Scopes []string
+	// Email, IP and UserAgent describe the connection in audit events
+	Email     string
+	IP        string
+	UserAgent string
+}
+
+// ClientConfig holds the limits of a client's queues
+type ClientConfig struct {
+	// SendQueueSize is how many messages a connection may fall behind by
+	SendQueueSize int
+	// ReplayFrames and ReplayBytes bound the frames kept for resuming the session
+	ReplayFrames int
+	ReplayBytes  int
+}
+
+// Client represents a user's WebSocket session. It outlives its connections: every frame is
+// numbered and kept in a replay buffer, so a client reconnecting after a dropped connection
+// can resume the session and receive the frames it missed. Frames are queued and written
+// by the connection's own writer goroutine, so senders never block on a slow connection.
 type Client struct {
-	// Conn is the WebSocket connection
-	Conn *websocket.Conn
 	// UserID is the unique identifier for the user
-	UserID string
+	UserID uint
+	// Name is the user's display name
+	Name string
+	// SessionID is the login session the connection was authenticated with, if any
+	SessionID uint
+	// APIKeyID is the API key the connection was authenticated with, if any
+	APIKeyID uint
+	// ReadOnly is set for users that may not send prompts
+	ReadOnly bool
+	// Role is the user's role when the connection was opened
+	Role string
+	// Scopes are the scopes of the API key the connection was authenticated with, if any
+	Scopes []string
+	// Email, IP and UserAgent describe the connection in audit events
+	Email     string
+	IP        string
+	UserAgent string
 	// Status is the current status of the client
 	Status ClientStatus
 	// LastActivity is the timestamp of the last activity
@@ -37,93 +101,278 @@ type Client struct {
 	ErrorCount int
 	// Metadata stores additional client information
 	Metadata map[string]interface{}
+
+	// resumeToken is the secret a reconnecting client presents to resume the session
+	resumeToken string
+	// seq is the sequence number of the last frame sent in the session
+	seq uint64
+	// replay keeps the latest frames for resuming
+	replay *replayBuffer
+	// conn is the current connection, nil while the client is away
+	conn *connection
+	// detachedAt is when the last connection dropped
+	detachedAt time.Time
+	// queueSize is the size of each connection's send queue
+	queueSize int
+	// version is the protocol version negotiated by the current connection
+	version int
+	// requestID is the ID of the request being handled, if it carried one
+	requestID string
 }
 
-// NewClient creates a new WebSocket client
-func NewClient(conn *websocket.Conn, userID string) *Client {
+// connection is one WebSocket connection of a client; only its writer goroutine writes to it
+type connection struct {
+	ws *websocket.Conn
+	// send queues the messages waiting to be written
+	send chan []byte
+	// closing is closed to make the writer send closeFrame and drop the connection
+	closing    chan struct{}
+	closeOnce  sync.Once
+	closeFrame []byte
+	// stopped is closed once the writer has exited
+	stopped chan struct{}
+}
+
+// NewClient creates a new WebSocket client; attach connects it
+func NewClient(identity Identity, resumeToken string, config ClientConfig) *Client {
+	if config.SendQueueSize <= 0 {
+		config.SendQueueSize = DefaultSendQueueSize
+	}
 	return &Client{
-		Conn:         conn,
-		UserID:       userID,
-		Status:       StatusConnected,
+		UserID:       identity.UserID,
+		Name:         identity.Name,
+		SessionID:    identity.SessionID,
+		APIKeyID:     identity.APIKeyID,
+		ReadOnly:     identity.ReadOnly,
+		Role:         identity.Role,
+		Scopes:       identity.Scopes,
+		Email:        identity.Email,
+		IP:           identity.IP,
+		UserAgent:    identity.UserAgent,
+		Status:       StatusDisconnecting,
 		LastActivity: time.Now(),
 		Metadata:     make(map[string]interface{}),
+		resumeToken:  resumeToken,
+		replay:       newReplayBuffer(config.ReplayFrames, config.ReplayBytes),
+		detachedAt:   time.Now(),
+		queueSize:    config.SendQueueSize,
 	}
 }
 
-// SendJSON sends a JSON message to the client
-func (c *Client) SendJSON(data interface{}) error {
+// attach makes ws the client's connection, replacing any earlier one. The connection opens
+// with a session frame; when resuming, the frames sent after lastSeq follow. It reports false
+// if the session cannot be resumed because it ended or the frames were already dropped.
+func (c *Client) attach(ws *websocket.Conn, resume bool, lastSeq uint64) (*connection, bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.Status != StatusConnected {
-		return ErrClientDisconnected
+	if c.Status == StatusDisconnected {
+		return nil, false
+	}
+	var missed [][]byte
+	if resume {
+		var ok bool
+		if missed, ok = c.replay.since(lastSeq, c.seq); !ok {
+			return nil, false
+		}
 	}
 
-	c.LastActivity = time.Now()
-	if err := c.Conn.WriteJSON(data); err != nil {
-		c.ErrorCount++
-		return err
+	if c.conn != nil {
+		c.conn.close(CloseResumedElsewhere, "session resumed on another connection")
 	}
 
-	c.ErrorCount = 0
-	return nil
+	conn := &connection{
+		ws:      ws,
+		send:    make(chan []byte, c.queueSize+len(missed)+1),
+		closing: make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	opening, err := json.Marshal(NewSessionMessage(c.resumeToken, resume, c.seq))
+	if err != nil {
+		return nil, false
+	}
+	conn.send <- opening
+	for _, frame := range missed {
+		conn.send <- frame
+	}
+
+	c.conn = conn
+	c.version = protocol.ParseSubprotocol(ws.Subprotocol())
+	c.Status = StatusConnected
+	c.LastActivity = time.Now()
+	return conn, true
 }
 
-// SendText sends a text message to the client
-func (c *Client) SendText(message string) error {
+// detach lets go of a connection once its read loop ended. The client stays resumable
+// unless its session was ended or another connection took over.
+func (c *Client) detach(conn *connection) {
+	conn.close(websocket.CloseNormalClosure, "connection closed")
+	<-conn.stopped
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.Status != StatusConnected {
-		return ErrClientDisconnected
+	if c.conn != conn {
+		return
+	}
+	c.conn = nil
+	c.detachedAt = time.Now()
+	if c.Status != StatusDisconnected {
+		c.Status = StatusDisconnecting
 	}
+}
 
-	c.LastActivity = time.Now()
-	if err := c.Conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
-		c.ErrorCount++
+// expired reports whether the client's session is over: ended by the server, or without
+// a connection for longer than the resume window. Expired sessions cannot be resumed.
+func (c *Client) expired(resumeWindow time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != nil {
+		return false
+	}
+	if c.Status != StatusDisconnected && time.Since(c.detachedAt) > resumeWindow {
+		c.Status = StatusDisconnected
+	}
+	return c.Status == StatusDisconnected
+}
+
+// resumes reports whether a resume token belongs to this client's session
+func (c *Client) resumes(identity Identity, resumeToken string) bool {
+	return c.UserID == identity.UserID && c.SessionID == identity.SessionID && c.APIKeyID == identity.APIKeyID &&
+		subtle.ConstantTimeCompare([]byte(c.resumeToken), []byte(resumeToken)) == 1
+}
+
+// run writes queued messages and pings to the connection until it is closed or a write
+// fails, then closes the connection, which ends the client's read loop
+func (conn *connection) run(pingInterval, writeTimeout time.Duration) {
+	defer close(conn.stopped)
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case message := <-conn.send:
+			if err := conn.write(websocket.TextMessage, message, writeTimeout); err != nil {
+				_ = conn.ws.Close()
+				return
+			}
+
+		case <-ticker.C:
+			if err := conn.write(websocket.PingMessage, nil, writeTimeout); err != nil {
+				_ = conn.ws.Close()
+				return
+			}
+
+		case <-conn.closing:
+			_ = conn.ws.WriteControl(websocket.CloseMessage, conn.closeFrame, time.Now().Add(writeTimeout))
+			_ = conn.ws.Close()
+			return
+		}
+	}
+}
+
+// write writes a single message, giving up after the write timeout
+func (conn *connection) write(messageType int, data []byte, writeTimeout time.Duration) error {
+	if err := conn.ws.SetWriteDeadline(time.Now().Add(writeTimeout)); err != nil {
 		return err
 	}
+	return conn.ws.WriteMessage(messageType, data)
+}
 
-	c.ErrorCount = 0
-	return nil
+// close makes the writer send a close frame with the given code and drop the connection.
+// Queued messages are discarded.
+func (conn *connection) close(code int, reason string) {
+	conn.closeOnce.Do(func() {
+		conn.closeFrame = websocket.FormatCloseMessage(code, reason)
+		close(conn.closing)
+	})
 }
 
-// SendPing sends a ping message to the client
-func (c *Client) SendPing() error {
+// SendJSON sends a JSON message to the client
+func (c *Client) SendJSON(data interface{}) error {
+	message, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return c.enqueue(message)
+}
+
+// SendText sends a text message to the client
+func (c *Client) SendText(message string) error {
+	return c.enqueue([]byte(message))
+}
+
+// enqueue numbers a message, keeps it for replay and queues it on the current connection.
+// While the client is away the message is only kept. A connection whose queue is full is
+// too slow to keep up; it is dropped rather than holding up the sender, and the client
+// may resume from the replay buffer.
+func (c *Client) enqueue(message []byte) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.Status != StatusConnected {
+	if c.Status == StatusDisconnected {
 		return ErrClientDisconnected
 	}
 
-	if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+	c.seq++
+	message = stampFrame(message, c.seq)
+	c.replay.add(c.seq, message)
+	c.LastActivity = time.Now()
+
+	if c.conn == nil {
+		return nil
+	}
+	select {
+	case c.conn.send <- message:
+	default:
+		c.conn.close(CloseSlowConsumer, "too slow to keep up")
 		c.ErrorCount++
-		return err
 	}
-
 	return nil
 }
 
-// Close closes the client connection
+// Close ends the client's session and closes its connection
 func (c *Client) Close() error {
+	return c.CloseWithReason(websocket.CloseNormalClosure, "connection closed")
+}
+
+// CloseWithReason ends the client's session and closes its connection with the given code,
+// which ends the client's read loop. The session cannot be resumed.
+func (c *Client) CloseWithReason(code int, reason string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if c.Status == StatusDisconnected {
-		return nil
+	c.Status = StatusDisconnected
+	if c.conn != nil {
+		c.conn.close(code, reason)
 	}
+	return nil
+}
 
-	c.Status = StatusDisconnecting
+// Version returns the protocol version negotiated by the client's connection
+func (c *Client) Version() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	// Send close message
-	closeMessage := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "connection closed")
-	err := c.Conn.WriteMessage(websocket.CloseMessage, closeMessage)
+	return c.version
+}
 
-	// Close connection
-	c.Status = StatusDisconnected
+// RequestID returns the ID of the request being handled, so the frames it causes can carry it
+func (c *Client) RequestID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.requestID
+}
+
+// setRequest records the ID of the request being handled; requests are handled one at a time
+func (c *Client) setRequest(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	return err
+	c.requestID = id
 }
 
 // UpdateActivity updates the client's last activity timestamp
@@ -0,0 +1,336 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hra42/7x42/internal/cluster"
+)
+
+// Kinds of cluster messages exchanged between the managers of different instances
+const (
+	clusterRoomFrame  = "ws.room"
+	clusterRoomJoin   = "ws.room.join"
+	clusterRoomLeave  = "ws.room.leave"
+	clusterRooms      = "ws.rooms"
+	clusterUserFrame  = "ws.user"
+	clusterBroadcast  = "ws.broadcast"
+	clusterDisconnect = "ws.disconnect"
+)
+
+const (
+	// roomsSyncInterval is how often each instance announces all rooms it has members in
+	roomsSyncInterval = 30 * time.Second
+	// remoteNodeExpiry is how long the rooms of an instance that stopped announcing them are kept
+	remoteNodeExpiry = 3 * roomsSyncInterval
+)
+
+// roomFrame is a frame for the members of a chat's room connected to other instances
+type roomFrame struct {
+	ChatID  uint            `json:"chatId"`
+	Message json.RawMessage `json:"message"`
+}
+
+// userFrame is a frame for a user's connections to other instances
+type userFrame struct {
+	UserID  uint            `json:"userId"`
+	Message json.RawMessage `json:"message"`
+}
+
+// roomPresence tells the other instances that a room gained its first or lost its last member on a node
+type roomPresence struct {
+	Node   string `json:"node"`
+	ChatID uint   `json:"chatId"`
+}
+
+// roomsSnapshot lists every room a node has members in; it replaces what was known about the node
+type roomsSnapshot struct {
+	Node    string `json:"node"`
+	ChatIDs []uint `json:"chatIds"`
+}
+
+// disconnectRequest closes a user's connections to other instances
+type disconnectRequest struct {
+	UserID uint   `json:"userId"`
+	Reason string `json:"reason"`
+}
+
+// remoteRoom stands in for the members of a chat's room connected to other instances,
+// so generations and prompts reach them too. It is a value so that equal rooms
+// subscribe to a job only once.
+type remoteRoom struct {
+	bus    *cluster.Bus
+	rooms  *remoteRooms
+	chatID uint
+}
+
+// SendJSON relays a frame to the room on the other instances. Frames that cannot be
+// relayed are dropped without error, so the room keeps receiving the ones after them.
+// Nothing is relayed while no other instance has members in the room.
+func (r remoteRoom) SendJSON(data interface{}) error {
+	if !r.rooms.watched(r.chatID) {
+		return nil
+	}
+	message, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if err := r.bus.Publish(clusterRoomFrame, roomFrame{ChatID: r.chatID, Message: message}); err != nil {
+		log.Printf("Error relaying a frame for chat %d: %v", r.chatID, err)
+	}
+	return nil
+}
+
+// remoteRooms tracks which rooms have members connected to other instances
+type remoteRooms struct {
+	mu    sync.Mutex
+	nodes map[string]*remoteNode
+}
+
+// remoteNode is what is known about the rooms of another instance
+type remoteNode struct {
+	chats map[uint]struct{}
+	seen  time.Time
+}
+
+// newRemoteRooms creates a tracker that knows of no other instances yet
+func newRemoteRooms() *remoteRooms {
+	return &remoteRooms{nodes: make(map[string]*remoteNode)}
+}
+
+// watched reports whether another instance has members in a chat's room
+func (r *remoteRooms) watched(chatID uint) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.watchedLocked(chatID)
+}
+
+// watchedLocked reports whether another instance has members in a chat's room; r.mu must be held
+func (r *remoteRooms) watchedLocked(chatID uint) bool {
+	for _, node := range r.nodes {
+		if _, ok := node.chats[chatID]; ok && time.Since(node.seen) < remoteNodeExpiry {
+			return true
+		}
+	}
+	return false
+}
+
+// node returns the rooms of an instance, marking it as seen, and reports whether it was known
+func (r *remoteRooms) node(id string) (*remoteNode, bool) {
+	node, ok := r.nodes[id]
+	if !ok {
+		node = &remoteNode{chats: make(map[uint]struct{})}
+		r.nodes[id] = node
+	}
+	node.seen = time.Now()
+	return node, ok
+}
+
+// join records that an instance has members in a chat's room. It reports whether the
+// instance was known and whether the room had no members on other instances before.
+func (r *remoteRooms) join(nodeID string, chatID uint) (known, first bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	first = !r.watchedLocked(chatID)
+	node, known := r.node(nodeID)
+	node.chats[chatID] = struct{}{}
+	return known, first
+}
+
+// leave records that an instance has no more members in a chat's room and reports whether it was known
+func (r *remoteRooms) leave(nodeID string, chatID uint) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	node, known := r.node(nodeID)
+	delete(node.chats, chatID)
+	return known
+}
+
+// replace records every room an instance has members in. It reports whether the instance
+// was known and the rooms that had no members on other instances before.
+func (r *remoteRooms) replace(nodeID string, chatIDs []uint) (known bool, first []uint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, chatID := range chatIDs {
+		if !r.watchedLocked(chatID) {
+			first = append(first, chatID)
+		}
+	}
+	node, known := r.node(nodeID)
+	node.chats = make(map[uint]struct{}, len(chatIDs))
+	for _, chatID := range chatIDs {
+		node.chats[chatID] = struct{}{}
+	}
+	return known, first
+}
+
+// expire forgets instances that stopped announcing their rooms
+func (r *remoteRooms) expire() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, node := range r.nodes {
+		if time.Since(node.seen) >= remoteNodeExpiry {
+			delete(r.nodes, id)
+		}
+	}
+}
+
+// handleCluster registers the handlers for frames relayed by other instances
+func (m *Manager) handleCluster(bus *cluster.Bus) {
+	m.cluster = bus
+	m.remoteRooms = newRemoteRooms()
+
+	bus.Handle(clusterRoomJoin, func(data json.RawMessage) {
+		var presence roomPresence
+		if err := json.Unmarshal(data, &presence); err != nil {
+			log.Printf("Ignoring malformed room join: %v", err)
+			return
+		}
+		known, first := m.remoteRooms.join(presence.Node, presence.ChatID)
+		m.remoteNodeSeen(known)
+		if first {
+			m.followRemoteRoom(presence.ChatID)
+		}
+	})
+
+	bus.Handle(clusterRoomLeave, func(data json.RawMessage) {
+		var presence roomPresence
+		if err := json.Unmarshal(data, &presence); err != nil {
+			log.Printf("Ignoring malformed room leave: %v", err)
+			return
+		}
+		m.remoteNodeSeen(m.remoteRooms.leave(presence.Node, presence.ChatID))
+	})
+
+	bus.Handle(clusterRooms, func(data json.RawMessage) {
+		var snapshot roomsSnapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			log.Printf("Ignoring malformed rooms snapshot: %v", err)
+			return
+		}
+		known, first := m.remoteRooms.replace(snapshot.Node, snapshot.ChatIDs)
+		m.remoteNodeSeen(known)
+		for _, chatID := range first {
+			m.followRemoteRoom(chatID)
+		}
+	})
+
+	bus.Handle(clusterRoomFrame, func(data json.RawMessage) {
+		var frame roomFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			log.Printf("Ignoring malformed room frame: %v", err)
+			return
+		}
+		m.sendToRoom(frame.ChatID, nil, frame.Message)
+	})
+
+	bus.Handle(clusterUserFrame, func(data json.RawMessage) {
+		var frame userFrame
+		if err := json.Unmarshal(data, &frame); err != nil {
+			log.Printf("Ignoring malformed user frame: %v", err)
+			return
+		}
+		m.sendToUser(frame.UserID, frame.Message)
+	})
+
+	bus.Handle(clusterBroadcast, func(data json.RawMessage) {
+		m.sendToAll(data)
+	})
+
+	bus.Handle(clusterDisconnect, func(data json.RawMessage) {
+		var request disconnectRequest
+		if err := json.Unmarshal(data, &request); err != nil {
+			log.Printf("Ignoring malformed disconnect request: %v", err)
+			return
+		}
+		m.disconnectUser(request.UserID, request.Reason)
+	})
+}
+
+// relay publishes a message to the managers of the other instances, if there are any
+func (m *Manager) relay(kind string, data interface{}) {
+	if m.cluster == nil {
+		return
+	}
+	if err := m.cluster.Publish(kind, data); err != nil {
+		log.Printf("Error relaying %s to the cluster: %v", kind, err)
+	}
+}
+
+// relayToRoom sends a message to the members of a chat's room on other instances.
+// Presence is not relayed: each instance reports the members connected to it.
+func (m *Manager) relayToRoom(chatID uint, message interface{}) {
+	if m.cluster == nil {
+		return
+	}
+	m.remoteRoom(chatID).SendJSON(message)
+}
+
+// remoteRoom returns the writer reaching the members of a chat's room on other instances
+func (m *Manager) remoteRoom(chatID uint) remoteRoom {
+	return remoteRoom{bus: m.cluster, rooms: m.remoteRooms, chatID: chatID}
+}
+
+// followRemoteRoom streams the generations already running in a chat to the members
+// other instances now have in its room
+func (m *Manager) followRemoteRoom(chatID uint) {
+	if m.aiService != nil {
+		m.aiService.FollowJobs(m.remoteRoom(chatID), uint64(chatID))
+	}
+}
+
+// relayRoomPresence tells the other instances that a room on this one gained its first
+// member or lost its last; roomsMu is held so the announcements keep their order
+func (m *Manager) relayRoomPresence(kind string, chatID uint) {
+	if m.cluster == nil {
+		return
+	}
+	m.relay(kind, roomPresence{Node: m.cluster.NodeID(), ChatID: chatID})
+}
+
+// remoteNodeSeen answers an instance heard from for the first time, e.g. one that just
+// started, with the rooms this instance has members in
+func (m *Manager) remoteNodeSeen(known bool) {
+	if !known {
+		m.announceRooms()
+	}
+}
+
+// announceRooms sends the rooms this instance has members in to the other instances
+func (m *Manager) announceRooms() {
+	m.roomsMu.RLock()
+	chatIDs := make([]uint, 0, len(m.rooms))
+	for chatID := range m.rooms {
+		chatIDs = append(chatIDs, chatID)
+	}
+	m.roomsMu.RUnlock()
+
+	m.relay(clusterRooms, roomsSnapshot{Node: m.cluster.NodeID(), ChatIDs: chatIDs})
+}
+
+// syncRooms periodically announces this instance's rooms, so instances that missed a join
+// or leave catch up, and forgets the rooms of instances that stopped announcing theirs
+func (m *Manager) syncRooms() {
+	m.announceRooms()
+
+	ticker := time.NewTicker(roomsSyncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			m.remoteRooms.expire()
+			m.announceRooms()
+
+		case <-m.done:
+			return
+		}
+	}
+}
@@ -3,6 +3,7 @@ package websocket
 import (
 	"errors"
 	"fmt"
+	"time"
 )
 
 // Common WebSocket errors
@@ -12,6 +13,12 @@ var (
 	ErrMessageTooLarge    = errors.New("message too large")
 	ErrRateLimitExceeded  = errors.New("rate limit exceeded")
 	ErrInvalidChatID      = errors.New("invalid chat ID")
+	ErrReadOnly           = errors.New("read-only accounts cannot send messages")
+	ErrNotInRoom          = errors.New("join the chat first")
+	ErrInvalidTopic       = errors.New("invalid topic")
+	ErrTopicDenied        = errors.New("topic not found or not accessible")
+	ErrTooManyTopics      = errors.New("too many topic subscriptions")
+	ErrChatsUnavailable   = errors.New("chat operations are not available")
 )
 
 // WebSocketError represents a WebSocket-specific error
@@ -44,3 +51,19 @@ func (e *WebSocketError) Unwrap() error {
 func (e *WebSocketError) GetCode() string {
 	return e.Code
 }
+
+// RateLimitError is returned when a client exceeds its rate limit
+type RateLimitError struct {
+	Class      string
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%v: too many %s, retry in %s", ErrRateLimitExceeded, e.Class, e.RetryAfter.Round(time.Second))
+}
+
+// Unwrap returns ErrRateLimitExceeded
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimitExceeded
+}
@@ -1,14 +1,28 @@
 package websocket
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/websocket/v2"
 	"github.com/hra42/7x42/internal/ai"
+	"github.com/hra42/7x42/internal/audit"
+	"github.com/hra42/7x42/internal/auth"
+	"github.com/hra42/7x42/internal/authz"
+	"github.com/hra42/7x42/internal/cluster"
+	"github.com/hra42/7x42/internal/credits"
+	"github.com/hra42/7x42/internal/events"
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/providerkeys"
+	"github.com/hra42/7x42/internal/ratelimit"
+	"github.com/hra42/7x42/internal/repository"
+	"github.com/hra42/7x42/pkg/protocol"
 )
 
 const (
@@ -20,27 +34,67 @@ const (
 
 	// MaxMessageSize is the maximum message size in bytes
 	MaxMessageSize = 1024 * 1024 // 1MB
+
+	// DefaultRevalidateInterval is how often the sessions behind open connections are rechecked
+	DefaultRevalidateInterval = time.Minute
+
+	// CloseSessionExpired is the close code sent when a connection's session is no longer valid
+	CloseSessionExpired = 4401
+
+	// DefaultResumeWindow is how long a client whose connection dropped may resume its session
+	DefaultResumeWindow = 2 * time.Minute
 )
 
 // Manager manages WebSocket connections
 type Manager struct {
-	// clients is a map of all connected clients
-	clients map[*Client]bool
+	// clients holds all connected clients; connections add and remove themselves without locking
+	clients sync.Map
 
-	// register is a channel for registering new clients
-	register chan *Client
-
-	// unregister is a channel for unregistering clients
-	unregister chan *Client
-
-	// broadcast is a channel for broadcasting messages to all clients
-	broadcast chan []byte
+	// clientCount is the number of entries in clients
+	clientCount atomic.Int64
 
 	// aiService is the AI service for handling chat messages
 	aiService *ai.Service
 
-	// mu protects the manager's fields during concurrent access
-	mu sync.RWMutex
+	// authService revalidates the sessions of open connections
+	authService *auth.Service
+
+	// rateLimiter limits messages and generations per user; nil disables limiting
+	rateLimiter *ratelimit.Limiter
+
+	// rooms maps chat IDs to the connections following them
+	rooms map[uint]map[*Client]*roomMember
+
+	// roomsMu protects rooms; it is never held while sending
+	roomsMu sync.RWMutex
+
+	// topics maps event topics to the connections subscribed to them
+	topics map[string]map[*Client]struct{}
+
+	// topicsMu protects topics; it is never held while sending
+	topicsMu sync.RWMutex
+
+	// authorizer checks access to the chats and workspaces behind topics
+	authorizer *authz.Authorizer
+
+	// chatRepo and messageRepo serve the chat operations, checked by authorizer
+	chatRepo    *repository.ChatRepository
+	messageRepo *repository.MessageRepository
+
+	// auditLog records the chats deleted over connections
+	auditLog *audit.Logger
+
+	// events receives the changes made over connections
+	events *events.Bus
+
+	// cluster relays room frames, broadcasts and disconnects to other instances; nil when running alone
+	cluster *cluster.Bus
+
+	// remoteRooms tracks the rooms other instances have members in; nil when running alone
+	remoteRooms *remoteRooms
+
+	// mu protects running
+	mu sync.Mutex
 
 	// pingInterval is the interval for sending ping messages
 	pingInterval time.Duration
@@ -48,6 +102,18 @@ type Manager struct {
 	// idleTimeout is the timeout for idle connections
 	idleTimeout time.Duration
 
+	// revalidateInterval is the interval for rechecking client sessions
+	revalidateInterval time.Duration
+
+	// clientConfig holds the queue limits of new clients
+	clientConfig ClientConfig
+
+	// resumeWindow is how long sessions without a connection are kept for resuming
+	resumeWindow time.Duration
+
+	// writeTimeout bounds each write to a client
+	writeTimeout time.Duration
+
 	// running indicates if the manager is running
 	running bool
 
@@ -57,21 +123,45 @@ type Manager struct {
 
 // ManagerConfig holds configuration for the WebSocket manager
 type ManagerConfig struct {
-	PingInterval time.Duration
-	IdleTimeout  time.Duration
+	PingInterval       time.Duration
+	IdleTimeout        time.Duration
+	RevalidateInterval time.Duration
+	AuthService        *auth.Service
+	RateLimiter        *ratelimit.Limiter
+	// SendQueueSize is how many messages a client may fall behind by before it is disconnected
+	SendQueueSize int
+	// ReplayFrames and ReplayBytes bound the frames kept per session for resuming
+	ReplayFrames int
+	ReplayBytes  int
+	// ResumeWindow is how long a client whose connection dropped may resume its session
+	ResumeWindow time.Duration
+	// WriteTimeout bounds how long a single write to a client may take
+	WriteTimeout time.Duration
+	// Authorizer checks topic subscriptions; without one only user and system topics work
+	Authorizer *authz.Authorizer
+	// ChatRepo, MessageRepo and AuditLog, with Authorizer, enable the chat operations
+	ChatRepo    *repository.ChatRepository
+	MessageRepo *repository.MessageRepository
+	AuditLog    *audit.Logger
+	// Events, if set, is the bus whose events are delivered to topic subscribers and
+	// which the changes made by chat operations are published to
+	Events *events.Bus
+	// Cluster, if set, connects the manager to the managers of other instances
+	Cluster *cluster.Bus
 }
 
 // NewManager creates a new WebSocket manager
 func NewManager(aiService *ai.Service, config ...*ManagerConfig) *Manager {
 	m := &Manager{
-		clients:      make(map[*Client]bool),
-		register:     make(chan *Client),
-		unregister:   make(chan *Client),
-		broadcast:    make(chan []byte),
-		aiService:    aiService,
-		pingInterval: DefaultPingInterval,
-		idleTimeout:  DefaultIdleTimeout,
-		done:         make(chan struct{}),
+		aiService:          aiService,
+		rooms:              make(map[uint]map[*Client]*roomMember),
+		topics:             make(map[string]map[*Client]struct{}),
+		pingInterval:       DefaultPingInterval,
+		idleTimeout:        DefaultIdleTimeout,
+		revalidateInterval: DefaultRevalidateInterval,
+		resumeWindow:       DefaultResumeWindow,
+		writeTimeout:       DefaultWriteTimeout,
+		done:               make(chan struct{}),
 	}
 
 	// Apply config if provided
@@ -82,6 +172,38 @@ func NewManager(aiService *ai.Service, config ...*ManagerConfig) *Manager {
 		if config[0].IdleTimeout > 0 {
 			m.idleTimeout = config[0].IdleTimeout
 		}
+		if config[0].RevalidateInterval > 0 {
+			m.revalidateInterval = config[0].RevalidateInterval
+		}
+		if config[0].ResumeWindow > 0 {
+			m.resumeWindow = config[0].ResumeWindow
+		}
+		m.clientConfig = ClientConfig{
+			SendQueueSize: config[0].SendQueueSize,
+			ReplayFrames:  config[0].ReplayFrames,
+			ReplayBytes:   config[0].ReplayBytes,
+		}
+		if config[0].WriteTimeout > 0 {
+			m.writeTimeout = config[0].WriteTimeout
+		}
+		m.authService = config[0].AuthService
+		m.rateLimiter = config[0].RateLimiter
+		m.authorizer = config[0].Authorizer
+		m.chatRepo = config[0].ChatRepo
+		m.messageRepo = config[0].MessageRepo
+		m.auditLog = config[0].AuditLog
+		m.events = config[0].Events
+		if config[0].Events != nil {
+			config[0].Events.Subscribe(m.deliver)
+		}
+		if config[0].Cluster != nil {
+			m.handleCluster(config[0].Cluster)
+		}
+	}
+
+	// Generations stream to everyone in a chat's room
+	if aiService != nil {
+		aiService.SetChatWatchers(m)
 	}
 
 	return m
@@ -97,9 +219,13 @@ func (m *Manager) Start() {
 	m.running = true
 	m.mu.Unlock()
 
-	go m.run()
-	go m.pingClients()
 	go m.cleanIdleConnections()
+	if m.authService != nil {
+		go m.revalidateSessions()
+	}
+	if m.cluster != nil {
+		go m.syncRooms()
+	}
 
 	log.Println("WebSocket manager started")
 }
@@ -116,43 +242,33 @@ func (m *Manager) Stop() {
 	m.running = false
 	close(m.done)
 
-	// Close all client connections
-	for client := range m.clients {
+	// End all sessions; connected ones are removed once their read loop ends
+	for _, client := range m.clientList() {
 		client.Close()
-		delete(m.clients, client)
+		if client.expired(m.resumeWindow) {
+			m.removeClient(client)
+		}
 	}
 
 	log.Println("WebSocket manager stopped")
 }
 
-// run processes WebSocket events
-func (m *Manager) run() {
+// cleanIdleConnections closes idle connections and forgets sessions that can no longer be resumed
+func (m *Manager) cleanIdleConnections() {
+	ticker := time.NewTicker(min(m.idleTimeout, m.resumeWindow) / 2)
+	defer ticker.Stop()
+
 	for {
 		select {
-		case client := <-m.register:
-			m.mu.Lock()
-			m.clients[client] = true
-			m.mu.Unlock()
-			log.Printf("Client connected. Total clients: %d", m.countClients())
-
-		case client := <-m.unregister:
-			m.mu.Lock()
-			if _, ok := m.clients[client]; ok {
-				delete(m.clients, client)
-				client.Close()
-			}
-			m.mu.Unlock()
-			log.Printf("Client disconnected. Total clients: %d", m.countClients())
-
-		case message := <-m.broadcast:
-			m.mu.RLock()
-			for client := range m.clients {
-				if err := client.SendText(string(message)); err != nil {
-					log.Printf("Error broadcasting to client: %v", err)
-					m.unregister <- client
+		case <-ticker.C:
+			for _, client := range m.clientList() {
+				if client.expired(m.resumeWindow) {
+					m.removeClient(client)
+				} else if client.IsIdle(m.idleTimeout) {
+					log.Printf("Closing idle connection for user %d", client.UserID)
+					client.Close()
 				}
 			}
-			m.mu.RUnlock()
 
 		case <-m.done:
 			return
@@ -160,22 +276,20 @@ func (m *Manager) run() {
 	}
 }
 
-// pingClients sends ping messages to all clients periodically
-func (m *Manager) pingClients() {
-	ticker := time.NewTicker(m.pingInterval)
+// revalidateSessions closes connections whose session or API key was revoked, expired or disabled,
+// and rechecks who may still follow the chats, workspaces and topics they joined
+func (m *Manager) revalidateSessions() {
+	ticker := time.NewTicker(m.revalidateInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			m.mu.RLock()
-			for client := range m.clients {
-				if err := client.SendPing(); err != nil {
-					log.Printf("Error sending ping to client: %v", err)
-					m.unregister <- client
-				}
+			for _, client := range m.clientList() {
+				m.revalidateClient(client)
 			}
-			m.mu.RUnlock()
+			m.refreshRooms()
+			m.refreshTopics()
 
 		case <-m.done:
 			return
@@ -183,49 +297,124 @@ func (m *Manager) pingClients() {
 	}
 }
 
-// cleanIdleConnections closes idle connections
-func (m *Manager) cleanIdleConnections() {
-	ticker := time.NewTicker(m.idleTimeout / 2)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-			m.mu.RLock()
-			for client := range m.clients {
-				if client.IsIdle(m.idleTimeout) {
-					log.Printf("Closing idle connection for user %s", client.UserID)
-					m.unregister <- client
-				}
-			}
-			m.mu.RUnlock()
+// revalidateClient closes the client if its credentials are no longer valid.
+// Database errors keep the connection open; they are not the user's fault.
+func (m *Manager) revalidateClient(client *Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var (
+		user    *models.User
+		session *models.Session
+		err     error
+	)
+	if client.APIKeyID != 0 {
+		user, _, err = m.authService.ValidateAPIKey(ctx, client.APIKeyID)
+	} else {
+		user, session, err = m.authService.ValidateSession(ctx, client.SessionID)
+	}
+	if err == nil {
+		// An administrator may have started requiring two-factor authentication for the user's role
+		err = m.authService.CheckSecondFactor(ctx, user, session)
+	}
+	if err == nil {
+		return
+	}
+	if !errors.Is(err, auth.ErrInvalidSession) && !errors.Is(err, auth.ErrInvalidAPIKey) &&
+		!errors.Is(err, auth.ErrAccountDisabled) && !errors.Is(err, auth.ErrSecondFactorRequired) {
+		log.Printf("Error revalidating credentials of user %d: %v", client.UserID, err)
+		return
+	}
 
-		case <-m.done:
-			return
-		}
+	log.Printf("Closing connection for user %d: %v", client.UserID, err)
+	if err := client.CloseWithReason(CloseSessionExpired, "session expired"); err != nil {
+		log.Printf("Error closing connection: %v", err)
 	}
 }
 
-// HandleConnection handles a new WebSocket connection
-func (m *Manager) HandleConnection(conn *websocket.Conn, userID string) {
-	// Create a new client
-	client := NewClient(conn, userID)
+// Resume asks to continue an earlier session on a new connection
+type Resume struct {
+	// Token is the resume token the session was opened with
+	Token string
+	// LastSeq is the sequence number of the last frame the client received
+	LastSeq uint64
+}
 
+// HandleConnection handles a new WebSocket connection authenticated as the given identity.
+// A connection presenting the resume token of one of the user's sessions continues that
+// session, receiving the frames it missed; otherwise a new session starts. Sessions live
+// on the instance that created them, so resuming elsewhere starts a new one.
+func (m *Manager) HandleConnection(conn *websocket.Conn, identity Identity, resume Resume) {
 	// Set read limit to prevent malicious messages
 	conn.SetReadLimit(MaxMessageSize)
 
-	// Register the client
-	m.register <- client
+	client, attached := m.resumeClient(conn, identity, resume)
+	if client != nil {
+		log.Printf("Client of user %d resumed its session", client.UserID)
+	} else {
+		token, err := auth.NewToken()
+		if err != nil {
+			log.Printf("Error generating resume token: %v", err)
+			_ = conn.Close()
+			return
+		}
+		client = NewClient(identity, token, m.clientConfig)
+		attached, _ = client.attach(conn, false, 0)
+
+		m.clients.Store(client, struct{}{})
+		m.clientCount.Add(1)
+		log.Printf("Client connected. Total clients: %d", m.countClients())
+	}
+	go attached.run(m.pingInterval, m.writeTimeout)
 
 	// Handle client messages
-	m.handleClientMessages(client)
+	m.handleClientMessages(client, conn)
+
+	// The connection must not be written to once this handler returns. The session stays
+	// around for resuming unless the server ended it.
+	client.detach(attached)
+	if client.expired(m.resumeWindow) {
+		m.removeClient(client)
+	}
+}
+
+// resumeClient attaches a connection to the session a resume token belongs to. A session
+// that cannot be resumed because it missed too much is ended, since its client moved on.
+func (m *Manager) resumeClient(conn *websocket.Conn, identity Identity, resume Resume) (*Client, *connection) {
+	if resume.Token == "" {
+		return nil, nil
+	}
 
-	// Unregister the client when done
-	m.unregister <- client
+	for _, client := range m.clientList() {
+		if !client.resumes(identity, resume.Token) {
+			continue
+		}
+		if attached, ok := client.attach(conn, true, resume.LastSeq); ok {
+			return client, attached
+		}
+		client.Close()
+		if client.expired(m.resumeWindow) {
+			m.removeClient(client)
+		}
+		return nil, nil
+	}
+	return nil, nil
 }
 
-// handleClientMessages processes messages from a client
-func (m *Manager) handleClientMessages(client *Client) {
+// removeClient forgets an ended session, telling the rooms it was in
+func (m *Manager) removeClient(client *Client) {
+	if _, ok := m.clients.LoadAndDelete(client); !ok {
+		return
+	}
+	m.clientCount.Add(-1)
+
+	m.leaveRooms(client)
+	m.leaveTopics(client)
+	log.Printf("Client disconnected. Total clients: %d", m.countClients())
+}
+
+// handleClientMessages processes messages from a client's connection
+func (m *Manager) handleClientMessages(client *Client, conn *websocket.Conn) {
 	defer func() {
 		if r := recover(); r != nil {
 			log.Printf("Recovered from panic in handleClientMessages: %v", r)
@@ -233,7 +422,7 @@ func (m *Manager) handleClientMessages(client *Client) {
 	}()
 
 	for {
-		messageType, payload, err := client.Conn.ReadMessage()
+		messageType, payload, err := conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err,
 				websocket.CloseGoingAway,
@@ -249,15 +438,10 @@ func (m *Manager) handleClientMessages(client *Client) {
 		// Handle different message types
 		switch messageType {
 		case websocket.TextMessage:
-			if err := m.handleTextMessage(client, payload); err != nil {
-				log.Printf("Error handling text message: %v", err)
-				if err := client.SendJSON(NewErrorMessage(err.Error(), "message_error")); err != nil {
-					log.Printf("Error sending error message: %v", err)
-				}
-			}
+			m.handleTextMessage(client, payload)
 
 		case websocket.PingMessage:
-			if err := client.SendText(`{"type":"pong"}`); err != nil {
+			if err := client.SendJSON(NewPongMessage()); err != nil {
 				log.Printf("Error sending pong: %v", err)
 			}
 
@@ -267,67 +451,299 @@ func (m *Manager) handleClientMessages(client *Client) {
 	}
 }
 
-// handleTextMessage processes text messages from a client
-func (m *Manager) handleTextMessage(client *Client, payload []byte) error {
+// handleTextMessage handles a request from a client and answers it: rejected requests with an
+// error frame, accepted ones carrying an ID with an ack if the client speaks version 2. Both
+// carry the request's ID as correlation ID, as do the frames of generations it starts.
+func (m *Manager) handleTextMessage(client *Client, payload []byte) {
 	var msg Message
 	if err := json.Unmarshal(payload, &msg); err != nil {
-		return NewError("unmarshal", ErrInvalidMessage, "invalid_format")
+		m.reject(client, "", NewError("unmarshal", ErrInvalidMessage, "invalid_format"))
+		return
+	}
+
+	client.setRequest(msg.ID)
+	defer client.setRequest("")
+
+	if err := m.handleRequest(client, &msg); err != nil {
+		m.reject(client, msg.ID, err)
+		return
+	}
+
+	// The pong answers a ping
+	if msg.ID == "" || msg.Type == protocol.TypePing || client.Version() < protocol.Version2 {
+		return
+	}
+	if err := client.SendJSON(NewAckMessage(msg.ID)); err != nil {
+		log.Printf("Error sending ack: %v", err)
+	}
+}
+
+// reject sends the error frame for a failed request
+func (m *Manager) reject(client *Client, requestID string, err error) {
+	log.Printf("Error handling text message: %v", err)
+
+	frame := errorFrame(err)
+	frame.CorrelationID = requestID
+	if err := client.SendJSON(frame); err != nil {
+		log.Printf("Error sending error message: %v", err)
+	}
+}
+
+// handleRequest dispatches a request to the handler of its type
+func (m *Manager) handleRequest(client *Client, msg *Message) error {
+	if client.ReadOnly && msg.Type.Writes() {
+		return NewError("authorize", ErrReadOnly, "read_only")
+	}
+
+	// Pings keep the connection alive and must not be starved by the limit
+	if msg.Type != protocol.TypePing {
+		if err := m.allow(client, ratelimit.ClassMessages, 1); err != nil {
+			return err
+		}
 	}
 
 	switch msg.Type {
-	case TypeChatMessage:
+	case protocol.TypeChatMessage:
 		return m.handleChatMessage(client, msg.Content)
 
-	case TypePing:
-		return client.SendJSON(map[string]string{"type": "pong"})
+	case protocol.TypeCompareMessage:
+		return m.handleCompareMessage(client, msg.Content)
+
+	case protocol.TypeSubscribeJob:
+		return m.handleSubscribeJob(client, msg.Content)
+
+	case protocol.TypeCancelJob:
+		return m.handleCancelJob(client, msg.Content)
+
+	case protocol.TypeRetryMessage:
+		return m.handleRetryMessage(client, msg.Content)
+
+	case protocol.TypeJoinChat:
+		return m.handleJoinChat(client, msg.Content)
+
+	case protocol.TypeLeaveChat:
+		return m.handleLeaveChat(client, msg.Content)
+
+	case protocol.TypeTyping:
+		return m.handleTyping(client, msg.Content)
+
+	case protocol.TypeSubscribe:
+		return m.handleSubscribe(client, msg.Content)
+
+	case protocol.TypeUnsubscribe:
+		return m.handleUnsubscribe(client, msg.Content)
+
+	case protocol.TypeListChats:
+		return m.handleListChats(client, msg.Content)
+
+	case protocol.TypeGetChat:
+		return m.handleGetChat(client, msg.Content)
+
+	case protocol.TypeListMessages:
+		return m.handleListMessages(client, msg.Content)
+
+	case protocol.TypeRenameChat:
+		return m.handleRenameChat(client, msg.Content)
+
+	case protocol.TypeDeleteChat:
+		return m.handleDeleteChat(client, msg.Content)
+
+	case protocol.TypePing:
+		pong := NewPongMessage()
+		pong.CorrelationID = msg.ID
+		return client.SendJSON(pong)
 
 	default:
 		return fmt.Errorf("unknown message type: %s", msg.Type)
 	}
 }
 
+// decodeRequest unmarshals the content of a request. A malformed chat ID is reported as
+// invalid_chat_id, anything else with the given code.
+func decodeRequest(content json.RawMessage, request interface{}, code string) error {
+	if err := json.Unmarshal(content, request); err != nil {
+		if errors.Is(err, protocol.ErrInvalidID) {
+			return NewError("parse_chat_id", ErrInvalidChatID, "invalid_chat_id")
+		}
+		return NewError("unmarshal", ErrInvalidMessage, code)
+	}
+	return nil
+}
+
 // handleChatMessage processes chat messages
 func (m *Manager) handleChatMessage(client *Client, content json.RawMessage) error {
-	var rawChatMsg ChatMessageRaw
-	if err := json.Unmarshal(content, &rawChatMsg); err != nil {
-		return NewError("unmarshal", ErrInvalidMessage, "invalid_chat_format")
+	var request protocol.ChatRequest
+	if err := decodeRequest(content, &request, "invalid_chat_format"); err != nil {
+		return err
 	}
 
-	chatID, err := ParseChatID(rawChatMsg.ChatID)
-	if err != nil {
-		return NewError("parse_chat_id", ErrInvalidChatID, "invalid_chat_id")
+	if err := m.allow(client, ratelimit.ClassGenerations, 1); err != nil {
+		return err
 	}
 
-	chatMsg := ChatMessage{
-		ChatID:    chatID,
-		Content:   rawChatMsg.Content,
-		Role:      rawChatMsg.Role,
-		Timestamp: rawChatMsg.Timestamp,
+	// Process the chat message with the AI service
+	if err := m.aiService.HandleChatMessage(client, uint(request.ChatID), request.Content, client.UserID); err != nil {
+		return NewError("ai_service", err, "ai_service_error")
 	}
 
-	// Process the chat message with the AI service
-	if err := m.aiService.HandleChatMessage(client.Conn, chatMsg.ChatID, chatMsg.Content, client.UserID); err != nil {
+	return nil
+}
+
+// handleCompareMessage processes comparison requests that fan one prompt out to several models
+func (m *Manager) handleCompareMessage(client *Client, content json.RawMessage) error {
+	var request protocol.CompareRequest
+	if err := decodeRequest(content, &request, "invalid_compare_format"); err != nil {
+		return err
+	}
+
+	if err := m.allow(client, ratelimit.ClassGenerations, max(len(request.Models), 1)); err != nil {
+		return err
+	}
+
+	if err := m.aiService.HandleCompareMessage(client, uint(request.ChatID), request.Content, client.UserID, request.Models); err != nil {
 		return NewError("ai_service", err, "ai_service_error")
 	}
 
 	return nil
 }
 
-// BroadcastToUser broadcasts a message to a specific user
-func (m *Manager) BroadcastToUser(userID string, message interface{}) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+// handleSubscribeJob attaches a reconnecting client to its running generation jobs
+func (m *Manager) handleSubscribeJob(client *Client, content json.RawMessage) error {
+	var request protocol.SubscribeJobRequest
+	if err := decodeRequest(content, &request, "invalid_subscribe_format"); err != nil {
+		return err
+	}
+
+	var chatID uint
+	if request.JobID == 0 {
+		chatID = uint(request.ChatID)
+	}
 
-	for client := range m.clients {
+	if err := m.aiService.SubscribeJobs(client, chatID, request.JobID, client.UserID); err != nil {
+		return NewError("subscribe_job", err, "job_not_found")
+	}
+
+	return nil
+}
+
+// handleCancelJob stops one of the client's running generation jobs
+func (m *Manager) handleCancelJob(client *Client, content json.RawMessage) error {
+	var ref protocol.JobRef
+	if err := decodeRequest(content, &ref, "invalid_cancel_format"); err != nil {
+		return err
+	}
+
+	if err := m.aiService.CancelJob(ref.JobID, client.UserID); err != nil {
+		return NewError("cancel_job", err, "job_not_found")
+	}
+
+	return nil
+}
+
+// handleRetryMessage regenerates a failed or cancelled reply
+func (m *Manager) handleRetryMessage(client *Client, content json.RawMessage) error {
+	var ref protocol.MessageRef
+	if err := decodeRequest(content, &ref, "invalid_retry_format"); err != nil {
+		return err
+	}
+
+	if err := m.allow(client, ratelimit.ClassGenerations, 1); err != nil {
+		return err
+	}
+
+	if err := m.aiService.RetryMessage(client, ref.MessageID, client.UserID); err != nil {
+		return NewError("retry_message", err, "retry_failed")
+	}
+
+	return nil
+}
+
+// allow takes n tokens of a class from the client's rate limit
+func (m *Manager) allow(client *Client, class string, n int) error {
+	if m.rateLimiter == nil {
+		return nil
+	}
+	if ok, retryAfter := m.rateLimiter.AllowUser(client.UserID, client.Role, class, n); !ok {
+		return &RateLimitError{Class: class, RetryAfter: retryAfter}
+	}
+	return nil
+}
+
+// errorFrame converts a message handling error into the error frame sent to the client
+func errorFrame(err error) *Message {
+	var rateErr *RateLimitError
+	if errors.As(err, &rateErr) {
+		return NewRateLimitMessage(rateErr)
+	}
+	switch {
+	case errors.Is(err, credits.ErrBudgetExceeded):
+		return NewErrorMessage(err.Error(), "budget_exceeded")
+	case errors.Is(err, credits.ErrInsufficientCredits):
+		return NewErrorMessage(err.Error(), "insufficient_credits")
+	case errors.Is(err, providerkeys.ErrUnreadable):
+		return NewErrorMessage(providerkeys.ErrUnreadable.Error(), "provider_key_error")
+	case errors.Is(err, authz.ErrForbidden):
+		return NewErrorMessage(authz.ErrForbidden.Error(), "forbidden")
+	}
+	var wsErr *WebSocketError
+	if errors.As(err, &wsErr) && wsErr.Code != "" {
+		return NewErrorMessage(err.Error(), wsErr.Code)
+	}
+	return NewErrorMessage(err.Error(), "message_error")
+}
+
+// BroadcastToUser broadcasts a message to a specific user on every instance
+func (m *Manager) BroadcastToUser(userID uint, message interface{}) {
+	m.sendToUser(userID, message)
+
+	if m.cluster != nil {
+		raw, err := json.Marshal(message)
+		if err != nil {
+			log.Printf("Error marshaling message for user %d: %v", userID, err)
+			return
+		}
+		m.relay(clusterUserFrame, userFrame{UserID: userID, Message: raw})
+	}
+}
+
+// sendToUser sends a message to the connections of a user on this instance
+func (m *Manager) sendToUser(userID uint, message interface{}) {
+	for _, client := range m.clientList() {
 		if client.UserID == userID {
 			if err := client.SendJSON(message); err != nil {
-				log.Printf("Error sending message to user %s: %v", userID, err)
+				log.Printf("Error sending message to user %d: %v", userID, err)
 			}
 		}
 	}
 }
 
-// Broadcast broadcasts a message to all clients
+// DisconnectUser closes all connections of a user, e.g. after an admin ended their sessions.
+// Other instances close theirs too; the number returned counts the ones closed on this instance.
+func (m *Manager) DisconnectUser(userID uint, reason string) int {
+	closed := m.disconnectUser(userID, reason)
+	m.relay(clusterDisconnect, disconnectRequest{UserID: userID, Reason: reason})
+	return closed
+}
+
+// disconnectUser closes the connections of a user on this instance
+func (m *Manager) disconnectUser(userID uint, reason string) int {
+	var clients []*Client
+	for _, client := range m.clientList() {
+		if client.UserID == userID {
+			clients = append(clients, client)
+		}
+	}
+
+	for _, client := range clients {
+		if err := client.CloseWithReason(CloseSessionExpired, reason); err != nil {
+			log.Printf("Error closing connection for user %d: %v", userID, err)
+		}
+	}
+
+	return len(clients)
+}
+
+// Broadcast broadcasts a message to all clients on every instance
 func (m *Manager) Broadcast(message interface{}) {
 	jsonMessage, err := json.Marshal(message)
 	if err != nil {
@@ -335,15 +751,32 @@ func (m *Manager) Broadcast(message interface{}) {
 		return
 	}
 
-	m.broadcast <- jsonMessage
+	m.sendToAll(jsonMessage)
+	m.relay(clusterBroadcast, json.RawMessage(jsonMessage))
+}
+
+// sendToAll sends an encoded message to every client on this instance
+func (m *Manager) sendToAll(message []byte) {
+	for _, client := range m.clientList() {
+		if err := client.SendText(string(message)); err != nil {
+			log.Printf("Error broadcasting to user %d: %v", client.UserID, err)
+		}
+	}
+}
+
+// clientList returns the connected clients
+func (m *Manager) clientList() []*Client {
+	var clients []*Client
+	m.clients.Range(func(key, _ interface{}) bool {
+		clients = append(clients, key.(*Client))
+		return true
+	})
+	return clients
 }
 
 // countClients returns the number of connected clients
 func (m *Manager) countClients() int {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	return len(m.clients)
+	return int(m.clientCount.Load())
 }
 
 // GetClientCount returns the number of connected clients
@@ -352,11 +785,8 @@ func (m *Manager) GetClientCount() int {
 }
 
 // GetClientByUserID returns a client by user ID
-func (m *Manager) GetClientByUserID(userID string) *Client {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-
-	for client := range m.clients {
+func (m *Manager) GetClientByUserID(userID uint) *Client {
+	for _, client := range m.clientList() {
 		if client.UserID == userID {
 			return client
 		}
@@ -1,129 +1,95 @@
 package websocket
 
 import (
-	"encoding/json"
-	"fmt"
-	"time"
+	"github.com/hra42/7x42/internal/events"
+	"github.com/hra42/7x42/internal/ratelimit"
+	"github.com/hra42/7x42/pkg/protocol"
 )
 
-// MessageType represents the type of WebSocket message
-type MessageType string
+// Message is a frame of the WebSocket protocol, see protocol.Envelope
+type Message = protocol.Envelope
 
-const (
-	// TypeChatMessage is a chat message
-	TypeChatMessage MessageType = "chat_message"
-	// TypeTyping indicates the user is typing
-	TypeTyping MessageType = "typing"
-	// TypePing is a ping message
-	TypePing MessageType = "ping"
-	// TypePong is a pong message
-	TypePong MessageType = "pong"
-	// TypeError is an error message
-	TypeError MessageType = "error"
-	// TypeSystem is a system message
-	TypeSystem MessageType = "system"
-)
-
-// Message represents a WebSocket message
-type Message struct {
-	Type    MessageType     `json:"type"`
-	Content json.RawMessage `json:"content"`
-}
+// Participant is a user following a chat
+type Participant = protocol.Participant
 
-// ChatMessage represents a chat message
-type ChatMessage struct {
-	ChatID    uint      `json:"chatId"`
-	Content   string    `json:"content"`
-	Role      string    `json:"role"`
-	Timestamp time.Time `json:"timestamp"`
+// NewTypingMessage creates a new typing message
+func NewTypingMessage(chatID, userID uint, name string, typing bool) *Message {
+	message := protocol.NewEnvelope(protocol.TypeTyping, protocol.Typing{
+		ChatID: chatID,
+		UserID: userID,
+		Name:   name,
+		Typing: typing,
+	})
+	message.ChatID = uint64(chatID)
+	return message
 }
 
-// ChatMessageRaw is used for parsing chat messages
-type ChatMessageRaw struct {
-	ChatID    interface{} `json:"chatId"`
-	Content   string      `json:"content"`
-	Role      string      `json:"role"`
-	Timestamp time.Time   `json:"timestamp"`
+// NewPresenceMessage creates a new presence message
+func NewPresenceMessage(chatID uint, participants []Participant) *Message {
+	message := protocol.NewEnvelope(protocol.TypePresence, protocol.Presence{
+		ChatID:       chatID,
+		Participants: participants,
+	})
+	message.ChatID = uint64(chatID)
+	return message
 }
 
-// ErrorMessage represents an error message
-type ErrorMessage struct {
-	Message string `json:"message"`
-	Code    string `json:"code,omitempty"`
+// NewSessionMessage creates the message opening a connection
+func NewSessionMessage(resumeToken string, resumed bool, seq uint64) *Message {
+	return protocol.NewEnvelope(protocol.TypeSession, protocol.Session{ResumeToken: resumeToken, Resumed: resumed, Seq: seq})
 }
 
-// SystemMessage represents a system message
-type SystemMessage struct {
-	Message string `json:"message"`
+// NewTopicMessage creates a subscribed or unsubscribed message
+func NewTopicMessage(msgType protocol.Type, topic string) *Message {
+	return protocol.NewEnvelope(msgType, protocol.TopicRef{Topic: topic})
 }
 
-// NewChatMessage creates a new chat message
-func NewChatMessage(chatID uint, content, role string) *Message {
-	chatMsg := ChatMessage{
-		ChatID:    chatID,
-		Content:   content,
-		Role:      role,
-		Timestamp: time.Now(),
+// NewEventMessage creates a message carrying a published event; events of a chat name it
+func NewEventMessage(event events.Event) *Message {
+	message := protocol.NewEnvelope(protocol.TypeEvent, protocol.Event{
+		Topic:     event.Topic,
+		Event:     event.Name,
+		Data:      event.Data,
+		Timestamp: event.Timestamp,
+	})
+	if kind, id, err := events.ParseTopic(event.Topic); err == nil && kind == events.KindChat {
+		message.ChatID = id
 	}
+	return message
+}
 
-	contentBytes, _ := json.Marshal(chatMsg)
-
-	return &Message{
-		Type:    TypeChatMessage,
-		Content: contentBytes,
-	}
+// NewAckMessage creates the message acknowledging a request
+func NewAckMessage(requestID string) *Message {
+	message := protocol.NewEnvelope(protocol.TypeAck, nil)
+	message.CorrelationID = requestID
+	return message
 }
 
-// NewTypingMessage creates a new typing message
-func NewTypingMessage() *Message {
-	return &Message{
-		Type: TypeTyping,
-	}
+// NewPongMessage creates the message answering a ping
+func NewPongMessage() *Message {
+	return protocol.NewEnvelope(protocol.TypePong, nil)
 }
 
 // NewErrorMessage creates a new error message
 func NewErrorMessage(message, code string) *Message {
-	errMsg := ErrorMessage{
+	return protocol.NewEnvelope(protocol.TypeError, protocol.Error{
 		Message: message,
 		Code:    code,
-	}
-
-	contentBytes, _ := json.Marshal(errMsg)
+	})
+}
 
-	return &Message{
-		Type:    TypeError,
-		Content: contentBytes,
-	}
+// NewRateLimitMessage creates a rate_limited error message for a rate limit error
+func NewRateLimitMessage(err *RateLimitError) *Message {
+	return protocol.NewEnvelope(protocol.TypeError, protocol.Error{
+		Message:    err.Error(),
+		Code:       "rate_limited",
+		RetryAfter: ratelimit.RetryAfterSeconds(err.RetryAfter),
+	})
 }
 
 // NewSystemMessage creates a new system message
 func NewSystemMessage(message string) *Message {
-	sysMsg := SystemMessage{
+	return protocol.NewEnvelope(protocol.TypeSystem, protocol.System{
 		Message: message,
-	}
-
-	contentBytes, _ := json.Marshal(sysMsg)
-
-	return &Message{
-		Type:    TypeSystem,
-		Content: contentBytes,
-	}
-}
-
-// ParseChatID parses a chat ID from different types
-func ParseChatID(rawID interface{}) (uint, error) {
-	switch v := rawID.(type) {
-	case float64:
-		return uint(v), nil
-	case int:
-		return uint(v), nil
-	case string:
-		var id uint
-		if _, err := fmt.Sscanf(v, "%d", &id); err != nil {
-			return 0, err
-		}
-		return id, nil
-	default:
-		return 0, fmt.Errorf("invalid chat ID format: %v (type: %T)", rawID, rawID)
-	}
+	})
 }
@@ -0,0 +1,89 @@
+package websocket
+
+import (
+	"strconv"
+)
+
+const (
+	// DefaultReplayFrames bounds the number of frames kept for a client to resume with
+	DefaultReplayFrames = 512
+
+	// DefaultReplayBytes bounds the size of the frames kept for a client to resume with
+	DefaultReplayBytes = 4 * 1024 * 1024
+)
+
+// replayFrame is a frame as it was sent, with its sequence number
+type replayFrame struct {
+	seq  uint64
+	data []byte
+}
+
+// replayBuffer keeps the latest frames sent in a session, dropping the oldest ones
+// once it holds more than maxFrames frames or maxBytes bytes
+type replayBuffer struct {
+	frames    []replayFrame
+	bytes     int
+	maxFrames int
+	maxBytes  int
+}
+
+// newReplayBuffer creates a replay buffer with the given limits
+func newReplayBuffer(maxFrames, maxBytes int) *replayBuffer {
+	if maxFrames <= 0 {
+		maxFrames = DefaultReplayFrames
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultReplayBytes
+	}
+	return &replayBuffer{maxFrames: maxFrames, maxBytes: maxBytes}
+}
+
+// add records a frame, dropping the oldest ones beyond the limits
+func (b *replayBuffer) add(seq uint64, data []byte) {
+	b.frames = append(b.frames, replayFrame{seq: seq, data: data})
+	b.bytes += len(data)
+
+	for len(b.frames) > b.maxFrames || (b.bytes > b.maxBytes && len(b.frames) > 1) {
+		b.bytes -= len(b.frames[0].data)
+		b.frames[0] = replayFrame{}
+		b.frames = b.frames[1:]
+	}
+}
+
+// since returns the frames sent after seq, up to and including last. It reports false
+// if some of them were already dropped, in which case the session cannot be resumed.
+func (b *replayBuffer) since(seq, last uint64) ([][]byte, bool) {
+	if seq > last {
+		return nil, false
+	}
+	if seq == last {
+		return nil, true
+	}
+	if len(b.frames) == 0 || b.frames[0].seq > seq+1 {
+		return nil, false
+	}
+
+	missed := make([][]byte, 0, last-seq)
+	for _, frame := range b.frames {
+		if frame.seq > seq {
+			missed = append(missed, frame.data)
+		}
+	}
+	return missed, true
+}
+
+// stampFrame adds a session sequence number to an encoded JSON object. Other frames
+// are returned unchanged.
+func stampFrame(data []byte, seq uint64) []byte {
+	if len(data) < 2 || data[0] != '{' {
+		return data
+	}
+
+	stamped := make([]byte, 0, len(data)+32)
+	stamped = append(stamped, `{"sessionSeq":`...)
+	stamped = strconv.AppendUint(stamped, seq, 10)
+	if data[1] != '}' {
+		stamped = append(stamped, ',')
+	}
+	return append(stamped, data[1:]...)
+}
@@ -0,0 +1,268 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/hra42/7x42/internal/ai/service"
+	"github.com/hra42/7x42/internal/authz"
+	"github.com/hra42/7x42/internal/repository"
+	"github.com/hra42/7x42/pkg/protocol"
+)
+
+// roomMember is a connection following a chat
+type roomMember struct {
+	// canPrompt is false for members who can only watch
+	canPrompt bool
+}
+
+// handleJoinChat adds the client to a chat's room. From then on it receives every prompt
+// sent to the chat and every reply streamed in it, starting with the ones already running.
+func (m *Manager) handleJoinChat(client *Client, content json.RawMessage) error {
+	var ref protocol.ChatRef
+	if err := decodeRequest(content, &ref, "invalid_join_format"); err != nil {
+		return err
+	}
+	chatID := uint(ref.ChatID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	canPrompt, err := m.aiService.ChatAccess(ctx, client.UserID, chatID)
+	if err != nil {
+		return NewError("join_chat", err, "chat_not_found")
+	}
+
+	m.roomsMu.Lock()
+	room, ok := m.rooms[chatID]
+	if !ok {
+		room = make(map[*Client]*roomMember)
+		m.rooms[chatID] = room
+		m.relayRoomPresence(clusterRoomJoin, chatID)
+	}
+	room[client] = &roomMember{canPrompt: canPrompt && !client.ReadOnly}
+	m.roomsMu.Unlock()
+
+	if err := m.aiService.SubscribeJobs(client, chatID, 0, client.UserID); err != nil {
+		return NewError("subscribe_job", err, "job_not_found")
+	}
+
+	m.sendPresence(chatID)
+	return nil
+}
+
+// handleLeaveChat removes the client from a chat's room
+func (m *Manager) handleLeaveChat(client *Client, content json.RawMessage) error {
+	var ref protocol.ChatRef
+	if err := decodeRequest(content, &ref, "invalid_leave_format"); err != nil {
+		return err
+	}
+	chatID := uint(ref.ChatID)
+
+	if m.removeMember(chatID, client) {
+		m.sendPresence(chatID)
+	}
+	return nil
+}
+
+// handleTyping passes a typing indicator on to the other members of a chat's room.
+// Only members who may prompt can type.
+func (m *Manager) handleTyping(client *Client, content json.RawMessage) error {
+	var request protocol.TypingRequest
+	if err := decodeRequest(content, &request, "invalid_typing_format"); err != nil {
+		return err
+	}
+	chatID := uint(request.ChatID)
+
+	m.roomsMu.RLock()
+	member := m.rooms[chatID][client]
+	canPrompt := member != nil && member.canPrompt
+	m.roomsMu.RUnlock()
+
+	if member == nil {
+		return NewError("typing", ErrNotInRoom, "not_in_room")
+	}
+	if !canPrompt {
+		return NewError("typing", authz.ErrForbidden, "forbidden")
+	}
+
+	typing := NewTypingMessage(chatID, client.UserID, client.Name, request.Typing)
+	m.sendToRoom(chatID, client, typing)
+	m.relayToRoom(chatID, typing)
+	return nil
+}
+
+// ChatWatchers returns the connections following a chat, so generations stream to all of them.
+// Members connected to other instances are reached through the cluster, if there are any.
+func (m *Manager) ChatWatchers(chatID uint64) []service.StreamWriter {
+	members := m.roomMembers(uint(chatID))
+
+	writers := make([]service.StreamWriter, 0, len(members)+1)
+	for _, client := range members {
+		writers = append(writers, client)
+	}
+	if m.cluster != nil && m.remoteRooms.watched(uint(chatID)) {
+		writers = append(writers, m.remoteRoom(uint(chatID)))
+	}
+	return writers
+}
+
+// RefreshRoom rechecks in the background who may follow and prompt in a chat,
+// e.g. after its participants changed. Members who lost access are removed from the room.
+func (m *Manager) RefreshRoom(chatID uint) {
+	go m.refreshRoom(chatID)
+}
+
+// refreshRoom rechecks the members of a chat's room and tells them if anything changed
+func (m *Manager) refreshRoom(chatID uint) {
+	changed := false
+	for _, client := range m.roomMembers(chatID) {
+		if m.recheckMember(chatID, client) {
+			changed = true
+		}
+	}
+	if changed {
+		m.sendPresence(chatID)
+	}
+}
+
+// refreshRooms rechecks every room, catching workspace roles and memberships that changed
+func (m *Manager) refreshRooms() {
+	m.roomsMu.RLock()
+	chatIDs := make([]uint, 0, len(m.rooms))
+	for chatID := range m.rooms {
+		chatIDs = append(chatIDs, chatID)
+	}
+	m.roomsMu.RUnlock()
+
+	for _, chatID := range chatIDs {
+		m.refreshRoom(chatID)
+	}
+}
+
+// recheckMember authorizes a room member again and reports whether their access changed.
+// Database errors keep the member as they are.
+func (m *Manager) recheckMember(chatID uint, client *Client) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	canPrompt, err := m.aiService.ChatAccess(ctx, client.UserID, chatID)
+	if err != nil && !repository.IsNotFound(err) {
+		log.Printf("Error rechecking access of user %d to chat %d: %v", client.UserID, chatID, err)
+		return false
+	}
+	if err != nil {
+		if !m.removeMember(chatID, client) {
+			return false
+		}
+		if err := client.SendJSON(NewErrorMessage("You no longer have access to this chat", "chat_access_revoked")); err != nil {
+			log.Printf("Error notifying user %d about revoked access: %v", client.UserID, err)
+		}
+		return true
+	}
+
+	m.roomsMu.Lock()
+	defer m.roomsMu.Unlock()
+
+	member, ok := m.rooms[chatID][client]
+	if !ok {
+		return false
+	}
+	canPrompt = canPrompt && !client.ReadOnly
+	changed := member.canPrompt != canPrompt
+	member.canPrompt = canPrompt
+	return changed
+}
+
+// leaveRooms removes a disconnecting client from every room it joined
+func (m *Manager) leaveRooms(client *Client) {
+	m.roomsMu.RLock()
+	var joined []uint
+	for chatID, room := range m.rooms {
+		if _, ok := room[client]; ok {
+			joined = append(joined, chatID)
+		}
+	}
+	m.roomsMu.RUnlock()
+
+	for _, chatID := range joined {
+		if m.removeMember(chatID, client) {
+			m.sendPresence(chatID)
+		}
+	}
+}
+
+// removeMember removes a client from a chat's room, dropping the room once it is empty.
+// It reports whether the client was a member.
+func (m *Manager) removeMember(chatID uint, client *Client) bool {
+	m.roomsMu.Lock()
+	defer m.roomsMu.Unlock()
+
+	room, ok := m.rooms[chatID]
+	if !ok {
+		return false
+	}
+	if _, ok := room[client]; !ok {
+		return false
+	}
+	delete(room, client)
+	if len(room) == 0 {
+		delete(m.rooms, chatID)
+		m.relayRoomPresence(clusterRoomLeave, chatID)
+	}
+	return true
+}
+
+// roomMembers returns the connections in a chat's room
+func (m *Manager) roomMembers(chatID uint) []*Client {
+	m.roomsMu.RLock()
+	defer m.roomsMu.RUnlock()
+
+	room := m.rooms[chatID]
+	members := make([]*Client, 0, len(room))
+	for client := range room {
+		members = append(members, client)
+	}
+	return members
+}
+
+// sendPresence sends the users following a chat to everyone in its room.
+// A user with several connections is listed once.
+func (m *Manager) sendPresence(chatID uint) {
+	m.roomsMu.RLock()
+	byUser := make(map[uint]*Participant)
+	for client, member := range m.rooms[chatID] {
+		participant, ok := byUser[client.UserID]
+		if !ok {
+			participant = &Participant{UserID: client.UserID, Name: client.Name}
+			byUser[client.UserID] = participant
+		}
+		participant.CanPrompt = participant.CanPrompt || member.canPrompt
+	}
+	m.roomsMu.RUnlock()
+
+	participants := make([]Participant, 0, len(byUser))
+	for _, participant := range byUser {
+		participants = append(participants, *participant)
+	}
+	sort.Slice(participants, func(i, j int) bool {
+		return participants[i].UserID < participants[j].UserID
+	})
+
+	m.sendToRoom(chatID, nil, NewPresenceMessage(chatID, participants))
+}
+
+// sendToRoom sends a message to every member of a chat's room except the sender
+func (m *Manager) sendToRoom(chatID uint, sender *Client, message interface{}) {
+	for _, client := range m.roomMembers(chatID) {
+		if client == sender {
+			continue
+		}
+		if err := client.SendJSON(message); err != nil {
+			log.Printf("Error sending to user %d in chat %d: %v", client.UserID, chatID, err)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/hra42/7x42/internal/authz"
+	"github.com/hra42/7x42/internal/events"
+	"github.com/hra42/7x42/internal/repository"
+	"github.com/hra42/7x42/pkg/protocol"
+)
+
+// MaxTopicsPerClient bounds the number of topics a single connection may subscribe to
+const MaxTopicsPerClient = 100
+
+// handleSubscribe subscribes the client to a topic it may see
+func (m *Manager) handleSubscribe(client *Client, content json.RawMessage) error {
+	var ref protocol.TopicRef
+	if err := decodeRequest(content, &ref, "invalid_subscribe_format"); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := m.authorizeTopic(ctx, client, ref.Topic); err != nil {
+		return err
+	}
+
+	m.topicsMu.Lock()
+	if m.countTopicsLocked(client) >= MaxTopicsPerClient {
+		m.topicsMu.Unlock()
+		return NewError("subscribe", ErrTooManyTopics, "too_many_topics")
+	}
+	subscribers, ok := m.topics[ref.Topic]
+	if !ok {
+		subscribers = make(map[*Client]struct{})
+		m.topics[ref.Topic] = subscribers
+	}
+	subscribers[client] = struct{}{}
+	m.topicsMu.Unlock()
+
+	return client.SendJSON(NewTopicMessage(protocol.TypeSubscribed, ref.Topic))
+}
+
+// handleUnsubscribe removes one of the client's topic subscriptions
+func (m *Manager) handleUnsubscribe(client *Client, content json.RawMessage) error {
+	var ref protocol.TopicRef
+	if err := decodeRequest(content, &ref, "invalid_unsubscribe_format"); err != nil {
+		return err
+	}
+
+	m.unsubscribe(ref.Topic, client)
+	return client.SendJSON(NewTopicMessage(protocol.TypeUnsubscribed, ref.Topic))
+}
+
+// authorizeTopic checks that the client may follow a topic: its own user topic, chats
+// and workspaces it can read, and the system topic
+func (m *Manager) authorizeTopic(ctx context.Context, client *Client, topic string) error {
+	kind, id, err := events.ParseTopic(topic)
+	if err != nil {
+		return NewError("subscribe", ErrInvalidTopic, "invalid_topic")
+	}
+
+	denied := NewError("subscribe", ErrTopicDenied, "topic_denied")
+	switch kind {
+	case events.SystemTopic:
+		return nil
+	case events.KindUser:
+		if uint(id) != client.UserID {
+			return denied
+		}
+		return nil
+	}
+
+	if m.authorizer == nil {
+		return denied
+	}
+	if kind == events.KindChat {
+		_, err = m.authorizer.ChatInfo(ctx, client.UserID, id, authz.ActionRead)
+	} else {
+		_, _, err = m.authorizer.Workspace(ctx, client.UserID, uint(id), authz.ActionRead)
+	}
+	if err != nil {
+		if repository.IsNotFound(err) {
+			return denied
+		}
+		return NewError("subscribe", err, "subscribe_failed")
+	}
+	return nil
+}
+
+// deliver sends a published event to the clients subscribed to its topic
+func (m *Manager) deliver(event events.Event) {
+	m.topicsMu.RLock()
+	subscribers := make([]*Client, 0, len(m.topics[event.Topic]))
+	for client := range m.topics[event.Topic] {
+		subscribers = append(subscribers, client)
+	}
+	m.topicsMu.RUnlock()
+
+	if len(subscribers) == 0 {
+		return
+	}
+
+	message := NewEventMessage(event)
+	for _, client := range subscribers {
+		if err := client.SendJSON(message); err != nil {
+			log.Printf("Error delivering %s to user %d: %v", event.Name, client.UserID, err)
+		}
+	}
+}
+
+// refreshTopics drops the chat and workspace subscriptions of clients who lost access,
+// e.g. after being removed from a workspace. Database errors keep the subscription.
+func (m *Manager) refreshTopics() {
+	type subscription struct {
+		topic  string
+		client *Client
+	}
+
+	m.topicsMu.RLock()
+	var subscriptions []subscription
+	for topic, subscribers := range m.topics {
+		for client := range subscribers {
+			subscriptions = append(subscriptions, subscription{topic, client})
+		}
+	}
+	m.topicsMu.RUnlock()
+
+	for _, sub := range subscriptions {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := m.authorizeTopic(ctx, sub.client, sub.topic)
+		cancel()
+		if err == nil || !errors.Is(err, ErrTopicDenied) {
+			continue
+		}
+
+		m.unsubscribe(sub.topic, sub.client)
+		if err := sub.client.SendJSON(NewTopicMessage(protocol.TypeUnsubscribed, sub.topic)); err != nil {
+			log.Printf("Error notifying user %d about a revoked subscription: %v", sub.client.UserID, err)
+		}
+	}
+}
+
+// leaveTopics removes a disconnecting client from every topic
+func (m *Manager) leaveTopics(client *Client) {
+	m.topicsMu.Lock()
+	defer m.topicsMu.Unlock()
+
+	for topic, subscribers := range m.topics {
+		delete(subscribers, client)
+		if len(subscribers) == 0 {
+			delete(m.topics, topic)
+		}
+	}
+}
+
+// unsubscribe removes a client from a topic, dropping the topic once nobody follows it
+func (m *Manager) unsubscribe(topic string, client *Client) {
+	m.topicsMu.Lock()
+	defer m.topicsMu.Unlock()
+
+	subscribers, ok := m.topics[topic]
+	if !ok {
+		return
+	}
+	delete(subscribers, client)
+	if len(subscribers) == 0 {
+		delete(m.topics, topic)
+	}
+}
+
+// countTopicsLocked counts the client's subscriptions; topicsMu must be held
+func (m *Manager) countTopicsLocked(client *Client) int {
+	n := 0
+	for _, subscribers := range m.topics {
+		if _, ok := subscribers[client]; ok {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,194 @@
+package workspaces
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hra42/7x42/internal/authz"
+	"github.com/hra42/7x42/internal/models"
+	"github.com/hra42/7x42/internal/repository"
+	"gorm.io/gorm"
+)
+
+// Workspace errors
+var (
+	ErrInvalidName   = errors.New("workspace name must be between 1 and 255 characters")
+	ErrInvalidRole   = errors.New("invalid workspace role")
+	ErrLastOwner     = errors.New("a workspace needs at least one owner")
+	ErrAlreadyMember = errors.New("user is already a member of the workspace")
+)
+
+// Settings are the editable fields of a workspace
+type Settings struct {
+	Name         string
+	SystemPrompt string
+	DefaultModel string
+}
+
+// Service manages workspaces and their members. Callers authorize the acting member
+// first; the service enforces the rules between members, such as only owners
+// appointing or removing owners and every workspace keeping one.
+type Service struct {
+	repo     *repository.WorkspaceRepository
+	userRepo *repository.UserRepository
+}
+
+// NewService creates a new workspace service
+func NewService(db *gorm.DB) *Service {
+	return &Service{
+		repo:     repository.NewWorkspaceRepository(db),
+		userRepo: repository.NewUserRepository(db),
+	}
+}
+
+// List lists the workspaces a user is a member of
+func (s *Service) List(ctx context.Context, userID uint) ([]models.Workspace, error) {
+	return s.repo.ListUserWorkspaces(ctx, userID)
+}
+
+// Create creates a workspace owned by the user
+func (s *Service) Create(ctx context.Context, userID uint, settings Settings) (*models.Workspace, error) {
+	workspace := &models.Workspace{}
+	if err := apply(workspace, settings); err != nil {
+		return nil, err
+	}
+	if err := s.repo.CreateWorkspace(ctx, workspace, userID); err != nil {
+		return nil, err
+	}
+	return workspace, nil
+}
+
+// Update changes a workspace's name, persona and default model
+func (s *Service) Update(ctx context.Context, workspace *models.Workspace, settings Settings) error {
+	if err := apply(workspace, settings); err != nil {
+		return err
+	}
+	return s.repo.UpdateWorkspace(ctx, workspace)
+}
+
+// Delete deletes a workspace with its chats
+func (s *Service) Delete(ctx context.Context, workspaceID uint) error {
+	return s.repo.DeleteWorkspace(ctx, workspaceID)
+}
+
+// Members lists the members of a workspace
+func (s *Service) Members(ctx context.Context, workspaceID uint) ([]models.WorkspaceMember, error) {
+	return s.repo.ListMembers(ctx, workspaceID)
+}
+
+// AddMember adds the user with the given email to a workspace
+func (s *Service) AddMember(ctx context.Context, actor *models.WorkspaceMember, email, role string) (*models.WorkspaceMember, error) {
+	if err := checkRole(actor, role); err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetUserByEmail(ctx, strings.TrimSpace(email))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.repo.GetMember(ctx, actor.WorkspaceID, user.ID); err == nil {
+		return nil, ErrAlreadyMember
+	} else if !repository.IsNotFound(err) {
+		return nil, err
+	}
+
+	member := &models.WorkspaceMember{
+		WorkspaceID: actor.WorkspaceID,
+		UserID:      user.ID,
+		Role:        role,
+		User:        user,
+	}
+	if err := s.repo.SaveMember(ctx, member); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// UpdateMember changes the role of a member
+func (s *Service) UpdateMember(ctx context.Context, actor *models.WorkspaceMember, userID uint, role string) (*models.WorkspaceMember, error) {
+	if err := checkRole(actor, role); err != nil {
+		return nil, err
+	}
+
+	member, err := s.repo.GetMember(ctx, actor.WorkspaceID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if member.Role == models.WorkspaceRoleOwner {
+		if actor.Role != models.WorkspaceRoleOwner {
+			return nil, authz.ErrForbidden
+		}
+		if role != models.WorkspaceRoleOwner {
+			if err := s.keepOwner(ctx, actor.WorkspaceID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	member.Role = role
+	if err := s.repo.SaveMember(ctx, member); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// RemoveMember removes a member from a workspace; members may also remove themselves
+func (s *Service) RemoveMember(ctx context.Context, actor *models.WorkspaceMember, userID uint) error {
+	if actor.UserID != userID && !actor.CanManage() {
+		return authz.ErrForbidden
+	}
+
+	member, err := s.repo.GetMember(ctx, actor.WorkspaceID, userID)
+	if err != nil {
+		return err
+	}
+	if member.Role == models.WorkspaceRoleOwner {
+		if actor.Role != models.WorkspaceRoleOwner {
+			return authz.ErrForbidden
+		}
+		if err := s.keepOwner(ctx, actor.WorkspaceID); err != nil {
+			return err
+		}
+	}
+
+	return s.repo.RemoveMember(ctx, actor.WorkspaceID, userID)
+}
+
+// keepOwner fails if an owner is about to step down as the workspace's only owner
+func (s *Service) keepOwner(ctx context.Context, workspaceID uint) error {
+	owners, err := s.repo.CountOwners(ctx, workspaceID)
+	if err != nil {
+		return err
+	}
+	if owners <= 1 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// checkRole validates a role the actor wants to give; only owners appoint owners
+func checkRole(actor *models.WorkspaceMember, role string) error {
+	if !models.IsValidWorkspaceRole(role) {
+		return fmt.Errorf("%w %q", ErrInvalidRole, role)
+	}
+	if role == models.WorkspaceRoleOwner && actor.Role != models.WorkspaceRoleOwner {
+		return authz.ErrForbidden
+	}
+	return nil
+}
+
+// apply validates settings and copies them onto a workspace
+func apply(workspace *models.Workspace, settings Settings) error {
+	name := strings.TrimSpace(settings.Name)
+	if name == "" || len(name) > 255 {
+		return ErrInvalidName
+	}
+
+	workspace.Name = name
+	workspace.SystemPrompt = strings.TrimSpace(settings.SystemPrompt)
+	workspace.DefaultModel = strings.TrimSpace(settings.DefaultModel)
+	return nil
+}
@@ -0,0 +1,257 @@
+package protocol
+
+import (
+	"time"
+)
+
+// ChatRequest is the content of a chat_message request
+type ChatRequest struct {
+	ChatID    ID        `json:"chatId"`
+	Content   string    `json:"content"`
+	Role      string    `json:"role,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// CompareRequest is the content of a compare_message request
+type CompareRequest struct {
+	ChatID  ID       `json:"chatId"`
+	Content string   `json:"content"`
+	Models  []string `json:"models"`
+}
+
+// SubscribeJobRequest is the content of a subscribe_job request.
+// Either a single job or all running jobs of a chat can be resumed.
+type SubscribeJobRequest struct {
+	ChatID ID   `json:"chatId,omitempty"`
+	JobID  uint `json:"jobId,omitempty"`
+}
+
+// ChatRef is the content of requests that target a single chat: join_chat and leave_chat
+type ChatRef struct {
+	ChatID ID `json:"chatId"`
+}
+
+// TypingRequest is the content of a typing request; Typing is false once the user stopped
+type TypingRequest struct {
+	ChatID ID   `json:"chatId"`
+	Typing bool `json:"typing"`
+}
+
+// TopicRef is the content of subscribe and unsubscribe requests, and of the
+// subscribed and unsubscribed frames answering them
+type TopicRef struct {
+	Topic string `json:"topic"`
+}
+
+// JobRef is the content of a cancel_job request
+type JobRef struct {
+	JobID uint `json:"jobId"`
+}
+
+// MessageRef is the content of a retry_message request
+type MessageRef struct {
+	MessageID uint `json:"messageId"`
+}
+
+// ListChatsRequest is the content of a list_chats request. Without a scope every chat the
+// user can see is listed.
+type ListChatsRequest struct {
+	// Personal lists only the user's own chats
+	Personal bool `json:"personal,omitempty"`
+	// WorkspaceID lists only the chats of a workspace
+	WorkspaceID ID  `json:"workspaceId,omitempty"`
+	Page        int `json:"page,omitempty"`
+	PageSize    int `json:"pageSize,omitempty"`
+}
+
+// GetChatRequest is the content of a get_chat request
+type GetChatRequest struct {
+	ChatID ID `json:"chatId"`
+	// Limit is the number of latest messages to load
+	Limit int `json:"limit,omitempty"`
+}
+
+// ListMessagesRequest is the content of a list_messages request
+type ListMessagesRequest struct {
+	ChatID ID `json:"chatId"`
+	// Before is the ID of the oldest message the client has; zero starts from the newest
+	Before uint `json:"before,omitempty"`
+	Limit  int  `json:"limit,omitempty"`
+}
+
+// RenameChatRequest is the content of a rename_chat request
+type RenameChatRequest struct {
+	ChatID ID     `json:"chatId"`
+	Title  string `json:"title"`
+}
+
+// Session is the content of the session frame opening every connection
+type Session struct {
+	// ResumeToken is presented when reconnecting, as ?resume=<token>&lastSeq=<n>, to resume the session
+	ResumeToken string `json:"resumeToken"`
+	// Resumed is set when the connection resumed an earlier session and missed frames follow
+	Resumed bool `json:"resumed"`
+	// Seq is the sequence number of the last frame sent in the session
+	Seq uint64 `json:"seq"`
+}
+
+// Chunk is the content of chat_message and compare_message frames streaming a reply.
+// Replay chunks carry everything generated up to their Seq and replace what was shown.
+type Chunk struct {
+	JobID     uint      `json:"jobId"`
+	ChatID    uint64    `json:"chatId"`
+	MessageID *uint     `json:"messageId"`
+	Content   string    `json:"content"`
+	Role      string    `json:"role"`
+	Model     string    `json:"model,omitempty"`
+	Replay    bool      `json:"replay,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Completion is the metadata of the chat_message or compare_message frame ending a reply
+type Completion struct {
+	Complete  bool   `json:"complete"`
+	JobID     uint   `json:"jobId"`
+	ParentID  *uint  `json:"parentId"`
+	MessageID uint   `json:"messageId,omitempty"`
+	Usage     *Usage `json:"usage"`
+	// ProcessingTime is how long the generation took in milliseconds
+	ProcessingTime int `json:"processingTime,omitempty"`
+}
+
+// Usage is the number of tokens a generation used and what it cost
+type Usage struct {
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+// ModelRef is the content of the compare_message frame ending one model's reply
+type ModelRef struct {
+	Model string `json:"model"`
+}
+
+// JobStatus is the content of a generation_cancelled frame
+type JobStatus struct {
+	JobID     uint   `json:"jobId"`
+	ChatID    uint64 `json:"chatId"`
+	MessageID *uint  `json:"messageId"`
+}
+
+// Generating is the content of the typing frame announcing that a reply is being generated
+type Generating struct {
+	JobID  uint   `json:"jobId"`
+	ChatID uint64 `json:"chatId"`
+	Model  string `json:"model"`
+}
+
+// CompareComplete is the content of a compare_complete frame
+type CompareComplete struct {
+	ChatID    uint64    `json:"chatId"`
+	ParentID  uint      `json:"parentId"`
+	Models    []string  `json:"models"`
+	JobIDs    []uint    `json:"jobIds"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Participant is a user following a chat
+type Participant struct {
+	UserID uint   `json:"userId"`
+	Name   string `json:"name"`
+	// CanPrompt is false for users who can only watch
+	CanPrompt bool `json:"canPrompt"`
+}
+
+// Presence is the content of a presence frame
+type Presence struct {
+	ChatID       uint          `json:"chatId"`
+	Participants []Participant `json:"participants"`
+}
+
+// Typing is the content of the typing frame telling the users following a chat that someone is typing
+type Typing struct {
+	ChatID uint   `json:"chatId"`
+	UserID uint   `json:"userId"`
+	Name   string `json:"name"`
+	Typing bool   `json:"typing"`
+}
+
+// Event is the content of an event frame
+type Event struct {
+	Topic     string      `json:"topic"`
+	Event     string      `json:"event"`
+	Data      interface{} `json:"data"`
+	Timestamp time.Time   `json:"timestamp"`
+}
+
+// Error is the content of an error frame. Errors of a generation name its job.
+type Error struct {
+	Message string `json:"message"`
+	Code    string `json:"code,omitempty"`
+	// RetryAfter is the number of seconds to wait before retrying, for rate_limited errors
+	RetryAfter int    `json:"retryAfter,omitempty"`
+	JobID      uint   `json:"jobId,omitempty"`
+	ChatID     uint64 `json:"chatId,omitempty"`
+	MessageID  *uint  `json:"messageId,omitempty"`
+	Model      string `json:"model,omitempty"`
+}
+
+// System is the content of a system frame
+type System struct {
+	Message string `json:"message"`
+}
+
+// ChatInfo describes a chat
+type ChatInfo struct {
+	ID          uint      `json:"id"`
+	Title       string    `json:"title"`
+	CreatedAt   time.Time `json:"createdAt"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+	LastMessage time.Time `json:"lastMessage"`
+	UserID      uint      `json:"userId"`
+	WorkspaceID *uint     `json:"workspaceId"`
+}
+
+// Pagination describes a page of a list
+type Pagination struct {
+	Page       int   `json:"page"`
+	PageSize   int   `json:"pageSize"`
+	Total      int64 `json:"total"`
+	TotalPages int64 `json:"totalPages"`
+}
+
+// Chats is the content of a chats frame
+type Chats struct {
+	Chats      []ChatInfo `json:"chats"`
+	Pagination Pagination `json:"pagination"`
+}
+
+// Message is a stored message of a chat
+type Message struct {
+	ID        uint        `json:"id"`
+	Content   string      `json:"content"`
+	Role      string      `json:"role"`
+	Timestamp time.Time   `json:"timestamp"`
+	Metadata  interface{} `json:"metadata"`
+	ParentID  *uint       `json:"parentId"`
+	UserID    *uint       `json:"userId"`
+	Status    string      `json:"status"`
+	Error     string      `json:"error"`
+}
+
+// Chat is the content of a chat frame. Answering get_chat it carries the chat's latest
+// messages, oldest first; HasMore is set if older ones can be loaded with list_messages.
+type Chat struct {
+	ChatInfo
+	Messages []Message `json:"messages,omitempty"`
+	HasMore  bool      `json:"hasMore,omitempty"`
+}
+
+// Messages is the content of a messages frame: messages of a chat, oldest first
+type Messages struct {
+	ChatID   uint64    `json:"chatId"`
+	Messages []Message `json:"messages"`
+	// HasMore is set if there are even older messages
+	HasMore bool `json:"hasMore"`
+}
@@ -0,0 +1,191 @@
+// Package protocol defines the WebSocket protocol spoken between 7x42 and its clients:
+// the frame envelope, the message types and the content each type carries. It is shared
+// by the server, the web frontend and SDKs.
+//
+// The version is negotiated with the WebSocket subprotocol: clients offering
+// Subprotocol(Version2) speak version 2, connections without a subprotocol speak version 1.
+// Both use the same frames. Version 2 adds acknowledgements: a request carrying an ID is
+// answered with an ack frame once accepted, or an error frame if rejected, both carrying
+// the request's ID as their correlation ID.
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Protocol versions
+const (
+	// Version1 is spoken by clients that do not negotiate a subprotocol
+	Version1 = 1
+	// Version2 adds acknowledgements of requests
+	Version2 = 2
+	// LatestVersion is the newest version the server speaks
+	LatestVersion = Version2
+)
+
+// subprotocolPrefix is followed by the version number in subprotocol names
+const subprotocolPrefix = "7x42.v"
+
+// Subprotocol returns the WebSocket subprotocol name of a version, e.g. "7x42.v2"
+func Subprotocol(version int) string {
+	return subprotocolPrefix + strconv.Itoa(version)
+}
+
+// Subprotocols lists the subprotocols the server accepts, newest first. Version 1 has
+// none; it is spoken when no subprotocol is negotiated.
+func Subprotocols() []string {
+	var names []string
+	for version := LatestVersion; version > Version1; version-- {
+		names = append(names, Subprotocol(version))
+	}
+	return names
+}
+
+// ParseSubprotocol returns the version a negotiated subprotocol stands for.
+// An empty or unknown subprotocol means version 1.
+func ParseSubprotocol(name string) int {
+	version, err := strconv.Atoi(strings.TrimPrefix(name, subprotocolPrefix))
+	if err != nil || !strings.HasPrefix(name, subprotocolPrefix) || version < Version1 || version > LatestVersion {
+		return Version1
+	}
+	return version
+}
+
+// Type is the type of a frame
+type Type string
+
+// Requests sent by clients
+const (
+	// TypeChatMessage sends a prompt to a chat; the reply streams back as chat_message frames
+	TypeChatMessage Type = "chat_message"
+	// TypeCompareMessage sends one prompt to several models side by side
+	TypeCompareMessage Type = "compare_message"
+	// TypeSubscribeJob resumes streaming of running generation jobs
+	TypeSubscribeJob Type = "subscribe_job"
+	// TypeCancelJob stops a running generation job
+	TypeCancelJob Type = "cancel_job"
+	// TypeRetryMessage regenerates a failed or cancelled reply
+	TypeRetryMessage Type = "retry_message"
+	// TypeJoinChat follows a chat with everyone else in it, see TypePresence
+	TypeJoinChat Type = "join_chat"
+	// TypeLeaveChat stops following a chat
+	TypeLeaveChat Type = "leave_chat"
+	// TypeSubscribe follows a topic such as "chat:42"
+	TypeSubscribe Type = "subscribe"
+	// TypeUnsubscribe stops following a topic
+	TypeUnsubscribe Type = "unsubscribe"
+	// TypeTyping tells the others in a chat that the user is typing; from the server, it
+	// also announces that a reply is being generated
+	TypeTyping Type = "typing"
+	// TypePing is answered with a pong
+	TypePing Type = "ping"
+	// TypeListChats lists the user's chats, answered with a chats frame
+	TypeListChats Type = "list_chats"
+	// TypeGetChat loads a chat with its latest messages, answered with a chat frame
+	TypeGetChat Type = "get_chat"
+	// TypeListMessages loads the messages sent before a given one, answered with a messages frame
+	TypeListMessages Type = "list_messages"
+	// TypeRenameChat changes the title of a chat, answered with a chat frame
+	TypeRenameChat Type = "rename_chat"
+	// TypeDeleteChat deletes a chat, answered with a chat_deleted frame
+	TypeDeleteChat Type = "delete_chat"
+)
+
+// Frames sent by the server
+const (
+	// TypeAck acknowledges a request that carried an ID (version 2)
+	TypeAck Type = "ack"
+	// TypeSession opens every connection, telling the client how to resume its session
+	TypeSession Type = "session"
+	// TypePresence lists the users following a chat
+	TypePresence Type = "presence"
+	// TypeParticipantMessage shows a prompt another participant sent to a chat
+	TypeParticipantMessage Type = "participant_message"
+	// TypeCompareComplete announces that every model of a comparison has finished
+	TypeCompareComplete Type = "compare_complete"
+	// TypeGenerationCancelled reports a generation stopped by a user
+	TypeGenerationCancelled Type = "generation_cancelled"
+	// TypeSubscribed confirms a subscription
+	TypeSubscribed Type = "subscribed"
+	// TypeUnsubscribed confirms an unsubscription, or reports one the server ended
+	TypeUnsubscribed Type = "unsubscribed"
+	// TypeEvent carries an event published to a subscribed topic
+	TypeEvent Type = "event"
+	// TypePong answers a ping
+	TypePong Type = "pong"
+	// TypeError reports a rejected request or a failed generation
+	TypeError Type = "error"
+	// TypeSystem is a system message
+	TypeSystem Type = "system"
+	// TypeChats answers list_chats
+	TypeChats Type = "chats"
+	// TypeChat answers get_chat and rename_chat
+	TypeChat Type = "chat"
+	// TypeMessages answers list_messages
+	TypeMessages Type = "messages"
+	// TypeChatDeleted answers delete_chat
+	TypeChatDeleted Type = "chat_deleted"
+)
+
+// Writes reports whether requests of this type change chats or start generations
+func (t Type) Writes() bool {
+	switch t {
+	case TypeChatMessage, TypeCompareMessage, TypeCancelJob, TypeRetryMessage, TypeRenameChat, TypeDeleteChat:
+		return true
+	}
+	return false
+}
+
+// Envelope is a frame of the protocol. Content depends on the type; the content types
+// of this package name the frames they belong to.
+type Envelope struct {
+	Type Type `json:"type"`
+	// ID identifies a request. Clients choose it; frames caused by the request carry it as CorrelationID.
+	ID string `json:"id,omitempty"`
+	// CorrelationID is the ID of the request a server frame answers or was caused by
+	CorrelationID string `json:"correlationId,omitempty"`
+	// ChatID is the chat a server frame concerns, if any
+	ChatID uint64 `json:"chatId,omitempty"`
+	// SessionSeq numbers the frames the server sent in a session, for resuming it; see Session
+	SessionSeq uint64 `json:"sessionSeq,omitempty"`
+	// Seq numbers the chunks of a generation; a gap means chunks were lost
+	Seq uint64 `json:"seq,omitempty"`
+	// Content is the frame's payload
+	Content json.RawMessage `json:"content,omitempty"`
+	// Metadata describes a finished generation, see Completion
+	Metadata json.RawMessage `json:"metadata,omitempty"`
+}
+
+// NewEnvelope creates a frame carrying content
+func NewEnvelope(t Type, content interface{}) *Envelope {
+	envelope := &Envelope{Type: t}
+	if content != nil {
+		envelope.Content, _ = json.Marshal(content)
+	}
+	return envelope
+}
+
+// ErrInvalidID is returned when a request carries an ID that is not a number
+var ErrInvalidID = errors.New("invalid ID")
+
+// ID is a chat or workspace ID in a request; clients may send it as a number or a numeric string
+type ID uint64
+
+// UnmarshalJSON accepts 42 as well as "42"
+func (id *ID) UnmarshalJSON(data []byte) error {
+	raw := strings.Trim(string(data), `"`)
+	if raw == "" || raw == "null" {
+		*id = 0
+		return nil
+	}
+	value, err := strconv.ParseUint(raw, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w %s", ErrInvalidID, data)
+	}
+	*id = ID(value)
+	return nil
+}
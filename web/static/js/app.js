@@ -1,5 +1,7 @@
 import Alpine from 'alpinejs'
+import './auth.js'
 import './chat.js'
+import './settings.js'
 
 // Initialize Alpine
 window.Alpine = Alpine
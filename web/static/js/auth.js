@@ -0,0 +1,76 @@
+// Login and registration form logic
+document.addEventListener('alpine:init', () => {
+    Alpine.data('authForm', () => ({
+        mode: 'login',
+        name: '',
+        email: '',
+        password: '',
+        code: '',
+        challenge: '',
+        error: '',
+        isLoading: false,
+
+        submit() {
+            if (this.isLoading) return;
+            this.isLoading = true;
+            this.error = '';
+
+            const body = { email: this.email, password: this.password };
+            if (this.mode === 'register') {
+                body.name = this.name;
+            }
+
+            fetch(`/api/v1/auth/${this.mode}`, {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                },
+                body: JSON.stringify(body)
+            })
+                .then(response => response.json().then(data => ({ ok: response.ok, data })))
+                .then(({ ok, data }) => {
+                    if (!ok) {
+                        throw new Error(data.error || 'Authentication failed');
+                    }
+                    if (data.secondFactor) {
+                        this.challenge = data.challenge;
+                        this.mode = 'verify';
+                        this.isLoading = false;
+                        this.$nextTick(() => this.$refs.code.focus());
+                        return;
+                    }
+                    window.location.href = this.$root.dataset.next || '/chat';
+                })
+                .catch(error => {
+                    this.error = error.message;
+                    this.isLoading = false;
+                });
+        },
+
+        verify() {
+            if (this.isLoading) return;
+            this.isLoading = true;
+            this.error = '';
+
+            fetch('/api/v1/auth/login/verify', {
+                method: 'POST',
+                headers: {
+                    'Content-Type': 'application/json',
+                },
+                body: JSON.stringify({ challenge: this.challenge, code: this.code })
+            })
+                .then(response => response.json().then(data => ({ ok: response.ok, data })))
+                .then(({ ok, data }) => {
+                    if (!ok) {
+                        throw new Error(data.error || 'Verification failed');
+                    }
+                    window.location.href = this.$root.dataset.next || '/chat';
+                })
+                .catch(error => {
+                    this.error = error.message;
+                    this.code = '';
+                    this.isLoading = false;
+                });
+        }
+    }))
+})
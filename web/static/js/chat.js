@@ -1,18 +1,48 @@
 // Chat application logic
+
+// Explanations for error codes that stop a generation before it starts; null shows the server's message
+const blockedGenerationMessages = {
+    budget_exceeded: 'You have reached your spending limit. Ask an administrator to raise it or wait until it resets.',
+    insufficient_credits: 'You have no credits left. Ask an administrator to grant you more.',
+    provider_key_error: null
+};
+
 document.addEventListener('alpine:init', () => {
     Alpine.data('chatApp', () => ({
         messages: [],
         newMessage: '',
         isLoading: false,
         isTyping: false,
-        userId: 'user-' + Date.now(),
+        userId: null,
         ws: null,
         chatId: new URLSearchParams(window.location.search).get('id') || 'new',
+        // New chats are shared with this workspace, if set
+        workspaceId: parseInt(new URLSearchParams(window.location.search).get('workspace'), 10) || null,
         messagesLoading: true,
         loadError: null,
         reconnectAttempts: 0,
+        compareModels: '',
+        runningJobs: {},
+        share: null,
+        shareError: '',
+        // Everyone following the chat, and who of them is typing right now
+        participants: [],
+        typingUsers: {},
+        canPrompt: true,
+        lastTypingSent: 0,
+        // A dropped connection resumes its session and receives the frames sent after lastSessionSeq
+        resumeToken: null,
+        lastSessionSeq: 0,
+        // Requests carry an ID; the server acknowledges them and names them in the frames they cause
+        requestSeq: 0,
+        // The requests loading the chat and its older messages, matched by the correlationId of the answers
+        loadRequest: null,
+        olderRequest: null,
+        hasOlderMessages: false,
+        olderLoading: false,
 
         init() {
+            this.userId = this.$el.dataset.userId;
             this.loadMessages();
             this.connectWebSocket();
         },
@@ -26,35 +56,46 @@ document.addEventListener('alpine:init', () => {
             }
             this.messagesLoading = true;
             this.loadError = null;
-            // Fetch chat history from API
-            fetch(`/api/v1/chat/${this.chatId}`)
-                .then(response => {
-                    if (!response.ok) {
-                        throw new Error(`Failed to load messages: ${response.status}`);
-                    }
-                    return response.json();
-                })
-                .then(data => {
-                    if (data.messages && Array.isArray(data.messages)) {
-                        this.messages = data.messages.map(msg => ({
-                            role: msg.role,
-                            content: msg.content,
-                            timestamp: new Date(msg.timestamp)
-                        }));
-                    } else {
-                        this.messages = [];
-                    }
-                    this.messagesLoading = false;
-                    // Scroll to bottom after messages are rendered
-                    this.$nextTick(() => {
-                        this.scrollToBottom();
-                    });
-                })
-                .catch(error => {
-                    console.error('Error loading messages:', error);
-                    this.loadError = 'Failed to load chat history. Please try again.';
-                    this.messagesLoading = false;
-                });
+            // The history arrives as a chat frame; it is requested again whenever a new session opens
+            if (!this.ws || this.ws.readyState !== WebSocket.OPEN) return;
+            this.loadRequest = this.sendRequest('get_chat', { chatId: this.chatId });
+        },
+
+        loadOlderMessages() {
+            const oldest = this.messages.find(m => m.id);
+            if (!oldest || this.olderLoading || !this.ws || this.ws.readyState !== WebSocket.OPEN) return;
+            this.olderLoading = true;
+            this.olderRequest = this.sendRequest('list_messages', { chatId: this.chatId, before: oldest.id });
+        },
+
+        showChat(chat) {
+            this.messages = (chat.messages || []).map(msg => this.storedMessage(msg));
+            this.hasOlderMessages = chat.hasMore;
+            this.messagesLoading = false;
+            this.joinChat();
+            // Scroll to bottom after messages are rendered
+            this.$nextTick(() => {
+                this.scrollToBottom();
+            });
+        },
+
+        showOlderMessages(page) {
+            this.messages.unshift(...page.messages.map(msg => this.storedMessage(msg)));
+            this.hasOlderMessages = page.hasMore;
+            this.olderLoading = false;
+        },
+
+        storedMessage(msg) {
+            return {
+                id: msg.id,
+                status: msg.status,
+                error: msg.error,
+                role: msg.role,
+                userId: msg.userId,
+                content: msg.content,
+                model: msg.parentId && msg.metadata ? msg.metadata.model : null,
+                timestamp: new Date(msg.timestamp)
+            };
         },
 
         connectWebSocket() {
@@ -63,7 +104,10 @@ document.addEventListener('alpine:init', () => {
             }
 
             const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-            this.ws = new WebSocket(`${protocol}//${window.location.host}/ws/${this.userId}`);
+            const resume = this.resumeToken
+                ? `?resume=${encodeURIComponent(this.resumeToken)}&lastSeq=${this.lastSessionSeq}`
+                : '';
+            this.ws = new WebSocket(`${protocol}//${window.location.host}/ws${resume}`, ['7x42.v2']);
 
             this.ws.onopen = () => {
                 console.log('Connected to WebSocket');
@@ -73,34 +117,103 @@ document.addEventListener('alpine:init', () => {
 
             this.ws.onmessage = (event) => {
                 const message = JSON.parse(event.data);
-                if (message.type === 'chat_message') {
-                    if (message.content) {
-                        // Handle streaming chunks
-                        const chatMessage = message.content;
-                        // If this is the first chunk of a response, create a new message
-                        if (this.isTyping) {
-                            this.isTyping = false;
-                            this.messages.push({
-                                role: 'assistant',
-                                content: chatMessage.content || '',
-                                timestamp: new Date(chatMessage.timestamp)
-                            });
-                        } else if (chatMessage.content) {
-                            // Append to the last message for streaming updates
-                            const lastMessage = this.messages[this.messages.length - 1];
-                            if (lastMessage && lastMessage.role === 'assistant') {
-                                lastMessage.content += chatMessage.content;
-                            }
-                        }
-                        this.scrollToBottom();
+                if (message.sessionSeq) {
+                    this.lastSessionSeq = message.sessionSeq;
+                }
+                if (message.type === 'session') {
+                    this.resumeToken = message.content.resumeToken;
+                    if (!message.content.resumed) {
+                        // A new session: reload the chat, which rejoins it and picks up generations
+                        // that kept running while we were away
+                        this.lastSessionSeq = 0;
+                        this.loadMessages();
+                    }
+                } else if (message.type === 'chat' && message.correlationId === this.loadRequest) {
+                    this.showChat(message.content);
+                } else if (message.type === 'messages' && message.correlationId === this.olderRequest) {
+                    this.showOlderMessages(message.content);
+                } else if (message.type === 'error' && message.correlationId && message.correlationId === this.loadRequest) {
+                    console.error('Error loading messages:', message.content.message);
+                    this.loadError = 'Failed to load chat history. Please try again.';
+                    this.messagesLoading = false;
+                } else if (message.type === 'error' && message.correlationId && message.correlationId === this.olderRequest) {
+                    this.olderLoading = false;
+                } else if (message.type === 'chat_message' || message.type === 'compare_message') {
+                    if (message.content && message.content.jobId) {
+                        this.handleChunk(message.content, message.seq);
                     } else if (message.metadata && message.metadata.complete) {
                         // Message is complete, can update UI if needed
                         console.log('Message complete, processing time:', message.metadata.processingTime);
-                        this.isLoading = false;
+                        const reply = this.messages.find(m => m.jobId === message.metadata.jobId);
+                        if (reply) {
+                            reply.status = 'complete';
+                        }
+                        this.finishJob(message.metadata.jobId);
+                    }
+                } else if (message.type === 'compare_complete') {
+                    this.isTyping = false;
+                    this.isLoading = false;
+                } else if (message.type === 'error' && message.content && message.content.jobId) {
+                    const reply = this.jobReply(message.content);
+                    reply.status = 'failed';
+                    reply.error = message.content.message;
+                    this.finishJob(message.content.jobId);
+                } else if (message.type === 'error' && message.content && message.content.code === 'rate_limited') {
+                    this.isTyping = false;
+                    this.isLoading = false;
+                    this.messages.push({
+                        role: 'system',
+                        content: `You are sending messages too quickly. Try again in ${message.content.retryAfter} seconds.`,
+                        timestamp: new Date()
+                    });
+                    this.scrollToBottom();
+                } else if (message.type === 'error' && message.content && message.content.code in blockedGenerationMessages) {
+                    this.isTyping = false;
+                    this.isLoading = false;
+                    this.messages.push({
+                        role: 'system',
+                        content: blockedGenerationMessages[message.content.code] || message.content.message,
+                        timestamp: new Date()
+                    });
+                    this.scrollToBottom();
+                } else if (message.type === 'generation_cancelled') {
+                    const reply = this.jobReply(message.content);
+                    reply.status = 'cancelled';
+                    this.finishJob(message.content.jobId);
+                } else if (message.type === 'error' && message.content && message.content.code === 'chat_access_revoked') {
+                    this.participants = [];
+                    this.canPrompt = false;
+                    this.messages.push({
+                        role: 'system',
+                        content: message.content.message,
+                        timestamp: new Date()
+                    });
+                    this.scrollToBottom();
+                } else if (message.type === 'presence' && this.isCurrentChat(message.content.chatId)) {
+                    this.participants = message.content.participants || [];
+                    const me = this.participants.find(p => String(p.userId) === String(this.userId));
+                    this.canPrompt = me ? me.canPrompt : true;
+                    // People who left stop typing
+                    Object.keys(this.typingUsers).forEach(userId => {
+                        if (!this.participants.some(p => String(p.userId) === userId)) {
+                            delete this.typingUsers[userId];
+                        }
+                    });
+                } else if (message.type === 'typing' && message.content && message.content.userId) {
+                    if (this.isCurrentChat(message.content.chatId)) {
+                        this.setTyping(message.content);
                     }
                 } else if (message.type === 'typing') {
                     this.isTyping = true;
                     this.scrollToBottom();
+                } else if (message.type === 'participant_message' && this.isCurrentChat(message.content.chatId)) {
+                    // Another participant prompted; their reply streams in like ours
+                    delete this.typingUsers[String(message.content.userId)];
+                    this.addUserMessage(message.content);
+                } else if (message.type === 'event' && message.content) {
+                    this.handleEvent(message.content);
+                } else if (message.type === 'ack') {
+                    // The request named by correlationId was accepted
                 } else if (message.type === 'pong') {
                     // Received pong from server
                 }
@@ -108,6 +221,15 @@ document.addEventListener('alpine:init', () => {
 
             this.ws.onclose = (event) => {
                 console.log(`WebSocket closed: ${event.code} ${event.reason}`);
+                if (event.code === 4401) {
+                    // The server ended our session; reconnecting would only be rejected
+                    this.checkAuth({ status: 401 });
+                    return;
+                }
+                // A rejected handshake looks like any other failure, so check the session first
+                if (this.reconnectAttempts > 0) {
+                    fetch('/api/v1/auth/me').then(response => this.checkAuth(response)).catch(() => {});
+                }
                 // Implement exponential backoff for reconnection
                 const delay = Math.min(1000 * Math.pow(1.5, this.reconnectAttempts), 10000);
                 this.reconnectAttempts++;
@@ -122,13 +244,174 @@ document.addEventListener('alpine:init', () => {
             // Handle ping/pong
             setInterval(() => {
                 if (this.ws && this.ws.readyState === WebSocket.OPEN) {
-                    this.ws.send(JSON.stringify({ type: "ping" }));
+                    this.sendRequest('ping');
                 }
             }, 30000);
         },
 
+        sendRequest(type, content) {
+            this.requestSeq++;
+            const id = String(this.requestSeq);
+            this.ws.send(JSON.stringify({ type: type, id: id, content: content }));
+            return id;
+        },
+
+        jobReply(chunk) {
+            // Each generation job streams into its own assistant bubble
+            let reply = this.messages.find(m => m.jobId === chunk.jobId ||
+                (chunk.messageId && m.id === chunk.messageId));
+            if (reply) {
+                // The message may have been loaded from history while still streaming
+                if (reply.jobId !== chunk.jobId) {
+                    reply.jobId = chunk.jobId;
+                    reply.status = 'streaming';
+                    reply.error = '';
+                    this.runningJobs[chunk.jobId] = true;
+                }
+            } else {
+                this.isTyping = false;
+                this.messages.push({
+                    id: chunk.messageId,
+                    status: 'streaming',
+                    role: 'assistant',
+                    jobId: chunk.jobId,
+                    model: chunk.model || null,
+                    content: '',
+                    timestamp: chunk.timestamp ? new Date(chunk.timestamp) : new Date()
+                });
+                reply = this.messages[this.messages.length - 1];
+                this.runningJobs[chunk.jobId] = true;
+            }
+            return reply;
+        },
+
+        handleChunk(chunk, seq) {
+            const reply = this.jobReply(chunk);
+            if (chunk.replay) {
+                // Replays carry everything delivered up to seq
+                reply.content = chunk.content || '';
+                reply.lastSeq = seq || 0;
+                this.isLoading = true;
+            } else if (reply.lastSeq !== undefined && seq !== reply.lastSeq + 1) {
+                // A frame went missing; ask for a replay instead of showing a broken answer
+                console.warn(`Gap in job ${chunk.jobId}: expected ${reply.lastSeq + 1}, got ${seq}`);
+                this.sendRequest('subscribe_job', { jobId: chunk.jobId });
+            } else {
+                reply.content += chunk.content || '';
+                reply.lastSeq = seq;
+            }
+            this.scrollToBottom();
+        },
+
+        finishJob(jobId) {
+            delete this.runningJobs[jobId];
+            if (Object.keys(this.runningJobs).length === 0) {
+                this.isTyping = false;
+                this.isLoading = false;
+            }
+        },
+
+        cancelGeneration() {
+            if (!this.ws || this.ws.readyState !== WebSocket.OPEN) return;
+            Object.keys(this.runningJobs).forEach(jobId => {
+                this.sendRequest('cancel_job', { jobId: Number(jobId) });
+            });
+        },
+
+        retryMessage(message) {
+            if (!message.id || !this.ws || this.ws.readyState !== WebSocket.OPEN) return;
+            message.content = '';
+            message.error = '';
+            message.status = 'pending';
+            this.isLoading = true;
+            this.sendRequest('retry_message', { messageId: message.id });
+        },
+
+        joinChat() {
+            // Joining follows the chat live and resumes its running generations
+            if (this.chatId === 'new' || !this.ws || this.ws.readyState !== WebSocket.OPEN) return;
+            this.sendRequest('join_chat', { chatId: this.chatId });
+            // Changes made elsewhere, e.g. in another tab, arrive as events
+            this.sendRequest('subscribe', { topic: `chat:${this.chatId}` });
+        },
+
+        handleEvent(event) {
+            if (event.topic !== `chat:${this.chatId}`) return;
+            if (event.event === 'chat.deleted') {
+                this.loadError = 'This chat was deleted.';
+            } else if (event.event === 'message.created' && event.data.role === 'user') {
+                this.addUserMessage(event.data);
+            }
+        },
+
+        addUserMessage(data) {
+            if (this.messages.some(m => m.id === data.id)) return;
+            // Our own prompt is already shown; it only lacked its ID
+            const own = this.messages.find(m => !m.id && m.role === 'user' && m.content === data.content);
+            if (own) {
+                own.id = data.id;
+                own.userId = data.userId;
+                return;
+            }
+            this.messages.push({
+                id: data.id,
+                status: data.status,
+                role: data.role,
+                userId: data.userId,
+                content: data.content,
+                timestamp: new Date(data.timestamp)
+            });
+            this.scrollToBottom();
+        },
+
+        isCurrentChat(chatId) {
+            return String(chatId) === String(this.chatId);
+        },
+
+        setTyping(typing) {
+            const userId = String(typing.userId);
+            if (this.typingUsers[userId]) {
+                clearTimeout(this.typingUsers[userId].timer);
+            }
+            if (!typing.typing) {
+                delete this.typingUsers[userId];
+                return;
+            }
+            // Indicators expire on their own in case the stop never arrives
+            this.typingUsers[userId] = {
+                name: typing.name,
+                timer: setTimeout(() => delete this.typingUsers[userId], 6000)
+            };
+        },
+
+        typingNames() {
+            return Object.values(this.typingUsers).map(t => t.name || 'Someone').join(', ');
+        },
+
+        authorName(message) {
+            if (!message.userId || String(message.userId) === String(this.userId)) return '';
+            const participant = this.participants.find(p => p.userId === message.userId);
+            return participant ? participant.name : '';
+        },
+
+        sendTyping(typing) {
+            if (this.chatId === 'new' || !this.canPrompt || !this.ws || this.ws.readyState !== WebSocket.OPEN) return;
+            if (this.participants.length < 2) return;
+            // Repeat the indicator at most every few seconds while the user keeps typing
+            const now = Date.now();
+            if (typing && now - this.lastTypingSent < 3000) return;
+            if (!typing && this.lastTypingSent === 0) return;
+            this.lastTypingSent = typing ? now : 0;
+            this.sendRequest('typing', { chatId: this.chatId, typing: typing });
+        },
+
+        parseCompareModels() {
+            return this.compareModels.split(',').map(m => m.trim()).filter(m => m);
+        },
+
         sendMessage() {
-            if (!this.newMessage.trim() || this.isLoading) return;
+            if (!this.newMessage.trim() || this.isLoading || !this.canPrompt) return;
+            this.sendTyping(false);
 
             const message = {
                 role: 'user',
@@ -154,10 +437,12 @@ document.addEventListener('alpine:init', () => {
                         'Content-Type': 'application/json',
                     },
                     body: JSON.stringify({
-                        title: messageText.substring(0, 30) + (messageText.length > 30 ? '...' : '')
+                        title: messageText.substring(0, 30) + (messageText.length > 30 ? '...' : ''),
+                        workspaceId: this.workspaceId
                     })
                 })
                     .then(response => {
+                        this.checkAuth(response);
                         if (!response.ok) {
                             throw new Error('Failed to create chat');
                         }
@@ -170,6 +455,7 @@ document.addEventListener('alpine:init', () => {
                         window.history.pushState({}, '', url);
                         // Update chatId
                         this.chatId = data.id;
+                        this.joinChat();
                         return data.id;
                     });
             }
@@ -178,16 +464,24 @@ document.addEventListener('alpine:init', () => {
             apiRequest
                 .then(chatId => {
                     this.isLoading = true;
-                    if (this.ws && this.ws.readyState === WebSocket.OPEN) {
-                        this.ws.send(JSON.stringify({
-                            type: 'chat_message',
-                            content: {
-                                chatId: chatId,
-                                content: messageText,
-                                role: 'user',
-                                timestamp: message.timestamp
-                            }
-                        }));
+                    const models = this.parseCompareModels();
+                    if (this.ws && this.ws.readyState === WebSocket.OPEN && models.length > 0) {
+                        this.sendRequest('compare_message', {
+                            chatId: chatId,
+                            content: messageText,
+                            models: models
+                        });
+                        setTimeout(() => {
+                            this.isTyping = true;
+                            this.scrollToBottom();
+                        }, 300);
+                    } else if (this.ws && this.ws.readyState === WebSocket.OPEN) {
+                        this.sendRequest('chat_message', {
+                            chatId: chatId,
+                            content: messageText,
+                            role: 'user',
+                            timestamp: message.timestamp
+                        });
                         // Show typing indicator
                         setTimeout(() => {
                             this.isTyping = true;
@@ -210,6 +504,47 @@ document.addEventListener('alpine:init', () => {
                 });
         },
 
+        shareChat(messageId) {
+            // Without a message the link follows the chat; with one it is a snapshot up to it
+            this.shareError = '';
+            fetch(`/api/v1/chat/${this.chatId}/share`, {
+                method: 'POST',
+                headers: { 'Content-Type': 'application/json' },
+                body: JSON.stringify(messageId ? { messageId } : {})
+            })
+                .then(response => {
+                    this.checkAuth(response);
+                    return response.json().then(data => ({ ok: response.ok, data }));
+                })
+                .then(({ ok, data }) => {
+                    if (!ok) {
+                        throw new Error(data.error || 'Failed to create share link');
+                    }
+                    this.share = data;
+                })
+                .catch(error => this.shareError = error.message);
+        },
+
+        revokeShare() {
+            if (!this.share) return;
+            fetch(`/api/v1/chat/${this.chatId}/share/${this.share.id}`, { method: 'DELETE' })
+                .then(response => {
+                    this.checkAuth(response);
+                    if (!response.ok) {
+                        throw new Error('Failed to revoke share link');
+                    }
+                    this.share = null;
+                })
+                .catch(error => this.shareError = error.message);
+        },
+
+        checkAuth(response) {
+            // The session expired or was revoked; sign in again and come back here
+            if (response.status === 401) {
+                window.location.href = '/login?next=' + encodeURIComponent(window.location.pathname + window.location.search);
+            }
+        },
+
         scrollToBottom() {
             setTimeout(() => {
                 const scrollAnchor = document.getElementById('scroll-anchor');
@@ -228,6 +563,13 @@ document.addEventListener('alpine:init', () => {
         formatMessage(content) {
             // Simple markdown-like formatting
             if (!content) return '';
+            // Escape HTML first; messages may come from other participants
+            content = content
+                .replace(/&/g, '&amp;')
+                .replace(/</g, '&lt;')
+                .replace(/>/g, '&gt;')
+                .replace(/"/g, '&quot;')
+                .replace(/'/g, '&#39;');
             // Format code blocks
             content = content.replace(/```(\w+)?\n([\s\S]*?)\n```/g, '<div class="code-block"><pre><code>$2</code></pre></div>');
             // Format inline code
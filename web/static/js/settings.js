@@ -0,0 +1,141 @@
+// Account settings: two-factor enrollment, recovery codes and the user's own provider keys
+
+// settingsRequest sends a JSON request, tracking loading and error state on the component
+function settingsRequest(component, method, url, body) {
+    component.isLoading = true;
+    component.error = '';
+
+    const options = { method, headers: {} };
+    if (body) {
+        options.headers['Content-Type'] = 'application/json';
+        options.body = JSON.stringify(body);
+    }
+
+    return fetch(url, options)
+        .then(response => {
+            if (response.status === 401) {
+                window.location.href = '/login?next=' + encodeURIComponent('/settings');
+            }
+            return response.json().then(data => ({ ok: response.ok, data }));
+        })
+        .then(({ ok, data }) => {
+            component.isLoading = false;
+            if (!ok) {
+                throw new Error(data.error || 'Request failed');
+            }
+            return data;
+        })
+        .catch(error => {
+            component.isLoading = false;
+            component.error = error.message;
+            throw error;
+        });
+}
+
+document.addEventListener('alpine:init', () => {
+    Alpine.data('securitySettings', () => ({
+        status: { enabled: false, required: false, recoveryCodesLeft: 0 },
+        enrollment: null,
+        recoveryCodes: null,
+        code: '',
+        error: '',
+        isLoading: false,
+
+        init() {
+            this.loadStatus();
+        },
+
+        loadStatus() {
+            this.request('GET', '/api/v1/auth/totp')
+                .then(data => this.status = data)
+                .catch(() => {});
+        },
+
+        enroll() {
+            this.request('POST', '/api/v1/auth/totp/enroll')
+                .then(data => {
+                    this.enrollment = data;
+                    this.code = '';
+                })
+                .catch(() => {});
+        },
+
+        confirm() {
+            this.request('POST', '/api/v1/auth/totp/confirm', { code: this.code })
+                .then(data => {
+                    this.enrollment = null;
+                    this.recoveryCodes = data.recoveryCodes;
+                    this.code = '';
+                    this.loadStatus();
+                })
+                .catch(() => {});
+        },
+
+        regenerate() {
+            this.request('POST', '/api/v1/auth/totp/recovery-codes', { code: this.code })
+                .then(data => {
+                    this.recoveryCodes = data.recoveryCodes;
+                    this.code = '';
+                    this.loadStatus();
+                })
+                .catch(() => {});
+        },
+
+        disable() {
+            this.request('POST', '/api/v1/auth/totp/disable', { code: this.code })
+                .then(() => {
+                    this.code = '';
+                    this.loadStatus();
+                })
+                .catch(() => {});
+        },
+
+        done() {
+            this.recoveryCodes = null;
+            // Users sent here to enroll can continue now
+            if (this.status.required) {
+                window.location.href = '/chat';
+            }
+        },
+
+        request(method, url, body) {
+            return settingsRequest(this, method, url, body);
+        }
+    }))
+
+    Alpine.data('providerKeySettings', () => ({
+        enabled: false,
+        key: null,
+        apiKey: '',
+        error: '',
+        isLoading: false,
+
+        init() {
+            this.load();
+        },
+
+        load() {
+            settingsRequest(this, 'GET', '/api/v1/provider-keys')
+                .then(data => {
+                    this.enabled = data.enabled;
+                    this.key = data.keys.find(k => k.provider === 'openrouter') || null;
+                })
+                .catch(() => {});
+        },
+
+        save() {
+            settingsRequest(this, 'PUT', '/api/v1/provider-keys/openrouter', { apiKey: this.apiKey })
+                .then(data => {
+                    this.key = data;
+                    this.apiKey = '';
+                })
+                .catch(() => {});
+        },
+
+        remove() {
+            settingsRequest(this, 'DELETE', '/api/v1/provider-keys/openrouter')
+                .then(() => this.key = null)
+                .catch(() => {});
+        }
+    }))
+})